@@ -0,0 +1,70 @@
+// oreon/defense · watchthelight <wtl>
+
+// defense-scan is what file-manager "Scan with Oreon Defense" actions run:
+// it scans the selected files, prints progress and shows the verdict as a
+// desktop notification.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/oreonproject/defense/internal/tray"
+	"github.com/oreonproject/defense/pkg/config"
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+const usage = `usage: defense-scan [-socket path] <path>...
+       defense-scan -install-menus [-exec path] [dir]
+
+Scans files or directories and shows the result as a notification. Exits
+1 if anything was found or the scan failed.
+
+-install-menus writes service menus for Dolphin, Nautilus and Nemo under
+dir (default: $XDG_DATA_HOME, i.e. ~/.local/share). Packagers can use
+/usr/share, or a staging directory.
+`
+
+func main() {
+	fs := flag.NewFlagSet("defense-scan", flag.ExitOnError)
+	socketPath := fs.String("socket", config.SocketPath, "path to IPC socket")
+	install := fs.Bool("install-menus", false, "write file-manager service menus and exit")
+	execPath := fs.String("exec", "", "helper path the menus run (default: this binary)")
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	fs.Parse(os.Args[1:])
+
+	if *install {
+		if err := installMenus(fs.Arg(0), *execPath); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := ipc.NewClient(*socketPath)
+	defer client.Close()
+
+	st, err := tray.ScanPaths(ctx, client, fs.Args(), os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		if errors.Is(err, ipc.ErrPermissionDenied) {
+			fmt.Fprintln(os.Stderr, "hint: you can only scan files you can read yourself")
+		}
+		os.Exit(1)
+	}
+	if st.ThreatsFound > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,97 @@
+// oreon/defense · watchthelight <wtl>
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// menuLabel is what the file managers show.
+const menuLabel = "Scan with Oreon Defense"
+
+// serviceMenu is one file manager's action definition, relative to a
+// data directory like ~/.local/share.
+type serviceMenu struct {
+	path    string
+	mode    os.FileMode
+	content string // %s is the quoted helper path
+}
+
+var serviceMenus = []serviceMenu{
+	// KDE Dolphin (KF5 and KF6); KF6 only loads executable menus
+	{"kio/servicemenus/oreon-defense-scan.desktop", 0o755, `[Desktop Entry]
+Type=Service
+MimeType=all/all;
+X-KDE-ServiceTypes=KonqPopupMenu/Plugin
+Actions=scan;
+X-KDE-Priority=TopLevel
+
+[Desktop Action scan]
+Name=` + menuLabel + `
+Icon=security-high
+Exec=%s %%F
+`},
+	// GNOME Nautilus runs scripts with the selection as arguments, from
+	// the directory being shown
+	{"nautilus/scripts/" + menuLabel, 0o755, `#!/bin/sh
+exec %s "$@"
+`},
+	// Cinnamon Nemo
+	{"nemo/actions/oreon-defense-scan.nemo_action", 0o644, `[Nemo Action]
+Name=` + menuLabel + `
+Comment=Check the selection for malware
+Exec=%s %%F
+Icon-Name=security-high
+Selection=notnone
+Extensions=any;
+`},
+}
+
+// installMenus writes every service menu under dir, running helper.
+func installMenus(dir, helper string) error {
+	if dir == "" {
+		dir = os.Getenv("XDG_DATA_HOME")
+	}
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	if helper == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		helper = exe
+	}
+	for _, m := range serviceMenus {
+		path := filepath.Join(dir, m.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(fmt.Sprintf(m.content, quoteExec(helper))), m.mode); err != nil {
+			return err
+		}
+		// WriteFile keeps the mode of an existing file
+		if err := os.Chmod(path, m.mode); err != nil {
+			return err
+		}
+		fmt.Println(path)
+	}
+	return nil
+}
+
+// quoteExec quotes a path for both desktop-entry Exec lines and sh,
+// which agree on double quotes as long as $, `, " and \ are escaped.
+func quoteExec(path string) string {
+	if !strings.ContainsAny(path, " \t\"'\\$`") {
+		return path
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	return `"` + r.Replace(path) + `"`
+}
@@ -0,0 +1,552 @@
+// oreon/defense · watchthelight <wtl>
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+// pollInterval is how often --wait checks on a scan or rules update.
+const pollInterval = time.Second
+
+func (c *ctl) status() error {
+	st, err := c.client.Status(c.ctx)
+	if err != nil {
+		return err
+	}
+	return c.print(st, func(w io.Writer) {
+		fmt.Fprintf(w, "state:\t%s\n", st.State)
+		if !st.PausedUntil.IsZero() {
+			fmt.Fprintf(w, "paused until:\t%s\n", formatTime(st.PausedUntil))
+		}
+		fmt.Fprintf(w, "firewall:\t%s\n", onOff(st.FirewallEnabled))
+		fmt.Fprintf(w, "last scan:\t%s\n", formatTime(st.LastScan))
+		fmt.Fprintf(w, "rules updated:\t%s\n", formatAge(st.RulesUpdated))
+	})
+}
+
+func (c *ctl) version() error {
+	hello, err := c.client.Hello(c.ctx, "defensectl/"+version)
+	if errors.Is(err, ipc.ErrUnknownCommand) {
+		// daemon predates hello
+		hello, err = &ipc.HelloResponse{Daemon: "unknown", Protocol: ipc.ProtocolVersion, MinProtocol: ipc.ProtocolVersion}, nil
+	}
+	if err != nil {
+		return err
+	}
+	out := struct {
+		Client string `json:"client"`
+		*ipc.HelloResponse
+	}{version, hello}
+	return c.print(out, func(w io.Writer) {
+		fmt.Fprintf(w, "client:\t%s (protocol %d)\n", version, ipc.ProtocolVersion)
+		fmt.Fprintf(w, "daemon:\t%s (protocol %d-%d)\n", hello.Daemon, hello.MinProtocol, hello.Protocol)
+		if len(hello.Features) > 0 {
+			fmt.Fprintf(w, "features:\t%s\n", strings.Join(hello.Features, ", "))
+		}
+	})
+}
+
+func (c *ctl) scan(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: defensectl scan start|path|status|cancel|history")
+	}
+	switch args[0] {
+	case "start":
+		fs := subFlags("scan start")
+		wait := fs.Bool("wait", false, "wait for the scan to finish")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		scanType := "quick"
+		if fs.NArg() > 0 {
+			scanType = fs.Arg(0)
+		}
+		var res *ipc.ScanResponse
+		var err error
+		switch scanType {
+		case "quick":
+			res, err = c.client.StartQuickScan(c.ctx)
+		case "full":
+			res, err = c.client.StartFullScan(c.ctx)
+		default:
+			return fmt.Errorf("unknown scan type %q (want quick or full)", scanType)
+		}
+		if err != nil {
+			return err
+		}
+		if !*wait {
+			return c.print(res, func(w io.Writer) {
+				fmt.Fprintf(w, "started %s scan %s\n", scanType, res.JobID)
+			})
+		}
+		return c.waitScan(res.JobID)
+
+	case "path":
+		return c.scanPaths(args[1:])
+
+	case "status":
+		job := ""
+		if len(args) > 1 {
+			job = args[1]
+		}
+		st, err := c.client.ScanStatus(c.ctx, job)
+		if err != nil {
+			return err
+		}
+		return c.print(st, func(w io.Writer) { printScan(w, st) })
+
+	case "cancel":
+		job := ""
+		if len(args) > 1 {
+			job = args[1]
+		}
+		if err := c.client.CancelScan(c.ctx, job); err != nil {
+			return err
+		}
+		return c.done("scan cancelled")
+
+	case "history":
+		fs := subFlags("scan history")
+		n := fs.Int("n", 20, "number of scans to show")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		scans, err := c.client.ScanHistory(c.ctx, *n)
+		if err != nil {
+			return err
+		}
+		return c.print(scans, func(w io.Writer) {
+			fmt.Fprintln(w, "JOB\tSTATUS\tSTARTED\tDURATION\tFILES\tTHREATS")
+			for _, s := range scans {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\n", s.JobID, s.Status, formatTime(s.StartedAt),
+					s.FinishedAt.Sub(s.StartedAt).Round(time.Second), s.FilesScanned, s.ThreatsFound)
+			}
+		})
+
+	default:
+		return fmt.Errorf("unknown scan command %q", args[0])
+	}
+}
+
+// scanPaths scans the given files and directories and, unless detached,
+// prints the verdict once the daemon is done. Threats make it fail, so
+// scripts can check the exit status.
+func (c *ctl) scanPaths(args []string) error {
+	fs := subFlags("scan path")
+	var params ipc.ScanParams
+	fs.IntVar(&params.Depth, "depth", 0, "directory levels to descend (0 = no limit)")
+	fs.StringVar(&params.Engine, "engine", "", "scan engine (default clamav)")
+	fs.Func("exclude", "glob pattern to skip (repeatable)", func(s string) error {
+		params.Exclude = append(params.Exclude, s)
+		return nil
+	})
+	detach := fs.Bool("detach", false, "start the scan and return right away")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("usage: defensectl scan path [-depth N] [-exclude PATTERN] [-engine E] [--detach] <path>...")
+	}
+	for _, p := range fs.Args() {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		params.Paths = append(params.Paths, abs)
+	}
+	params.Follow = !*detach
+
+	st, err := c.client.Scan(c.ctx, params)
+	if err != nil {
+		return err
+	}
+	if err := c.print(st, func(w io.Writer) {
+		if *detach {
+			fmt.Fprintf(w, "started scan %s\n", st.JobID)
+			return
+		}
+		printScan(w, st)
+	}); err != nil {
+		return err
+	}
+	switch {
+	case *detach:
+		return nil
+	case st.Status == "failed":
+		return errors.New("scan failed")
+	case st.ThreatsFound > 0:
+		return fmt.Errorf("%d threat(s) found", st.ThreatsFound)
+	}
+	return nil
+}
+
+// waitScan polls a scan until it's no longer running and prints the result.
+func (c *ctl) waitScan(job string) error {
+	for {
+		st, err := c.client.ScanStatus(c.ctx, job)
+		if err != nil {
+			return err
+		}
+		if st.Status != "running" {
+			if err := c.print(st, func(w io.Writer) { printScan(w, st) }); err != nil {
+				return err
+			}
+			if st.Status == "failed" {
+				return errors.New("scan failed")
+			}
+			return nil
+		}
+		if err := c.sleep(pollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+func printScan(w io.Writer, st *ipc.ScanStatusResponse) {
+	fmt.Fprintf(w, "job:\t%s (%s)\n", st.JobID, st.Type)
+	fmt.Fprintf(w, "status:\t%s\n", st.Status)
+	fmt.Fprintf(w, "started:\t%s\n", formatTime(st.StartedAt))
+	if !st.FinishedAt.IsZero() {
+		fmt.Fprintf(w, "finished:\t%s\n", formatTime(st.FinishedAt))
+	}
+	fmt.Fprintf(w, "files scanned:\t%d\n", st.FilesScanned)
+	fmt.Fprintf(w, "threats found:\t%d\n", st.ThreatsFound)
+	if st.Error != "" {
+		fmt.Fprintf(w, "error:\t%s\n", st.Error)
+	}
+	for _, t := range st.Threats {
+		fmt.Fprintf(w, "  %s\t%s\t%s %s\n", t.Path, t.Threat, t.Action, t.QuarantineID)
+	}
+}
+
+func (c *ctl) pause(args []string) error {
+	duration := ""
+	if len(args) > 0 {
+		duration = args[0]
+	}
+	if duration != "" && duration != "reboot" {
+		if _, err := time.ParseDuration(duration); err != nil {
+			return fmt.Errorf("invalid duration %q (e.g. 15m, 1h or reboot)", duration)
+		}
+	}
+	if err := c.client.Pause(c.ctx, duration); err != nil {
+		return err
+	}
+	if duration == "" || duration == "reboot" {
+		return c.done("protection paused until resumed or reboot")
+	}
+	return c.done("protection paused for " + duration)
+}
+
+func (c *ctl) firewall(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: defensectl firewall status|enable|disable|panic|blocked")
+	}
+	switch args[0] {
+	case "status":
+		enabled, err := c.client.IsFirewallEnabled(c.ctx)
+		if err != nil {
+			return err
+		}
+		return c.print(map[string]bool{"enabled": enabled}, func(w io.Writer) {
+			fmt.Fprintf(w, "firewall: %s\n", onOff(enabled))
+		})
+
+	case "enable", "disable":
+		enabled := args[0] == "enable"
+		if err := c.client.SetFirewallEnabled(c.ctx, enabled); err != nil {
+			return err
+		}
+		return c.done("firewall " + args[0] + "d")
+
+	case "panic":
+		if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+			return errors.New("usage: defensectl firewall panic on|off")
+		}
+		if err := c.client.SetFirewallPanic(c.ctx, args[1] == "on"); err != nil {
+			return err
+		}
+		return c.done("panic mode " + args[1])
+
+	case "blocked":
+		fs := subFlags("firewall blocked")
+		source := fs.String("source", "", "only connections from this address")
+		n := fs.Int("n", 50, "number of connections to show")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		res, err := c.client.FirewallBlocked(c.ctx, ipc.FirewallBlockedParams{Source: *source, Limit: *n})
+		if err != nil {
+			return err
+		}
+		return c.print(res, func(w io.Writer) {
+			fmt.Fprintln(w, "TIME\tSOURCE\tDESTINATION\tPORT\tPROTO")
+			for _, b := range res.Recent {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", formatTime(b.Time), b.Source, b.Destination, b.Port, b.Protocol)
+			}
+		})
+
+	default:
+		return fmt.Errorf("unknown firewall command %q", args[0])
+	}
+}
+
+func (c *ctl) quarantine(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: defensectl quarantine list|restore <id>|delete <id>")
+	}
+	switch args[0] {
+	case "list":
+		items, err := c.client.Quarantine(c.ctx)
+		if err != nil {
+			return err
+		}
+		return c.print(items, func(w io.Writer) {
+			fmt.Fprintln(w, "ID\tQUARANTINED\tTHREAT\tSIZE\tPATH")
+			for _, it := range items {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", it.ID, formatTime(it.QuarantinedAt), it.Threat, it.Size, it.OriginalPath)
+			}
+		})
+
+	case "restore", "delete":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: defensectl quarantine %s <id>", args[0])
+		}
+		if args[0] == "restore" {
+			if err := c.client.RestoreQuarantined(c.ctx, args[1]); err != nil {
+				return err
+			}
+			return c.done("restored " + args[1])
+		}
+		if err := c.client.DeleteQuarantined(c.ctx, args[1]); err != nil {
+			return err
+		}
+		return c.done("deleted " + args[1])
+
+	default:
+		return fmt.Errorf("unknown quarantine command %q", args[0])
+	}
+}
+
+func (c *ctl) rules(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: defensectl rules status|update|import <bundle>")
+	}
+	switch args[0] {
+	case "status":
+		st, err := c.client.RulesStatus(c.ctx)
+		if err != nil {
+			return err
+		}
+		return c.print(st, func(w io.Writer) { printRules(w, st) })
+
+	case "update":
+		fs := subFlags("rules update")
+		wait := fs.Bool("wait", false, "wait for the update to finish")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		st, err := c.client.UpdateRules(c.ctx)
+		if err != nil {
+			return err
+		}
+		for *wait && st.Updating {
+			if err := c.sleep(pollInterval); err != nil {
+				return err
+			}
+			if st, err = c.client.RulesStatus(c.ctx); err != nil {
+				return err
+			}
+		}
+		return c.print(st, func(w io.Writer) {
+			if st.Updating {
+				fmt.Fprintln(w, "update started")
+				return
+			}
+			printRules(w, st)
+		})
+
+	case "import":
+		if len(args) != 2 {
+			return errors.New("usage: defensectl rules import <bundle>")
+		}
+		// the daemon opens the file, and its working directory isn't ours
+		path, err := filepath.Abs(args[1])
+		if err != nil {
+			return err
+		}
+		res, err := c.client.ImportRules(c.ctx, path)
+		if err != nil {
+			return err
+		}
+		return c.print(res, func(w io.Writer) {
+			fmt.Fprintf(w, "imported bundle version %d (created %s)\n", res.Version, res.Created.Format("2006-01-02 15:04"))
+			for _, f := range res.Installed {
+				fmt.Fprintf(w, "  %s\n", f)
+			}
+		})
+
+	default:
+		return fmt.Errorf("unknown rules command %q", args[0])
+	}
+}
+
+func printRules(w io.Writer, st *ipc.RulesStatusResponse) {
+	fmt.Fprintln(w, "SOURCE\tKIND\tVERSION\tUPDATED\tSTATUS")
+	for _, src := range st.Sources {
+		status := "ok"
+		switch {
+		case src.Error != "":
+			status = src.Error
+		case src.Stale:
+			status = "stale"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", src.Name, src.Kind, src.Version, formatAge(src.Updated), status)
+	}
+	if st.Bundle != 0 {
+		fmt.Fprintf(w, "offline bundle: version %d\n", st.Bundle)
+	}
+}
+
+// eventFlags are the filters shared by events and tail.
+type eventFlags struct {
+	eventType, component *string
+	failed, ok           *bool
+}
+
+func addEventFlags(fs *flag.FlagSet) eventFlags {
+	return eventFlags{
+		eventType: fs.String("type", "", "only events of this type (e.g. scan_complete)"),
+		component: fs.String("component", "", "only events from this component"),
+		failed:    fs.Bool("failed", false, "only failed events"),
+		ok:        fs.Bool("ok", false, "only successful events"),
+	}
+}
+
+// success maps -failed/-ok to the protocol's tri-state filter.
+func (f eventFlags) success() (*bool, error) {
+	if *f.failed && *f.ok {
+		return nil, errors.New("-failed and -ok are mutually exclusive")
+	}
+	if !*f.failed && !*f.ok {
+		return nil, nil
+	}
+	success := *f.ok
+	return &success, nil
+}
+
+func (c *ctl) events(args []string) error {
+	fs := subFlags("events")
+	filter := addEventFlags(fs)
+	since := fs.Duration("since", 0, "only events from the last duration (e.g. 1h)")
+	n := fs.Int("n", 50, "number of events to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	success, err := filter.success()
+	if err != nil {
+		return err
+	}
+
+	params := ipc.EventsParams{Type: *filter.eventType, Component: *filter.component, Success: success, Limit: *n}
+	if *since > 0 {
+		params.Since = time.Now().Add(-*since)
+	}
+	evts, err := c.client.Events(c.ctx, params)
+	if err != nil {
+		return err
+	}
+	return c.print(evts, func(w io.Writer) {
+		fmt.Fprintln(w, "TIME\tTYPE\tCOMPONENT\tRESULT\tDETAILS")
+		for _, e := range evts {
+			printEvent(w, e)
+		}
+	})
+}
+
+// tail follows events as the daemon emits them, one line (or JSON object)
+// per event, until interrupted. It rides out daemon restarts.
+func (c *ctl) tail(args []string) error {
+	fs := subFlags("tail")
+	filter := addEventFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	success, err := filter.success()
+	if err != nil {
+		return err
+	}
+
+	notes, err := c.client.Watch(c.ctx, ipc.WatchParams{
+		Kinds: []string{ipc.KindEvent},
+		Events: &ipc.SubscribeEventsParams{
+			Type:      *filter.eventType,
+			Component: *filter.component,
+			Success:   success,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(c.out)
+	for n := range notes {
+		switch p := n.Payload.(type) {
+		case *ipc.Event:
+			if c.json {
+				if err := enc.Encode(p); err != nil {
+					return err
+				}
+				continue
+			}
+			printEvent(c.out, *p)
+		case *ipc.WatchResponse:
+			if p.Missed {
+				fmt.Fprintln(os.Stderr, "-- reconnected, some events were missed --")
+			} else {
+				fmt.Fprintln(os.Stderr, "-- reconnected --")
+			}
+		default:
+			if n.Kind == ipc.KindDisconnected {
+				fmt.Fprintf(os.Stderr, "-- lost connection to daemon (%v), reconnecting --\n", n.Payload)
+			}
+		}
+	}
+	return nil
+}
+
+func printEvent(w io.Writer, e ipc.Event) {
+	result := "ok"
+	if !e.Success {
+		result = "error: " + e.Error
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", formatTime(e.StartedAt), e.Type, e.Component, result, formatFields(e.Fields))
+}
+
+func formatFields(fields map[string]any) string {
+	parts := make([]string, 0, len(fields))
+	for k, v := range fields {
+		parts = append(parts, fmt.Sprintf("%s=%v", k, v))
+	}
+	// map order is random; keep output stable
+	slices.Sort(parts)
+	return strings.Join(parts, " ")
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
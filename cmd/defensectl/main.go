@@ -0,0 +1,214 @@
+// oreon/defense · watchthelight <wtl>
+
+// defensectl talks to defensed from the command line.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/config"
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "0.1.0-dev"
+
+const usage = `usage: defensectl [-socket path] [-timeout 30s] [--json] <command>
+
+commands:
+  status                              daemon state, firewall, last scan, rules age
+  version                             client and daemon versions, protocol, features
+  scan start [quick|full] [--wait]    start a scan (quick by default)
+  scan path [-depth N] [-exclude P] [--detach] <path>...
+                                      scan files or directories and print the verdict
+  scan status [job]                   progress of a scan (default: current or latest)
+  scan cancel [job]                   stop the running scan
+  scan history [-n N]                 finished scans, newest first
+  pause [15m|1h|reboot]               pause protection (default: until resume/reboot)
+  resume                              resume protection
+  firewall status|enable|disable      show or toggle the firewall
+  firewall panic on|off               drop all traffic except loopback
+  firewall blocked [-source A] [-n N] recently blocked connections
+  quarantine list                     quarantined files
+  quarantine restore <id>             put a file back where it was
+  quarantine delete <id>              delete a quarantined file for good
+  rules status                        signature and rule-list versions
+  rules update [--wait]               fetch new signatures and rule lists
+  rules import <bundle>               install a signed offline rules bundle
+  events [-type T] [-component C] [-since 1h] [-failed|-ok] [-n N]
+                                      recent daemon events, newest first
+  tail [-type T] [-component C] [-failed|-ok]
+                                      follow events as they happen
+
+--json prints the raw daemon response instead of tables. -timeout limits
+the whole command (0 for none); scan start --wait, scan path and tail run
+until done or interrupted. scan path exits 1 if it finds anything.
+`
+
+func main() {
+	args, jsonOut := extractJSONFlag(os.Args[1:])
+
+	fs := flag.NewFlagSet("defensectl", flag.ExitOnError)
+	socketPath := fs.String("socket", config.SocketPath, "path to IPC socket")
+	timeout := fs.Duration("timeout", 30*time.Second, "give up on the daemon after this long")
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 && !follows(fs.Args()) {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	client := ipc.NewClient(*socketPath)
+	defer client.Close()
+
+	ctl := &ctl{ctx: ctx, client: client, out: os.Stdout, json: jsonOut}
+	if err := ctl.run(fs.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		if errors.Is(err, ipc.ErrPermissionDenied) {
+			fmt.Fprintln(os.Stderr, "hint: run as root or as a member of the daemon's admin group")
+		}
+		os.Exit(1)
+	}
+}
+
+// follows reports whether the command runs until interrupted, so
+// -timeout doesn't apply.
+func follows(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	if len(args) > 1 && args[0] == "scan" && args[1] == "path" {
+		return !slices.Contains(args, "--detach") && !slices.Contains(args, "-detach")
+	}
+	return args[0] == "tail" || slices.Contains(args, "--wait") || slices.Contains(args, "-wait")
+}
+
+// extractJSONFlag pulls --json out of args wherever it appears, so
+// "defensectl scan history --json" works as well as the global position.
+func extractJSONFlag(args []string) ([]string, bool) {
+	out := args[:0:0]
+	found := false
+	for _, a := range args {
+		if a == "--json" || a == "-json" {
+			found = true
+			continue
+		}
+		out = append(out, a)
+	}
+	return out, found
+}
+
+// ctl runs one command against the daemon.
+type ctl struct {
+	ctx    context.Context // cancelled on Ctrl-C or -timeout
+	client ipc.Client
+	out    io.Writer
+	json   bool
+}
+
+func (c *ctl) run(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return errors.New("no command given")
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "status":
+		return c.status()
+	case "version":
+		return c.version()
+	case "scan":
+		return c.scan(args)
+	case "pause":
+		return c.pause(args)
+	case "resume":
+		if err := c.client.Resume(c.ctx); err != nil {
+			return err
+		}
+		return c.done("protection resumed")
+	case "firewall":
+		return c.firewall(args)
+	case "quarantine":
+		return c.quarantine(args)
+	case "rules":
+		return c.rules(args)
+	case "events":
+		return c.events(args)
+	case "tail":
+		return c.tail(args)
+	case "help":
+		fmt.Fprint(c.out, usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+// print writes v as JSON with --json, otherwise calls text with a tab
+// writer for aligned columns.
+func (c *ctl) print(v any, text func(w io.Writer)) error {
+	if c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	text(tw)
+	return tw.Flush()
+}
+
+// done reports a command with nothing to show but success.
+func (c *ctl) done(msg string) error {
+	return c.print(map[string]string{"result": msg}, func(w io.Writer) {
+		fmt.Fprintln(w, msg)
+	})
+}
+
+// subFlags builds a flag set for a subcommand whose errors come back to
+// run instead of exiting.
+func subFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("defensectl "+name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// sleep waits for d, or until the command is interrupted or times out.
+func (c *ctl) sleep(d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return time.Since(t).Round(time.Minute).String() + " ago"
+}
@@ -0,0 +1,258 @@
+// oreon/defense · watchthelight <wtl>
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+// fakeClient answers the calls these tests make; anything else panics
+// through the nil embedded Client.
+type fakeClient struct {
+	ipc.Client
+
+	scan       *ipc.ScanStatusResponse
+	scanParams ipc.ScanParams
+	status     []*ipc.ScanStatusResponse // ScanStatus answers, last one repeats
+	paused     []string
+	history    int
+}
+
+func (f *fakeClient) Scan(_ context.Context, params ipc.ScanParams) (*ipc.ScanStatusResponse, error) {
+	f.scanParams = params
+	return f.scan, nil
+}
+
+func (f *fakeClient) ScanStatus(context.Context, string) (*ipc.ScanStatusResponse, error) {
+	st := f.status[0]
+	if len(f.status) > 1 {
+		f.status = f.status[1:]
+	}
+	return st, nil
+}
+
+func (f *fakeClient) ScanHistory(_ context.Context, limit int) ([]ipc.ScanStatusResponse, error) {
+	f.history = limit
+	return nil, nil
+}
+
+func (f *fakeClient) Pause(_ context.Context, duration string) error {
+	f.paused = append(f.paused, duration)
+	return nil
+}
+
+func testCtl(t *testing.T, client ipc.Client) (*ctl, *bytes.Buffer) {
+	var out bytes.Buffer
+	return &ctl{ctx: t.Context(), client: client, out: &out}, &out
+}
+
+func TestFollows(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{nil, false},
+		{[]string{"status"}, false},
+		{[]string{"tail"}, true},
+		{[]string{"tail", "-type", "ban"}, true},
+		{[]string{"scan", "start"}, false},
+		{[]string{"scan", "start", "--wait"}, true},
+		{[]string{"scan", "start", "full", "-wait"}, true},
+		{[]string{"rules", "update", "--wait"}, true},
+		{[]string{"scan", "path", "/tmp"}, true},
+		{[]string{"scan", "path", "--detach", "/tmp"}, false},
+		{[]string{"scan", "path", "-detach", "/tmp"}, false},
+		{[]string{"scan", "status"}, false},
+	}
+	for _, tt := range tests {
+		if got := follows(tt.args); got != tt.want {
+			t.Errorf("follows(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestExtractJSONFlag(t *testing.T) {
+	tests := []struct {
+		args     []string
+		want     []string
+		wantJSON bool
+	}{
+		{[]string{"status"}, []string{"status"}, false},
+		{[]string{"--json", "status"}, []string{"status"}, true},
+		{[]string{"scan", "history", "-json"}, []string{"scan", "history"}, true},
+		{[]string{"-socket", "/run/x.sock", "events", "--json", "-n", "5"}, []string{"-socket", "/run/x.sock", "events", "-n", "5"}, true},
+		{[]string{"events", "--jsonish"}, []string{"events", "--jsonish"}, false},
+	}
+	for _, tt := range tests {
+		orig := slices.Clone(tt.args)
+		got, gotJSON := extractJSONFlag(tt.args)
+		if !slices.Equal(got, tt.want) || gotJSON != tt.wantJSON {
+			t.Errorf("extractJSONFlag(%q) = %q, %v; want %q, %v", orig, got, gotJSON, tt.want, tt.wantJSON)
+		}
+		if !slices.Equal(tt.args, orig) {
+			t.Errorf("extractJSONFlag modified its input: %q", tt.args)
+		}
+	}
+}
+
+func TestScanPathFlags(t *testing.T) {
+	clean := &ipc.ScanStatusResponse{JobID: "j1", Status: "completed"}
+	tests := []struct {
+		name    string
+		args    []string
+		want    ipc.ScanParams
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			args: []string{"/srv"},
+			want: ipc.ScanParams{Paths: []string{"/srv"}, Follow: true},
+		},
+		{
+			name: "all flags",
+			args: []string{"-depth", "2", "-exclude", "*.iso", "-exclude", "node_modules", "-engine", "clamav", "--detach", "/srv", "/home"},
+			want: ipc.ScanParams{Paths: []string{"/srv", "/home"}, Depth: 2, Exclude: []string{"*.iso", "node_modules"}, Engine: "clamav"},
+		},
+		{
+			name: "relative path",
+			args: []string{"docs"},
+			want: ipc.ScanParams{Paths: []string{"docs"}, Follow: true},
+		},
+		{name: "no paths", args: []string{"-depth", "1"}, wantErr: "usage"},
+		{name: "bad depth", args: []string{"-depth", "deep", "/srv"}, wantErr: "invalid value"},
+		{name: "unknown flag", args: []string{"-recursive", "/srv"}, wantErr: "not defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeClient{scan: clean}
+			c, _ := testCtl(t, client)
+			err := c.run(append([]string{"scan", "path"}, tt.args...))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			// the daemon has its own working directory
+			for i, p := range tt.want.Paths {
+				tt.want.Paths[i], _ = filepath.Abs(p)
+			}
+			got := client.scanParams
+			if !slices.Equal(got.Paths, tt.want.Paths) || got.Depth != tt.want.Depth ||
+				!slices.Equal(got.Exclude, tt.want.Exclude) || got.Engine != tt.want.Engine || got.Follow != tt.want.Follow {
+				t.Errorf("params = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanHistoryFlags(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    int
+		wantErr bool
+	}{
+		{nil, 20, false},
+		{[]string{"-n", "5"}, 5, false},
+		{[]string{"-n", "five"}, 0, true},
+	}
+	for _, tt := range tests {
+		client := &fakeClient{}
+		c, _ := testCtl(t, client)
+		err := c.run(append([]string{"scan", "history"}, tt.args...))
+		if (err != nil) != tt.wantErr || client.history != tt.want {
+			t.Errorf("scan history %q: limit %d, error %v; want %d, error %v", tt.args, client.history, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPauseDuration(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    string // what reaches the daemon
+		wantErr bool
+	}{
+		{nil, "", false},
+		{[]string{"reboot"}, "reboot", false},
+		{[]string{"15m"}, "15m", false},
+		{[]string{"1h30m"}, "1h30m", false},
+		{[]string{"soon"}, "", true},
+		{[]string{"15"}, "", true},
+	}
+	for _, tt := range tests {
+		client := &fakeClient{}
+		c, out := testCtl(t, client)
+		err := c.run(append([]string{"pause"}, tt.args...))
+		if tt.wantErr {
+			if err == nil || len(client.paused) != 0 {
+				t.Errorf("pause %q: error %v, sent %q; want an error and nothing sent", tt.args, err, client.paused)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(client.paused, []string{tt.want}) {
+			t.Errorf("pause %q: error %v, sent %q; want %q", tt.args, err, client.paused, tt.want)
+		}
+		if !strings.Contains(out.String(), "paused") {
+			t.Errorf("pause %q printed %q", tt.args, out)
+		}
+	}
+}
+
+func TestScanPathExitStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  *ipc.ScanStatusResponse
+		args    []string
+		wantErr string
+	}{
+		{"clean", &ipc.ScanStatusResponse{Status: "completed", FilesScanned: 3}, nil, ""},
+		{"threats", &ipc.ScanStatusResponse{Status: "completed", ThreatsFound: 2, Threats: []ipc.ScanThreat{
+			{Path: "/srv/a", Threat: "Eicar-Test-Signature"},
+		}}, nil, "2 threat(s) found"},
+		{"failed", &ipc.ScanStatusResponse{Status: "failed", Error: "clamd unavailable"}, nil, "scan failed"},
+		{"detached", &ipc.ScanStatusResponse{Status: "running"}, []string{"--detach"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, out := testCtl(t, &fakeClient{scan: tt.status})
+			err := c.run(append(append([]string{"scan", "path"}, tt.args...), "/srv"))
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("error = %v, want none", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+			if tt.status.ThreatsFound > 0 && !strings.Contains(out.String(), "/srv/a") {
+				t.Errorf("threat not printed:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestWaitScanCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	client := &fakeClient{status: []*ipc.ScanStatusResponse{{Status: "running"}}}
+	c := &ctl{ctx: ctx, client: client, out: &bytes.Buffer{}}
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	err := c.waitScan("j1")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("waitScan() error = %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d >= pollInterval {
+		t.Errorf("waitScan() took %v to notice the cancel", d)
+	}
+}
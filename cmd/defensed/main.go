@@ -46,6 +46,7 @@ func run(ctx context.Context, configPath, socketPath string) error {
 
 	slog.Info("config loaded", "path", configPath)
 
+	daemon.Version = version
 	d := daemon.New(cfg, slog.Default())
 	return d.Run(ctx, socketPath)
 }
@@ -7,6 +7,7 @@ require (
 	github.com/energye/systray v1.0.2
 	github.com/esiqveland/notify v0.13.3
 	github.com/godbus/dbus/v5 v5.2.1
+	github.com/therecipe/qt v0.0.0-20200904063919-c0c124a5770d
 	modernc.org/sqlite v1.41.0
 )
 
@@ -27,7 +28,6 @@ require (
 	github.com/therecipe/env_linux_amd64_513 v0.0.0-20190626000307-e137a3934da6 // indirect
 	github.com/therecipe/env_windows_amd64_513 v0.0.0-20190626000028-79ec8bd06fb2 // indirect
 	github.com/therecipe/env_windows_amd64_513/Tools v0.0.0-20190626000028-79ec8bd06fb2 // indirect
-	github.com/therecipe/qt v0.0.0-20200904063919-c0c124a5770d // indirect
 	github.com/therecipe/qt/internal/binding/files/docs/5.12.0 v0.0.0-20200904063919-c0c124a5770d // indirect
 	github.com/therecipe/qt/internal/binding/files/docs/5.13.0 v0.0.0-20200904063919-c0c124a5770d // indirect
 	golang.org/x/crypto v0.0.0-20190418165655-df01cb2cc480 // indirect
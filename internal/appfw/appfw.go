@@ -0,0 +1,280 @@
+// oreon/defense · watchthelight <wtl>
+
+// Package appfw controls which applications may make outbound connections.
+// Rules name an executable or a cgroup; both are resolved to cgroups and
+// handed to the firewall as nftables `socket cgroupv2` matches.
+package appfw
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oreonproject/defense/internal/firewall"
+	"github.com/oreonproject/defense/internal/procnet"
+	"github.com/oreonproject/defense/pkg/config"
+)
+
+// tickInterval is how often rules are re-resolved and, in learning mode,
+// connections are sampled. Exe rules follow programs as they start and stop.
+const tickInterval = 10 * time.Second
+
+// Rule actions.
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// RuleSetter is the part of the firewall the app firewall drives.
+type RuleSetter interface {
+	SetAppRules(ctx context.Context, rules []firewall.AppRule, defaultDeny bool) error
+}
+
+// RuleStatus reports how a configured rule resolved on the last tick.
+type RuleStatus struct {
+	Name    string
+	Exe     string
+	Cgroup  string
+	Action  string
+	Cgroups []string // cgroups the rule currently matches
+	Error   string
+}
+
+// Manager resolves app rules for the firewall and runs learning mode.
+type Manager struct {
+	cfg        config.AppFirewall
+	setter     RuleSetter
+	logger     *slog.Logger
+	procRoot   string
+	cgroupRoot string
+	learner    *Learner
+
+	mu       sync.Mutex
+	learning bool
+	status   []RuleStatus
+}
+
+// Option configures a Manager.
+type Option func(*Manager)
+
+// WithProcRoot reads processes and sockets from root instead of /proc.
+func WithProcRoot(root string) Option {
+	return func(m *Manager) {
+		m.procRoot = root
+	}
+}
+
+// WithCgroupRoot looks up cgroups under root instead of /sys/fs/cgroup.
+func WithCgroupRoot(root string) Option {
+	return func(m *Manager) {
+		m.cgroupRoot = root
+	}
+}
+
+// New creates an app firewall manager. Nothing happens until Run or Tick.
+func New(cfg config.AppFirewall, setter RuleSetter, logger *slog.Logger, opts ...Option) *Manager {
+	m := &Manager{
+		cfg:        cfg,
+		setter:     setter,
+		logger:     logger,
+		procRoot:   "/proc",
+		cgroupRoot: "/sys/fs/cgroup",
+		learner:    NewLearner(),
+		learning:   cfg.Learning,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Run ticks until ctx is cancelled.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		m.Tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick re-resolves rules and pushes them to the firewall if enabled,
+// then samples connections if learning.
+func (m *Manager) Tick(ctx context.Context) {
+	if m.cfg.Enabled {
+		rules, status := m.Resolve()
+		m.mu.Lock()
+		m.status = status
+		m.mu.Unlock()
+
+		if err := m.setter.SetAppRules(ctx, rules, m.cfg.Default == ActionDeny); err != nil {
+			m.logger.Error("failed to apply app firewall rules", "error", err)
+		}
+	}
+
+	if m.Learning() {
+		if err := m.observe(time.Now()); err != nil {
+			m.logger.Warn("app learning sample failed", "error", err)
+		}
+	}
+}
+
+// Resolve turns configured rules into per-cgroup firewall rules.
+// Exe rules match every cgroup a copy of the program is running in, so
+// they cover the whole unit or app scope, not just that one process.
+// If two rules hit the same cgroup the first one wins.
+func (m *Manager) Resolve() ([]firewall.AppRule, []RuleStatus) {
+	var procs []procnet.Process
+	for _, r := range m.cfg.Rules {
+		if r.Exe != "" {
+			var err error
+			if procs, err = procnet.Processes(m.procRoot); err != nil {
+				m.logger.Warn("failed to list processes", "error", err)
+			}
+			break
+		}
+	}
+
+	var rules []firewall.AppRule
+	status := make([]RuleStatus, 0, len(m.cfg.Rules))
+	seen := make(map[string]bool)
+
+	for _, r := range m.cfg.Rules {
+		st := RuleStatus{Name: r.Name, Exe: r.Exe, Cgroup: r.Cgroup, Action: r.Action}
+
+		cgroups, err := m.resolveRule(r, procs)
+		if err != nil {
+			st.Error = err.Error()
+			status = append(status, st)
+			continue
+		}
+		st.Cgroups = cgroups
+		for _, cg := range cgroups {
+			if seen[cg] {
+				continue
+			}
+			seen[cg] = true
+			rules = append(rules, firewall.AppRule{Cgroup: cg, Allow: r.Action == ActionAllow})
+		}
+		status = append(status, st)
+	}
+	return rules, status
+}
+
+func (m *Manager) resolveRule(r config.AppRule, procs []procnet.Process) ([]string, error) {
+	if r.Action != ActionAllow && r.Action != ActionDeny {
+		return nil, fmt.Errorf("invalid action %q", r.Action)
+	}
+
+	switch {
+	case r.Cgroup != "" && r.Exe != "":
+		return nil, fmt.Errorf("set exe or cgroup, not both")
+	case r.Cgroup != "":
+		cg := strings.Trim(r.Cgroup, "/")
+		if !validCgroup(cg) {
+			return nil, fmt.Errorf("invalid cgroup path %q", r.Cgroup)
+		}
+		if !m.cgroupExists(cg) {
+			return nil, fmt.Errorf("cgroup %s not found", cg)
+		}
+		return []string{cg}, nil
+	case r.Exe != "":
+		set := make(map[string]bool)
+		for _, p := range procs {
+			// the root cgroup would match everything
+			if p.Exe == r.Exe && p.Cgroup != "" && validCgroup(p.Cgroup) && m.cgroupExists(p.Cgroup) {
+				set[p.Cgroup] = true
+			}
+		}
+		out := make([]string, 0, len(set))
+		for cg := range set {
+			out = append(out, cg)
+		}
+		sort.Strings(out)
+		return out, nil
+	default:
+		return nil, fmt.Errorf("rule needs exe or cgroup")
+	}
+}
+
+// validCgroup rejects paths that would break out of the nft string literal
+// or point outside the hierarchy.
+func validCgroup(cg string) bool {
+	if cg == "" || strings.ContainsAny(cg, "\"\n\\") {
+		return false
+	}
+	for _, part := range strings.Split(cg, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// cgroupExists checks the path before it reaches nft, which fails the whole
+// ruleset if any cgroup is missing.
+func (m *Manager) cgroupExists(cg string) bool {
+	info, err := os.Stat(filepath.Join(m.cgroupRoot, cg))
+	return err == nil && info.IsDir()
+}
+
+// Rules returns the status of each configured rule as of the last tick.
+func (m *Manager) Rules() []RuleStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]RuleStatus(nil), m.status...)
+}
+
+// Config returns the app firewall settings.
+func (m *Manager) Config() config.AppFirewall {
+	return m.cfg
+}
+
+// SetLearning turns learning mode on or off. Learned apps are kept.
+func (m *Manager) SetLearning(on bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.learning = on
+}
+
+// Learning reports whether learning mode is on.
+func (m *Manager) Learning() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.learning
+}
+
+// Learned returns the applications seen so far in learning mode.
+func (m *Manager) Learned() []LearnedApp {
+	return m.learner.Apps()
+}
+
+// Proposals suggests allow rules for learned apps that no rule covers yet.
+func (m *Manager) Proposals() []config.AppRule {
+	return m.learner.Propose(m.cfg.Rules)
+}
+
+// observe samples open connections and records their owners.
+func (m *Manager) observe(now time.Time) error {
+	socks, err := procnet.ReadSockets(m.procRoot)
+	if err != nil {
+		return err
+	}
+	owners, err := procnet.SocketOwners(m.procRoot)
+	if err != nil {
+		return err
+	}
+	m.learner.Observe(socks, owners, now)
+	return nil
+}
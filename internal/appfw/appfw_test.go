@@ -0,0 +1,208 @@
+// oreon/defense · watchthelight <wtl>
+
+package appfw
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oreonproject/defense/internal/firewall"
+	"github.com/oreonproject/defense/pkg/config"
+)
+
+// fakeSetter records what the manager pushes to the firewall.
+type fakeSetter struct {
+	calls       int
+	rules       []firewall.AppRule
+	defaultDeny bool
+}
+
+func (s *fakeSetter) SetAppRules(_ context.Context, rules []firewall.AppRule, defaultDeny bool) error {
+	s.calls++
+	s.rules = rules
+	s.defaultDeny = defaultDeny
+	return nil
+}
+
+// fakeHost builds a /proc and /sys/fs/cgroup with the given processes.
+type fakeHost struct {
+	t          *testing.T
+	procRoot   string
+	cgroupRoot string
+}
+
+func newFakeHost(t *testing.T) *fakeHost {
+	dir := t.TempDir()
+	h := &fakeHost{t: t, procRoot: filepath.Join(dir, "proc"), cgroupRoot: filepath.Join(dir, "cgroup")}
+	os.MkdirAll(filepath.Join(h.procRoot, "net"), 0o755)
+	os.MkdirAll(h.cgroupRoot, 0o755)
+	return h
+}
+
+func (h *fakeHost) cgroup(path string) {
+	if err := os.MkdirAll(filepath.Join(h.cgroupRoot, path), 0o755); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+func (h *fakeHost) process(pid int, exe, cgroup string, uid int, inodes ...uint64) {
+	h.t.Helper()
+	h.cgroup(cgroup)
+	dir := filepath.Join(h.procRoot, fmt.Sprint(pid))
+	os.MkdirAll(filepath.Join(dir, "fd"), 0o755)
+	os.WriteFile(filepath.Join(dir, "cgroup"), []byte("0::/"+cgroup+"\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "status"), []byte(fmt.Sprintf("Uid:\t%d\t%d\t%d\t%d\n", uid, uid, uid, uid)), 0o644)
+	if err := os.Symlink(exe, filepath.Join(dir, "exe")); err != nil {
+		h.t.Fatal(err)
+	}
+	for i, inode := range inodes {
+		os.Symlink(fmt.Sprintf("socket:[%d]", inode), filepath.Join(dir, "fd", fmt.Sprint(i+3)))
+	}
+}
+
+func (h *fakeHost) tcp(table string) {
+	os.WriteFile(filepath.Join(h.procRoot, "net", "tcp"), []byte(table), 0o644)
+}
+
+func (h *fakeHost) manager(cfg config.AppFirewall, setter RuleSetter) *Manager {
+	return New(cfg, setter, slog.Default(), WithProcRoot(h.procRoot), WithCgroupRoot(h.cgroupRoot))
+}
+
+func TestManager_Resolve(t *testing.T) {
+	h := newFakeHost(t)
+	h.cgroup("system.slice/nginx.service")
+	h.process(100, "/usr/bin/curl", "user.slice/user-1000.slice/session-2.scope", 1000)
+	h.process(101, "/usr/bin/curl", "system.slice/backup.service", 0)
+	h.process(102, "/usr/bin/wget", "system.slice/backup.service", 0)
+
+	m := h.manager(config.AppFirewall{Enabled: true, Rules: []config.AppRule{
+		{Name: "nginx", Cgroup: "/system.slice/nginx.service/", Action: "allow"},
+		{Name: "curl", Exe: "/usr/bin/curl", Action: "deny"},
+		{Name: "wget", Exe: "/usr/bin/wget", Action: "allow"}, // same cgroup as curl, curl wins
+		{Name: "gone", Cgroup: "system.slice/gone.service", Action: "allow"},
+		{Name: "sneaky", Cgroup: `system.slice/x" accept`, Action: "allow"},
+		{Name: "typo", Exe: "/usr/bin/foo", Action: "permit"},
+		{Name: "idle", Exe: "/usr/bin/rsync", Action: "deny"},
+	}}, &fakeSetter{})
+
+	rules, status := m.Resolve()
+	want := []firewall.AppRule{
+		{Cgroup: "system.slice/nginx.service", Allow: true},
+		{Cgroup: "system.slice/backup.service", Allow: false},
+		{Cgroup: "user.slice/user-1000.slice/session-2.scope", Allow: false},
+	}
+	if fmt.Sprint(rules) != fmt.Sprint(want) {
+		t.Errorf("rules = %v\nwant  %v", rules, want)
+	}
+
+	if len(status) != 7 {
+		t.Fatalf("len(status) = %d, want 7", len(status))
+	}
+	if len(status[1].Cgroups) != 2 {
+		t.Errorf("curl cgroups = %v", status[1].Cgroups)
+	}
+	for i, wantErr := range []bool{false, false, false, true, true, true, false} {
+		if (status[i].Error != "") != wantErr {
+			t.Errorf("status[%d] (%s) error = %q, want error: %v", i, status[i].Name, status[i].Error, wantErr)
+		}
+	}
+	if len(status[6].Cgroups) != 0 {
+		t.Errorf("idle rule matched %v", status[6].Cgroups)
+	}
+}
+
+func TestManager_Tick(t *testing.T) {
+	h := newFakeHost(t)
+	h.cgroup("system.slice/sshd.service")
+	setter := &fakeSetter{}
+	m := h.manager(config.AppFirewall{Enabled: true, Default: "deny", Rules: []config.AppRule{
+		{Name: "sshd", Cgroup: "system.slice/sshd.service", Action: "allow"},
+	}}, setter)
+
+	m.Tick(context.Background())
+	if setter.calls != 1 || !setter.defaultDeny || len(setter.rules) != 1 {
+		t.Errorf("setter = %+v", setter)
+	}
+	if len(m.Rules()) != 1 {
+		t.Errorf("Rules() = %+v", m.Rules())
+	}
+
+	// disabled: nothing is pushed
+	setter = &fakeSetter{}
+	h.manager(config.AppFirewall{}, setter).Tick(context.Background())
+	if setter.calls != 0 {
+		t.Error("disabled manager pushed rules")
+	}
+}
+
+func TestManager_Learning(t *testing.T) {
+	h := newFakeHost(t)
+	h.process(200, "/usr/bin/curl", "user.slice/user-1000.slice/session-2.scope", 1000, 5001)
+	h.process(201, "/usr/sbin/chronyd", "system.slice/chronyd.service", 0, 5002)
+	h.tcp(`  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0F02000A:D1B4 2200A8C0:01BB 01 00000000:00000000 02:000A7D2E 00000000  1000        0 5001 2 0000000000000000 20 4 30 10 -1
+   1: 0F02000A:D1B5 0100007F:1F90 01 00000000:00000000 02:000A7D2E 00000000  1000        0 5001 2 0000000000000000 20 4 30 10 -1
+   2: 0F02000A:D1B6 0A0A0A0A:01BB 01 00000000:00000000 02:000A7D2E 00000000     0        0 5002 2 0000000000000000 20 4 30 10 -1
+   3: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 5003 1 0000000000000000 100 0 0 10 0
+`)
+
+	m := h.manager(config.AppFirewall{Rules: []config.AppRule{}}, &fakeSetter{})
+	m.Tick(context.Background())
+	if len(m.Learned()) != 0 {
+		t.Fatal("learned apps while learning is off")
+	}
+
+	m.SetLearning(true)
+	m.Tick(context.Background())
+	m.Tick(context.Background())
+
+	apps := m.Learned()
+	if len(apps) != 2 {
+		t.Fatalf("learned %d apps, want 2: %+v", len(apps), apps)
+	}
+	curl := apps[0]
+	if curl.Exe != "/usr/bin/curl" || curl.UID != 1000 {
+		t.Errorf("apps[0] = %+v", curl)
+	}
+	// loopback connection is ignored
+	if len(curl.Destinations) != 1 {
+		t.Fatalf("curl destinations = %+v", curl.Destinations)
+	}
+	if d := curl.Destinations[0]; d.Addr.String() != "192.168.0.34" || d.Port != 443 || d.Proto != "tcp" || d.Count != 2 {
+		t.Errorf("destination = %+v", d)
+	}
+
+	proposed := m.Proposals()
+	if len(proposed) != 2 {
+		t.Fatalf("proposals = %+v", proposed)
+	}
+	if p := proposed[0]; p.Name != "curl" || p.Exe != "/usr/bin/curl" || p.Action != "allow" {
+		t.Errorf("proposed[0] = %+v", p)
+	}
+	if p := proposed[1]; p.Name != "chronyd" || p.Cgroup != "system.slice/chronyd.service" || p.Exe != "" {
+		t.Errorf("proposed[1] = %+v", p)
+	}
+}
+
+func TestLearner_ProposeSkipsCovered(t *testing.T) {
+	h := newFakeHost(t)
+	h.process(200, "/usr/bin/curl", "user.slice/user-1000.slice/session-2.scope", 1000, 5001)
+	h.tcp(`  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0F02000A:D1B4 2200A8C0:01BB 01 00000000:00000000 02:000A7D2E 00000000  1000        0 5001 2 0000000000000000 20 4 30 10 -1
+`)
+	m := h.manager(config.AppFirewall{Learning: true, Rules: []config.AppRule{
+		{Name: "curl", Exe: "/usr/bin/curl", Action: "deny"},
+	}}, &fakeSetter{})
+	m.Tick(context.Background())
+
+	if len(m.Learned()) != 1 {
+		t.Fatalf("Learned() = %+v", m.Learned())
+	}
+	if p := m.Proposals(); len(p) != 0 {
+		t.Errorf("proposed rules for a covered app: %+v", p)
+	}
+}
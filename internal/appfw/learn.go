@@ -0,0 +1,192 @@
+// oreon/defense · watchthelight <wtl>
+
+package appfw
+
+import (
+	"net/netip"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oreonproject/defense/internal/procnet"
+	"github.com/oreonproject/defense/pkg/config"
+)
+
+const (
+	maxLearnedApps  = 512 // apps tracked in learning mode
+	maxDestinations = 64  // destinations tracked per app
+)
+
+// LearnedApp is an application seen with outbound connections.
+type LearnedApp struct {
+	Exe          string
+	Cgroup       string
+	UID          int
+	FirstSeen    time.Time
+	LastSeen     time.Time
+	Destinations []Destination // most recently seen first
+}
+
+// Destination is somewhere a learned app connected to.
+type Destination struct {
+	Addr     netip.Addr
+	Port     int
+	Proto    string // "tcp" or "udp"
+	Count    int    // samples in which a connection was open
+	LastSeen time.Time
+}
+
+type appKey struct {
+	exe    string
+	cgroup string
+}
+
+// Learner records which apps connect where. Connections are sampled from
+// /proc, so very short-lived ones can be missed; it's meant for building
+// an initial rule set, not auditing.
+// Thread-safe.
+type Learner struct {
+	mu   sync.Mutex
+	apps map[appKey]*LearnedApp
+}
+
+// NewLearner creates an empty learner.
+func NewLearner() *Learner {
+	return &Learner{apps: make(map[appKey]*LearnedApp)}
+}
+
+// Observe records outbound connections from one sample of the socket tables.
+// Listening sockets, loopback traffic and unowned sockets are ignored.
+func (l *Learner) Observe(socks []procnet.Socket, owners map[uint64]procnet.Process, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, s := range socks {
+		if !outbound(s) {
+			continue
+		}
+		p, ok := owners[s.Inode]
+		if !ok || p.Exe == "" {
+			continue
+		}
+
+		key := appKey{exe: p.Exe, cgroup: p.Cgroup}
+		app, ok := l.apps[key]
+		if !ok {
+			if len(l.apps) >= maxLearnedApps {
+				continue
+			}
+			app = &LearnedApp{Exe: p.Exe, Cgroup: p.Cgroup, UID: p.UID, FirstSeen: now}
+			l.apps[key] = app
+		}
+		app.LastSeen = now
+		app.record(s, now)
+	}
+}
+
+// outbound reports whether s is a connection we initiated or are part of
+// to a non-local peer.
+func outbound(s procnet.Socket) bool {
+	if s.Listening() || !s.Remote.IsValid() || s.Remote.Port() == 0 {
+		return false
+	}
+	addr := s.Remote.Addr()
+	if addr.IsUnspecified() || addr.IsLoopback() {
+		return false
+	}
+	if strings.HasPrefix(s.Proto, "tcp") {
+		return s.State == "ESTABLISHED" || s.State == "SYN_SENT"
+	}
+	return true
+}
+
+func (a *LearnedApp) record(s procnet.Socket, now time.Time) {
+	proto := strings.TrimSuffix(s.Proto, "6")
+	addr, port := s.Remote.Addr(), int(s.Remote.Port())
+
+	for i := range a.Destinations {
+		d := &a.Destinations[i]
+		if d.Addr == addr && d.Port == port && d.Proto == proto {
+			d.Count++
+			d.LastSeen = now
+			return
+		}
+	}
+	if len(a.Destinations) >= maxDestinations {
+		// replace the stalest entry
+		oldest := 0
+		for i, d := range a.Destinations {
+			if d.LastSeen.Before(a.Destinations[oldest].LastSeen) {
+				oldest = i
+			}
+		}
+		a.Destinations = append(a.Destinations[:oldest], a.Destinations[oldest+1:]...)
+	}
+	a.Destinations = append(a.Destinations, Destination{Addr: addr, Port: port, Proto: proto, Count: 1, LastSeen: now})
+}
+
+// Apps returns a snapshot of learned apps, sorted by executable.
+func (l *Learner) Apps() []LearnedApp {
+	l.mu.Lock()
+	out := make([]LearnedApp, 0, len(l.apps))
+	for _, app := range l.apps {
+		cp := *app
+		cp.Destinations = append([]Destination(nil), app.Destinations...)
+		sort.Slice(cp.Destinations, func(i, j int) bool {
+			return cp.Destinations[i].LastSeen.After(cp.Destinations[j].LastSeen)
+		})
+		out = append(out, cp)
+	}
+	l.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Exe != out[j].Exe {
+			return out[i].Exe < out[j].Exe
+		}
+		return out[i].Cgroup < out[j].Cgroup
+	})
+	return out
+}
+
+// Propose suggests an allow rule for every learned app not already covered
+// by existing. Apps running as their own systemd service get a cgroup rule,
+// which is tighter; everything else gets an exe rule.
+func (l *Learner) Propose(existing []config.AppRule) []config.AppRule {
+	covered := make(map[string]bool)
+	for _, r := range existing {
+		if r.Exe != "" {
+			covered["exe:"+r.Exe] = true
+		}
+		if r.Cgroup != "" {
+			covered["cgroup:"+strings.Trim(r.Cgroup, "/")] = true
+		}
+	}
+
+	var out []config.AppRule
+	for _, app := range l.Apps() {
+		if covered["exe:"+app.Exe] || covered["cgroup:"+app.Cgroup] {
+			continue
+		}
+		var rule config.AppRule
+		if unit, ok := serviceUnit(app.Cgroup); ok {
+			rule = config.AppRule{Name: strings.TrimSuffix(unit, ".service"), Cgroup: app.Cgroup, Action: ActionAllow}
+			covered["cgroup:"+app.Cgroup] = true
+		} else {
+			rule = config.AppRule{Name: filepath.Base(app.Exe), Exe: app.Exe, Action: ActionAllow}
+			covered["exe:"+app.Exe] = true
+		}
+		out = append(out, rule)
+	}
+	return out
+}
+
+// serviceUnit returns the unit name if cg is a system service's cgroup.
+func serviceUnit(cg string) (string, bool) {
+	unit, ok := strings.CutPrefix(cg, "system.slice/")
+	if !ok || strings.Contains(unit, "/") || !strings.HasSuffix(unit, ".service") {
+		return "", false
+	}
+	return unit, true
+}
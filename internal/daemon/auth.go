@@ -0,0 +1,311 @@
+// oreon/defense · watchthelight <wtl>
+
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/oreonproject/defense/internal/polkit"
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+// ErrPermissionDenied is returned for privileged commands from callers
+// the policy doesn't allow. The same sentinel clients match against.
+var ErrPermissionDenied = ipc.ErrPermissionDenied
+
+// Peer is the process on the other end of an IPC connection, as reported
+// by the kernel (SO_PEERCRED) when it connected.
+type Peer struct {
+	UID int
+	GID int
+	PID int
+	// Start is PID's start time, read when it connected so polkit checks
+	// can't land on a process that reused the pid. 0 if unknown.
+	Start uint64
+}
+
+// withStart fills in p.Start from /proc. A process that's already gone
+// keeps 0 and so can't be authorized by polkit.
+func (p Peer) withStart() Peer {
+	if p.PID > 0 {
+		p.Start, _ = polkit.ProcessStartTime(p.PID)
+	}
+	return p
+}
+
+// peerCred reads the credentials of a unix socket peer.
+func peerCred(conn net.Conn) (Peer, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return Peer{}, fmt.Errorf("not a unix socket: %T", conn)
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return Peer{}, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return Peer{}, err
+	}
+	if credErr != nil {
+		return Peer{}, fmt.Errorf("SO_PEERCRED: %w", credErr)
+	}
+	return Peer{UID: int(cred.Uid), GID: int(cred.Gid), PID: int(cred.Pid)}.withStart(), nil
+}
+
+// openCommands may be run by any local user: they only read state, or
+// (scans, rule updates) do something harmless the tray offers everyone.
+// Anything not listed is privileged, so new commands are locked down
+// until someone decides otherwise.
+var openCommands = map[string]bool{
+	ipc.CmdHello:           true,
+	ipc.CmdPing:            true,
+	ipc.CmdStatus:          true,
+	ipc.CmdFirewallStatus:  true,
+	ipc.CmdFirewallBlocked: true,
+	ipc.CmdScan:            true, // custom scans only go where the caller could
+	ipc.CmdScanQuick:       true,
+	ipc.CmdScanFull:        true,
+	ipc.CmdScanStatus:      true,
+	ipc.CmdScanHistory:     true,
+	ipc.CmdQuarantineList:  true,
+	ipc.CmdEvents:          true,
+	ipc.CmdBansList:        true,
+	ipc.CmdAppRules:        true,
+	ipc.CmdAppLearned:      true, // filtered to the caller's own apps
+	ipc.CmdNetInventory:    true, // filtered to the caller's own sockets
+	ipc.CmdRulesStatus:     true,
+	ipc.CmdRulesUpdate:     true,
+	ipc.CmdSubscribe:       true,
+	ipc.CmdSubscribeEvents: true,
+	ipc.CmdWatch:           true,
+}
+
+// commandActions maps privileged commands to the polkit action that
+// covers them. Unlisted ones fall under polkit.ActionManage.
+var commandActions = map[string]string{
+	ipc.CmdPause:             polkit.ActionPause,
+	ipc.CmdResume:            polkit.ActionPause,
+	ipc.CmdFirewallEnable:    polkit.ActionFirewall,
+	ipc.CmdFirewallDisable:   polkit.ActionFirewall,
+	ipc.CmdFirewallPanic:     polkit.ActionFirewall,
+	ipc.CmdBanLift:           polkit.ActionFirewall,
+	ipc.CmdAppLearning:       polkit.ActionFirewall,
+	ipc.CmdQuarantineRestore: polkit.ActionQuarantine,
+	ipc.CmdQuarantineDelete:  polkit.ActionQuarantine,
+}
+
+// polkitTimeout bounds how long a caller gets to answer the password
+// prompt. Kept under the tray's and defensectl's 30s request timeouts so
+// they hear back.
+const polkitTimeout = 25 * time.Second
+
+// authorizeRequest is authorize, except that callers may always manage
+// what they started themselves: cancelling their own scan.
+func (s *Server) authorizeRequest(ctx context.Context, p Peer, req *ipc.Request) error {
+	if req.Command == ipc.CmdScanCancel {
+		var params ipc.ScanJobParams
+		if decodeParams(req, &params) == nil {
+			if job, err := s.daemon.Scans().Get(params.JobID); err == nil && job.State == ScanRunning && job.Owner == p.UID {
+				return nil
+			}
+		}
+	}
+	return s.authorize(ctx, p, req.Command)
+}
+
+// authorize checks cmd against the policy: open commands for everyone,
+// the rest for root, members of general.admin_group, and whoever polkit
+// says yes to.
+func (s *Server) authorize(ctx context.Context, p Peer, cmd string) error {
+	if openCommands[cmd] || p.UID == 0 {
+		return nil
+	}
+	group := s.daemon.Config().General.AdminGroup
+	if group != "" && inGroup(p, group) {
+		return nil
+	}
+	if s.daemon.polkit != nil && p.PID > 0 {
+		action, ok := commandActions[cmd]
+		if !ok {
+			action = polkit.ActionManage
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, polkitTimeout)
+		defer cancel()
+		allowed, err := s.daemon.polkit.Check(checkCtx, p.PID, p.Start, p.UID, action)
+		if err := ctx.Err(); err != nil && !allowed {
+			return err // the caller gave up on the prompt
+		}
+		if err != nil {
+			slog.Warn("polkit check failed", "command", cmd, "pid", p.PID, "error", err)
+		}
+		if allowed {
+			return nil
+		}
+		return denied(map[string]any{"command": cmd, "polkit_action": action},
+			"%s not authorized by polkit (%s)", cmd, action)
+	}
+	if group == "" {
+		return denied(map[string]any{"command": cmd}, "%s needs root", cmd)
+	}
+	return denied(map[string]any{"command": cmd, "group": group},
+		"%s needs root or membership in group %q", cmd, group)
+}
+
+// denied builds a permission_denied error; its details tell a client what
+// would have been allowed.
+func denied(details map[string]any, format string, args ...any) error {
+	return &ipc.Error{
+		Code:    ipc.CodePermissionDenied,
+		Message: ErrPermissionDenied.Error() + ": " + fmt.Sprintf(format, args...),
+		Details: details,
+	}
+}
+
+// privileged reports whether p sees everything open commands can show:
+// root and members of general.admin_group.
+func (s *Server) privileged(p Peer) bool {
+	if p.UID == 0 {
+		return true
+	}
+	group := s.daemon.Config().General.AdminGroup
+	return group != "" && inGroup(p, group)
+}
+
+// checkReadable refuses custom scans of paths the caller couldn't read
+// themselves, so scan results don't leak what's in other users' files.
+// Root and admin_group skip the check. Only mode bits count, not ACLs,
+// which errs on the side of refusing.
+func (s *Server) checkReadable(p Peer, path string) error {
+	if s.privileged(p) {
+		return nil
+	}
+	gids := peerGroups(p)
+	ok := permits(p, gids, path, 4)
+	// and every directory on the way needs search permission
+	for dir := path; ok && dir != "/"; {
+		dir = filepath.Dir(dir)
+		ok = permits(p, gids, dir, 1)
+	}
+	if !ok {
+		return denied(map[string]any{"command": ipc.CmdScan, "path": path},
+			"uid %d can't read %s", p.UID, path)
+	}
+	return nil
+}
+
+// permits reports whether path's mode grants p the permission bit
+// (4 read, 1 search) through its owner, group or other bits.
+func permits(p Peer, gids []string, path string, bit uint32) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && modeGrants(p, gids, st, bit)
+}
+
+func modeGrants(p Peer, gids []string, st *syscall.Stat_t, bit uint32) bool {
+	switch {
+	case int(st.Uid) == p.UID:
+		return st.Mode&(bit<<6) != 0
+	case slices.Contains(gids, strconv.Itoa(int(st.Gid))):
+		return st.Mode&(bit<<3) != 0
+	}
+	return st.Mode&bit != 0
+}
+
+// access is what an unprivileged caller may read, for walking their
+// custom scans the way they could themselves.
+type access struct {
+	peer Peer
+	gids []string
+}
+
+// accessFor returns p's access, or nil if they're privileged and may
+// scan anything.
+func (s *Server) accessFor(p Peer) *access {
+	if s.privileged(p) {
+		return nil
+	}
+	return &access{peer: p, gids: peerGroups(p)}
+}
+
+// allows reports whether st's mode grants the permission bit.
+func (a *access) allows(st *syscall.Stat_t, bit uint32) bool {
+	return modeGrants(a.peer, a.gids, st, bit)
+}
+
+// open opens path one component at a time from /, without following
+// symlinks, checking the caller may search every directory on the way.
+// path must be absolute and clean.
+func (a *access) open(path string) (int, error) {
+	fd, err := syscall.Open("/", walkOpenFlags|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		return -1, err
+	}
+	for name := range strings.SplitSeq(strings.TrimPrefix(path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		var st syscall.Stat_t
+		if err := syscall.Fstat(fd, &st); err != nil || !a.allows(&st, 1) {
+			syscall.Close(fd)
+			return -1, syscall.EACCES
+		}
+		next, err := syscall.Openat(fd, name, walkOpenFlags, 0)
+		syscall.Close(fd)
+		if err != nil {
+			return -1, err
+		}
+		fd = next
+	}
+	return fd, nil
+}
+
+// peerGroups returns the peer's primary and supplementary group IDs, as
+// far as they can be found out.
+func peerGroups(p Peer) []string {
+	var gids []string
+	if p.GID >= 0 {
+		gids = append(gids, strconv.Itoa(p.GID))
+	}
+	if u, err := user.LookupId(strconv.Itoa(p.UID)); err == nil {
+		if more, err := u.GroupIds(); err == nil {
+			gids = append(gids, more...)
+		}
+	}
+	return gids
+}
+
+// inGroup reports whether the peer's primary or supplementary groups
+// include name. Lookup failures count as not a member.
+func inGroup(p Peer, name string) bool {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return false
+	}
+	if g.Gid == strconv.Itoa(p.GID) {
+		return true
+	}
+	u, err := user.LookupId(strconv.Itoa(p.UID))
+	if err != nil {
+		return false
+	}
+	gids, err := u.GroupIds()
+	return err == nil && slices.Contains(gids, g.Gid)
+}
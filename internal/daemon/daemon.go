@@ -4,47 +4,156 @@ package daemon
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/godbus/dbus/v5"
+	"github.com/oreonproject/defense/internal/appfw"
+	"github.com/oreonproject/defense/internal/dnsfilter"
+	"github.com/oreonproject/defense/internal/firewall"
+	"github.com/oreonproject/defense/internal/ids"
+	"github.com/oreonproject/defense/internal/inventory"
+	"github.com/oreonproject/defense/internal/polkit"
+	"github.com/oreonproject/defense/internal/quarantine"
+	"github.com/oreonproject/defense/internal/rules"
 	"github.com/oreonproject/defense/internal/scanner"
 	"github.com/oreonproject/defense/pkg/config"
 	"github.com/oreonproject/defense/pkg/events"
+	"github.com/oreonproject/defense/pkg/ipc"
+	"github.com/oreonproject/defense/pkg/logging"
 )
 
+// Version is the daemon version reported by hello, set by main.
+var Version = "dev"
+
+// ErrQuarantineUnavailable is returned for quarantine commands when the
+// quarantine directory couldn't be set up.
+var ErrQuarantineUnavailable = errors.New("quarantine is unavailable")
+
+// blocklistPollInterval is how often blocklist files are checked for changes.
+const blocklistPollInterval = 30 * time.Second
+
+// eventPruneInterval is how often stored events past events.retention
+// are deleted.
+const eventPruneInterval = time.Hour
+
 // Daemon is the main defense daemon that coordinates scanning,
 // firewall, and protection state.
 type Daemon struct {
-	cfg     *config.Config
-	state   *StateManager
-	logger  *slog.Logger
-	scanner *scanner.ClamAV
-	events  *events.Emitter
+	cfg      *config.Config
+	state    *StateManager
+	logger   *slog.Logger
+	scanner  *scanner.ClamAV
+	firewall *firewall.Firewall
+	ids      *ids.Manager
+	appfw    *appfw.Manager
+	network  *inventory.Collector
+	ports    *inventory.Monitor
+	dns      *dnsfilter.Server // nil unless dns.enabled
+	rules    *rules.Manager
+	scans    ScanJobs
+	qstore   *quarantine.Store // nil if the directory can't be created
+	events   *events.Emitter
+	recent   *events.Recent
+	store    *logging.LogStore  // nil unless events.database_path is set and opens
+	sink     *logging.EventSink // writes sampled events to store
+	keep     time.Duration      // events.retention, 0 = keep forever
+	notifier *Notifier
+	polkit   *polkit.Authority // nil unless general.polkit and the system bus is up
 
 	// Runtime state (may differ from config)
 	firewallEnabled bool
 	lastScan        time.Time
-	rulesUpdated    time.Time
+
+	pauseMu     sync.Mutex
+	pauseTimer  *time.Timer
+	pausedUntil time.Time // zero when not paused or paused indefinitely
 }
 
+// recentEvents is how many events are kept in memory for queries.
+const recentEvents = 1000
+
 // New creates a new daemon instance.
 func New(cfg *config.Config, logger *slog.Logger) *Daemon {
+	recent := events.NewRecent(recentEvents)
+	notifier := NewNotifier()
 	d := &Daemon{
 		cfg:             cfg,
 		state:           NewStateManager(),
 		logger:          logger,
 		scanner:         scanner.New(cfg.ClamAV.SocketPath),
-		events:          events.NewEmitter(events.WithLogger(logger)),
+		recent:          recent,
+		notifier:        notifier,
 		firewallEnabled: cfg.Firewall.Enabled,
-		rulesUpdated:    time.Now(), // Assume rules are current at startup
+	}
+	emitterOpts := []events.EmitterOption{events.WithLogger(logger), events.WithSink(recent), events.WithSink(notifier)}
+	if d.openEventStore() {
+		emitterOpts = append(emitterOpts, events.WithSampledSink(d.sink, cfg.Events.SampleRate))
+	}
+	d.events = events.NewEmitter(emitterOpts...)
+
+	q, err := quarantine.New(cfg.Scanning.QuarantineDir)
+	if err != nil {
+		logger.Warn("quarantine unavailable", "dir", cfg.Scanning.QuarantineDir, "error", err)
+	} else {
+		d.qstore = q
+	}
+
+	backend, err := firewall.SelectBackend(cfg.Firewall, logger)
+	if err != nil {
+		logger.Error("firewall backend unavailable, falling back to nftables", "backend", cfg.Firewall.Backend, "error", err)
+		backend = firewall.NewNFTables(nil)
+	}
+	logger.Info("firewall backend selected", "backend", backend.Name())
+	d.firewall = firewall.New(cfg.Firewall, firewall.WithBackend(backend), firewall.WithLogger(logger))
+
+	d.ids = ids.New(cfg.IDS, d.firewall, d.events, logger)
+	d.appfw = appfw.New(cfg.AppFirewall, d.firewall, logger)
+
+	onRules := rules.WithUpdateHandler(func(st rules.Status, err error) {
+		evt := events.StartRulesUpdate(st.Name, st.Kind).Version(st.Version)
+		evt.SetError(err)
+		d.events.Emit(evt.End())
+		d.notifier.Publish(ipc.KindRules, ipc.RulesEvent{
+			Source:  st.Name,
+			Kind:    st.Kind,
+			Version: st.Version,
+			Error:   st.Error,
+		})
+	})
+	d.rules, err = rules.New(cfg.Rules, d.scanner, nil, nil, rules.WithLogger(logger), onRules)
+	if err != nil {
+		logger.Error("bad rules config, only updating ClamAV signatures", "error", err)
+		d.rules, _ = rules.New(config.Rules{
+			FreshclamPath: cfg.Rules.FreshclamPath,
+			DatabaseDir:   cfg.Rules.DatabaseDir,
+		}, d.scanner, nil, nil, rules.WithLogger(logger), onRules)
+	}
+
+	if cfg.General.Polkit {
+		if conn, err := dbus.SystemBus(); err != nil {
+			logger.Warn("polkit unavailable, privileged commands need root or admin_group", "error", err)
+		} else {
+			d.polkit = polkit.New(conn)
+		}
+	}
+
+	d.network = inventory.New()
+	d.ports, err = inventory.NewMonitor(cfg.Inventory.ExpectedPorts, cfg.Firewall.AllowedTCPPorts, cfg.Firewall.AllowedUDPPorts)
+	if err != nil {
+		logger.Warn("ignoring bad inventory.expected_ports entries", "error", err)
 	}
 
 	// Register listener to emit state change events
 	d.state.OnStateChange(func(old, new State) {
 		evt := events.StartStateChange(old.String(), new.String())
 		d.events.Emit(evt.End())
+		d.notifier.Publish(ipc.KindStateChange, ipc.StateChangeEvent{OldState: old.String(), NewState: new.String()})
 	})
 
 	return d
@@ -60,11 +169,65 @@ func (d *Daemon) FirewallEnabled() bool {
 	return d.firewallEnabled
 }
 
-// SetFirewallEnabled enables or disables the firewall.
-func (d *Daemon) SetFirewallEnabled(enabled bool) {
+// SetFirewallEnabled installs or removes the firewall ruleset.
+func (d *Daemon) SetFirewallEnabled(ctx context.Context, enabled bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var err error
+	if enabled {
+		err = d.firewall.Enable(ctx)
+	} else {
+		err = d.firewall.Disable(ctx)
+	}
+	if err != nil {
+		d.logger.Error("firewall toggle failed", "enabled", enabled, "error", err)
+		return err
+	}
+
 	d.firewallEnabled = enabled
 	d.cfg.Firewall.Enabled = enabled
 	d.logger.Info("firewall toggled", "enabled", enabled)
+	d.notifier.Publish(ipc.KindFirewall, ipc.FirewallEvent{Enabled: enabled, Panic: d.firewall.Panic()})
+	return nil
+}
+
+// SetFirewallPanic turns firewall panic mode on or off.
+func (d *Daemon) SetFirewallPanic(ctx context.Context, on bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if err := d.firewall.SetPanic(ctx, on); err != nil {
+		d.logger.Error("firewall panic toggle failed", "panic", on, "error", err)
+		return err
+	}
+	d.notifier.Publish(ipc.KindFirewall, ipc.FirewallEvent{Enabled: d.firewallEnabled, Panic: on})
+	return nil
+}
+
+// Firewall returns the firewall manager.
+func (d *Daemon) Firewall() *firewall.Firewall {
+	return d.firewall
+}
+
+// IDS returns the intrusion detection manager.
+func (d *Daemon) IDS() *ids.Manager {
+	return d.ids
+}
+
+// AppFirewall returns the per-application firewall manager.
+func (d *Daemon) AppFirewall() *appfw.Manager {
+	return d.appfw
+}
+
+// Network returns the socket inventory collector.
+func (d *Daemon) Network() *inventory.Collector {
+	return d.network
+}
+
+// ExpectedListener reports whether a listening socket is one we expect.
+func (d *Daemon) ExpectedListener(s inventory.Socket) bool {
+	return d.ports.Expected(s)
 }
 
 // LastScan returns the time of the last scan.
@@ -77,9 +240,29 @@ func (d *Daemon) SetLastScan(t time.Time) {
 	d.lastScan = t
 }
 
-// RulesUpdated returns the time rules were last updated.
+// RulesUpdated returns the build time of the oldest installed rules.
 func (d *Daemon) RulesUpdated() time.Time {
-	return d.rulesUpdated
+	return d.rules.Oldest()
+}
+
+// ImportRules installs a signed offline bundle and records the outcome.
+func (d *Daemon) ImportRules(ctx context.Context, path string) (rules.ImportResult, error) {
+	evt := events.StartRulesImport(path)
+	res, err := d.rules.Import(ctx, path)
+	if err != nil {
+		d.logger.Error("rules bundle import failed", "path", path, "error", err)
+	} else {
+		d.logger.Info("rules bundle imported", "path", path, "version", res.Version, "files", len(res.Installed))
+	}
+	evt.Bundle(res.Version, len(res.Installed))
+	evt.SetError(err)
+	d.events.Emit(evt.End())
+	return res, err
+}
+
+// Rules returns the rules update manager.
+func (d *Daemon) Rules() *rules.Manager {
+	return d.rules
 }
 
 // State returns the state manager for external access (e.g. IPC).
@@ -92,6 +275,85 @@ func (d *Daemon) Scanner() *scanner.ClamAV {
 	return d.scanner
 }
 
+// RecentEvents returns the in-memory buffer of emitted events.
+func (d *Daemon) RecentEvents() *events.Recent {
+	return d.recent
+}
+
+// EventStore returns the SQLite event store, nil if events aren't stored.
+func (d *Daemon) EventStore() *logging.LogStore {
+	return d.store
+}
+
+// Notifier returns the feed of typed notifications for watching clients.
+func (d *Daemon) Notifier() *Notifier {
+	return d.notifier
+}
+
+// Scans returns the scan job tracker.
+func (d *Daemon) Scans() *ScanJobs {
+	return &d.scans
+}
+
+// Quarantine returns the quarantine store.
+func (d *Daemon) Quarantine() (*quarantine.Store, error) {
+	if d.qstore == nil {
+		return nil, ErrQuarantineUnavailable
+	}
+	return d.qstore, nil
+}
+
+// Pause pauses protection. A zero duration pauses until Resume.
+func (d *Daemon) Pause(dur time.Duration) {
+	d.pauseMu.Lock()
+	defer d.pauseMu.Unlock()
+
+	if d.pauseTimer != nil {
+		d.pauseTimer.Stop()
+		d.pauseTimer = nil
+	}
+	d.pausedUntil = time.Time{}
+	if dur > 0 {
+		d.pausedUntil = time.Now().Add(dur)
+		var timer *time.Timer
+		timer = time.AfterFunc(dur, func() {
+			d.pauseMu.Lock()
+			// a later Pause or Resume replaced us
+			if d.pauseTimer != timer {
+				d.pauseMu.Unlock()
+				return
+			}
+			d.pauseTimer = nil
+			d.pausedUntil = time.Time{}
+			d.pauseMu.Unlock()
+			d.logger.Info("pause expired, resuming protection")
+			d.state.SetState(StateProtected)
+		})
+		d.pauseTimer = timer
+	}
+	d.state.SetState(StatePaused)
+	d.logger.Info("protection paused", "duration", dur)
+}
+
+// Resume ends a pause.
+func (d *Daemon) Resume() {
+	d.pauseMu.Lock()
+	if d.pauseTimer != nil {
+		d.pauseTimer.Stop()
+		d.pauseTimer = nil
+	}
+	d.pausedUntil = time.Time{}
+	d.pauseMu.Unlock()
+	d.state.SetState(StateProtected)
+}
+
+// PausedUntil returns when a timed pause ends, zero otherwise.
+func (d *Daemon) PausedUntil() time.Time {
+	d.pauseMu.Lock()
+	defer d.pauseMu.Unlock()
+	return d.pausedUntil
+}
+
 // Events returns the event emitter for logging wide events.
 func (d *Daemon) Events() *events.Emitter {
 	return d.events
@@ -100,6 +362,7 @@ func (d *Daemon) Events() *events.Emitter {
 // Run starts the daemon and blocks until context is cancelled.
 func (d *Daemon) Run(ctx context.Context, socketPath string) error {
 	d.logger.Info("daemon starting")
+	defer d.closeEventStore()
 
 	// Start IPC server
 	server := NewServer(socketPath, d)
@@ -109,11 +372,54 @@ func (d *Daemon) Run(ctx context.Context, socketPath string) error {
 	go server.Serve()
 	defer server.Close()
 
+	if d.cfg.HTTP.Enabled {
+		if err := server.ListenHTTP(d.cfg.HTTP); err != nil {
+			d.logger.Error("HTTP gateway unavailable", "error", err)
+		}
+	}
+	if d.cfg.General.DBus {
+		if conn, err := dbus.SystemBus(); err != nil {
+			d.logger.Warn("D-Bus service unavailable", "error", err)
+		} else if err := server.ExportDBus(conn); err != nil {
+			d.logger.Warn("D-Bus service unavailable", "error", err)
+		}
+	}
+
+	// load blocklists first so the initial ruleset includes them
+	if err := d.firewall.RefreshBlocklists(ctx); err != nil {
+		d.logger.Error("failed to load blocklists", "error", err)
+	}
+	go d.firewall.WatchBlocklists(ctx, blocklistPollInterval)
+
+	if d.firewallEnabled {
+		if err := d.firewall.Enable(ctx); err != nil {
+			// healthCheck will report the mismatch as a warning
+			d.logger.Error("failed to apply firewall rules", "error", err)
+			d.firewallEnabled = false
+		}
+	}
+	// NFLOG only sees packets dropped by our own nftables table
+	if d.cfg.Firewall.LogBlocked && d.firewall.Backend() == firewall.BackendNFTables {
+		go d.watchBlocked(ctx)
+	}
+	if d.cfg.IDS.SSHEnabled {
+		go d.runIDS(ctx)
+	}
+	if d.cfg.DNS.Enabled {
+		d.startDNS(ctx)
+	}
+	// always runs so learning can be switched on over IPC
+	go d.appfw.Run(ctx)
+	go d.rules.Run(ctx)
+
 	// initial health check
 	d.healthCheck()
+	d.pruneEvents()
 
 	ticker := time.NewTicker(60 * time.Second)
 	defer ticker.Stop()
+	pruneTicker := time.NewTicker(eventPruneInterval)
+	defer pruneTicker.Stop()
 
 	for {
 		select {
@@ -122,8 +428,122 @@ func (d *Daemon) Run(ctx context.Context, socketPath string) error {
 			return nil
 		case <-ticker.C:
 			d.healthCheck()
+		case <-pruneTicker.C:
+			d.pruneEvents()
+		}
+	}
+}
+
+// openEventStore opens the SQLite event store if events.database_path is
+// set. Failures are logged and events just aren't persisted.
+func (d *Daemon) openEventStore() bool {
+	path := d.cfg.Events.DatabasePath
+	if path == "" {
+		return false
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		d.logger.Error("event store unavailable", "path", path, "error", err)
+		return false
+	}
+	store, err := logging.NewLogStore(path)
+	if err != nil {
+		d.logger.Error("event store unavailable", "path", path, "error", err)
+		return false
+	}
+	d.store = store
+	d.sink = logging.NewEventSink(store, logging.WithSinkLogger(d.logger))
+	if r := d.cfg.Events.Retention; r != "" {
+		keep, err := time.ParseDuration(r)
+		if err != nil || keep <= 0 {
+			// keep everything rather than guess and delete too much
+			d.logger.Warn("invalid events.retention, keeping events forever", "value", r)
+		} else {
+			d.keep = keep
 		}
 	}
+	return true
+}
+
+// pruneEvents deletes stored events older than events.retention.
+func (d *Daemon) pruneEvents() {
+	if d.store == nil || d.keep == 0 {
+		return
+	}
+	n, err := d.store.PruneEvents(d.keep)
+	if err != nil {
+		d.logger.Warn("failed to prune stored events", "error", err)
+		return
+	}
+	if n > 0 {
+		d.logger.Debug("pruned stored events", "count", n, "retention", d.keep)
+	}
+}
+
+// closeEventStore writes out queued events and closes the store.
+func (d *Daemon) closeEventStore() {
+	if d.store == nil {
+		return
+	}
+	d.sink.Close()
+	if err := d.store.Close(); err != nil {
+		d.logger.Warn("failed to close event store", "error", err)
+	}
+}
+
+// watchBlocked turns packets dropped by the firewall into events.
+// Returns when ctx is cancelled or NFLOG can't be opened.
+func (d *Daemon) watchBlocked(ctx context.Context) {
+	err := d.firewall.WatchBlocked(ctx, func(p firewall.BlockedPacket, suppressed int) {
+		evt := events.StartFirewallBlock(p.Source.String(), p.Dest.String(), p.DestPort, p.Protocol).
+			Interface(p.Interface).
+			Suppressed(suppressed)
+		d.events.Emit(evt.End())
+	})
+	if err != nil {
+		d.logger.Warn("blocked-connection logging unavailable", "error", err)
+	}
+}
+
+// startDNS starts the filtering DNS forwarder. Failures are logged and
+// the daemon carries on without it.
+func (d *Daemon) startDNS(ctx context.Context) {
+	srv, err := dnsfilter.New(d.cfg.DNS, dnsfilter.WithLogger(d.logger), dnsfilter.WithBlockHandler(func(b dnsfilter.Block, suppressed int) {
+		evt := events.StartDNSBlock(b.Domain, b.QType, b.Client.String()).
+			List(b.List).
+			Suppressed(suppressed)
+		d.events.Emit(evt.End())
+	}))
+	if err != nil {
+		d.logger.Error("dns filter disabled", "error", err)
+		return
+	}
+	srv.RefreshLists()
+	if err := srv.Listen(); err != nil {
+		d.logger.Error("dns filter disabled", "error", err)
+		return
+	}
+	d.dns = srv
+	d.logger.Info("dns filter listening", "addr", srv.Addr())
+	go srv.Serve(ctx)
+	go srv.WatchLists(ctx, blocklistPollInterval)
+}
+
+// DNS returns the DNS filter, or nil if it isn't running.
+func (d *Daemon) DNS() *dnsfilter.Server {
+	return d.dns
+}
+
+// runIDS follows the auth log and bans brute-force sources.
+func (d *Daemon) runIDS(ctx context.Context) {
+	src, err := ids.DetectSource(d.cfg.IDS.Source, d.cfg.IDS.LogPath)
+	if err != nil {
+		d.logger.Error("ssh brute-force detection disabled", "error", err)
+		return
+	}
+	d.logger.Info("ssh brute-force detection started", "source", d.cfg.IDS.Source)
+	if err := d.ids.Run(ctx, src); err != nil {
+		d.logger.Warn("ssh brute-force detection stopped", "error", err)
+	}
 }
 
 // healthCheck evaluates system state and updates the state machine.
@@ -143,6 +563,15 @@ func (d *Daemon) healthCheck() {
 	clamAvailable := d.checkClamAV()
 	evt.ClamAVAvailable(clamAvailable)
 	evt.FirewallEnabled(d.firewallEnabled)
+	unexpected := d.checkListeners()
+	evt.UnexpectedListeners(unexpected)
+	// freshclam may run as its own service, so re-read what's installed
+	d.rules.Refresh()
+	stale := d.rules.Stale()
+	evt.RulesStale(len(stale))
+	if len(stale) > 0 {
+		d.logger.Warn("security rules are out of date", "sources", stale, "max_age", d.rules.MaxAge())
+	}
 
 	// Determine the appropriate state
 	var newState State
@@ -152,6 +581,10 @@ func (d *Daemon) healthCheck() {
 	} else if !d.firewallEnabled && d.cfg.Firewall.Enabled {
 		// Firewall should be on but isn't
 		newState = StateWarning
+	} else if unexpected > 0 {
+		newState = StateWarning
+	} else if len(stale) > 0 {
+		newState = StateWarning
 	} else {
 		newState = StateProtected
 	}
@@ -161,6 +594,29 @@ func (d *Daemon) healthCheck() {
 	}
 }
 
+// checkListeners looks for non-loopback listeners on unexpected ports and emits
+// an event for each one that appeared since the last check. Returns how
+// many are currently listening.
+func (d *Daemon) checkListeners() int {
+	if !d.cfg.Inventory.WarnUnexpected {
+		return 0
+	}
+	snap, err := d.network.Collect()
+	if err != nil {
+		d.logger.Warn("network inventory failed", "error", err)
+		return 0
+	}
+
+	unexpected, added := d.ports.Check(snap)
+	for _, s := range added {
+		d.logger.Warn("unexpected port listening", "proto", s.Proto, "addr", s.Local, "exe", s.Exe, "pid", s.PID)
+		evt := events.StartUnexpectedListener(s.Proto, s.Local.Addr().String(), int(s.Local.Port())).
+			Process(s.PID, s.Exe, s.User)
+		d.events.Emit(evt.End())
+	}
+	return len(unexpected)
+}
+
 // checkClamAV verifies ClamAV daemon is available.
 func (d *Daemon) checkClamAV() bool {
 	// Use scanner to check (does ping)
@@ -4,11 +4,14 @@ package daemon
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"testing"
 	"time"
 
 	"github.com/oreonproject/defense/pkg/config"
+	"github.com/oreonproject/defense/pkg/events"
+	"github.com/oreonproject/defense/pkg/logging"
 )
 
 func TestDaemonRun(t *testing.T) {
@@ -34,3 +37,67 @@ func TestDaemonRun(t *testing.T) {
 		t.Errorf("state = %v, want %v or %v", state, StateWarning, StateProtected)
 	}
 }
+
+func TestDaemonEventStore(t *testing.T) {
+	path := t.TempDir() + "/lib/events.db"
+	cfg := &config.Config{Events: config.Events{DatabasePath: path, SampleRate: 0}}
+	d := New(cfg, slog.Default())
+	if d.store == nil {
+		t.Fatal("event store not opened")
+	}
+
+	// sample_rate 0 keeps only failures (and slow operations)
+	d.Events().Emit(events.StartBan("192.0.2.1", "ssh").End())
+	failed := events.StartRulesUpdate("clamav", "clamav")
+	failed.SetError(errors.New("freshclam failed"))
+	d.Events().Emit(failed.End())
+	d.closeEventStore()
+
+	store, err := logging.NewLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	got, err := store.QueryEvents(t.Context(), logging.EventQueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Type != events.EventTypeRules || got[0].Error != "freshclam failed" {
+		t.Errorf("stored events = %+v", got)
+	}
+	// the in-memory buffer isn't sampled
+	if n := len(d.RecentEvents().Query(events.Filter{}, 0)); n != 2 {
+		t.Errorf("recent events = %d, want 2", n)
+	}
+}
+
+func TestDaemonPruneEvents(t *testing.T) {
+	path := t.TempDir() + "/events.db"
+	cfg := &config.Config{Events: config.Events{DatabasePath: path, Retention: "24h"}}
+	d := New(cfg, slog.Default())
+	defer d.closeEventStore()
+	if d.keep != 24*time.Hour {
+		t.Fatalf("retention = %v, want 24h", d.keep)
+	}
+
+	old := events.StartBan("192.0.2.1", "ssh").End()
+	old.StartedAt = time.Now().Add(-48 * time.Hour)
+	if err := d.store.InsertEvents([]events.Event{old, events.StartBan("192.0.2.2", "ssh").End()}); err != nil {
+		t.Fatal(err)
+	}
+	d.pruneEvents()
+	got, err := d.store.QueryEvents(t.Context(), logging.EventQueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Fields[events.FieldSrcAddr] != "192.0.2.2" {
+		t.Errorf("stored events after prune = %+v", got)
+	}
+
+	// a typo mustn't turn into deleting everything
+	bad := New(&config.Config{Events: config.Events{DatabasePath: t.TempDir() + "/events.db", Retention: "30 days"}}, slog.Default())
+	defer bad.closeEventStore()
+	if bad.keep != 0 {
+		t.Errorf("retention = %v for an invalid value, want forever", bad.keep)
+	}
+}
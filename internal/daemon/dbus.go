@@ -0,0 +1,290 @@
+// oreon/defense · watchthelight <wtl>
+
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+// D-Bus names for the daemon's own service.
+const (
+	DBusName  = "org.oreon.Defense1"
+	DBusPath  = dbus.ObjectPath("/org/oreon/Defense1")
+	DBusIface = "org.oreon.Defense1"
+)
+
+// dbusCommands are the IPC commands exported as D-Bus methods, named in
+// CamelCase (firewall_enable -> FirewallEnable). The ones in
+// dbusTypedMethods have real signatures; the rest take the command's params
+// as a JSON string ("" for none) and return its data as JSON.
+// Subscriptions aren't here: D-Bus clients get properties and signals.
+var dbusCommands = slices.DeleteFunc(slices.Clone(commands), isSubscription)
+
+// dbusTypedMethod is a command exported with its own D-Bus signature.
+type dbusTypedMethod struct {
+	handler any
+	args    []introspect.Arg
+}
+
+// dbusTypedMethods are the commands desktop clients use most, typed so
+// introspection says what they take. Times are unix seconds, 0 for never.
+func (s *Server) dbusTypedMethods(conn *dbus.Conn) map[string]dbusTypedMethod {
+	plain := func(cmd string) dbusTypedMethod {
+		return dbusTypedMethod{handler: func(sender dbus.Sender) *dbus.Error {
+			_, err := s.dbusCall(conn, sender, cmd, nil)
+			return err
+		}}
+	}
+	return map[string]dbusTypedMethod{
+		ipc.CmdStatus: {
+			handler: func(sender dbus.Sender) (string, bool, int64, int64, int64, *dbus.Error) {
+				data, dbusErr := s.dbusCall(conn, sender, ipc.CmdStatus, nil)
+				if dbusErr != nil {
+					return "", false, 0, 0, 0, dbusErr
+				}
+				var status ipc.StatusResponse
+				if err := json.Unmarshal(data, &status); err != nil {
+					return "", false, 0, 0, 0, dbus.MakeFailedError(err)
+				}
+				return status.State, status.FirewallEnabled, unixTime(status.LastScan), unixTime(status.RulesUpdated), unixTime(status.PausedUntil), nil
+			},
+			args: []introspect.Arg{
+				{Name: "state", Type: "s", Direction: "out"},
+				{Name: "firewall_enabled", Type: "b", Direction: "out"},
+				{Name: "last_scan", Type: "x", Direction: "out"},
+				{Name: "rules_updated", Type: "x", Direction: "out"},
+				{Name: "paused_until", Type: "x", Direction: "out"},
+			},
+		},
+		ipc.CmdPause: {
+			handler: func(sender dbus.Sender, duration string) *dbus.Error {
+				params, _ := json.Marshal(ipc.PauseParams{Duration: duration})
+				_, err := s.dbusCall(conn, sender, ipc.CmdPause, params)
+				return err
+			},
+			args: []introspect.Arg{{Name: "duration", Type: "s", Direction: "in"}},
+		},
+		ipc.CmdResume:          plain(ipc.CmdResume),
+		ipc.CmdFirewallEnable:  plain(ipc.CmdFirewallEnable),
+		ipc.CmdFirewallDisable: plain(ipc.CmdFirewallDisable),
+	}
+}
+
+// unixTime is t in unix seconds, or 0 if it's unset.
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// dbusMethodName turns an IPC command into a D-Bus method name.
+func dbusMethodName(cmd string) string {
+	parts := strings.Split(cmd, "_")
+	for i, p := range parts {
+		parts[i] = strings.ToUpper(p[:1]) + p[1:]
+	}
+	return strings.Join(parts, "")
+}
+
+// dbusErrorName turns an ipc error code into a D-Bus error name
+// (permission_denied -> org.oreon.Defense1.Error.PermissionDenied).
+func dbusErrorName(code string) string {
+	if code == "" {
+		code = ipc.CodeFailed
+	}
+	return DBusIface + ".Error." + dbusMethodName(code)
+}
+
+// ExportDBus publishes the daemon as DBusName on conn (normally the system
+// bus). Methods go through the same handleRequest as the unix socket, so
+// authorization and request events are identical. State and
+// FirewallEnabled are properties that emit PropertiesChanged, and threats
+// found by scans are sent as ThreatDetected signals.
+func (s *Server) ExportDBus(conn *dbus.Conn) error {
+	methods := make(map[string]any, len(dbusCommands))
+	node := introspect.Node{Name: string(DBusPath)}
+	iface := introspect.Interface{
+		Name: DBusIface,
+		Signals: []introspect.Signal{{
+			Name: "ThreatDetected",
+			Args: []introspect.Arg{
+				{Name: "path", Type: "s"},
+				{Name: "threat", Type: "s"},
+				{Name: "action", Type: "s"},
+				{Name: "quarantine_id", Type: "s"},
+			},
+		}},
+	}
+	typed := s.dbusTypedMethods(conn)
+	for _, cmd := range dbusCommands {
+		m, ok := typed[cmd]
+		if !ok {
+			m = dbusTypedMethod{
+				handler: s.dbusMethod(conn, cmd),
+				args: []introspect.Arg{
+					{Name: "params", Type: "s", Direction: "in"},
+					{Name: "data", Type: "s", Direction: "out"},
+				},
+			}
+		}
+		methods[dbusMethodName(cmd)] = m.handler
+		iface.Methods = append(iface.Methods, introspect.Method{Name: dbusMethodName(cmd), Args: m.args})
+	}
+	if err := conn.ExportMethodTable(methods, DBusPath, DBusIface); err != nil {
+		return fmt.Errorf("dbus: export methods: %w", err)
+	}
+
+	props, err := prop.Export(conn, DBusPath, prop.Map{
+		DBusIface: {
+			"State":           {Value: s.daemon.State().State().String(), Emit: prop.EmitTrue},
+			"FirewallEnabled": {Value: s.daemon.FirewallEnabled(), Emit: prop.EmitTrue},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("dbus: export properties: %w", err)
+	}
+	iface.Properties = props.Introspection(DBusIface)
+
+	node.Interfaces = []introspect.Interface{introspect.IntrospectData, prop.IntrospectData, iface}
+	if err := conn.Export(introspect.NewIntrospectable(&node), DBusPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		return fmt.Errorf("dbus: export introspection: %w", err)
+	}
+
+	reply, err := conn.RequestName(DBusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return fmt.Errorf("dbus: request name: %w", err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("dbus: %s is already owned", DBusName)
+	}
+
+	s.connMu.Lock()
+	s.dbus = true
+	s.connMu.Unlock()
+	go s.dbusNotify(conn, props)
+	return nil
+}
+
+// dbusMethod builds the JSON handler for one command.
+func (s *Server) dbusMethod(conn *dbus.Conn, cmd string) func(dbus.Sender, string) (string, *dbus.Error) {
+	return func(sender dbus.Sender, params string) (string, *dbus.Error) {
+		var raw json.RawMessage
+		if params != "" {
+			raw = json.RawMessage(params)
+		}
+		data, err := s.dbusCall(conn, sender, cmd, raw)
+		return string(data), err
+	}
+}
+
+// dbusCall runs cmd for whoever sent a D-Bus message and returns its data.
+func (s *Server) dbusCall(conn *dbus.Conn, sender dbus.Sender, cmd string, params json.RawMessage) (json.RawMessage, *dbus.Error) {
+	peer, err := dbusPeer(conn, sender)
+	if err != nil {
+		// like the socket: open commands still work
+		slog.Warn("failed to read D-Bus caller credentials", "sender", sender, "error", err)
+		peer = Peer{UID: -1, GID: -1, PID: -1}
+	}
+
+	req := &ipc.Request{ID: "dbus", Command: cmd, Version: ipc.ProtocolVersion, Params: params}
+	ctx, cancel := s.dbusContext()
+	defer cancel()
+	resp := s.handleRequest(ctx, peer, req)
+	if !resp.Success {
+		return nil, dbus.NewError(dbusErrorName(resp.Code), []any{resp.Error})
+	}
+	return resp.Data, nil
+}
+
+// dbusCallTimeout is how long libdbus and most bindings wait for a reply
+// by default; past it the caller has given up on us.
+const dbusCallTimeout = 25 * time.Second
+
+// dbusContext is the context a D-Bus method runs under. D-Bus doesn't
+// tell us when a caller stops waiting, so it ends at their likely reply
+// timeout, or when the server closes.
+func (s *Server) dbusContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbusCallTimeout)
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// dbusPeer asks the bus who sent a message. D-Bus doesn't give us the
+// primary group, so GID is -1; group checks fall back to the user's groups.
+func dbusPeer(conn *dbus.Conn, sender dbus.Sender) (Peer, error) {
+	var creds map[string]dbus.Variant
+	if err := conn.BusObject().Call("org.freedesktop.DBus.GetConnectionCredentials", 0, string(sender)).Store(&creds); err != nil {
+		return Peer{}, err
+	}
+	uid, ok1 := creds["UnixUserID"].Value().(uint32)
+	pid, ok2 := creds["ProcessID"].Value().(uint32)
+	if !ok1 || !ok2 {
+		return Peer{}, errors.New("bus did not report caller uid and pid")
+	}
+	return Peer{UID: int(uid), GID: -1, PID: int(pid)}.withStart(), nil
+}
+
+// dbusNotify keeps the properties current and emits ThreatDetected until
+// the server closes.
+func (s *Server) dbusNotify(conn *dbus.Conn, props *prop.Properties) {
+	for {
+		w := s.daemon.Notifier().Watch(WatchOpts{
+			Kinds:  []string{ipc.KindStateChange, ipc.KindFirewall, ipc.KindThreat},
+			Buffer: watchBuffer,
+			// signals go to the whole bus
+			Restricted: true,
+			UID:        -1,
+		})
+		go func() {
+			<-s.done
+			w.Stop()
+		}()
+		for env := range w.C {
+			s.dbusPublish(conn, props, env)
+		}
+		if !w.Overflowed() {
+			return
+		}
+		// only possible if the bus stalls for a long time; resync
+		slog.Warn("D-Bus notifications fell behind, resyncing")
+		props.SetMust(DBusIface, "State", s.daemon.State().State().String())
+		props.SetMust(DBusIface, "FirewallEnabled", s.daemon.FirewallEnabled())
+	}
+}
+
+func (s *Server) dbusPublish(conn *dbus.Conn, props *prop.Properties, env ipc.Envelope) {
+	payload, err := env.Decode()
+	if err != nil {
+		slog.Warn("bad notification payload", "kind", env.Kind, "error", err)
+		return
+	}
+	switch p := payload.(type) {
+	case *ipc.StateChangeEvent:
+		props.SetMust(DBusIface, "State", p.NewState)
+	case *ipc.FirewallEvent:
+		props.SetMust(DBusIface, "FirewallEnabled", p.Enabled)
+	case *ipc.ThreatEvent:
+		if err := conn.Emit(DBusPath, DBusIface+".ThreatDetected", p.Path, p.Threat, p.Action, p.QuarantineID); err != nil {
+			slog.Warn("failed to emit ThreatDetected", "error", err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// oreon/defense · watchthelight <wtl>
+
+package daemon
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/oreonproject/defense/internal/dbustest"
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+func TestDBusMethodName(t *testing.T) {
+	for cmd, want := range map[string]string{
+		ipc.CmdPing:              "Ping",
+		ipc.CmdFirewallEnable:    "FirewallEnable",
+		ipc.CmdQuarantineRestore: "QuarantineRestore",
+	} {
+		if got := dbusMethodName(cmd); got != want {
+			t.Errorf("dbusMethodName(%q) = %q, want %q", cmd, got, want)
+		}
+	}
+}
+
+func TestServer_DBus(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	addr := dbustest.StartBus(t)
+	if err := server.ExportDBus(dbustest.Connect(t, addr)); err != nil {
+		t.Fatalf("ExportDBus() error = %v", err)
+	}
+
+	if hello := server.hello(); !hello.Has("dbus") {
+		t.Errorf("hello features = %v, want dbus", hello.Features)
+	}
+
+	client := dbustest.Connect(t, addr)
+	if err := client.AddMatchSignal(dbus.WithMatchObjectPath(DBusPath)); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *dbus.Signal, 10)
+	client.Signal(signals)
+	obj := client.Object(DBusName, DBusPath)
+
+	var current string
+	var firewall bool
+	var lastScan, rulesUpdated, pausedUntil int64
+	if err := obj.Call(DBusIface+".Status", 0).Store(&current, &firewall, &lastScan, &rulesUpdated, &pausedUntil); err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if current != StateProtected.String() || pausedUntil != 0 {
+		t.Errorf("Status() = %q, paused until %d", current, pausedUntil)
+	}
+
+	// commands without a signature of their own speak JSON
+	var data string
+	if err := obj.Call(DBusIface+".ScanHistory", 0, `{"limit":5}`).Store(&data); err != nil || !json.Valid([]byte(data)) {
+		t.Errorf("ScanHistory() = %q, %v", data, err)
+	}
+
+	// introspection shows the typed signatures
+	var xml string
+	if err := obj.Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&xml); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<arg name="duration" type="s" direction="in">`, `<arg name="paused_until" type="x" direction="out">`} {
+		if !strings.Contains(xml, want) {
+			t.Errorf("introspection is missing %s", want)
+		}
+	}
+
+	// bad params come back as a D-Bus error
+	err := obj.Call(DBusIface+".Pause", 0, "-5m").Err
+	if dbusErr, ok := err.(dbus.Error); !ok || dbusErr.Name != DBusIface+".Error.InvalidParams" {
+		t.Errorf("Pause(-5m) error = %v, want InvalidParams", err)
+	}
+
+	if err := obj.Call(DBusIface+".Pause", 0, "").Err; err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	sig := waitSignal(t, signals, "org.freedesktop.DBus.Properties.PropertiesChanged")
+	changed := sig.Body[1].(map[string]dbus.Variant)
+	if changed["State"].Value() != StatePaused.String() {
+		t.Errorf("PropertiesChanged = %v, want State %q", changed, StatePaused.String())
+	}
+	state, err := obj.GetProperty(DBusIface + ".State")
+	if err != nil || state.Value() != StatePaused.String() {
+		t.Errorf("State property = %v, %v", state, err)
+	}
+
+	server.daemon.Notifier().Publish(ipc.KindThreat, ipc.ThreatEvent{Path: "/tmp/eicar.com", Threat: "Eicar-Test-Signature", Action: "detected"})
+	sig = waitSignal(t, signals, DBusIface+".ThreatDetected")
+	if sig.Body[0] != "/tmp/eicar.com" || sig.Body[1] != "Eicar-Test-Signature" || sig.Body[2] != "detected" {
+		t.Errorf("ThreatDetected body = %v", sig.Body)
+	}
+}
+
+// waitSignal returns the next signal called name, skipping others.
+func waitSignal(t *testing.T, ch <-chan *dbus.Signal, name string) *dbus.Signal {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case sig := <-ch:
+			if sig.Name == name {
+				return sig
+			}
+		case <-timeout:
+			t.Fatalf("no %s signal", name)
+			return nil
+		}
+	}
+}
+
+func TestServer_DBusContext(t *testing.T) {
+	s := NewServer(t.TempDir()+"/defense.sock", nil)
+	ctx, cancel := s.dbusContext()
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > dbusCallTimeout {
+		t.Errorf("deadline = %v, %v; want within %v", deadline, ok, dbusCallTimeout)
+	}
+
+	// closing the server ends calls still running
+	close(s.done)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled when the server closed")
+	}
+}
@@ -0,0 +1,440 @@
+// oreon/defense · watchthelight <wtl>
+
+package daemon
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/config"
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+// httpRoute maps a REST endpoint onto an IPC command.
+type httpRoute struct {
+	method  string
+	path    string // {name} wildcards fill the param with that JSON name
+	command string
+	params  any // zero value of the params type, nil if the command takes none
+	result  any // zero value of the response data type
+	summary string
+}
+
+// httpRoutes is the REST view of the command set. GET params come from
+// the query string, others from a JSON body; path wildcards override both.
+var httpRoutes = []httpRoute{
+	{"GET", "/v1/ping", ipc.CmdPing, nil, "", "Health check"},
+	{"GET", "/v1/status", ipc.CmdStatus, nil, ipc.StatusResponse{}, "Daemon state, firewall, last scan, rules age"},
+	{"POST", "/v1/pause", ipc.CmdPause, ipc.PauseParams{}, "", "Pause protection"},
+	{"POST", "/v1/resume", ipc.CmdResume, nil, "", "Resume protection"},
+
+	{"GET", "/v1/firewall", ipc.CmdFirewallStatus, nil, ipc.FirewallStatusResponse{}, "Firewall status"},
+	{"POST", "/v1/firewall/enable", ipc.CmdFirewallEnable, nil, "", "Enable the firewall"},
+	{"POST", "/v1/firewall/disable", ipc.CmdFirewallDisable, nil, "", "Disable the firewall"},
+	{"POST", "/v1/firewall/panic", ipc.CmdFirewallPanic, ipc.FirewallPanicParams{}, ipc.FirewallStatusResponse{}, "Block all traffic except loopback, or lift the block"},
+	{"GET", "/v1/firewall/blocked", ipc.CmdFirewallBlocked, ipc.FirewallBlockedParams{}, ipc.FirewallBlockedResponse{}, "Recently blocked connections and top sources"},
+	{"GET", "/v1/bans", ipc.CmdBansList, nil, ipc.BansResponse{}, "Active IDS bans"},
+	{"DELETE", "/v1/bans/{address}", ipc.CmdBanLift, ipc.BanLiftParams{}, "", "Lift a ban early"},
+
+	{"POST", "/v1/scans", ipc.CmdScan, ipc.ScanParams{}, ipc.ScanStatusResponse{}, "Start a scan of the given paths, or a quick or full scan"},
+	{"POST", "/v1/scans/quick", ipc.CmdScanQuick, nil, ipc.ScanResponse{}, "Start a quick scan"},
+	{"POST", "/v1/scans/full", ipc.CmdScanFull, nil, ipc.ScanResponse{}, "Start a full scan"},
+	{"GET", "/v1/scans", ipc.CmdScanHistory, ipc.ScanHistoryParams{}, ipc.ScanHistoryResponse{}, "Finished scans, newest first"},
+	{"GET", "/v1/scans/current", ipc.CmdScanStatus, nil, ipc.ScanStatusResponse{}, "The running or latest scan"},
+	{"GET", "/v1/scans/{job_id}", ipc.CmdScanStatus, ipc.ScanJobParams{}, ipc.ScanStatusResponse{}, "Progress of a scan"},
+	{"DELETE", "/v1/scans/{job_id}", ipc.CmdScanCancel, ipc.ScanJobParams{}, "", "Cancel a scan"},
+
+	{"GET", "/v1/quarantine", ipc.CmdQuarantineList, nil, ipc.QuarantineListResponse{}, "Quarantined files"},
+	{"POST", "/v1/quarantine/{id}/restore", ipc.CmdQuarantineRestore, ipc.QuarantineParams{}, ipc.QuarantineItem{}, "Put a file back where it was"},
+	{"DELETE", "/v1/quarantine/{id}", ipc.CmdQuarantineDelete, ipc.QuarantineParams{}, "", "Delete a quarantined file for good"},
+
+	{"GET", "/v1/events", ipc.CmdEvents, ipc.EventsParams{}, ipc.EventsResponse{}, "Recent daemon events, newest first"},
+
+	{"GET", "/v1/apps/rules", ipc.CmdAppRules, nil, ipc.AppRulesResponse{}, "Per-application rules and what they matched"},
+	{"GET", "/v1/apps/learned", ipc.CmdAppLearned, nil, ipc.AppLearnedResponse{}, "Apps seen in learning mode and proposed rules"},
+	{"PUT", "/v1/apps/learning", ipc.CmdAppLearning, ipc.AppLearningParams{}, ipc.AppRulesResponse{}, "Turn learning mode on or off"},
+	{"GET", "/v1/network", ipc.CmdNetInventory, ipc.NetInventoryParams{}, ipc.NetInventoryResponse{}, "Listening sockets and connections with owning processes"},
+
+	{"GET", "/v1/rules", ipc.CmdRulesStatus, nil, ipc.RulesStatusResponse{}, "Signature and rule-list versions"},
+	{"POST", "/v1/rules/update", ipc.CmdRulesUpdate, nil, ipc.RulesStatusResponse{}, "Start a rules update"},
+	{"POST", "/v1/rules/import", ipc.CmdRulesImport, ipc.RulesImportParams{}, ipc.RulesImportResponse{}, "Install a signed offline rules bundle"},
+}
+
+// sseKeepalive is how often an idle event stream gets a comment line, so
+// proxies and clients can tell it's still alive.
+const sseKeepalive = 15 * time.Second
+
+type peerKey struct{}
+
+// ListenHTTP starts the REST gateway described by cfg. On a unix socket
+// callers are identified by SO_PEERCRED, as on the IPC socket; on a
+// loopback address they must present the bearer token and are treated as
+// root (only root can read the token file). Close stops it.
+func (s *Server) ListenHTTP(cfg config.HTTP) error {
+	var ln net.Listener
+	handler := s.HTTPHandler()
+	srv := &http.Server{ReadHeaderTimeout: 10 * time.Second}
+
+	if cfg.Address != "" {
+		if err := checkLoopback(cfg.Address); err != nil {
+			return err
+		}
+		token, err := loadToken(cfg.TokenFile)
+		if err != nil {
+			return err
+		}
+		if ln, err = net.Listen("tcp", cfg.Address); err != nil {
+			return err
+		}
+		srv.Handler = tokenAuth(token, handler)
+	} else {
+		if err := os.MkdirAll(filepath.Dir(cfg.SocketPath), 0755); err != nil {
+			return err
+		}
+		os.Remove(cfg.SocketPath)
+		var err error
+		if ln, err = net.Listen("unix", cfg.SocketPath); err != nil {
+			return err
+		}
+		// per-command policy decides who may do what, like the IPC socket
+		if err := os.Chmod(cfg.SocketPath, 0666); err != nil {
+			ln.Close()
+			return err
+		}
+		srv.Handler = handler
+		srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+			peer, err := s.credentials(c)
+			if err != nil {
+				slog.Warn("failed to read peer credentials", "error", err)
+				peer = Peer{UID: -1, GID: -1, PID: -1}
+			}
+			return context.WithValue(ctx, peerKey{}, peer)
+		}
+	}
+
+	s.connMu.Lock()
+	s.httpSrv = srv
+	s.connMu.Unlock()
+	slog.Info("HTTP gateway listening", "address", ln.Addr())
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP gateway stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// tokenAuth lets requests with the bearer token through as root.
+func tokenAuth(token string, next http.Handler) http.Handler {
+	root := Peer{UID: 0, GID: 0, PID: -1}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeHTTPError(w, http.StatusUnauthorized, &ipc.Error{Code: ipc.CodePermissionDenied, Message: "missing or wrong bearer token"})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), peerKey{}, root)))
+	})
+}
+
+// checkLoopback refuses addresses reachable from other machines.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("http.address: %w", err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("http.address %q is not a loopback address", addr)
+	}
+	return nil
+}
+
+// loadToken reads the bearer token, creating a random one if the file
+// doesn't exist yet.
+func loadToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+		return "", fmt.Errorf("http token file %s is empty", path)
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	slog.Info("created HTTP gateway token", "path", path)
+	return token, nil
+}
+
+// HTTPHandler serves the REST endpoints, the event stream and the OpenAPI
+// document. The caller's Peer must be in the request context.
+func (s *Server) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	for _, rt := range httpRoutes {
+		mux.HandleFunc(rt.method+" "+rt.path, func(w http.ResponseWriter, r *http.Request) {
+			s.serveCommand(w, r, rt)
+		})
+	}
+	mux.HandleFunc("GET /v1/events/stream", s.serveEventStream)
+
+	doc, err := json.MarshalIndent(openAPIDoc(), "", "  ")
+	if err != nil {
+		panic("openapi: " + err.Error()) // only if the ipc types can't be marshaled
+	}
+	mux.HandleFunc("GET /v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	})
+	return mux
+}
+
+func requestPeer(r *http.Request) Peer {
+	if p, ok := r.Context().Value(peerKey{}).(Peer); ok {
+		return p
+	}
+	return Peer{UID: -1, GID: -1, PID: -1}
+}
+
+// serveCommand runs one route's command through handleRequest.
+func (s *Server) serveCommand(w http.ResponseWriter, r *http.Request, rt httpRoute) {
+	req := &ipc.Request{ID: "http", Command: rt.command, Version: ipc.ProtocolVersion}
+	if rt.params != nil {
+		params, err := routeParams(r, reflect.TypeOf(rt.params))
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, &ipc.Error{Code: ipc.CodeInvalidParams, Message: err.Error()})
+			return
+		}
+		req.Params = params
+	}
+
+	resp := s.handleRequest(r.Context(), requestPeer(r), req)
+	if !resp.Success {
+		writeHTTPError(w, httpStatus(resp.Code), &ipc.Error{Code: resp.Code, Message: resp.Error, Details: resp.Details})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp.Data)
+}
+
+// httpStatus picks a status code for a failed command's error code.
+func httpStatus(code string) int {
+	switch code {
+	case ipc.CodePermissionDenied:
+		return http.StatusForbidden
+	case ipc.CodeUnknownCommand, ipc.CodeNotFound:
+		return http.StatusNotFound
+	case ipc.CodeInvalidParams, ipc.CodeVersionMismatch:
+		return http.StatusBadRequest
+	case ipc.CodeBusy:
+		return http.StatusConflict
+	case ipc.CodeUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// httpError is the body of every failed request. An alias so OpenAPI
+// shows it inline rather than as a component.
+type httpError = struct {
+	Error   string         `json:"error"`
+	Code    string         `json:"code"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+func writeHTTPError(w http.ResponseWriter, status int, err error) {
+	body := httpError{Error: err.Error(), Code: errorCode(err)}
+	var ipcErr *ipc.Error
+	if errors.As(err, &ipcErr) {
+		body.Details = ipcErr.Details
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// routeParams assembles a command's params from the JSON body, the query
+// string and path wildcards, in increasing precedence.
+func routeParams(r *http.Request, typ reflect.Type) (json.RawMessage, error) {
+	fields := make(map[string]any)
+	if r.Method != http.MethodGet {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			return nil, err
+		}
+		if len(strings.TrimSpace(string(body))) > 0 {
+			if err := json.Unmarshal(body, &fields); err != nil {
+				return nil, fmt.Errorf("invalid JSON body: %w", err)
+			}
+		}
+	}
+
+	query := r.URL.Query()
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		name := jsonName(f)
+		if name == "" {
+			continue
+		}
+		v := r.PathValue(name)
+		if v == "" {
+			v = query.Get(name)
+		}
+		if v == "" {
+			continue
+		}
+		val, err := queryValue(f.Type, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		fields[name] = val
+	}
+	return json.Marshal(fields)
+}
+
+// queryValue converts a query string value to what the JSON field expects.
+func queryValue(t reflect.Type, v string) (any, error) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return strconv.ParseBool(v)
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return strconv.Atoi(v)
+	case reflect.Float64:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return v, nil // strings, and times in RFC 3339
+	}
+}
+
+// serveEventStream sends notifications as Server-Sent Events: first a
+// "watch" event with the WatchResponse, then one event per notification
+// named after its kind, with the Envelope as data and "epoch:seq" as id.
+// Reconnecting with Last-Event-ID resumes like ipc.WatchParams.
+//
+// Query: kinds (comma-separated), and type, component, success to filter
+// ipc.KindEvent.
+func (s *Server) serveEventStream(w http.ResponseWriter, r *http.Request) {
+	peer := requestPeer(r)
+	if err := s.authorize(r.Context(), peer, ipc.CmdWatch); err != nil {
+		writeHTTPError(w, http.StatusForbidden, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeHTTPError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+
+	q := r.URL.Query()
+	filter := ipc.SubscribeEventsParams{Type: q.Get("type"), Component: q.Get("component")}
+	if v := q.Get("success"); v != "" {
+		ok, err := strconv.ParseBool(v)
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, &ipc.Error{Code: ipc.CodeInvalidParams, Message: "invalid success: " + err.Error()})
+			return
+		}
+		filter.Success = &ok
+	}
+	opts := WatchOpts{Events: eventFilter(&filter), Buffer: watchBuffer}
+	opts.Restricted, opts.UID = !s.privileged(peer), peer.UID
+	if kinds := q.Get("kinds"); kinds != "" {
+		opts.Kinds = strings.Split(kinds, ",")
+	}
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		epoch, seq, _ := strings.Cut(last, ":")
+		after, err := strconv.ParseUint(seq, 10, 64)
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, invalidParams("invalid Last-Event-ID %q", last))
+			return
+		}
+		opts.Resume, opts.Epoch, opts.After = true, epoch, after
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	opts.OnOverflow = cancel
+	watcher := s.daemon.Notifier().Watch(opts)
+	defer watcher.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	epoch := s.daemon.Notifier().Epoch()
+	writeSSE(w, "watch", "", s.watchResponse(watcher))
+	flusher.Flush()
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case env, ok := <-watcher.C:
+			if !ok {
+				return // fell behind; the client resumes from its last id
+			}
+			writeSSE(w, env.Kind, epoch+":"+strconv.FormatUint(env.Seq, 10), env)
+		}
+		flusher.Flush()
+	}
+}
+
+func writeSSE(w io.Writer, event, id string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Warn("failed to encode SSE event", "event", event, "error", err)
+		return
+	}
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
+
+// jsonName is the JSON key for a struct field, "" if it isn't encoded.
+func jsonName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return f.Name
+	}
+	return name
+}
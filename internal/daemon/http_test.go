@@ -0,0 +1,280 @@
+// oreon/defense · watchthelight <wtl>
+
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oreonproject/defense/pkg/config"
+	"github.com/oreonproject/defense/pkg/events"
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+// setupHTTP starts the gateway on a unix socket for a server whose
+// clients connect as peer, and returns a client for it.
+func setupHTTP(t *testing.T, peer *Peer) (*Server, *http.Client) {
+	t.Helper()
+	server, _, cleanup := setupTestServerAs(t, peer)
+	t.Cleanup(cleanup)
+
+	sock := filepath.Join(t.TempDir(), "http.sock")
+	if err := server.ListenHTTP(config.HTTP{Enabled: true, SocketPath: sock}); err != nil {
+		t.Fatalf("ListenHTTP() error = %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	return server, client
+}
+
+func doHTTP(t *testing.T, c *http.Client, method, path, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, "http://defense"+path, strings.NewReader(body))
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestHTTP_Commands(t *testing.T) {
+	server, client := setupHTTP(t, &Peer{UID: 1000, GID: 1000, PID: -1})
+
+	code, body := doHTTP(t, client, "GET", "/v1/status", "")
+	var status ipc.StatusResponse
+	if code != 200 || json.Unmarshal([]byte(body), &status) != nil || status.State != StateProtected.String() {
+		t.Errorf("GET /v1/status = %d %s", code, body)
+	}
+
+	// same policy as the socket
+	if code, body := doHTTP(t, client, "POST", "/v1/pause", ""); code != http.StatusForbidden {
+		t.Errorf("POST /v1/pause as uid 1000 = %d %s, want 403", code, body)
+	}
+	if code, body := doHTTP(t, client, "GET", "/v1/scans?limit=lots", ""); code != http.StatusBadRequest {
+		t.Errorf("GET /v1/scans?limit=lots = %d %s, want 400", code, body)
+	}
+
+	server.daemon.Scans().Start("quick")
+	code, body = doHTTP(t, client, "GET", "/v1/scans/current", "")
+	if code != 200 || !strings.Contains(body, `"status":"running"`) {
+		t.Errorf("GET /v1/scans/current = %d %s", code, body)
+	}
+	if code, body := doHTTP(t, client, "GET", "/v1/scans/nope", ""); code != http.StatusNotFound || !strings.Contains(body, `"code":"not_found"`) {
+		t.Errorf("GET /v1/scans/nope = %d %s", code, body)
+	}
+}
+
+func TestHTTP_Params(t *testing.T) {
+	_, client := setupHTTP(t, &Peer{UID: 0, GID: 0, PID: -1})
+
+	// body and path both feed the params
+	code, body := doHTTP(t, client, "POST", "/v1/pause", `{"duration":"-5m"}`)
+	if code != http.StatusBadRequest || !strings.Contains(body, "invalid pause duration") {
+		t.Errorf("POST /v1/pause -5m = %d %s", code, body)
+	}
+	code, body = doHTTP(t, client, "DELETE", "/v1/bans/not-an-ip", "")
+	if code != http.StatusBadRequest || !strings.Contains(body, "invalid address") {
+		t.Errorf("DELETE /v1/bans/not-an-ip = %d %s", code, body)
+	}
+	if code, body := doHTTP(t, client, "POST", "/v1/pause", `{"duration":"1h"}`); code != 200 {
+		t.Errorf("POST /v1/pause 1h = %d %s", code, body)
+	}
+	if code, body := doHTTP(t, client, "GET", "/v1/events?type=state_change&success=true&limit=1", ""); code != 200 || !strings.Contains(body, "paused") {
+		t.Errorf("GET /v1/events = %d %s", code, body)
+	}
+}
+
+func TestHTTP_Token(t *testing.T) {
+	h := tokenAuth("s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestPeer(r).UID != 0 {
+			t.Error("token holder isn't root")
+		}
+	}))
+	for header, want := range map[string]int{
+		"":               http.StatusUnauthorized,
+		"Bearer wrong":   http.StatusUnauthorized,
+		"s3cret":         http.StatusUnauthorized,
+		"Bearer s3cret":  http.StatusOK,
+		"Bearer s3cret ": http.StatusUnauthorized,
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/v1/status", nil)
+		req.Header.Set("Authorization", header)
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("Authorization %q = %d, want %d", header, rec.Code, want)
+		}
+	}
+
+	for addr, ok := range map[string]bool{
+		"127.0.0.1:8470": true,
+		"[::1]:8470":     true,
+		"localhost:8470": true,
+		"0.0.0.0:8470":   false,
+		"10.0.0.1:8470":  false,
+		"127.0.0.1":      false,
+	} {
+		if err := checkLoopback(addr); (err == nil) != ok {
+			t.Errorf("checkLoopback(%q) = %v", addr, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "token")
+	token, err := loadToken(path)
+	if err != nil || len(token) != 64 {
+		t.Fatalf("loadToken() = %q, %v", token, err)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0600 {
+		t.Errorf("token file mode = %v", fi.Mode())
+	}
+	if again, _ := loadToken(path); again != token {
+		t.Error("token changed on second load")
+	}
+}
+
+// sseEvent is one parsed Server-Sent Event.
+type sseEvent struct {
+	id, event, data string
+}
+
+func readSSE(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	var e sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if e.event != "" {
+				return e
+			}
+		case strings.HasPrefix(line, "id: "):
+			e.id = line[4:]
+		case strings.HasPrefix(line, "event: "):
+			e.event = line[7:]
+		case strings.HasPrefix(line, "data: "):
+			e.data = line[6:]
+		}
+	}
+}
+
+func TestHTTP_EventStream(t *testing.T) {
+	server, client := setupHTTP(t, nil)
+	n := server.daemon.Notifier()
+
+	stream := func(lastID string) (*bufio.Reader, func()) {
+		req, _ := http.NewRequest("GET", "http://defense/v1/events/stream?kinds=threat", nil)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Content-Type = %q", ct)
+		}
+		return bufio.NewReader(resp.Body), func() { resp.Body.Close() }
+	}
+
+	r, stop := stream("")
+	if e := readSSE(t, r); e.event != "watch" || !strings.Contains(e.data, n.Epoch()) {
+		t.Fatalf("first event = %+v, want watch ack", e)
+	}
+	n.Publish(ipc.KindStateChange, ipc.StateChangeEvent{NewState: "paused"}) // filtered out
+	n.Publish(ipc.KindThreat, ipc.ThreatEvent{Path: "/tmp/a", Threat: "Eicar"})
+	e := readSSE(t, r)
+	var env ipc.Envelope
+	if e.event != ipc.KindThreat || json.Unmarshal([]byte(e.data), &env) != nil || !strings.Contains(string(env.Payload), "/tmp/a") {
+		t.Fatalf("event = %+v", e)
+	}
+	stop()
+
+	// resume from the last id and get only what was missed
+	n.Publish(ipc.KindThreat, ipc.ThreatEvent{Path: "/tmp/b", Threat: "Eicar"})
+	r, stop = stream(e.id)
+	defer stop()
+	readSSE(t, r)
+	if e := readSSE(t, r); !strings.Contains(e.data, "/tmp/b") {
+		t.Errorf("resumed event = %+v, want /tmp/b", e)
+	}
+}
+
+func TestHTTP_EventStreamPrivate(t *testing.T) {
+	server, client := setupHTTP(t, &Peer{UID: 1000, GID: 1000, PID: -1})
+	n := server.daemon.Notifier()
+
+	resp, err := client.Get("http://defense/v1/events/stream?kinds=threat,event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	readSSE(t, r) // watch ack
+
+	// another user's findings never reach this stream
+	n.PublishFor(1001, ipc.KindThreat, ipc.ThreatEvent{Path: "/home/bob/x"})
+	n.Write(events.StartThreat("/home/bob/x", "Eicar").Owner(1001).End())
+	n.PublishFor(1000, ipc.KindThreat, ipc.ThreatEvent{Path: "/home/alice/x"})
+	n.Write(events.StartThreat("/home/alice/x", "Eicar").Owner(1000).End())
+
+	for _, kind := range []string{ipc.KindThreat, ipc.KindEvent} {
+		e := readSSE(t, r)
+		if e.event != kind || !strings.Contains(e.data, "/home/alice/x") {
+			t.Errorf("event = %+v, want own %s", e, kind)
+		}
+	}
+}
+
+func TestOpenAPIDoc(t *testing.T) {
+	doc := openAPIDoc()
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := doc["paths"].(map[string]map[string]any)
+	ids := make(map[string]bool)
+	for _, rt := range httpRoutes {
+		op, ok := paths[rt.path][strings.ToLower(rt.method)].(map[string]any)
+		if !ok {
+			t.Errorf("%s %s missing", rt.method, rt.path)
+			continue
+		}
+		id := op["operationId"].(string)
+		if ids[id] {
+			t.Errorf("duplicate operationId %q", id)
+		}
+		ids[id] = true
+	}
+
+	// every $ref points at a generated component
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	for _, ref := range strings.Split(string(data), `"$ref":"#/components/schemas/`)[1:] {
+		name, _, _ := strings.Cut(ref, `"`)
+		if schemas[name] == nil {
+			t.Errorf("dangling $ref %s", name)
+		}
+	}
+	status := schemas["StatusResponse"].(map[string]any)["properties"].(map[string]any)
+	if status["last_scan"].(map[string]any)["format"] != "date-time" {
+		t.Errorf("StatusResponse.last_scan = %v", status["last_scan"])
+	}
+}
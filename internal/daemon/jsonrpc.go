@@ -0,0 +1,206 @@
+// oreon/defense · watchthelight <wtl>
+
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+// isJSONRPC reports whether a connection's first message is JSON-RPC 2.0.
+func isJSONRPC(line []byte) bool {
+	line = bytes.TrimSpace(line)
+	if len(line) > 0 && line[0] == '[' {
+		return true // batches only exist in JSON-RPC
+	}
+	var probe struct {
+		JSONRPC string `json:"jsonrpc"`
+	}
+	return json.Unmarshal(line, &probe) == nil && probe.JSONRPC == ipc.JSONRPCVersion
+}
+
+// serveRPC answers one line of JSON-RPC: a request, a notification or a
+// batch of them.
+func (c *clientConn) serveRPC(line []byte) error {
+	line = bytes.TrimSpace(line)
+	if !json.Valid(line) {
+		return c.writer.write(rpcError(nil, ipc.RPCParseError, "parse error"))
+	}
+	if line[0] != '[' {
+		if id, ok := rpcConcurrent(line); ok {
+			// like v1 requests with IDs: concurrent, answered out of order
+			c.run(id, func(ctx context.Context) {
+				if resp, _ := c.rpcCall(ctx, line); resp != nil {
+					c.writer.write(resp)
+				}
+			})
+			return nil
+		}
+		resp, start := c.rpcCall(c.ctx, line)
+		if resp != nil {
+			if err := c.writer.write(resp); err != nil {
+				return err
+			}
+		}
+		start()
+		return nil
+	}
+
+	var batch []json.RawMessage
+	json.Unmarshal(line, &batch)
+	if len(batch) == 0 {
+		return c.writer.write(rpcError(nil, ipc.RPCInvalidRequest, "empty batch"))
+	}
+	// calls run the same as on their own, each cancellable by its id; the
+	// rest are answered right here
+	resps := make([]*ipc.RPCResponse, len(batch))
+	var starts []func()
+	var wg sync.WaitGroup
+	for i, raw := range batch {
+		if id, ok := rpcConcurrent(raw); ok {
+			wg.Add(1)
+			c.run(id, func(ctx context.Context) {
+				defer wg.Done()
+				resps[i], _ = c.rpcCall(ctx, raw)
+			})
+			continue
+		}
+		resp, start := c.rpcCall(c.ctx, raw)
+		resps[i] = resp
+		starts = append(starts, start)
+	}
+	// the reply waits for every call, but the read loop doesn't
+	go func() {
+		wg.Wait()
+		var out []*ipc.RPCResponse
+		for _, resp := range resps {
+			if resp != nil {
+				out = append(out, resp)
+			}
+		}
+		// a batch of notifications gets nothing back
+		if len(out) > 0 {
+			if err := c.writer.write(out); err != nil {
+				return
+			}
+		}
+		for _, start := range starts {
+			start()
+		}
+	}()
+	return nil
+}
+
+// rpcConcurrent reports whether a JSON-RPC message is a call to run in its
+// own goroutine, and the key to cancel it by. Notifications, cancels and
+// subscriptions are handled in order on the read loop.
+func rpcConcurrent(raw json.RawMessage) (string, bool) {
+	var req ipc.RPCRequest
+	if json.Unmarshal(raw, &req) != nil || req.ID == nil || req.Method == ipc.CmdCancel || isSubscription(req.Method) {
+		return "", false
+	}
+	return rpcKey(req.ID), true
+}
+
+// rpcCall runs one JSON-RPC request through dispatch. The response is nil
+// for notifications.
+func (c *clientConn) rpcCall(ctx context.Context, raw json.RawMessage) (*ipc.RPCResponse, func()) {
+	noop := func() {}
+	var req ipc.RPCRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.JSONRPC != ipc.JSONRPCVersion || req.Method == "" {
+		return rpcError(req.ID, ipc.RPCInvalidRequest, "invalid request"), noop
+	}
+	// our params are always objects
+	if p := bytes.TrimSpace(req.Params); len(p) > 0 && p[0] != '{' && string(p) != "null" {
+		if req.ID == nil {
+			return nil, noop
+		}
+		return rpcError(req.ID, ipc.RPCInvalidParams, "params must be an object"), noop
+	}
+
+	if req.Method == ipc.CmdCancel {
+		return c.rpcCancel(req), noop
+	}
+
+	resp, start := c.dispatch(ctx, &ipc.Request{
+		Version: ipc.ProtocolVersion,
+		Command: req.Method,
+		Params:  req.Params,
+	})
+	if req.ID == nil {
+		return nil, start
+	}
+	if !resp.Success {
+		rpcResp := rpcError(req.ID, rpcCode(resp.Code), resp.Error)
+		rpcResp.Error.Data = map[string]any{"code": resp.Code, "details": resp.Details}
+		return rpcResp, start
+	}
+	result := resp.Data
+	if len(result) == 0 {
+		result = json.RawMessage("null")
+	}
+	return &ipc.RPCResponse{JSONRPC: ipc.JSONRPCVersion, Result: result, ID: req.ID}, start
+}
+
+// rpcCancel handles a cancel message, which as a notification (the usual
+// way) gets no response.
+func (c *clientConn) rpcCancel(req ipc.RPCRequest) *ipc.RPCResponse {
+	var params ipc.CancelParams
+	json.Unmarshal(req.Params, &params)
+	found := len(params.ID) > 0 && c.cancelRequest(rpcKey(params.ID))
+	switch {
+	case req.ID == nil:
+		return nil
+	case len(params.ID) == 0:
+		return rpcError(req.ID, ipc.RPCInvalidParams, "missing id")
+	case !found:
+		return rpcError(req.ID, ipc.RPCFailed, "no running request "+string(params.ID))
+	}
+	return &ipc.RPCResponse{JSONRPC: ipc.JSONRPCVersion, Result: json.RawMessage(`"cancel requested"`), ID: req.ID}
+}
+
+// rpcKey normalizes a JSON-RPC id for looking up running requests, so 1
+// and 1.0 or differently spaced strings still match.
+func rpcKey(id json.RawMessage) string {
+	var v any
+	if json.Unmarshal(id, &v) != nil {
+		return string(id)
+	}
+	key, _ := json.Marshal(v)
+	return string(key)
+}
+
+func rpcError(id json.RawMessage, code int, msg string) *ipc.RPCResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &ipc.RPCResponse{
+		JSONRPC: ipc.JSONRPCVersion,
+		Error:   &ipc.RPCError{Code: code, Message: msg},
+		ID:      id,
+	}
+}
+
+// rpcCode picks the JSON-RPC error code for a failed command. The ipc
+// code itself goes in the error's data.
+func rpcCode(code string) int {
+	switch code {
+	case ipc.CodeUnknownCommand:
+		return ipc.RPCMethodNotFound
+	case ipc.CodeInvalidParams:
+		return ipc.RPCInvalidParams
+	case ipc.CodePermissionDenied:
+		return ipc.RPCPermissionDenied
+	default:
+		return ipc.RPCFailed
+	}
+}
+
+// rpcNotification wraps a pushed envelope for JSON-RPC clients.
+func rpcNotification(env ipc.Envelope) any {
+	return ipc.RPCNotification{JSONRPC: ipc.JSONRPCVersion, Method: ipc.RPCNotifyMethod, Params: env}
+}
@@ -0,0 +1,147 @@
+// oreon/defense · watchthelight <wtl>
+
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+// rpcConn sends raw lines and reads raw lines back.
+type rpcConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialRPC(t *testing.T, sockPath string) *rpcConn {
+	t.Helper()
+	conn, err := net.Dial("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &rpcConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *rpcConn) call(line string, v any) {
+	c.t.Helper()
+	if line != "" {
+		c.conn.Write([]byte(line + "\n"))
+	}
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		c.t.Fatalf("read after %s: %v", line, err)
+	}
+	if err := json.Unmarshal(resp, v); err != nil {
+		c.t.Fatalf("unmarshal %s: %v", resp, err)
+	}
+}
+
+func TestServer_JSONRPC(t *testing.T) {
+	_, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+	c := dialRPC(t, sockPath)
+
+	var resp ipc.RPCResponse
+	c.call(`{"jsonrpc":"2.0","id":7,"method":"status"}`, &resp)
+	var status ipc.StatusResponse
+	if resp.Error != nil || string(resp.ID) != "7" || json.Unmarshal(resp.Result, &status) != nil || status.State != StateProtected.String() {
+		t.Fatalf("status = %+v", resp)
+	}
+
+	for _, tt := range []struct {
+		line string
+		code int
+	}{
+		{`{"jsonrpc":"2.0","id":"a","method":"nope"}`, ipc.RPCMethodNotFound},
+		{`{"jsonrpc":"2.0","id":"a","method":"scan_history","params":[1]}`, ipc.RPCInvalidParams},
+		{`{"jsonrpc":"2.0","id":"a","method":"scan_history","params":{"limit":"x"}}`, ipc.RPCInvalidParams},
+		{`{"jsonrpc":"1.0","id":"a","method":"status"}`, ipc.RPCInvalidRequest},
+		{`{"jsonrpc":"2.0","id":"a","method":`, ipc.RPCParseError},
+	} {
+		resp = ipc.RPCResponse{}
+		c.call(tt.line, &resp)
+		if resp.Error == nil || resp.Error.Code != tt.code {
+			t.Errorf("%s -> %+v, want code %d", tt.line, resp.Error, tt.code)
+		}
+		if tt.code == ipc.RPCParseError && string(resp.ID) != "null" {
+			t.Errorf("parse error id = %s, want null", resp.ID)
+		}
+	}
+
+	// notifications get no response; the batch answers only the calls
+	var batch []ipc.RPCResponse
+	c.call(`[{"jsonrpc":"2.0","method":"ping"},{"jsonrpc":"2.0","id":1,"method":"ping"},{"jsonrpc":"2.0","id":2,"method":"nope"},5]`, &batch)
+	if len(batch) != 3 || string(batch[0].Result) != `"pong"` || batch[1].Error.Code != ipc.RPCMethodNotFound || batch[2].Error.Code != ipc.RPCInvalidRequest {
+		t.Errorf("batch = %+v", batch)
+	}
+}
+
+func TestServer_JSONRPCDenied(t *testing.T) {
+	_, sockPath, cleanup := setupTestServerAs(t, &Peer{UID: 1000, GID: 1000, PID: -1})
+	defer cleanup()
+	c := dialRPC(t, sockPath)
+
+	var resp ipc.RPCResponse
+	c.call(`{"jsonrpc":"2.0","id":"a","method":"pause"}`, &resp)
+	if resp.Error == nil || resp.Error.Code != ipc.RPCPermissionDenied {
+		t.Errorf("pause as uid 1000 = %+v, want code %d", resp.Error, ipc.RPCPermissionDenied)
+	}
+}
+
+func TestServer_JSONRPCWatch(t *testing.T) {
+	server, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+	c := dialRPC(t, sockPath)
+
+	var resp ipc.RPCResponse
+	c.call(`{"jsonrpc":"2.0","id":1,"method":"watch","params":{"kinds":["threat"]}}`, &resp)
+	var ack ipc.WatchResponse
+	if resp.Error != nil || json.Unmarshal(resp.Result, &ack) != nil || ack.Epoch == "" {
+		t.Fatalf("watch = %+v", resp)
+	}
+
+	server.daemon.Notifier().Publish(ipc.KindThreat, ipc.ThreatEvent{Path: "/tmp/eicar.com"})
+	var note ipc.RPCNotification
+	c.call("", &note)
+	if note.Method != ipc.RPCNotifyMethod || note.Params.Kind != ipc.KindThreat || !strings.Contains(string(note.Params.Payload), "eicar") {
+		t.Errorf("notification = %+v", note)
+	}
+}
+
+func TestServer_V1StillDefault(t *testing.T) {
+	_, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+	c := dialRPC(t, sockPath)
+
+	// a v1 connection stays v1 even if a later line looks like JSON-RPC
+	var resp ipc.Response
+	c.call(`{"id":"1","cmd":"ping"}`, &resp)
+	if !resp.Success || resp.ID != "1" {
+		t.Fatalf("v1 ping = %+v", resp)
+	}
+	resp = ipc.Response{}
+	c.call(`{"jsonrpc":"2.0","id":"2","method":"ping"}`, &resp)
+	if resp.Success || !strings.Contains(resp.Error, "unknown command") {
+		t.Errorf("JSON-RPC line on a v1 connection = %+v", resp)
+	}
+}
+
+func TestRPCKey(t *testing.T) {
+	for _, tt := range [][2]string{{`1`, `1.0`}, {`"a"`, ` "a"`}, {`"\u0061"`, `"a"`}} {
+		if rpcKey(json.RawMessage(tt[0])) != rpcKey(json.RawMessage(tt[1])) {
+			t.Errorf("rpcKey(%s) != rpcKey(%s)", tt[0], tt[1])
+		}
+	}
+	if rpcKey(json.RawMessage(`1`)) == rpcKey(json.RawMessage(`"1"`)) {
+		t.Error("rpcKey(1) == rpcKey(\"1\")")
+	}
+}
@@ -0,0 +1,257 @@
+// oreon/defense · watchthelight <wtl>
+
+package daemon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/events"
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+// notifyHistory is how many notifications are kept for watchers resuming
+// after a dropped connection.
+const notifyHistory = 1024
+
+// Notifier fans typed notifications (state changes, scan progress,
+// threats, ...) out to watching clients. It's also an events.Sink, so
+// every emitted wide event goes out as ipc.KindEvent.
+//
+// The last notifyHistory notifications are kept so a client that
+// reconnects can pick up where it left off. Sequence numbers restart with
+// the daemon; the epoch tells clients when that happened.
+// Thread-safe.
+type Notifier struct {
+	mu       sync.Mutex
+	epoch    string
+	seq      uint64
+	history  []notification // ring, oldest at history[next] once full
+	next     int
+	watchers map[*Watcher]struct{}
+}
+
+// notification is a published envelope plus, for ipc.KindEvent, the
+// original event so watcher filters can be applied.
+type notification struct {
+	env   ipc.Envelope
+	evt   events.Event
+	owner int // uid a private notification is for, -1 = everyone
+}
+
+// WatchOpts selects what a watcher receives.
+type WatchOpts struct {
+	Kinds  []string      // empty = every kind
+	Events events.Filter // applied to ipc.KindEvent
+	Buffer int           // notifications that may queue before the watcher overflows
+
+	// Restricted watchers only get private notifications (what someone's
+	// own custom scan found) if they're for UID.
+	Restricted bool
+	UID        int
+
+	// OnOverflow is called (with the notifier locked, so it mustn't
+	// block) when the watcher is dropped for falling behind.
+	OnOverflow func()
+
+	// Resume replays buffered notifications after seq After from epoch
+	// Epoch. A different epoch means the daemon restarted, so everything
+	// buffered is replayed.
+	Resume bool
+	Epoch  string
+	After  uint64
+}
+
+// Watcher is one client's view of the notification feed.
+type Watcher struct {
+	C <-chan ipc.Envelope
+
+	// Missed is set when Resume couldn't replay everything the client
+	// hadn't seen, so it should re-read whatever state it shows.
+	Missed bool
+
+	n          *Notifier
+	onOverflow func()
+	restricted bool
+	uid        int
+	kinds      map[string]bool // nil = every kind
+	events     events.Filter
+	ch         chan ipc.Envelope
+	once       sync.Once
+	overflowed bool // guarded by Notifier.mu
+}
+
+// NewNotifier creates a notifier with nobody watching.
+func NewNotifier() *Notifier {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &Notifier{
+		epoch:    hex.EncodeToString(b),
+		watchers: make(map[*Watcher]struct{}),
+	}
+}
+
+// Epoch identifies this run of the daemon.
+func (n *Notifier) Epoch() string {
+	return n.epoch
+}
+
+// Seq returns the sequence number of the latest notification.
+func (n *Notifier) Seq() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.seq
+}
+
+// Watch starts a watcher. A watcher whose queue fills up is dropped (its
+// channel closes and Overflowed reports true) rather than blocking the
+// publisher; it can resume from the last seq it saw.
+func (n *Notifier) Watch(o WatchOpts) *Watcher {
+	w := &Watcher{n: n, events: o.Events, onOverflow: o.OnOverflow, restricted: o.Restricted, uid: o.UID}
+	if len(o.Kinds) > 0 {
+		w.kinds = make(map[string]bool, len(o.Kinds))
+		for _, k := range o.Kinds {
+			w.kinds[k] = true
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var replay []ipc.Envelope
+	if o.Resume {
+		after := o.After
+		if o.Epoch != n.epoch {
+			after, w.Missed = 0, true
+		}
+		oldest := n.seq - uint64(len(n.history)) + 1
+		if n.seq > 0 && after+1 < oldest {
+			w.Missed = true
+		}
+		for _, nt := range n.ordered() {
+			if nt.env.Seq > after && w.wants(nt) {
+				replay = append(replay, nt.env)
+			}
+		}
+	}
+
+	w.ch = make(chan ipc.Envelope, o.Buffer+len(replay))
+	w.C = w.ch
+	for _, env := range replay {
+		w.ch <- env
+	}
+	n.watchers[w] = struct{}{}
+	return w
+}
+
+// Stop ends the watch and closes C. Safe to call more than once.
+func (w *Watcher) Stop() {
+	w.n.mu.Lock()
+	defer w.n.mu.Unlock()
+	w.n.remove(w)
+}
+
+// Overflowed reports whether the watcher was dropped for falling behind.
+func (w *Watcher) Overflowed() bool {
+	w.n.mu.Lock()
+	defer w.n.mu.Unlock()
+	return w.overflowed
+}
+
+// remove must be called with n.mu held.
+func (n *Notifier) remove(w *Watcher) {
+	delete(n.watchers, w)
+	w.once.Do(func() { close(w.ch) })
+}
+
+// Publish sends payload to everyone watching kind.
+func (n *Notifier) Publish(kind string, payload any) {
+	n.publish(kind, payload, events.Event{}, -1)
+}
+
+// PublishFor sends payload only to uid and unrestricted watchers.
+func (n *Notifier) PublishFor(uid int, kind string, payload any) {
+	n.publish(kind, payload, events.Event{}, uid)
+}
+
+// Write implements events.Sink. Events with an owner are private to them.
+func (n *Notifier) Write(evt events.Event) {
+	n.publish(ipc.KindEvent, ipcEvent(evt), evt, evt.Owner())
+}
+
+// publish delivers a notification. evt is the original wide event for
+// ipc.KindEvent so per-watcher filters can be applied.
+func (n *Notifier) publish(kind string, payload any, evt events.Event, owner int) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("failed to marshal notification", "kind", kind, "error", err)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seq++
+	nt := notification{
+		env:   ipc.Envelope{Kind: kind, Seq: n.seq, Time: time.Now(), Payload: data},
+		evt:   evt,
+		owner: owner,
+	}
+	n.record(nt)
+
+	for w := range n.watchers {
+		if !w.wants(nt) {
+			continue
+		}
+		select {
+		case w.ch <- nt.env:
+		default:
+			w.overflowed = true
+			n.remove(w)
+			if w.onOverflow != nil {
+				w.onOverflow()
+			}
+		}
+	}
+}
+
+func (n *Notifier) record(nt notification) {
+	if len(n.history) < notifyHistory {
+		n.history = append(n.history, nt)
+		return
+	}
+	n.history[n.next] = nt
+	n.next = (n.next + 1) % notifyHistory
+}
+
+// ordered returns the history oldest first. Must be called with n.mu held.
+func (n *Notifier) ordered() []notification {
+	return append(n.history[n.next:len(n.history):len(n.history)], n.history[:n.next]...)
+}
+
+func (w *Watcher) wants(nt notification) bool {
+	if w.kinds != nil && !w.kinds[nt.env.Kind] {
+		return false
+	}
+	if w.restricted && nt.owner >= 0 && nt.owner != w.uid {
+		return false
+	}
+	return nt.env.Kind != ipc.KindEvent || w.events.Match(nt.evt)
+}
+
+// ipcEvent converts a wide event to its wire form.
+func ipcEvent(evt events.Event) ipc.Event {
+	return ipc.Event{
+		Type:        string(evt.Type),
+		OperationID: evt.OperationID,
+		Component:   evt.Component,
+		StartedAt:   evt.StartedAt,
+		DurationMs:  evt.DurationMs,
+		Success:     evt.Success,
+		Error:       evt.Error,
+		Fields:      evt.Fields,
+	}
+}
@@ -0,0 +1,117 @@
+// oreon/defense · watchthelight <wtl>
+
+package daemon
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/oreonproject/defense/pkg/events"
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+func TestNotifier(t *testing.T) {
+	n := NewNotifier()
+	all := n.Watch(WatchOpts{Buffer: 10})
+	failedOnly := false
+	failures := n.Watch(WatchOpts{Kinds: []string{ipc.KindEvent}, Events: events.Filter{Success: &failedOnly}, Buffer: 10})
+	firewall := n.Watch(WatchOpts{Kinds: []string{ipc.KindFirewall}, Buffer: 1})
+
+	n.Publish(ipc.KindFirewall, ipc.FirewallEvent{Enabled: true})
+	n.Write(events.StartBan("192.0.2.1", "ssh").End())
+	failed := events.StartRulesUpdate("clamav", "clamav")
+	failed.SetError(errors.New("freshclam failed"))
+	n.Write(failed.End())
+
+	for want := uint64(1); want <= 3; want++ {
+		if env := <-all.C; env.Seq != want {
+			t.Errorf("seq = %d, want %d", env.Seq, want)
+		}
+	}
+
+	env := <-failures.C
+	v, err := env.Decode()
+	if evt, ok := v.(*ipc.Event); err != nil || !ok || evt.Error != "freshclam failed" || env.Seq != 3 {
+		t.Errorf("failure watcher got %+v (%v)", v, err)
+	}
+
+	// the firewall watcher never read its first notification, so the
+	// second overflows it
+	n.Publish(ipc.KindFirewall, ipc.FirewallEvent{Enabled: false})
+	<-firewall.C
+	if _, ok := <-firewall.C; ok || !firewall.Overflowed() {
+		t.Error("full watcher not dropped")
+	}
+
+	all.Stop()
+	all.Stop() // safe twice
+	failures.Stop()
+	n.Publish(ipc.KindStateChange, ipc.StateChangeEvent{OldState: "protected", NewState: "alert"})
+	<-all.C // firewall notification published before Stop
+	if _, ok := <-all.C; ok || all.Overflowed() {
+		t.Error("channel still open after stop, or stop counted as overflow")
+	}
+}
+
+func TestNotifier_Private(t *testing.T) {
+	n := NewNotifier()
+	admin := n.Watch(WatchOpts{Buffer: 10})
+	owner := n.Watch(WatchOpts{Restricted: true, UID: 1000, Buffer: 10})
+	other := n.Watch(WatchOpts{Restricted: true, UID: 1001, Buffer: 10})
+
+	n.PublishFor(1000, ipc.KindThreat, ipc.ThreatEvent{Path: "/home/alice/x"})
+	n.Write(events.StartThreat("/home/alice/x", "Eicar").Owner(1000).End())
+	n.Publish(ipc.KindFirewall, ipc.FirewallEvent{Enabled: true})
+
+	for name, w := range map[string]*Watcher{"admin": admin, "owner": owner} {
+		for _, kind := range []string{ipc.KindThreat, ipc.KindEvent, ipc.KindFirewall} {
+			if env := <-w.C; env.Kind != kind {
+				t.Errorf("%s got %s, want %s", name, env.Kind, kind)
+			}
+		}
+	}
+	if env := <-other.C; env.Kind != ipc.KindFirewall {
+		t.Errorf("other user got %s: %s", env.Kind, env.Payload)
+	}
+}
+
+func TestNotifier_Resume(t *testing.T) {
+	n := NewNotifier()
+	for range notifyHistory + 10 {
+		n.Publish(ipc.KindScanProgress, ipc.ScanProgressEvent{})
+	}
+	n.Publish(ipc.KindThreat, ipc.ThreatEvent{Path: "/tmp/x"})
+	last := n.Seq()
+
+	tests := []struct {
+		name       string
+		opts       WatchOpts
+		wantFirst  uint64
+		wantCount  int
+		wantMissed bool
+	}{
+		{"live only", WatchOpts{}, 0, 0, false},
+		{"caught up", WatchOpts{Resume: true, Epoch: n.Epoch(), After: last}, 0, 0, false},
+		{"recent", WatchOpts{Resume: true, Epoch: n.Epoch(), After: last - 2}, last - 1, 2, false},
+		{"one kind", WatchOpts{Kinds: []string{ipc.KindThreat}, Resume: true, Epoch: n.Epoch(), After: last - 5}, last, 1, false},
+		{"evicted", WatchOpts{Resume: true, Epoch: n.Epoch(), After: 3}, last - notifyHistory + 1, notifyHistory, true},
+		{"restarted", WatchOpts{Resume: true, Epoch: "old", After: 5}, last - notifyHistory + 1, notifyHistory, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := n.Watch(tt.opts)
+			defer w.Stop()
+			if w.Missed != tt.wantMissed {
+				t.Errorf("Missed = %v, want %v", w.Missed, tt.wantMissed)
+			}
+			if len(w.C) != tt.wantCount {
+				t.Fatalf("replayed %d, want %d", len(w.C), tt.wantCount)
+			}
+			if tt.wantCount > 0 {
+				if env := <-w.C; env.Seq != tt.wantFirst {
+					t.Errorf("first replayed seq = %d, want %d", env.Seq, tt.wantFirst)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+// oreon/defense · watchthelight <wtl>
+
+package daemon
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+// openAPIDoc describes httpRoutes as an OpenAPI 3.1 document. Schemas are
+// generated from the ipc types by reflection, so they can't drift from
+// what the daemon actually sends.
+func openAPIDoc() map[string]any {
+	g := &schemaGen{components: make(map[string]any)}
+	paths := make(map[string]map[string]any)
+	used := make(map[string]bool)
+
+	for _, rt := range httpRoutes {
+		op := map[string]any{
+			"operationId": rt.command,
+			"summary":     rt.summary,
+			"responses": map[string]any{
+				"200":     jsonContent("OK", g.schema(reflect.TypeOf(rt.result))),
+				"default": jsonContent("Error", g.schema(reflect.TypeOf(httpError{}))),
+			},
+		}
+		wildcards := pathWildcards(rt.path)
+		// a command reachable from two paths gets the wildcard names
+		// appended the second time
+		if used[rt.command] {
+			op["operationId"] = rt.command + "_" + strings.Join(wildcards, "_")
+		}
+		used[rt.command] = true
+
+		var params []any
+		for _, name := range wildcards {
+			params = append(params, map[string]any{
+				"name": name, "in": "path", "required": true,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		if rt.params != nil {
+			typ := reflect.TypeOf(rt.params)
+			if rt.method == "GET" {
+				for i := 0; i < typ.NumField(); i++ {
+					name := jsonName(typ.Field(i))
+					if name == "" || slices.Contains(wildcards, name) {
+						continue
+					}
+					params = append(params, map[string]any{
+						"name": name, "in": "query",
+						"schema": g.schema(typ.Field(i).Type),
+					})
+				}
+			} else if typ.NumField() > len(wildcards) {
+				op["requestBody"] = map[string]any{
+					"content": map[string]any{
+						"application/json": map[string]any{"schema": g.schema(typ)},
+					},
+				}
+			}
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+
+		if paths[rt.path] == nil {
+			paths[rt.path] = make(map[string]any)
+		}
+		paths[rt.path][strings.ToLower(rt.method)] = op
+	}
+
+	paths["/v1/events/stream"] = map[string]any{
+		"get": map[string]any{
+			"operationId": ipc.CmdWatch,
+			"summary": "Notifications as Server-Sent Events: a \"watch\" event with the WatchResponse, " +
+				"then one event per notification named after its kind, with the Envelope as data and " +
+				"\"epoch:seq\" as id. Send Last-Event-ID to resume.",
+			"parameters": []any{
+				map[string]any{"name": "kinds", "in": "query", "description": "comma-separated notification kinds", "schema": map[string]any{"type": "string"}},
+				map[string]any{"name": "type", "in": "query", "description": "event type, for kind \"event\"", "schema": map[string]any{"type": "string"}},
+				map[string]any{"name": "component", "in": "query", "description": "event component, for kind \"event\"", "schema": map[string]any{"type": "string"}},
+				map[string]any{"name": "success", "in": "query", "description": "event outcome, for kind \"event\"", "schema": map[string]any{"type": "boolean"}},
+			},
+			"responses": map[string]any{
+				"200": map[string]any{
+					"description": "event stream",
+					"content": map[string]any{
+						"text/event-stream": map[string]any{"schema": g.schema(reflect.TypeOf(ipc.Envelope{}))},
+					},
+				},
+			},
+		},
+	}
+	// payloads carried in Envelope.Payload, so clients can generate them too
+	for _, payload := range []any{
+		ipc.WatchResponse{}, ipc.StateChangeEvent{}, ipc.ScanProgressEvent{}, ipc.ThreatEvent{},
+		ipc.QuarantineEvent{}, ipc.FirewallEvent{}, ipc.RulesEvent{}, ipc.Event{},
+	} {
+		g.schema(reflect.TypeOf(payload))
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "Oreon Defense",
+			"version": strconv.Itoa(ipc.ProtocolVersion),
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": g.components},
+	}
+}
+
+func jsonContent(desc string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"description": desc,
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": schema},
+		},
+	}
+}
+
+var wildcardRe = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+func pathWildcards(path string) []string {
+	var names []string
+	for _, m := range wildcardRe.FindAllStringSubmatch(path, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// schemaGen builds JSON Schemas, collecting named structs as components.
+type schemaGen struct {
+	components map[string]any
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == nil || t == rawType || t.Kind() == reflect.Interface:
+		return map[string]any{}
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := g.components[t.Name()]; !ok {
+			g.components[t.Name()] = nil // placeholder in case of recursion
+			g.components[t.Name()] = g.object(t)
+		}
+		return ref
+	}
+	return map[string]any{}
+}
+
+// object describes a struct's JSON encoding. Fields without omitempty or
+// omitzero are always sent, so they're required.
+func (g *schemaGen) object(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := jsonName(f)
+		if name == "" {
+			continue
+		}
+		props[name] = g.schema(f.Type)
+		tag := f.Tag.Get("json")
+		if !strings.Contains(tag, ",omitempty") && !strings.Contains(tag, ",omitzero") {
+			required = append(required, name)
+		}
+	}
+	obj := map[string]any{"type": "object", "properties": props}
+	if required != nil {
+		obj["required"] = required
+	}
+	return obj
+}
@@ -0,0 +1,185 @@
+// oreon/defense · watchthelight <wtl>
+
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Scan job states.
+const (
+	ScanRunning   = "running"
+	ScanCompleted = "completed"
+	ScanCancelled = "cancelled"
+	ScanFailed    = "failed"
+)
+
+// scanHistorySize is how many finished scans we remember.
+const scanHistorySize = 50
+
+var (
+	// ErrScanRunning is returned when starting a scan while one is running.
+	ErrScanRunning = errors.New("a scan is already running")
+	// ErrNoScan is returned for unknown job IDs.
+	ErrNoScan = errors.New("no such scan")
+	// ErrNotRunning is returned when cancelling with nothing running.
+	ErrNotRunning = errors.New("no scan is running")
+)
+
+// ScanJob is one scan run.
+type ScanJob struct {
+	ID           string
+	Type         string
+	State        string
+	Started      time.Time
+	Finished     time.Time // zero while running
+	FilesScanned int
+	ThreatsFound int
+	Threats      []ScanThreat
+	Error        string
+
+	// Owner is the uid that started the job and may cancel it. Private
+	// jobs are custom scans by unprivileged users; only Owner and admins
+	// see what they found.
+	Private bool
+	Owner   int
+}
+
+// ScanThreat is an infected file found by a scan.
+type ScanThreat struct {
+	Path         string
+	Threat       string
+	Action       string // "detected" or "quarantined"
+	QuarantineID string
+}
+
+// ScanJobs tracks the running scan and recent history. Only one scan runs
+// at a time.
+type ScanJobs struct {
+	mu      sync.Mutex
+	current *ScanJob
+	cancel  context.CancelFunc
+	history []ScanJob // oldest first
+}
+
+// Start registers a new running job. The returned context is cancelled by
+// Cancel.
+func (j *ScanJobs) Start(scanType string) (ScanJob, context.Context, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.current != nil {
+		return ScanJob{}, nil, ErrScanRunning
+	}
+	now := time.Now()
+	stamp := scanType + "-" + now.Format("20060102-150405")
+	id := stamp
+	for n := 2; j.known(id); n++ {
+		id = fmt.Sprintf("%s-%d", stamp, n)
+	}
+	j.current = &ScanJob{
+		ID:      id,
+		Type:    scanType,
+		State:   ScanRunning,
+		Started: now,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	j.cancel = cancel
+	return *j.current, ctx, nil
+}
+
+func (j *ScanJobs) known(id string) bool {
+	for _, job := range j.history {
+		if job.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Update changes the running job in place.
+func (j *ScanJobs) Update(fn func(*ScanJob)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.current != nil {
+		fn(j.current)
+	}
+}
+
+// Finish moves the running job to history.
+func (j *ScanJobs) Finish(state string, err error) ScanJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.current == nil {
+		return ScanJob{}
+	}
+	job := *j.current
+	job.State = state
+	job.Finished = time.Now()
+	if err != nil {
+		job.Error = err.Error()
+	}
+	j.cancel()
+	j.current, j.cancel = nil, nil
+
+	j.history = append(j.history, job)
+	if len(j.history) > scanHistorySize {
+		j.history = j.history[len(j.history)-scanHistorySize:]
+	}
+	return job
+}
+
+// Cancel stops the running job. An empty id means whatever is running.
+func (j *ScanJobs) Cancel(id string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.current == nil || (id != "" && j.current.ID != id) {
+		if id == "" {
+			return ErrNotRunning
+		}
+		return fmt.Errorf("%w: %s is not running", ErrNoScan, id)
+	}
+	j.cancel()
+	return nil
+}
+
+// Get returns a job by ID. An empty id means the running job, or the most
+// recent one if nothing is running.
+func (j *ScanJobs) Get(id string) (ScanJob, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.current != nil && (id == "" || j.current.ID == id) {
+		return copyJob(*j.current), nil
+	}
+	for i := len(j.history) - 1; i >= 0; i-- {
+		if id == "" || j.history[i].ID == id {
+			return copyJob(j.history[i]), nil
+		}
+	}
+	if id == "" {
+		return ScanJob{}, fmt.Errorf("%w: no scans have run yet", ErrNoScan)
+	}
+	return ScanJob{}, fmt.Errorf("%w: %s", ErrNoScan, id)
+}
+
+// History returns finished jobs, newest first. limit <= 0 means all.
+func (j *ScanJobs) History(limit int) []ScanJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var out []ScanJob
+	for i := len(j.history) - 1; i >= 0; i-- {
+		out = append(out, copyJob(j.history[i]))
+		if limit > 0 && len(out) == limit {
+			break
+		}
+	}
+	return out
+}
+
+func copyJob(job ScanJob) ScanJob {
+	job.Threats = append([]ScanThreat(nil), job.Threats...)
+	return job
+}
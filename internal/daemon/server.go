@@ -4,44 +4,61 @@ package daemon
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/oreonproject/defense/internal/appfw"
+	"github.com/oreonproject/defense/internal/firewall"
+	"github.com/oreonproject/defense/internal/inventory"
+	"github.com/oreonproject/defense/internal/quarantine"
+	"github.com/oreonproject/defense/internal/rules"
+	"github.com/oreonproject/defense/internal/scanner"
+	"github.com/oreonproject/defense/pkg/config"
 	"github.com/oreonproject/defense/pkg/events"
 	"github.com/oreonproject/defense/pkg/ipc"
+	"github.com/oreonproject/defense/pkg/logging"
 )
 
 // Server handles IPC connections from clients (tray, CLI).
 type Server struct {
-	socketPath  string
-	listener    net.Listener
-	daemon      *Daemon
-	done        chan struct{}
-	subscribers map[net.Conn]bool
-	subMu       sync.Mutex
+	socketPath string
+	listener   net.Listener
+	daemon     *Daemon
+	done       chan struct{}
+
+	// credentials identifies the process behind a connection. Swapped out
+	// in tests, which can't connect as anyone but themselves.
+	credentials func(net.Conn) (Peer, error)
+
+	connMu  sync.Mutex
+	conns   map[net.Conn]struct{} // open client connections, closed by Close
+	httpSrv *http.Server          // REST gateway, if started
+	dbus    bool                  // exported on D-Bus
 }
 
 // NewServer creates an IPC server that exposes daemon state.
 func NewServer(socketPath string, daemon *Daemon) *Server {
-	s := &Server{
+	return &Server{
 		socketPath:  socketPath,
 		daemon:      daemon,
 		done:        make(chan struct{}),
-		subscribers: make(map[net.Conn]bool),
+		conns:       make(map[net.Conn]struct{}),
+		credentials: peerCred,
 	}
-
-	// Register for state changes to push to subscribers
-	daemon.State().OnStateChange(func(old, new State) {
-		s.broadcastStateChange(old.String(), new.String())
-	})
-
-	return s
 }
 
 // Listen creates the unix socket and starts accepting connections.
@@ -93,94 +110,317 @@ func (s *Server) Close() error {
 	if s.listener != nil {
 		s.listener.Close()
 	}
+	s.connMu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	if s.httpSrv != nil {
+		s.httpSrv.Close()
+	}
+	s.connMu.Unlock()
 	os.Remove(s.socketPath)
 	return nil
 }
 
-// subscribe adds a connection to the subscriber list.
-func (s *Server) subscribe(conn net.Conn) {
-	s.subMu.Lock()
-	s.subscribers[conn] = true
-	s.subMu.Unlock()
-	slog.Debug("client subscribed", "remote", conn.RemoteAddr())
+// watchBuffer is how many notifications may queue for a client before
+// it's considered too slow and disconnected.
+const watchBuffer = 256
+
+// writeTimeout bounds every write to a client. A client that can't take
+// a line within it is treated as stuck and disconnected.
+const writeTimeout = 5 * time.Second
+
+// connWriter serializes writes to one connection: responses from the
+// request loop and pushed notifications share it.
+type connWriter struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	encoder *json.Encoder
 }
 
-// unsubscribe removes a connection from the subscriber list.
-func (s *Server) unsubscribe(conn net.Conn) {
-	s.subMu.Lock()
-	delete(s.subscribers, conn)
-	s.subMu.Unlock()
+func newConnWriter(conn net.Conn) *connWriter {
+	return &connWriter{conn: conn, encoder: json.NewEncoder(conn)}
 }
 
-// broadcastStateChange sends state change events to all subscribers.
-func (s *Server) broadcastStateChange(oldState, newState string) {
-	event := ipc.StateChangeEvent{
-		OldState: oldState,
-		NewState: newState,
+// write sends v as one JSON line. On error the connection is closed,
+// which also ends the request loop.
+func (cw *connWriter) write(v any) error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := cw.encoder.Encode(v); err != nil {
+		cw.conn.Close()
+		return err
 	}
-	resp := makeResponse("event", event)
+	return nil
+}
 
-	s.subMu.Lock()
-	subscribers := make([]net.Conn, 0, len(s.subscribers))
-	for conn := range s.subscribers {
-		subscribers = append(subscribers, conn)
+// push writes w's notifications until the watch stops. Each watcher has
+// its own bounded queue (w.C) and this goroutine, so a slow client only
+// holds up itself; one that overflows its queue or hits the write
+// deadline is disconnected and can resume from the replay buffer. wrap
+// turns each envelope into the line written: the envelope itself for
+// CmdWatch, a legacy "event" response otherwise, and a notification on
+// JSON-RPC connections.
+func (s *Server) push(cw *connWriter, w *Watcher, wrap func(ipc.Envelope) any) {
+	for env := range w.C {
+		if err := cw.write(wrap(env)); err != nil {
+			slog.Warn("failed to send notification, disconnecting client", "remote", cw.conn.RemoteAddr(), "error", err)
+			w.Stop()
+			return
+		}
 	}
-	s.subMu.Unlock()
+}
 
-	for _, conn := range subscribers {
-		encoder := json.NewEncoder(conn)
-		if err := encoder.Encode(resp); err != nil {
-			slog.Debug("failed to send event to subscriber", "error", err)
-			s.unsubscribe(conn)
-		}
+// legacyEvent wraps a payload the way pre-envelope subscriptions expect.
+func legacyEvent(env ipc.Envelope) any {
+	return &ipc.Response{ID: "event", Success: true, Data: env.Payload}
+}
+
+func envelope(env ipc.Envelope) any { return env }
+
+func (s *Server) watchResponse(w *Watcher) any {
+	n := s.daemon.Notifier()
+	return ipc.WatchResponse{Epoch: n.Epoch(), Seq: n.Seq(), Missed: w.Missed}
+}
+
+func eventFilter(p *ipc.SubscribeEventsParams) events.Filter {
+	if p == nil {
+		return events.Filter{}
+	}
+	return events.Filter{
+		Type:      events.EventType(p.Type),
+		Component: p.Component,
+		Success:   p.Success,
 	}
 }
 
 func (s *Server) handleConnection(conn net.Conn) {
-	defer conn.Close()
-	defer s.unsubscribe(conn) // clean up subscription on disconnect
+	s.connMu.Lock()
+	select {
+	case <-s.done:
+		// accepted just as Close ran
+		s.connMu.Unlock()
+		conn.Close()
+		return
+	default:
+	}
+	s.conns[conn] = struct{}{}
+	s.connMu.Unlock()
+	defer func() {
+		s.connMu.Lock()
+		delete(s.conns, conn)
+		s.connMu.Unlock()
+		conn.Close()
+	}()
 
-	reader := bufio.NewReader(conn)
-	encoder := json.NewEncoder(conn)
+	peer, err := s.credentials(conn)
+	if err != nil {
+		// nobody: open commands still work, privileged ones are refused
+		slog.Warn("failed to read peer credentials", "error", err)
+		peer = Peer{UID: -1, GID: -1, PID: -1}
+	}
+
+	// cancelled once the client is gone, which ends its requests too
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := &clientConn{
+		s:         s,
+		conn:      conn,
+		ctx:       ctx,
+		writer:    newConnWriter(conn),
+		peer:      peer,
+		stopWatch: func() {},
+		inflight:  make(chan struct{}, maxInFlight),
+		running:   make(map[string]*runningRequest),
+	}
+	defer func() { c.stopWatch() }()
 
+	reader := bufio.NewReader(conn)
+	first := true
 	for {
 		// Read one line (one JSON request)
 		line, err := reader.ReadBytes('\n')
 		if err != nil {
 			return // client disconnected
 		}
-
-		var req ipc.Request
-		if err := json.Unmarshal(line, &req); err != nil {
-			if err := encoder.Encode(ipc.Response{
-				Success: false,
-				Error:   "invalid JSON",
-			}); err != nil {
-				slog.Warn("failed to encode error response", "error", err)
-				return
-			}
+		if len(bytes.TrimSpace(line)) == 0 {
 			continue
 		}
-
-		// Handle subscribe specially - it registers this connection for push events
-		if req.Command == ipc.CmdSubscribe {
-			s.subscribe(conn)
-			resp := makeResponse(req.ID, "subscribed")
-			if err := encoder.Encode(resp); err != nil {
-				slog.Warn("failed to encode response", "error", err)
-				return
-			}
-			continue
+		if first {
+			c.rpc, first = isJSONRPC(line), false
 		}
 
-		resp := s.handleRequest(&req)
-		if err := encoder.Encode(resp); err != nil {
+		if c.rpc {
+			err = c.serveRPC(line)
+		} else {
+			err = c.serveV1(line)
+		}
+		if err != nil {
 			slog.Warn("failed to encode response", "error", err)
 			return
 		}
 	}
 }
 
+// clientConn is one client connection and what it has subscribed to.
+type clientConn struct {
+	s         *Server
+	conn      net.Conn
+	ctx       context.Context // done when the client disconnects
+	writer    *connWriter
+	peer      Peer
+	rpc       bool          // speaking JSON-RPC 2.0, decided by the first message
+	stopWatch func()        // ends the current subscription, if any
+	inflight  chan struct{} // requests running concurrently, up to maxInFlight
+
+	mu      sync.Mutex
+	running map[string]*runningRequest // by request ID, for CmdCancel
+}
+
+// runningRequest is a request being handled in its own goroutine.
+type runningRequest struct {
+	cancel context.CancelFunc
+}
+
+// run handles a request in its own goroutine, with a context cancelled by
+// a cancel message for id or the client going away. Past maxInFlight it
+// blocks, which stops us reading more from the client.
+func (c *clientConn) run(id string, handle func(ctx context.Context)) {
+	c.inflight <- struct{}{}
+	ctx, cancel := context.WithCancel(c.ctx)
+	r := &runningRequest{cancel: cancel}
+	c.mu.Lock()
+	c.running[id] = r
+	c.mu.Unlock()
+	go func() {
+		defer func() { <-c.inflight }()
+		defer cancel()
+		handle(ctx)
+		c.mu.Lock()
+		if c.running[id] == r {
+			delete(c.running, id)
+		}
+		c.mu.Unlock()
+	}()
+}
+
+// cancelRequest cancels the running request with id, if there is one.
+func (c *clientConn) cancelRequest(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.running[id]
+	if ok {
+		r.cancel()
+	}
+	return ok
+}
+
+// maxInFlight bounds how many requests one connection can have running
+// at once; past that we stop reading until one finishes.
+const maxInFlight = 16
+
+// serveV1 answers one line of the v1 Request/Response protocol.
+func (c *clientConn) serveV1(line []byte) error {
+	var req ipc.Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		return c.writer.write(ipc.Response{
+			Success: false,
+			Error:   "invalid JSON",
+		})
+	}
+	if req.Command == ipc.CmdCancel {
+		var params ipc.CancelParams
+		var id string
+		err := decodeParams(&req, &params)
+		if err == nil {
+			if err = json.Unmarshal(params.ID, &id); err != nil {
+				err = fmt.Errorf("%w: id must be a string", ipc.ErrInvalidParams)
+			}
+		}
+		if err == nil && !c.cancelRequest(id) {
+			err = &ipc.Error{Code: ipc.CodeNotFound, Message: "no running request " + id}
+		}
+		if req.ID == "" {
+			return nil
+		}
+		if err != nil {
+			return c.writer.write(errorResponse(req.ID, err))
+		}
+		return c.writer.write(makeResponse(req.ID, "cancel requested"))
+	}
+	if req.ID != "" && !isSubscription(req.Command) {
+		// run alongside whatever else the client has in flight; it matches
+		// responses up by ID, so they can come back out of order
+		c.run(req.ID, func(ctx context.Context) {
+			c.writer.write(c.s.handleRequest(ctx, c.peer, &req))
+		})
+		return nil
+	}
+	resp, start := c.dispatch(c.ctx, &req)
+	if err := c.writer.write(resp); err != nil {
+		return err
+	}
+	start()
+	return nil
+}
+
+func isSubscription(cmd string) bool {
+	return cmd == ipc.CmdSubscribe || cmd == ipc.CmdSubscribeEvents || cmd == ipc.CmdWatch
+}
+
+// dispatch runs a request. Subscriptions are handled here since they push
+// on this connection: start begins pushing and must be called after the
+// response is written, so the ack is the first line the client sees.
+func (c *clientConn) dispatch(ctx context.Context, req *ipc.Request) (resp *ipc.Response, start func()) {
+	var opts *WatchOpts
+	var ack func(*Watcher) any
+	var err error
+	wrap := legacyEvent
+	subscribed := func(*Watcher) any { return "subscribed" }
+	switch req.Command {
+	case ipc.CmdSubscribe:
+		opts, ack = &WatchOpts{Kinds: []string{ipc.KindStateChange}}, subscribed
+	case ipc.CmdSubscribeEvents:
+		var params ipc.SubscribeEventsParams
+		if err = decodeParams(req, &params); err == nil {
+			opts, ack = &WatchOpts{Kinds: []string{ipc.KindEvent}, Events: eventFilter(&params)}, subscribed
+		}
+	case ipc.CmdWatch:
+		var params ipc.WatchParams
+		if err = decodeParams(req, &params); err == nil {
+			opts = &WatchOpts{
+				Kinds:  params.Kinds,
+				Events: eventFilter(params.Events),
+				Resume: params.Epoch != "",
+				Epoch:  params.Epoch,
+				After:  params.After,
+			}
+			ack, wrap = c.s.watchResponse, envelope
+		}
+	default:
+		return c.s.handleRequest(ctx, c.peer, req), func() {}
+	}
+	if err != nil {
+		return errorResponse(req.ID, err), func() {}
+	}
+	if c.rpc {
+		wrap = rpcNotification
+	}
+
+	c.stopWatch() // a second subscription replaces the first
+	opts.Buffer = watchBuffer
+	opts.Restricted, opts.UID = !c.s.privileged(c.peer), c.peer.UID
+	opts.OnOverflow = func() {
+		// unblocks a push stuck writing to a client that stopped reading
+		slog.Warn("client too slow for notifications, disconnecting", "remote", c.conn.RemoteAddr())
+		c.conn.Close()
+	}
+	w := c.s.daemon.Notifier().Watch(*opts)
+	c.stopWatch = w.Stop
+	return makeResponse(req.ID, ack(w)), func() { go c.s.push(c.writer, w, wrap) }
+}
+
 // makeResponse creates a response with properly marshaled data.
 func makeResponse(id string, data interface{}) *ipc.Response {
 	resp := &ipc.Response{ID: id, Success: true}
@@ -194,8 +434,117 @@ func makeResponse(id string, data interface{}) *ipc.Response {
 	return resp
 }
 
-func (s *Server) handleRequest(req *ipc.Request) *ipc.Response {
-	evt := events.StartIPCRequest(req.Command, req.ID).ClientVersion(req.Version)
+// errorResponse creates a failed response from an error, with a code
+// (and details, for an *ipc.Error) clients can act on.
+func errorResponse(id string, err error) *ipc.Response {
+	resp := &ipc.Response{ID: id, Success: false, Error: err.Error(), Code: errorCode(err)}
+	var ipcErr *ipc.Error
+	if errors.As(err, &ipcErr) {
+		resp.Details = ipcErr.Details
+	}
+	return resp
+}
+
+// errorCode maps daemon errors onto ipc error codes.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrScanRunning), errors.Is(err, rules.ErrUpdateInProgress):
+		return ipc.CodeBusy
+	case errors.Is(err, ErrNoScan), errors.Is(err, ErrNotRunning), errors.Is(err, quarantine.ErrNotFound):
+		return ipc.CodeNotFound
+	case errors.Is(err, ErrQuarantineUnavailable):
+		return ipc.CodeUnavailable
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ipc.CodeCanceled
+	}
+	return ipc.ErrorCode(err)
+}
+
+// invalidParams reports params that decoded but make no sense.
+func invalidParams(format string, args ...any) error {
+	return &ipc.Error{Code: ipc.CodeInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// decodeParams unmarshals request params into target.
+// Requests without params leave target at its zero value.
+func decodeParams(req *ipc.Request, target interface{}) error {
+	if len(req.Params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(req.Params, target); err != nil {
+		return fmt.Errorf("%w: %v", ipc.ErrInvalidParams, err)
+	}
+	return nil
+}
+
+// commands is every command the daemon answers, as reported by hello.
+var commands = []string{
+	ipc.CmdHello,
+	ipc.CmdPing,
+	ipc.CmdStatus,
+	ipc.CmdPause,
+	ipc.CmdResume,
+	ipc.CmdFirewallStatus,
+	ipc.CmdFirewallEnable,
+	ipc.CmdFirewallDisable,
+	ipc.CmdFirewallBlocked,
+	ipc.CmdFirewallPanic,
+	ipc.CmdScan,
+	ipc.CmdScanQuick,
+	ipc.CmdScanFull,
+	ipc.CmdScanStatus,
+	ipc.CmdScanCancel,
+	ipc.CmdScanHistory,
+	ipc.CmdQuarantineList,
+	ipc.CmdQuarantineRestore,
+	ipc.CmdQuarantineDelete,
+	ipc.CmdEvents,
+	ipc.CmdBansList,
+	ipc.CmdBanLift,
+	ipc.CmdAppRules,
+	ipc.CmdAppLearned,
+	ipc.CmdAppLearning,
+	ipc.CmdNetInventory,
+	ipc.CmdRulesStatus,
+	ipc.CmdRulesUpdate,
+	ipc.CmdRulesImport,
+	ipc.CmdSubscribe,
+	ipc.CmdSubscribeEvents,
+	ipc.CmdWatch,
+}
+
+// hello describes this daemon to a client.
+func (s *Server) hello() ipc.HelloResponse {
+	features := []string{"cancel", "error_codes", "jsonrpc", "watch", "watch_resume"}
+	if s.daemon.polkit != nil {
+		features = append(features, "polkit")
+	}
+	s.connMu.Lock()
+	if s.httpSrv != nil {
+		features = append(features, "http")
+	}
+	if s.dbus {
+		features = append(features, "dbus")
+	}
+	s.connMu.Unlock()
+	return ipc.HelloResponse{
+		Daemon:      Version,
+		Protocol:    ipc.ProtocolVersion,
+		MinProtocol: ipc.MinProtocolVersion,
+		Commands:    commands,
+		Features:    features,
+	}
+}
+
+// handleRequest runs one command for every transport. ctx ends when the
+// caller stops waiting (disconnect, cancel message); long handlers check
+// it. It carries the request event's operation ID, so work done for the
+// request can be correlated with it.
+func (s *Server) handleRequest(ctx context.Context, peer Peer, req *ipc.Request) *ipc.Response {
+	evt := events.StartIPCRequest(req.Command, req.ID).
+		ClientVersion(req.Version).
+		Peer(peer.UID, peer.GID, peer.PID)
+	ctx = events.WithOperationID(ctx, evt.OperationID())
 	var resp *ipc.Response
 	defer func() {
 		if resp != nil && !resp.Success {
@@ -208,16 +557,45 @@ func (s *Server) handleRequest(req *ipc.Request) *ipc.Response {
 	}()
 
 	// Check protocol version (0 means old client that didn't send version)
-	if req.Version != 0 && req.Version != ipc.ProtocolVersion {
-		resp = &ipc.Response{
-			ID:      req.ID,
-			Success: false,
-			Error:   fmt.Sprintf("protocol version mismatch: client=%d, server=%d", req.Version, ipc.ProtocolVersion),
+	if req.Version != 0 && (req.Version < ipc.MinProtocolVersion || req.Version > ipc.ProtocolVersion) {
+		resp = errorResponse(req.ID, &ipc.Error{
+			Code:    ipc.CodeVersionMismatch,
+			Message: fmt.Sprintf("protocol version mismatch: client=%d, server=%d", req.Version, ipc.ProtocolVersion),
+			Details: map[string]any{"client": req.Version, "min": ipc.MinProtocolVersion, "max": ipc.ProtocolVersion},
+		})
+		return resp
+	}
+
+	// before authorize, so callers learn the command doesn't exist rather
+	// than that they may not run it
+	if !slices.Contains(commands, req.Command) {
+		resp = errorResponse(req.ID, &ipc.Error{
+			Code:    ipc.CodeUnknownCommand,
+			Message: "unknown command: " + req.Command,
+			Details: map[string]any{"command": req.Command},
+		})
+		return resp
+	}
+
+	if err := s.authorizeRequest(ctx, peer, req); err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("IPC command refused", "command", req.Command, "uid", peer.UID, "pid", peer.PID)
+			s.daemon.Events().Emit(events.StartAccessDenied(req.Command, peer.UID, peer.GID, peer.PID).
+				WithOperationID(evt.OperationID()).SetError(err).End())
 		}
+		resp = errorResponse(req.ID, err)
 		return resp
 	}
 
 	switch req.Command {
+	case ipc.CmdHello:
+		var params ipc.HelloParams
+		if err := decodeParams(req, &params); err != nil {
+			resp = errorResponse(req.ID, err)
+			break
+		}
+		resp = makeResponse(req.ID, s.hello())
+
 	case ipc.CmdPing:
 		resp = makeResponse(req.ID, "pong")
 
@@ -227,115 +605,951 @@ func (s *Server) handleRequest(req *ipc.Request) *ipc.Response {
 			FirewallEnabled: s.daemon.FirewallEnabled(),
 			LastScan:        s.daemon.LastScan(),
 			RulesUpdated:    s.daemon.RulesUpdated(),
+			PausedUntil:     s.daemon.PausedUntil(),
 		})
 
 	case ipc.CmdFirewallEnable:
-		s.daemon.SetFirewallEnabled(true)
+		if err := s.daemon.SetFirewallEnabled(ctx, true); err != nil {
+			resp = errorResponse(req.ID, err)
+			break
+		}
 		resp = makeResponse(req.ID, "firewall enabled")
 
 	case ipc.CmdFirewallDisable:
-		s.daemon.SetFirewallEnabled(false)
+		if err := s.daemon.SetFirewallEnabled(ctx, false); err != nil {
+			resp = errorResponse(req.ID, err)
+			break
+		}
 		resp = makeResponse(req.ID, "firewall disabled")
 
 	case ipc.CmdFirewallStatus:
-		resp = makeResponse(req.ID, ipc.FirewallStatusResponse{
-			Enabled: s.daemon.FirewallEnabled(),
-		})
+		resp = makeResponse(req.ID, s.firewallStatus())
 
-	case ipc.CmdScanQuick:
-		s.daemon.State().SetState(StateScanning)
-		go s.runScan("quick")
-		resp = makeResponse(req.ID, ipc.ScanResponse{
-			JobID: "quick-" + time.Now().Format("20060102-150405"),
-		})
+	case ipc.CmdFirewallBlocked:
+		resp = s.handleFirewallBlocked(req)
 
-	case ipc.CmdScanFull:
-		s.daemon.State().SetState(StateScanning)
-		go s.runScan("full")
-		resp = makeResponse(req.ID, ipc.ScanResponse{
-			JobID: "full-" + time.Now().Format("20060102-150405"),
-		})
+	case ipc.CmdFirewallPanic:
+		var params ipc.FirewallPanicParams
+		if err := decodeParams(req, &params); err != nil {
+			resp = errorResponse(req.ID, err)
+			break
+		}
+		if err := s.daemon.SetFirewallPanic(ctx, params.Enabled); err != nil {
+			resp = errorResponse(req.ID, err)
+			break
+		}
+		resp = makeResponse(req.ID, s.firewallStatus())
+
+	case ipc.CmdBansList:
+		result := ipc.BansResponse{Bans: []ipc.BanInfo{}}
+		for _, b := range s.daemon.IDS().Bans() {
+			result.Bans = append(result.Bans, ipc.BanInfo{
+				Address:   b.Addr.String(),
+				Reason:    b.Reason,
+				BannedAt:  b.Since,
+				ExpiresAt: b.Until,
+			})
+		}
+		resp = makeResponse(req.ID, result)
+
+	case ipc.CmdBanLift:
+		resp = s.handleBanLift(ctx, req)
+
+	case ipc.CmdNetInventory:
+		resp = s.handleNetInventory(peer, req)
+
+	case ipc.CmdAppRules:
+		resp = makeResponse(req.ID, s.appRules())
+
+	case ipc.CmdAppLearned:
+		resp = makeResponse(req.ID, s.appLearned(peer))
+
+	case ipc.CmdAppLearning:
+		var params ipc.AppLearningParams
+		if err := decodeParams(req, &params); err != nil {
+			resp = errorResponse(req.ID, err)
+			break
+		}
+		s.daemon.AppFirewall().SetLearning(params.Enabled)
+		resp = makeResponse(req.ID, s.appRules())
+
+	case ipc.CmdRulesStatus:
+		resp = makeResponse(req.ID, s.rulesStatus())
+
+	case ipc.CmdRulesUpdate:
+		if err := s.daemon.Rules().Start(context.Background()); err != nil && !errors.Is(err, rules.ErrUpdateInProgress) {
+			resp = errorResponse(req.ID, err)
+			break
+		}
+		resp = makeResponse(req.ID, s.rulesStatus())
+
+	case ipc.CmdRulesImport:
+		resp = s.handleRulesImport(ctx, req)
+
+	case ipc.CmdScanQuick, ipc.CmdScanFull:
+		scanType := "quick"
+		if req.Command == ipc.CmdScanFull {
+			scanType = "full"
+		}
+		job, _, err := s.startScan(scanType, scanTarget{paths: s.presetPaths(scanType), owner: peer.UID})
+		if err != nil {
+			resp = errorResponse(req.ID, err)
+			break
+		}
+		resp = makeResponse(req.ID, ipc.ScanResponse{JobID: job.ID})
+
+	case ipc.CmdScan:
+		resp = s.handleScan(ctx, peer, req)
+
+	case ipc.CmdScanStatus:
+		var params ipc.ScanJobParams
+		if err := decodeParams(req, &params); err != nil {
+			resp = errorResponse(req.ID, err)
+			break
+		}
+		job, err := s.daemon.Scans().Get(params.JobID)
+		if err != nil {
+			resp = errorResponse(req.ID, err)
+			break
+		}
+		resp = makeResponse(req.ID, s.scanStatusFor(peer, job))
+
+	case ipc.CmdScanCancel:
+		var params ipc.ScanJobParams
+		if err := decodeParams(req, &params); err != nil {
+			resp = errorResponse(req.ID, err)
+			break
+		}
+		if err := s.daemon.Scans().Cancel(params.JobID); err != nil {
+			resp = errorResponse(req.ID, err)
+			break
+		}
+		resp = makeResponse(req.ID, "scan cancelled")
+
+	case ipc.CmdScanHistory:
+		var params ipc.ScanHistoryParams
+		if err := decodeParams(req, &params); err != nil {
+			resp = errorResponse(req.ID, err)
+			break
+		}
+		history := ipc.ScanHistoryResponse{Scans: []ipc.ScanStatusResponse{}}
+		for _, job := range s.daemon.Scans().History(params.Limit) {
+			history.Scans = append(history.Scans, s.scanStatusFor(peer, job))
+		}
+		resp = makeResponse(req.ID, history)
+
+	case ipc.CmdQuarantineList, ipc.CmdQuarantineRestore, ipc.CmdQuarantineDelete:
+		resp = s.handleQuarantine(ctx, req)
+
+	case ipc.CmdEvents:
+		resp = s.handleEvents(ctx, peer, req)
 
 	case ipc.CmdPause:
-		s.daemon.State().SetState(StatePaused)
+		var params ipc.PauseParams
+		if err := decodeParams(req, &params); err != nil {
+			resp = errorResponse(req.ID, err)
+			break
+		}
+		var dur time.Duration
+		if params.Duration != "" && params.Duration != "reboot" {
+			d, err := time.ParseDuration(params.Duration)
+			if err != nil || d <= 0 {
+				resp = errorResponse(req.ID, invalidParams("invalid pause duration %q", params.Duration))
+				break
+			}
+			dur = d
+		}
+		s.daemon.Pause(dur)
 		resp = makeResponse(req.ID, "protection paused")
 
 	case ipc.CmdResume:
-		s.daemon.State().SetState(StateProtected)
+		s.daemon.Resume()
 		resp = makeResponse(req.ID, "protection resumed")
 
 	default:
-		resp = &ipc.Response{
-			ID:      req.ID,
-			Success: false,
-			Error:   "unknown command: " + req.Command,
-		}
+		// listed in commands but not handled here: a subscription sent
+		// somewhere that can't push
+		resp = errorResponse(req.ID, fmt.Errorf("%w: %s needs a streaming connection", ipc.ErrUnknownCommand, req.Command))
 	}
 
 	return resp
 }
 
-// runScan performs a scan using ClamAV.
-func (s *Server) runScan(scanType string) {
-	jobID := scanType + "-" + time.Now().Format("20060102-150405")
-	evt := events.StartScan(scanType, jobID)
+// firewallStatus collects ruleset counts and blocklist state.
+func (s *Server) firewallStatus() ipc.FirewallStatusResponse {
+	fw := s.daemon.Firewall()
+	tables, chains, rules := fw.Counts()
+	status := ipc.FirewallStatusResponse{
+		Enabled:    s.daemon.FirewallEnabled(),
+		Backend:    fw.Backend(),
+		Panic:      fw.Panic(),
+		TableCount: tables,
+		ChainCount: chains,
+		RuleCount:  rules,
+	}
+	for _, bl := range fw.BlocklistStatus() {
+		status.Blocklists = append(status.Blocklists, ipc.BlocklistStatus{
+			Name:        bl.Name,
+			Path:        bl.Path,
+			IPv4Entries: bl.IPv4Entries,
+			IPv6Entries: bl.IPv6Entries,
+			LoadedAt:    bl.LoadedAt,
+			Error:       bl.Error,
+		})
+	}
+	return status
+}
+
+// handleFirewallBlocked returns recently blocked connections and top sources.
+func (s *Server) handleFirewallBlocked(req *ipc.Request) *ipc.Response {
+	var params ipc.FirewallBlockedParams
+	if err := decodeParams(req, &params); err != nil {
+		return errorResponse(req.ID, err)
+	}
+
+	filter := firewall.BlockFilter{Since: params.Since, Limit: params.Limit}
+	if filter.Limit <= 0 {
+		filter.Limit = 100
+	}
+	if params.Source != "" {
+		addr, err := netip.ParseAddr(params.Source)
+		if err != nil {
+			return errorResponse(req.ID, invalidParams("invalid source address: %v", err))
+		}
+		filter.Source = addr
+	}
+	top := params.Top
+	if top <= 0 {
+		top = 10
+	}
+
+	blocks := s.daemon.Firewall().Blocked()
+	result := ipc.FirewallBlockedResponse{
+		Recent:     []ipc.BlockedConnection{},
+		TopSources: []ipc.BlockedSource{},
+	}
+	for _, p := range blocks.Recent(filter) {
+		result.Recent = append(result.Recent, ipc.BlockedConnection{
+			Time:        p.Time,
+			Source:      p.Source.String(),
+			Destination: p.Dest.String(),
+			Port:        p.DestPort,
+			Protocol:    p.Protocol,
+			Interface:   p.Interface,
+		})
+	}
+	for _, src := range blocks.TopSources(top) {
+		result.TopSources = append(result.TopSources, ipc.BlockedSource{
+			Source:   src.Source.String(),
+			Count:    src.Count,
+			LastSeen: src.LastSeen,
+			LastPort: src.LastPort,
+		})
+	}
+
+	return makeResponse(req.ID, result)
+}
+
+// handleBanLift removes an intrusion detection ban.
+func (s *Server) handleBanLift(ctx context.Context, req *ipc.Request) *ipc.Response {
+	var params ipc.BanLiftParams
+	if err := decodeParams(req, &params); err != nil {
+		return errorResponse(req.ID, err)
+	}
+	addr, err := netip.ParseAddr(params.Address)
+	if err != nil {
+		return errorResponse(req.ID, invalidParams("invalid address: %v", err))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := s.daemon.IDS().Lift(ctx, addr); err != nil {
+		return errorResponse(req.ID, err)
+	}
+	return makeResponse(req.ID, "ban lifted")
+}
+
+// scanTarget is what a scan job covers.
+type scanTarget struct {
+	paths   []string
+	depth   int      // directory levels to descend, 0 = no limit
+	exclude []string // glob patterns, on top of scanning.exclusions
+	as      *access  // scan only what this caller can read; nil = everything
+	owner   int      // uid that asked for the scan
+}
+
+// presetPaths returns the paths quick and full scans cover.
+func (s *Server) presetPaths(scanType string) []string {
+	if scanType == "quick" {
+		return s.daemon.Config().Scanning.QuickScanPaths
+	}
+	// Full scan: start from root (be careful with this)
+	return []string{"/home", "/tmp", "/var/tmp"}
+}
+
+// handleScan starts a quick, full or custom scan and, with Follow, waits
+// for it to finish. Giving up on a followed scan cancels it.
+func (s *Server) handleScan(ctx context.Context, peer Peer, req *ipc.Request) *ipc.Response {
+	var params ipc.ScanParams
+	if err := decodeParams(req, &params); err != nil {
+		return errorResponse(req.ID, err)
+	}
+	if params.Engine != "" && !slices.Contains(ipc.ScanEngines, params.Engine) {
+		return errorResponse(req.ID, &ipc.Error{
+			Code:    ipc.CodeInvalidParams,
+			Message: fmt.Sprintf("unknown scan engine %q", params.Engine),
+			Details: map[string]any{"engines": ipc.ScanEngines},
+		})
+	}
+	if params.Depth < 0 {
+		return errorResponse(req.ID, invalidParams("depth must not be negative"))
+	}
+	for _, pattern := range params.Exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return errorResponse(req.ID, invalidParams("bad exclude pattern %q: %v", pattern, err))
+		}
+	}
+
+	scanType := params.Type
+	target := scanTarget{depth: params.Depth, exclude: params.Exclude, owner: peer.UID}
+	if len(params.Paths) > 0 {
+		scanType = "custom"
+		target.as = s.accessFor(peer)
+		for _, path := range params.Paths {
+			if !filepath.IsAbs(path) {
+				return errorResponse(req.ID, invalidParams("path %q is not absolute", path))
+			}
+			// scan (and check access to) what the path really points at
+			real, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				return errorResponse(req.ID, &ipc.Error{
+					Code:    ipc.CodeNotFound,
+					Message: err.Error(),
+					Details: map[string]any{"path": path},
+				})
+			}
+			if err := s.checkReadable(peer, real); err != nil {
+				return errorResponse(req.ID, err)
+			}
+			target.paths = append(target.paths, real)
+		}
+	} else {
+		if scanType == "" {
+			scanType = "quick"
+		}
+		if scanType != "quick" && scanType != "full" {
+			return errorResponse(req.ID, invalidParams("unknown scan type %q", scanType))
+		}
+		target.paths = s.presetPaths(scanType)
+	}
+
+	job, done, err := s.startScan(scanType, target)
+	if err != nil {
+		return errorResponse(req.ID, err)
+	}
+	if params.Follow {
+		select {
+		case job = <-done:
+		case <-ctx.Done():
+			s.daemon.Scans().Cancel(job.ID)
+			return errorResponse(req.ID, ctx.Err())
+		}
+	}
+	return makeResponse(req.ID, scanStatus(job))
+}
+
+// startScan registers a scan job and runs it in the background. done
+// gets the job once it has finished.
+func (s *Server) startScan(scanType string, target scanTarget) (ScanJob, <-chan ScanJob, error) {
+	job, ctx, err := s.daemon.Scans().Start(scanType)
+	if err != nil {
+		return ScanJob{}, nil, err
+	}
+	job.Private, job.Owner = target.as != nil, target.owner
+	s.daemon.Scans().Update(func(j *ScanJob) { j.Private, j.Owner = job.Private, job.Owner })
+	s.daemon.State().SetState(StateScanning)
+	s.publishScan(job)
+	done := make(chan ScanJob, 1)
+	go func() { done <- s.runScan(ctx, job, target) }()
+	return job, done, nil
+}
+
+// runScan performs a scan using ClamAV until done or cancelled.
+func (s *Server) runScan(ctx context.Context, job ScanJob, target scanTarget) ScanJob {
+	evt := events.StartScan(job.Type, job.ID)
 	defer func() {
 		s.daemon.Events().Emit(evt.End())
 	}()
 
 	if !s.daemon.Scanner().IsAvailable() {
-		evt.SetError(fmt.Errorf("ClamAV not available"))
+		err := fmt.Errorf("ClamAV not available")
+		evt.SetError(err)
+		done := s.daemon.Scans().Finish(ScanFailed, err)
+		s.publishScan(done)
 		s.daemon.State().SetState(StateWarning)
-		return
+		return done
 	}
 
-	var paths []string
-	if scanType == "quick" {
-		paths = s.daemon.Config().Scanning.QuickScanPaths
-	} else {
-		// Full scan: start from root (be careful with this)
-		paths = []string{"/home", "/tmp", "/var/tmp"}
+	exclude := append(slices.Clone(s.daemon.Config().Scanning.Exclusions), target.exclude...)
+	last := time.Now()
+	progress := func() {
+		if time.Since(last) < scanProgressInterval {
+			return
+		}
+		last = time.Now()
+		if job, err := s.daemon.Scans().Get(job.ID); err == nil {
+			s.publishScan(job)
+		}
 	}
-
-	var filesScanned, threatsFound int
-	for _, basePath := range paths {
-		s.scanDirectory(basePath, &filesScanned, &threatsFound)
+	for _, basePath := range target.paths {
+		if target.as != nil {
+			s.scanAs(ctx, target.as, job.ID, basePath, target.depth, exclude, progress)
+			continue
+		}
+		s.scanDirectory(ctx, job.ID, basePath, target.depth, exclude, progress)
 	}
 
-	evt.FilesScanned(filesScanned).ThreatsFound(threatsFound)
+	state := ScanCompleted
+	if ctx.Err() != nil {
+		state = ScanCancelled
+		evt.SetError(errors.New("scan cancelled"))
+	}
+	done := s.daemon.Scans().Finish(state, nil)
+	s.publishScan(done)
+	evt.FilesScanned(done.FilesScanned).ThreatsFound(done.ThreatsFound)
 	s.daemon.SetLastScan(time.Now())
 
-	if threatsFound > 0 {
+	if done.ThreatsFound > 0 {
 		s.daemon.State().SetState(StateAlert)
 	} else {
 		s.daemon.State().SetState(StateProtected)
 	}
+	return done
+}
+
+// scanProgressInterval limits how often scan progress is pushed to
+// watching clients.
+const scanProgressInterval = time.Second
+
+func (s *Server) publishScan(job ScanJob) {
+	s.daemon.Notifier().Publish(ipc.KindScanProgress, ipc.ScanProgressEvent{
+		JobID:        job.ID,
+		Type:         job.Type,
+		Status:       job.State,
+		FilesScanned: job.FilesScanned,
+		ThreatsFound: job.ThreatsFound,
+		Error:        job.Error,
+	})
 }
 
-// scanDirectory recursively scans a directory.
-func (s *Server) scanDirectory(basePath string, filesScanned, threatsFound *int) {
+// scanDirectory recursively scans a directory (or just a file), stopping
+// early if ctx is cancelled. depth limits how far down it goes (0 = no
+// limit) and paths matching exclude are skipped. progress is called after
+// every file.
+func (s *Server) scanDirectory(ctx context.Context, jobID, basePath string, depth int, exclude []string, progress func()) {
 	filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return filepath.SkipAll
+		}
 		if err != nil {
 			return nil // skip inaccessible paths
 		}
+		if excluded(path, exclude) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		if info.IsDir() {
+			if depth > 0 && path != basePath && walkDepth(basePath, path) >= depth {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
-		result := s.daemon.Scanner().ScanFile(path)
-		if result.Error != nil {
-			return nil // skip files that can't be scanned
+		s.recordScan(nil, jobID, info.Size(), s.daemon.Scanner().ScanFile(path))
+		progress()
+		return nil
+	})
+}
+
+// walkOpenFlags open entries of a restricted walk without following
+// symlinks or blocking on FIFOs.
+const walkOpenFlags = syscall.O_RDONLY | syscall.O_NOFOLLOW | syscall.O_NONBLOCK | syscall.O_CLOEXEC
+
+// scanAs is scanDirectory for a caller who isn't privileged: it only goes
+// where a says they could. The walk holds descriptors, opened without
+// following symlinks, and checks each one's mode, then hands clamd the
+// open file; swapping a directory for a symlink mid-scan can't lead it
+// into someone else's files.
+func (s *Server) scanAs(ctx context.Context, a *access, jobID, basePath string, depth int, exclude []string, progress func()) {
+	if excluded(basePath, exclude) {
+		return
+	}
+	fd, err := a.open(basePath)
+	if err != nil {
+		return
+	}
+	s.scanAt(ctx, a, jobID, fd, basePath, basePath, depth, exclude, progress)
+}
+
+// scanAt scans the file or directory open as fd, and closes it.
+func (s *Server) scanAt(ctx context.Context, a *access, jobID string, fd int, path, basePath string, depth int, exclude []string, progress func()) {
+	f := os.NewFile(uintptr(fd), path)
+	defer f.Close()
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return
+	}
+
+	switch st.Mode & syscall.S_IFMT {
+	case syscall.S_IFREG:
+		if a.allows(&st, 4) {
+			s.recordScan(a, jobID, st.Size, s.daemon.Scanner().ScanFD(f, path))
+			progress()
 		}
+	case syscall.S_IFDIR:
+		if !a.allows(&st, 4) || !a.allows(&st, 1) {
+			return
+		}
+		if depth > 0 && path != basePath && walkDepth(basePath, path) >= depth {
+			return
+		}
+		entries, err := f.ReadDir(-1)
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			if ctx.Err() != nil {
+				return
+			}
+			child := filepath.Join(path, e.Name())
+			if (!e.Type().IsRegular() && !e.IsDir()) || excluded(child, exclude) {
+				continue
+			}
+			cfd, err := syscall.Openat(fd, e.Name(), walkOpenFlags, 0)
+			if err != nil {
+				continue // gone, or swapped for a symlink
+			}
+			s.scanAt(ctx, a, jobID, cfd, child, basePath, depth, exclude, progress)
+		}
+	}
+}
+
+// recordScan adds one scanned file to the running job and reports what
+// was found. Files clamd couldn't scan are left out. a is the caller a
+// restricted scan runs for: they're the only one (besides admins) told
+// about what it finds, and nothing is quarantined, since quarantine goes
+// by path and they could have swapped in someone else's file since.
+func (s *Server) recordScan(a *access, jobID string, size int64, result *scanner.ScanResult) {
+	if result.Error != nil {
+		return
+	}
+	s.daemon.Scans().Update(func(j *ScanJob) { j.FilesScanned++ })
+	if result.Clean {
+		return
+	}
+
+	threat := ScanThreat{Path: result.Path, Threat: result.Threat, Action: "detected"}
+	if a == nil {
+		threat = s.handleThreat(result.Path, result.Threat)
+	}
+	s.daemon.Scans().Update(func(j *ScanJob) {
+		j.ThreatsFound++
+		j.Threats = append(j.Threats, threat)
+	})
+	threatEvt := events.StartThreat(result.Path, result.Threat).
+		Action(threat.Action).
+		FileSize(size)
+	note := ipc.ThreatEvent{
+		JobID:        jobID,
+		Path:         result.Path,
+		Threat:       result.Threat,
+		Action:       threat.Action,
+		QuarantineID: threat.QuarantineID,
+	}
+	if a != nil {
+		s.daemon.Events().Emit(threatEvt.Owner(a.peer.UID).End())
+		s.daemon.Notifier().PublishFor(a.peer.UID, ipc.KindThreat, note)
+		return
+	}
+	s.daemon.Events().Emit(threatEvt.End())
+	s.daemon.Notifier().Publish(ipc.KindThreat, note)
+}
 
-		*filesScanned++
-		if !result.Clean {
-			*threatsFound++
-			// Emit threat detection event
-			threatEvt := events.StartThreat(path, result.Threat).
-				Action("detected").
-				FileSize(info.Size())
-			s.daemon.Events().Emit(threatEvt.End())
+// excluded reports whether path matches one of patterns: the whole path,
+// or just its name for patterns without a slash.
+func excluded(path string, patterns []string) bool {
+	for _, pattern := range patterns {
+		name := path
+		if !strings.Contains(pattern, "/") {
+			name = filepath.Base(path)
 		}
-		return nil
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// walkDepth is how many directories down from base path is; files
+// directly in base are at depth 1.
+func walkDepth(base, path string) int {
+	rel, err := filepath.Rel(base, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// handleThreat quarantines an infected file if configured to, falling
+// back to just reporting it.
+func (s *Server) handleThreat(path, threat string) ScanThreat {
+	t := ScanThreat{Path: path, Threat: threat, Action: "detected"}
+	if s.daemon.Config().Scanning.Action != "quarantine" {
+		return t
+	}
+	store, err := s.daemon.Quarantine()
+	if err == nil {
+		var item quarantine.Item
+		if item, err = store.Add(path, threat); err == nil {
+			t.Action, t.QuarantineID = "quarantined", item.ID
+			s.publishQuarantine("quarantined", item)
+			return t
+		}
+	}
+	slog.Error("failed to quarantine file", "path", path, "threat", threat, "error", err)
+	return t
+}
+
+func scanStatus(job ScanJob) ipc.ScanStatusResponse {
+	resp := ipc.ScanStatusResponse{
+		JobID:        job.ID,
+		Type:         job.Type,
+		Status:       job.State,
+		FilesScanned: job.FilesScanned,
+		ThreatsFound: job.ThreatsFound,
+		StartedAt:    job.Started,
+		FinishedAt:   job.Finished,
+		Threats:      []ipc.ScanThreat{},
+		Error:        job.Error,
+	}
+	if job.State != ScanRunning {
+		resp.Progress = 1
+	}
+	for _, t := range job.Threats {
+		resp.Threats = append(resp.Threats, ipc.ScanThreat{
+			Path:         t.Path,
+			Threat:       t.Threat,
+			Action:       t.Action,
+			QuarantineID: t.QuarantineID,
+		})
+	}
+	return resp
+}
+
+// scanStatusFor is scanStatus as peer may see it: a private job's threats
+// are only listed for its owner and privileged callers.
+func (s *Server) scanStatusFor(peer Peer, job ScanJob) ipc.ScanStatusResponse {
+	if job.Private && job.Owner != peer.UID && !s.privileged(peer) {
+		job.Threats = nil
+	}
+	return scanStatus(job)
+}
+
+// handleQuarantine lists, restores or deletes quarantined files.
+func (s *Server) handleQuarantine(ctx context.Context, req *ipc.Request) *ipc.Response {
+	store, err := s.daemon.Quarantine()
+	if err != nil {
+		return errorResponse(req.ID, err)
+	}
+
+	if req.Command == ipc.CmdQuarantineList {
+		items, err := store.List()
+		if err != nil {
+			return errorResponse(req.ID, err)
+		}
+		list := ipc.QuarantineListResponse{Items: []ipc.QuarantineItem{}}
+		for _, item := range items {
+			list.Items = append(list.Items, quarantineItem(item))
+		}
+		return makeResponse(req.ID, list)
+	}
+
+	var params ipc.QuarantineParams
+	if err := decodeParams(req, &params); err != nil {
+		return errorResponse(req.ID, err)
+	}
+	if req.Command == ipc.CmdQuarantineDelete {
+		item, err := store.Get(params.ID)
+		if err == nil {
+			err = store.Delete(params.ID)
+		}
+		if err != nil {
+			return errorResponse(req.ID, err)
+		}
+		s.publishQuarantine("deleted", item)
+		return makeResponse(req.ID, "deleted")
+	}
+	item, err := store.Restore(ctx, params.ID)
+	if err != nil {
+		return errorResponse(req.ID, err)
+	}
+	slog.Warn("quarantined file restored", "path", item.OriginalPath, "threat", item.Threat)
+	s.publishQuarantine("restored", item)
+	return makeResponse(req.ID, quarantineItem(item))
+}
+
+func (s *Server) publishQuarantine(action string, item quarantine.Item) {
+	s.daemon.Notifier().Publish(ipc.KindQuarantine, ipc.QuarantineEvent{
+		Action: action,
+		ID:     item.ID,
+		Path:   item.OriginalPath,
+		Threat: item.Threat,
+	})
+}
+
+func quarantineItem(item quarantine.Item) ipc.QuarantineItem {
+	return ipc.QuarantineItem{
+		ID:            item.ID,
+		OriginalPath:  item.OriginalPath,
+		Threat:        item.Threat,
+		Size:          item.Size,
+		SHA256:        item.SHA256,
+		QuarantinedAt: item.QuarantinedAt,
+	}
+}
+
+// handleEvents queries the in-memory event buffer, then the event store
+// for anything older.
+func (s *Server) handleEvents(ctx context.Context, peer Peer, req *ipc.Request) *ipc.Response {
+	var params ipc.EventsParams
+	if err := decodeParams(req, &params); err != nil {
+		return errorResponse(req.ID, err)
+	}
+	filter := events.Filter{
+		Type:      events.EventType(params.Type),
+		Component: params.Component,
+		Since:     params.Since,
+		Success:   params.Success,
+		// before the limit is counted, so nobody gets short-changed
+		Restricted: !s.privileged(peer),
+		UID:        peer.UID,
+	}
+	found := s.daemon.RecentEvents().Query(filter, params.Limit)
+	// the buffer only holds the last recentEvents, older ones come from
+	// the store when there is one
+	if store := s.daemon.EventStore(); store != nil && (params.Limit <= 0 || len(found) < params.Limit) {
+		opts := logging.EventQueryOptions{
+			Type:       filter.Type,
+			Component:  filter.Component,
+			Success:    filter.Success,
+			Since:      filter.Since,
+			Restricted: filter.Restricted,
+			UID:        filter.UID,
+		}
+		if params.Limit > 0 {
+			opts.Limit = params.Limit - len(found)
+		}
+		if len(found) > 0 {
+			// everything matching since then came from the buffer
+			opts.Before = found[len(found)-1].StartedAt
+		}
+		older, err := store.QueryEvents(ctx, opts)
+		if err != nil {
+			return errorResponse(req.ID, err)
+		}
+		found = append(found, older...)
+	}
+
+	result := ipc.EventsResponse{Events: []ipc.Event{}}
+	for _, evt := range found {
+		result.Events = append(result.Events, ipcEvent(evt))
+	}
+	return makeResponse(req.ID, result)
+}
+
+// appRules reports app firewall settings and per-rule resolution.
+func (s *Server) appRules() ipc.AppRulesResponse {
+	m := s.daemon.AppFirewall()
+	cfg := m.Config()
+	result := ipc.AppRulesResponse{
+		Enabled:  cfg.Enabled,
+		Default:  cfg.Default,
+		Learning: m.Learning(),
+		Rules:    []ipc.AppRuleStatus{},
+	}
+	for _, r := range m.Rules() {
+		result.Rules = append(result.Rules, ipc.AppRuleStatus{
+			Name:    r.Name,
+			Exe:     r.Exe,
+			Cgroup:  r.Cgroup,
+			Action:  r.Action,
+			Cgroups: r.Cgroups,
+			Error:   r.Error,
+		})
+	}
+	return result
+}
+
+// appLearned returns apps recorded in learning mode and suggested rules.
+// Unprivileged callers only see their own apps, like with net_inventory.
+func (s *Server) appLearned(peer Peer) ipc.AppLearnedResponse {
+	m := s.daemon.AppFirewall()
+	apps, proposed := m.Learned(), m.Proposals()
+	if !s.privileged(peer) {
+		apps, proposed = ownApps(apps, proposed, peer.UID)
+	}
+	result := ipc.AppLearnedResponse{Apps: []ipc.LearnedApp{}, Proposed: []ipc.AppRule{}}
+	for _, app := range apps {
+		la := ipc.LearnedApp{
+			Exe:       app.Exe,
+			Cgroup:    app.Cgroup,
+			UID:       app.UID,
+			FirstSeen: app.FirstSeen,
+			LastSeen:  app.LastSeen,
+		}
+		for _, d := range app.Destinations {
+			la.Destinations = append(la.Destinations, ipc.AppDestination{
+				Address:  d.Addr.String(),
+				Port:     d.Port,
+				Protocol: d.Proto,
+				Count:    d.Count,
+				LastSeen: d.LastSeen,
+			})
+		}
+		result.Apps = append(result.Apps, la)
+	}
+	for _, r := range proposed {
+		result.Proposed = append(result.Proposed, ipc.AppRule{Name: r.Name, Exe: r.Exe, Cgroup: r.Cgroup, Action: r.Action})
+	}
+	return result
+}
+
+// ownApps keeps the apps run by uid and the rules proposed for them.
+func ownApps(apps []appfw.LearnedApp, proposed []config.AppRule, uid int) ([]appfw.LearnedApp, []config.AppRule) {
+	var own []appfw.LearnedApp
+	seen := make(map[string]bool)
+	for _, app := range apps {
+		if app.UID != uid {
+			continue
+		}
+		own = append(own, app)
+		seen["exe:"+app.Exe] = true
+		seen["cgroup:"+app.Cgroup] = true
+	}
+	var rules []config.AppRule
+	for _, r := range proposed {
+		if (r.Exe != "" && seen["exe:"+r.Exe]) || (r.Cgroup != "" && seen["cgroup:"+r.Cgroup]) {
+			rules = append(rules, r)
+		}
+	}
+	return own, rules
+}
+
+// rulesStatus reports each rule source's version and whether it's stale.
+func (s *Server) rulesStatus() ipc.RulesStatusResponse {
+	mgr := s.daemon.Rules()
+	now := time.Now()
+	resp := ipc.RulesStatusResponse{
+		Updating: mgr.Updating(),
+		MaxAge:   mgr.MaxAge().String(),
+		Sources:  []ipc.RuleSource{},
+		Bundle:   mgr.BundleVersion(),
+	}
+	for _, st := range mgr.Status() {
+		stale := st.Stale(now, mgr.MaxAge())
+		resp.Stale = resp.Stale || stale
+		resp.Sources = append(resp.Sources, ipc.RuleSource{
+			Name:    st.Name,
+			Kind:    st.Kind,
+			Version: st.Version,
+			Updated: st.Updated,
+			Checked: st.Checked,
+			Error:   st.Error,
+			Stale:   stale,
+		})
+	}
+	return resp
+}
+
+// handleRulesImport installs an offline bundle. The import stops if the
+// caller goes away before it's done.
+func (s *Server) handleRulesImport(ctx context.Context, req *ipc.Request) *ipc.Response {
+	var params ipc.RulesImportParams
+	if err := decodeParams(req, &params); err != nil {
+		return errorResponse(req.ID, err)
+	}
+	if !filepath.IsAbs(params.Path) {
+		return errorResponse(req.ID, invalidParams("bundle path must be absolute: %q", params.Path))
+	}
+
+	res, err := s.daemon.ImportRules(ctx, params.Path)
+	if err != nil {
+		return errorResponse(req.ID, err)
+	}
+	return makeResponse(req.ID, ipc.RulesImportResponse{
+		Version:   res.Version,
+		Created:   res.Created,
+		Installed: res.Installed,
 	})
 }
+
+// handleNetInventory returns listening sockets and connections with owners.
+// Unprivileged callers only get their own, since the rest says what
+// other users run and talk to.
+func (s *Server) handleNetInventory(peer Peer, req *ipc.Request) *ipc.Response {
+	var params ipc.NetInventoryParams
+	if err := decodeParams(req, &params); err != nil {
+		return errorResponse(req.ID, err)
+	}
+
+	snap, err := s.daemon.Network().Collect()
+	if err != nil {
+		return errorResponse(req.ID, fmt.Errorf("collect inventory: %w", err))
+	}
+
+	result := ipc.NetInventoryResponse{
+		Time:        snap.Time,
+		Listening:   []ipc.NetSocket{},
+		Connections: []ipc.NetSocket{},
+	}
+	all := s.privileged(peer)
+	for _, sock := range snap.Listening {
+		if !all && sock.UID != peer.UID {
+			continue
+		}
+		ns := netSocket(sock)
+		ns.Unexpected = !s.daemon.ExpectedListener(sock)
+		result.Listening = append(result.Listening, ns)
+	}
+	if !params.ListeningOnly {
+		for _, sock := range snap.Connections {
+			if !all && sock.UID != peer.UID {
+				continue
+			}
+			result.Connections = append(result.Connections, netSocket(sock))
+		}
+	}
+	return makeResponse(req.ID, result)
+}
+
+func netSocket(s inventory.Socket) ipc.NetSocket {
+	ns := ipc.NetSocket{
+		Protocol:     s.Proto,
+		LocalAddress: s.Local.Addr().String(),
+		LocalPort:    int(s.Local.Port()),
+		State:        s.State,
+		PID:          s.PID,
+		UID:          s.UID,
+		User:         s.User,
+		Command:      s.Comm,
+		Executable:   s.Exe,
+		NonLoopback:  s.NonLoopback(),
+	}
+	if s.Remote.IsValid() {
+		ns.RemoteAddress = s.Remote.Addr().String()
+		ns.RemotePort = int(s.Remote.Port())
+	}
+	return ns
+}
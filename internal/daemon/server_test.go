@@ -4,25 +4,63 @@ package daemon
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log/slog"
 	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/godbus/dbus/v5"
+	"github.com/oreonproject/defense/internal/appfw"
+	"github.com/oreonproject/defense/internal/dbustest"
+	"github.com/oreonproject/defense/internal/firewall"
+	"github.com/oreonproject/defense/internal/ids"
+	"github.com/oreonproject/defense/internal/polkit"
+	"github.com/oreonproject/defense/internal/quarantine"
+	"github.com/oreonproject/defense/internal/scanner"
 	"github.com/oreonproject/defense/pkg/config"
+	"github.com/oreonproject/defense/pkg/events"
 	"github.com/oreonproject/defense/pkg/ipc"
+	"github.com/oreonproject/defense/pkg/logging"
 )
 
+// nopRunner stands in for nft so tests never touch the host's rules.
+type nopRunner struct{}
+
+func (nopRunner) Run(context.Context, string) error { return nil }
+
 func setupTestServer(t *testing.T) (*Server, string, func()) {
 	t.Helper()
+	return setupTestServerAs(t, nil)
+}
+
+// setupTestServerAs is setupTestServer with every client connecting as
+// peer (nil = whoever runs the test).
+func setupTestServerAs(t *testing.T, peer *Peer) (*Server, string, func()) {
+	t.Helper()
 
 	cfg := &config.Config{}
 	d := New(cfg, slog.Default())
+	d.firewall = firewall.New(cfg.Firewall, firewall.WithRunner(nopRunner{}))
+	d.ids = ids.New(cfg.IDS, d.firewall, d.events, slog.Default())
+	d.appfw = appfw.New(cfg.AppFirewall, d.firewall, slog.Default())
 	d.State().SetState(StateProtected)
 
 	sockPath := t.TempDir() + "/test.sock"
 	server := NewServer(sockPath, d)
+	if peer != nil {
+		server.credentials = func(net.Conn) (Peer, error) { return *peer, nil }
+	}
 
 	if err := server.Listen(); err != nil {
 		t.Fatalf("Listen() error = %v", err)
@@ -198,6 +236,69 @@ func TestServer_UnknownCommand(t *testing.T) {
 	if resp.Error == "" {
 		t.Error("Error is empty for unknown command")
 	}
+	if resp.Code != ipc.CodeUnknownCommand {
+		t.Errorf("Code = %q, want %q", resp.Code, ipc.CodeUnknownCommand)
+	}
+
+	// non-root callers learn it doesn't exist, not that they may not run it
+	_, sockPath, cleanup = setupTestServerAs(t, &Peer{UID: 1000, GID: 1000, PID: -1})
+	defer cleanup()
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "2", Command: "unknown_command"})
+	if resp.Code != ipc.CodeUnknownCommand {
+		t.Errorf("Code as uid 1000 = %q, want %q", resp.Code, ipc.CodeUnknownCommand)
+	}
+}
+
+func TestServer_Hello(t *testing.T) {
+	_, sockPath, cleanup := setupTestServerAs(t, &Peer{UID: 1000, GID: 1000, PID: -1})
+	defer cleanup()
+
+	params, _ := json.Marshal(ipc.HelloParams{Client: "test/1", Protocol: ipc.ProtocolVersion})
+	resp := sendRequest(t, sockPath, &ipc.Request{ID: "1", Command: ipc.CmdHello, Params: params})
+	if !resp.Success {
+		t.Fatalf("hello failed: %s", resp.Error)
+	}
+	var hello ipc.HelloResponse
+	if err := resp.UnmarshalData(&hello); err != nil {
+		t.Fatal(err)
+	}
+	if hello.Daemon != Version || hello.Protocol != ipc.ProtocolVersion || hello.MinProtocol != ipc.MinProtocolVersion {
+		t.Errorf("hello = %+v", hello)
+	}
+	for _, cmd := range []string{ipc.CmdHello, ipc.CmdStatus, ipc.CmdWatch} {
+		if !slices.Contains(hello.Commands, cmd) {
+			t.Errorf("hello.Commands missing %q", cmd)
+		}
+	}
+	if !hello.Has("error_codes") || hello.Has("http") {
+		t.Errorf("hello.Features = %v", hello.Features)
+	}
+}
+
+func TestServer_ErrorCodes(t *testing.T) {
+	server, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	if _, _, err := server.daemon.Scans().Start("quick"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		cmd, params, code string
+	}{
+		{ipc.CmdScanFull, "", ipc.CodeBusy},
+		{ipc.CmdScanStatus, `{"job_id":"nope"}`, ipc.CodeNotFound},
+		{ipc.CmdPause, `{"duration":"soon"}`, ipc.CodeInvalidParams},
+		{ipc.CmdPause, `{"duration":5}`, ipc.CodeInvalidParams},
+		{ipc.CmdQuarantineList, "", ipc.CodeUnavailable},
+	} {
+		req := &ipc.Request{ID: "1", Command: tt.cmd}
+		if tt.params != "" {
+			req.Params = json.RawMessage(tt.params)
+		}
+		if resp := sendRequest(t, sockPath, req); resp.Success || resp.Code != tt.code {
+			t.Errorf("%s %s = %+v, want code %q", tt.cmd, tt.params, resp, tt.code)
+		}
+	}
 }
 
 func TestServer_InvalidJSON(t *testing.T) {
@@ -262,6 +363,9 @@ func TestServer_BadVersion(t *testing.T) {
 	if resp.Error == "" {
 		t.Error("Error is empty for mismatched version")
 	}
+	if resp.Code != ipc.CodeVersionMismatch || resp.Details["max"] != float64(ipc.ProtocolVersion) {
+		t.Errorf("Code = %q, Details = %v", resp.Code, resp.Details)
+	}
 }
 
 func TestServer_LegacyClient(t *testing.T) {
@@ -279,3 +383,1271 @@ func TestServer_LegacyClient(t *testing.T) {
 		t.Error("Success = false for version 0 (legacy client)")
 	}
 }
+
+func TestServer_FirewallStatus(t *testing.T) {
+	server, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	if err := server.daemon.SetFirewallEnabled(t.Context(), true); err != nil {
+		t.Fatalf("SetFirewallEnabled error = %v", err)
+	}
+
+	resp := sendRequest(t, sockPath, &ipc.Request{
+		ID:      "1",
+		Command: ipc.CmdFirewallStatus,
+	})
+	if !resp.Success {
+		t.Fatalf("FirewallStatus failed: %s", resp.Error)
+	}
+
+	var status ipc.FirewallStatusResponse
+	if err := resp.UnmarshalData(&status); err != nil {
+		t.Fatalf("UnmarshalData error: %v", err)
+	}
+	if !status.Enabled || status.Backend != "nftables" || status.TableCount != 1 || status.RuleCount == 0 {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestServer_FirewallPanic(t *testing.T) {
+	_, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	params, _ := json.Marshal(ipc.FirewallPanicParams{Enabled: true})
+	resp := sendRequest(t, sockPath, &ipc.Request{
+		ID:      "1",
+		Command: ipc.CmdFirewallPanic,
+		Params:  params,
+	})
+	if !resp.Success {
+		t.Fatalf("FirewallPanic failed: %s", resp.Error)
+	}
+
+	var status ipc.FirewallStatusResponse
+	if err := resp.UnmarshalData(&status); err != nil {
+		t.Fatalf("UnmarshalData error: %v", err)
+	}
+	if !status.Panic || status.ChainCount != 3 {
+		t.Errorf("status = %+v, want panic with 3 chains", status)
+	}
+}
+
+func TestServer_FirewallBlocked(t *testing.T) {
+	server, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	blocks := server.daemon.Firewall().Blocked()
+	for _, src := range []string{"203.0.113.5", "203.0.113.5", "198.51.100.9"} {
+		blocks.Record(firewall.BlockedPacket{
+			Time:     time.Now(),
+			Source:   netip.MustParseAddr(src),
+			Dest:     netip.MustParseAddr("192.168.1.10"),
+			DestPort: 22,
+			Protocol: "tcp",
+		})
+	}
+
+	params, _ := json.Marshal(ipc.FirewallBlockedParams{Top: 1})
+	resp := sendRequest(t, sockPath, &ipc.Request{
+		ID:      "1",
+		Command: ipc.CmdFirewallBlocked,
+		Params:  params,
+	})
+	if !resp.Success {
+		t.Fatalf("FirewallBlocked failed: %s", resp.Error)
+	}
+
+	var blocked ipc.FirewallBlockedResponse
+	if err := resp.UnmarshalData(&blocked); err != nil {
+		t.Fatalf("UnmarshalData error: %v", err)
+	}
+	if len(blocked.Recent) != 3 {
+		t.Errorf("len(Recent) = %d, want 3", len(blocked.Recent))
+	}
+	if len(blocked.TopSources) != 1 || blocked.TopSources[0].Source != "203.0.113.5" || blocked.TopSources[0].Count != 2 {
+		t.Errorf("TopSources = %+v", blocked.TopSources)
+	}
+}
+
+func TestServer_FirewallBlockedBadSource(t *testing.T) {
+	_, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	params, _ := json.Marshal(ipc.FirewallBlockedParams{Source: "not-an-ip"})
+	resp := sendRequest(t, sockPath, &ipc.Request{
+		ID:      "1",
+		Command: ipc.CmdFirewallBlocked,
+		Params:  params,
+	})
+	if resp.Success {
+		t.Error("Success = true for invalid source address")
+	}
+}
+
+func TestServer_Bans(t *testing.T) {
+	server, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	addr := netip.MustParseAddr("203.0.113.50")
+	if err := server.daemon.Firewall().Ban(context.Background(), addr, time.Hour, ids.ReasonSSHBruteForce); err != nil {
+		t.Fatalf("Ban error = %v", err)
+	}
+
+	resp := sendRequest(t, sockPath, &ipc.Request{ID: "1", Command: ipc.CmdBansList})
+	if !resp.Success {
+		t.Fatalf("BansList failed: %s", resp.Error)
+	}
+	var bans ipc.BansResponse
+	if err := resp.UnmarshalData(&bans); err != nil {
+		t.Fatalf("UnmarshalData error: %v", err)
+	}
+	if len(bans.Bans) != 1 || bans.Bans[0].Address != addr.String() || bans.Bans[0].Reason != ids.ReasonSSHBruteForce {
+		t.Fatalf("Bans = %+v", bans.Bans)
+	}
+
+	params, _ := json.Marshal(ipc.BanLiftParams{Address: addr.String()})
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "2", Command: ipc.CmdBanLift, Params: params})
+	if !resp.Success {
+		t.Fatalf("BanLift failed: %s", resp.Error)
+	}
+	if len(server.daemon.Firewall().Bans()) != 0 {
+		t.Error("ban still present after lift")
+	}
+
+	// lifting again fails
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "3", Command: ipc.CmdBanLift, Params: params})
+	if resp.Success {
+		t.Error("Success = true lifting a ban that doesn't exist")
+	}
+}
+
+func TestServer_AppLearning(t *testing.T) {
+	_, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	params, _ := json.Marshal(ipc.AppLearningParams{Enabled: true})
+	resp := sendRequest(t, sockPath, &ipc.Request{ID: "1", Command: ipc.CmdAppLearning, Params: params})
+	if !resp.Success {
+		t.Fatalf("AppLearning failed: %s", resp.Error)
+	}
+	var rules ipc.AppRulesResponse
+	if err := resp.UnmarshalData(&rules); err != nil {
+		t.Fatalf("UnmarshalData error: %v", err)
+	}
+	if !rules.Learning {
+		t.Error("learning not enabled")
+	}
+
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "2", Command: ipc.CmdAppLearned})
+	if !resp.Success {
+		t.Fatalf("AppLearned failed: %s", resp.Error)
+	}
+	var learned ipc.AppLearnedResponse
+	if err := resp.UnmarshalData(&learned); err != nil {
+		t.Fatalf("UnmarshalData error: %v", err)
+	}
+	if learned.Apps == nil || learned.Proposed == nil {
+		t.Errorf("learned = %+v, want empty lists rather than null", learned)
+	}
+}
+
+func TestOwnApps(t *testing.T) {
+	apps := []appfw.LearnedApp{
+		{Exe: "/usr/bin/curl", Cgroup: "user.slice/user-1000.slice/session-2.scope", UID: 1000},
+		{Exe: "/usr/bin/ssh", Cgroup: "user.slice/user-1001.slice/session-3.scope", UID: 1001},
+		{Exe: "/usr/sbin/chronyd", Cgroup: "system.slice/chronyd.service", UID: 0},
+	}
+	proposed := []config.AppRule{
+		{Name: "curl", Exe: "/usr/bin/curl", Action: "allow"},
+		{Name: "ssh", Exe: "/usr/bin/ssh", Action: "allow"},
+		{Name: "chronyd", Cgroup: "system.slice/chronyd.service", Action: "allow"},
+	}
+
+	own, rules := ownApps(apps, proposed, 1000)
+	if len(own) != 1 || own[0].Exe != "/usr/bin/curl" {
+		t.Errorf("apps = %+v, want only curl", own)
+	}
+	if len(rules) != 1 || rules[0].Name != "curl" {
+		t.Errorf("proposed = %+v, want only curl", rules)
+	}
+}
+
+func TestServer_NetInventory(t *testing.T) {
+	_, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	// our own listening socket is a unix socket, so open a TCP one to find
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	params, _ := json.Marshal(ipc.NetInventoryParams{ListeningOnly: true})
+	resp := sendRequest(t, sockPath, &ipc.Request{ID: "1", Command: ipc.CmdNetInventory, Params: params})
+	if !resp.Success {
+		t.Fatalf("NetInventory failed: %s", resp.Error)
+	}
+
+	var inv ipc.NetInventoryResponse
+	if err := resp.UnmarshalData(&inv); err != nil {
+		t.Fatalf("UnmarshalData error: %v", err)
+	}
+	if len(inv.Connections) != 0 {
+		t.Errorf("connections returned with listening_only")
+	}
+	found := false
+	for _, s := range inv.Listening {
+		if s.Protocol == "tcp" && s.LocalPort == port {
+			if s.PID != os.Getpid() || s.NonLoopback || s.Unexpected {
+				t.Errorf("listener = %+v", s)
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("test listener on port %d not in inventory", port)
+	}
+
+	// other users only see their own sockets
+	_, sockPath, cleanup = setupTestServerAs(t, &Peer{UID: 1000, GID: 1000, PID: -1})
+	defer cleanup()
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "2", Command: ipc.CmdNetInventory})
+	if !resp.Success {
+		t.Fatalf("NetInventory as uid 1000 failed: %s", resp.Error)
+	}
+	inv = ipc.NetInventoryResponse{}
+	if err := resp.UnmarshalData(&inv); err != nil {
+		t.Fatalf("UnmarshalData error: %v", err)
+	}
+	for _, s := range append(inv.Listening, inv.Connections...) {
+		if s.UID != 1000 {
+			t.Errorf("uid 1000 got uid %d's socket: %+v", s.UID, s)
+		}
+	}
+}
+
+func TestServer_Rules(t *testing.T) {
+	_, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	resp := sendRequest(t, sockPath, &ipc.Request{ID: "1", Command: ipc.CmdRulesStatus})
+	if !resp.Success {
+		t.Fatalf("RulesStatus failed: %s", resp.Error)
+	}
+	var status ipc.RulesStatusResponse
+	if err := resp.UnmarshalData(&status); err != nil {
+		t.Fatalf("UnmarshalData error: %v", err)
+	}
+	// no signatures and no clamd in the test environment
+	if len(status.Sources) != 1 || status.Sources[0].Name != "clamav" || !status.Stale {
+		t.Fatalf("status = %+v", status)
+	}
+
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "2", Command: ipc.CmdRulesUpdate})
+	if !resp.Success {
+		t.Fatalf("RulesUpdate failed: %s", resp.Error)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp = sendRequest(t, sockPath, &ipc.Request{ID: "3", Command: ipc.CmdRulesStatus})
+		if err := resp.UnmarshalData(&status); err != nil {
+			t.Fatalf("UnmarshalData error: %v", err)
+		}
+		if !status.Updating || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Updating {
+		t.Fatal("update never finished")
+	}
+	if src := status.Sources[0]; src.Checked.IsZero() || src.Error == "" {
+		t.Errorf("clamav source after failed reload = %+v", src)
+	}
+}
+
+func TestServer_RulesImport(t *testing.T) {
+	_, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for path, code := range map[string]string{
+		"bundle.tar.gz":              ipc.CodeInvalidParams,
+		"/nonexistent/bundle.tar.gz": ipc.CodeFailed,
+	} {
+		params, _ := json.Marshal(ipc.RulesImportParams{Path: path})
+		resp := sendRequest(t, sockPath, &ipc.Request{ID: "1", Command: ipc.CmdRulesImport, Params: params})
+		if resp.Success || resp.Code != code {
+			t.Errorf("RulesImport(%s) = %v, code %q; want code %q", path, resp.Success, resp.Code, code)
+		}
+	}
+}
+
+func TestServer_ScanJobs(t *testing.T) {
+	_, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	resp := sendRequest(t, sockPath, &ipc.Request{ID: "1", Command: ipc.CmdScanStatus})
+	if resp.Success {
+		t.Error("ScanStatus succeeded before any scan ran")
+	}
+
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "2", Command: ipc.CmdScanQuick})
+	if !resp.Success {
+		t.Fatalf("ScanQuick failed: %s", resp.Error)
+	}
+	var started ipc.ScanResponse
+	resp.UnmarshalData(&started)
+
+	// no clamd in the test environment, so the job fails right away
+	var status ipc.ScanStatusResponse
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		params, _ := json.Marshal(ipc.ScanJobParams{JobID: started.JobID})
+		resp = sendRequest(t, sockPath, &ipc.Request{ID: "3", Command: ipc.CmdScanStatus, Params: params})
+		if !resp.Success {
+			t.Fatalf("ScanStatus failed: %s", resp.Error)
+		}
+		resp.UnmarshalData(&status)
+		if status.Status != "running" || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Status != "failed" || status.Error == "" || status.FinishedAt.IsZero() {
+		t.Errorf("status = %+v", status)
+	}
+
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "4", Command: ipc.CmdScanCancel})
+	if resp.Success {
+		t.Error("ScanCancel succeeded with nothing running")
+	}
+
+	params, _ := json.Marshal(ipc.ScanHistoryParams{Limit: 10})
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "5", Command: ipc.CmdScanHistory, Params: params})
+	var history ipc.ScanHistoryResponse
+	if err := resp.UnmarshalData(&history); err != nil {
+		t.Fatalf("UnmarshalData error: %v", err)
+	}
+	if len(history.Scans) != 1 || history.Scans[0].JobID != started.JobID {
+		t.Errorf("history = %+v", history.Scans)
+	}
+}
+
+func TestScanJobs_Cancel(t *testing.T) {
+	var jobs ScanJobs
+	job, ctx, err := jobs.Start("full")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := jobs.Start("quick"); err != ErrScanRunning {
+		t.Errorf("second Start error = %v, want ErrScanRunning", err)
+	}
+	if err := jobs.Cancel("other"); err == nil {
+		t.Error("Cancel(other) succeeded")
+	}
+	if err := jobs.Cancel(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	<-ctx.Done()
+	jobs.Finish(ScanCancelled, nil)
+
+	got, err := jobs.Get("")
+	if err != nil || got.ID != job.ID || got.State != ScanCancelled {
+		t.Errorf("Get() = %+v, %v", got, err)
+	}
+}
+
+// fakeClamd answers PING and SCAN, finding anything with EICAR in it.
+func fakeClamd(t *testing.T) *scanner.ClamAV {
+	t.Helper()
+	sockPath := t.TempDir() + "/clamd.sock"
+	ln, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				cmd, f := readClamdCommand(conn.(*net.UnixConn))
+				switch {
+				case cmd == "PING":
+					conn.Write([]byte("PONG\n"))
+				case strings.HasPrefix(cmd, "SCAN "):
+					path := strings.TrimPrefix(cmd, "SCAN ")
+					data, _ := os.ReadFile(path)
+					conn.Write([]byte(path + ": " + fakeVerdict(data) + "\n"))
+				case cmd == "zFILDES" && f != nil:
+					data, _ := io.ReadAll(f)
+					f.Close()
+					conn.Write([]byte("fd[5]: " + fakeVerdict(data) + "\x00"))
+				}
+			}()
+		}
+	}()
+	return scanner.New(sockPath)
+}
+
+// readClamdCommand reads one command, newline or NUL terminated, and the
+// descriptor passed along with FILDES.
+func readClamdCommand(conn *net.UnixConn) (string, *os.File) {
+	var data []byte
+	buf, oob := make([]byte, 256), make([]byte, syscall.CmsgSpace(4))
+	for {
+		n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			return "", nil
+		}
+		data = append(data, buf[:n]...)
+		if oobn > 0 {
+			msgs, _ := syscall.ParseSocketControlMessage(oob[:oobn])
+			if len(msgs) > 0 {
+				if fds, _ := syscall.ParseUnixRights(&msgs[0]); len(fds) > 0 {
+					cmd, _, _ := strings.Cut(string(data), "\x00")
+					return cmd, os.NewFile(uintptr(fds[0]), "fildes")
+				}
+			}
+		}
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			return string(data[:i]), nil
+		}
+	}
+}
+
+func fakeVerdict(data []byte) string {
+	if bytes.Contains(data, []byte("EICAR")) {
+		return "Eicar-Test-Signature FOUND"
+	}
+	return "OK"
+}
+
+func TestServer_ScanPaths(t *testing.T) {
+	server, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+	server.daemon.scanner = fakeClamd(t)
+	server.daemon.Config().Scanning.Exclusions = []string{"*.iso"}
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"clean.txt":          "hello",
+		"bad.txt":            "EICAR",
+		"big.iso":            "EICAR", // excluded by the config
+		"skip.tmp":           "EICAR", // excluded by the request
+		"sub/bad.txt":        "EICAR",
+		"sub/deeper/bad.txt": "EICAR", // below depth
+	} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	params, _ := json.Marshal(ipc.ScanParams{
+		Paths:   []string{dir},
+		Depth:   2,
+		Exclude: []string{"*.tmp"},
+		Follow:  true,
+	})
+	resp := sendRequest(t, sockPath, &ipc.Request{ID: "1", Command: ipc.CmdScan, Params: params})
+	if !resp.Success {
+		t.Fatalf("Scan failed: %s", resp.Error)
+	}
+	var status ipc.ScanStatusResponse
+	if err := resp.UnmarshalData(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Type != "custom" || status.Status != "completed" || status.FilesScanned != 3 || status.ThreatsFound != 2 {
+		t.Errorf("status = %+v", status)
+	}
+	var found []string
+	for _, threat := range status.Threats {
+		found = append(found, threat.Path)
+	}
+	slices.Sort(found)
+	want := []string{filepath.Join(dir, "bad.txt"), filepath.Join(dir, "sub/bad.txt")}
+	if !slices.Equal(found, want) {
+		t.Errorf("threats in %v, want %v", found, want)
+	}
+
+	// a single file, without following: the job is still running
+	params, _ = json.Marshal(ipc.ScanParams{Paths: []string{filepath.Join(dir, "clean.txt")}})
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "2", Command: ipc.CmdScan, Params: params})
+	if err := resp.UnmarshalData(&status); err != nil || status.Status != "running" || status.JobID == "" {
+		t.Errorf("unfollowed scan = %+v, %v (%s)", status, err, resp.Error)
+	}
+}
+
+func TestServer_ScanParams(t *testing.T) {
+	dir := t.TempDir()
+	private := filepath.Join(dir, "private")
+	if err := os.WriteFile(private, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	public := filepath.Join(dir, "public")
+	if err := os.WriteFile(public, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		params ipc.ScanParams
+		code   string
+	}{
+		{ipc.ScanParams{Paths: []string{"relative"}}, ipc.CodeInvalidParams},
+		{ipc.ScanParams{Paths: []string{dir + "/missing"}}, ipc.CodeNotFound},
+		{ipc.ScanParams{Paths: []string{public}, Engine: "yara"}, ipc.CodeInvalidParams},
+		{ipc.ScanParams{Paths: []string{public}, Depth: -1}, ipc.CodeInvalidParams},
+		{ipc.ScanParams{Paths: []string{public}, Exclude: []string{"["}}, ipc.CodeInvalidParams},
+		{ipc.ScanParams{Type: "thorough"}, ipc.CodeInvalidParams},
+		// someone else's file
+		{ipc.ScanParams{Paths: []string{private}}, ipc.CodePermissionDenied},
+	}
+
+	_, sockPath, cleanup := setupTestServerAs(t, &Peer{UID: 1000, GID: 1000, PID: -1})
+	defer cleanup()
+	for _, tt := range tests {
+		params, _ := json.Marshal(tt.params)
+		resp := sendRequest(t, sockPath, &ipc.Request{ID: "1", Command: ipc.CmdScan, Params: params})
+		if resp.Success || resp.Code != tt.code {
+			t.Errorf("%+v: success = %v, code = %q (%s), want %q", tt.params, resp.Success, resp.Code, resp.Error, tt.code)
+		}
+	}
+
+	// readable all the way down is fine
+	for d := dir; d != os.TempDir() && d != "/"; d = filepath.Dir(d) {
+		os.Chmod(d, 0o755)
+	}
+	params, _ := json.Marshal(ipc.ScanParams{Paths: []string{public}})
+	resp := sendRequest(t, sockPath, &ipc.Request{ID: "2", Command: ipc.CmdScan, Params: params})
+	if !resp.Success {
+		t.Errorf("scanning a readable file failed: %s (%s)", resp.Error, resp.Code)
+	}
+}
+
+func TestServer_ScanCancelOwn(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	job, _, err := server.daemon.Scans().Start("custom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.daemon.Scans().Update(func(j *ScanJob) { j.Owner = 1000 })
+
+	cancel := func(uid int) *ipc.Response {
+		params, _ := json.Marshal(ipc.ScanJobParams{JobID: job.ID})
+		return server.handleRequest(t.Context(), Peer{UID: uid, GID: uid, PID: -1},
+			&ipc.Request{ID: "1", Command: ipc.CmdScanCancel, Params: params})
+	}
+	if resp := cancel(1001); resp.Success || resp.Code != ipc.CodePermissionDenied {
+		t.Errorf("someone else cancelled the scan: %+v", resp)
+	}
+	if resp := cancel(1000); !resp.Success {
+		t.Errorf("owner couldn't cancel their scan: %s", resp.Error)
+	}
+}
+
+func TestServer_ScanAsUser(t *testing.T) {
+	peer := Peer{UID: 1000, GID: 1000, PID: -1}
+	server, sockPath, cleanup := setupTestServerAs(t, &peer)
+	defer cleanup()
+	server.daemon.scanner = fakeClamd(t)
+	server.daemon.Config().Scanning.Action = "quarantine"
+
+	dir := t.TempDir()
+	for d := dir; d != os.TempDir() && d != "/"; d = filepath.Dir(d) {
+		os.Chmod(d, 0o755)
+	}
+	for name, mode := range map[string]os.FileMode{
+		"bad.txt":          0o644,
+		"secret.txt":       0o600, // root's, unreadable for uid 1000
+		"closed/bad.txt":   0o644, // inside a directory they can't enter
+		"open/sub/bad.txt": 0o644,
+	} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte("EICAR"), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Chmod(filepath.Join(dir, "closed"), 0o700)
+	// links don't lead anywhere they couldn't go
+	os.Symlink(filepath.Join(dir, "closed"), filepath.Join(dir, "link"))
+	os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(dir, "link.txt"))
+
+	params, _ := json.Marshal(ipc.ScanParams{Paths: []string{dir}, Follow: true})
+	resp := sendRequest(t, sockPath, &ipc.Request{ID: "1", Command: ipc.CmdScan, Params: params})
+	if !resp.Success {
+		t.Fatalf("Scan failed: %s", resp.Error)
+	}
+	var status ipc.ScanStatusResponse
+	if err := resp.UnmarshalData(&status); err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, threat := range status.Threats {
+		found = append(found, threat.Path)
+		if threat.Action != "detected" {
+			t.Errorf("%s: action %q, want only detected", threat.Path, threat.Action)
+		}
+	}
+	slices.Sort(found)
+	want := []string{filepath.Join(dir, "bad.txt"), filepath.Join(dir, "open/sub/bad.txt")}
+	if !slices.Equal(found, want) || status.FilesScanned != 2 {
+		t.Errorf("scanned %d files, threats in %v; want 2, %v", status.FilesScanned, found, want)
+	}
+
+	// other users get the counts but not the paths
+	for _, p := range []Peer{{UID: 1001, GID: 1001, PID: -1}, {UID: 0, GID: 0, PID: -1}, peer} {
+		resp = server.handleRequest(t.Context(), p, &ipc.Request{ID: "2", Command: ipc.CmdScanHistory})
+		var history ipc.ScanHistoryResponse
+		if err := resp.UnmarshalData(&history); err != nil || len(history.Scans) != 1 {
+			t.Fatalf("history as uid %d = %+v, %v", p.UID, history, err)
+		}
+		got := history.Scans[0]
+		if wantThreats := p.UID != 1001; got.ThreatsFound != 2 || (len(got.Threats) == 2) != wantThreats {
+			t.Errorf("history as uid %d: %d found, %d listed", p.UID, got.ThreatsFound, len(got.Threats))
+		}
+
+		resp = server.handleRequest(t.Context(), p, &ipc.Request{ID: "3", Command: ipc.CmdEvents})
+		var evts ipc.EventsResponse
+		resp.UnmarshalData(&evts)
+		var threats int
+		for _, e := range evts.Events {
+			if e.Type == string(events.EventTypeThreat) {
+				threats++
+			}
+		}
+		if (threats == 2) != (p.UID != 1001) {
+			t.Errorf("events as uid %d: %d threats", p.UID, threats)
+		}
+	}
+}
+
+func TestServer_PauseDuration(t *testing.T) {
+	server, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	params, _ := json.Marshal(ipc.PauseParams{Duration: "-5m"})
+	resp := sendRequest(t, sockPath, &ipc.Request{ID: "1", Command: ipc.CmdPause, Params: params})
+	if resp.Success {
+		t.Error("Pause(-5m) succeeded")
+	}
+
+	params, _ = json.Marshal(ipc.PauseParams{Duration: "100ms"})
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "2", Command: ipc.CmdPause, Params: params})
+	if !resp.Success {
+		t.Fatalf("Pause failed: %s", resp.Error)
+	}
+	if server.daemon.State().State() != StatePaused || server.daemon.PausedUntil().IsZero() {
+		t.Fatal("not paused with a deadline")
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if server.daemon.State().State() != StateProtected {
+		t.Errorf("State = %v after pause expired, want StateProtected", server.daemon.State().State())
+	}
+	if !server.daemon.PausedUntil().IsZero() {
+		t.Error("PausedUntil still set after resume")
+	}
+}
+
+func TestServer_Quarantine(t *testing.T) {
+	server, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	store, err := quarantine.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.daemon.qstore = store
+
+	path := t.TempDir() + "/eicar.com"
+	os.WriteFile(path, []byte("infected"), 0o644)
+	item, err := store.Add(path, "Eicar-Test-Signature")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := sendRequest(t, sockPath, &ipc.Request{ID: "1", Command: ipc.CmdQuarantineList})
+	var list ipc.QuarantineListResponse
+	if err := resp.UnmarshalData(&list); err != nil {
+		t.Fatalf("UnmarshalData error: %v", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].ID != item.ID || list.Items[0].OriginalPath != path {
+		t.Fatalf("items = %+v", list.Items)
+	}
+
+	params, _ := json.Marshal(ipc.QuarantineParams{ID: item.ID})
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "2", Command: ipc.CmdQuarantineRestore, Params: params})
+	if !resp.Success {
+		t.Fatalf("Restore failed: %s", resp.Error)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "infected" {
+		t.Errorf("restored file = %q, %v", data, err)
+	}
+
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "3", Command: ipc.CmdQuarantineDelete, Params: params})
+	if resp.Success {
+		t.Error("Delete of a restored item succeeded")
+	}
+}
+
+func TestServer_Events(t *testing.T) {
+	server, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	server.daemon.Events().Emit(events.StartBan("203.0.113.7", "test").End())
+	server.daemon.Events().Emit(events.StartHealthCheck().SetError(errors.New("clamd down")).End())
+
+	failedOnly := false
+	params, _ := json.Marshal(ipc.EventsParams{Success: &failedOnly})
+	resp := sendRequest(t, sockPath, &ipc.Request{ID: "1", Command: ipc.CmdEvents, Params: params})
+	var result ipc.EventsResponse
+	if err := resp.UnmarshalData(&result); err != nil {
+		t.Fatalf("UnmarshalData error: %v", err)
+	}
+	if len(result.Events) != 1 || result.Events[0].Error != "clamd down" {
+		t.Errorf("events = %+v", result.Events)
+	}
+}
+
+func TestServer_EventsFromStore(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	store, err := logging.NewLogStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	server.daemon.store = store
+
+	// from before the buffer, e.g. a previous run
+	old := events.StartBan("203.0.113.1", "test").End()
+	old.StartedAt = time.Now().Add(-time.Hour)
+	if err := store.InsertEvents([]events.Event{old}); err != nil {
+		t.Fatal(err)
+	}
+	// stored and buffered, listed once
+	evt := events.StartBan("203.0.113.2", "test").End()
+	server.daemon.RecentEvents().Write(evt)
+	if err := store.InsertEvents([]events.Event{evt}); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(limit int) []ipc.Event {
+		t.Helper()
+		params, _ := json.Marshal(ipc.EventsParams{Type: string(events.EventTypeBan), Limit: limit})
+		resp := server.handleRequest(t.Context(), Peer{UID: 0}, &ipc.Request{ID: "1", Command: ipc.CmdEvents, Params: params})
+		var result ipc.EventsResponse
+		if err := resp.UnmarshalData(&result); err != nil {
+			t.Fatalf("UnmarshalData error: %v", err)
+		}
+		return result.Events
+	}
+	got := query(0)
+	if len(got) != 2 || got[0].Fields[events.FieldSrcAddr] != "203.0.113.2" || got[1].Fields[events.FieldSrcAddr] != "203.0.113.1" {
+		t.Errorf("events = %+v, want buffered then stored", got)
+	}
+	if got := query(1); len(got) != 1 || got[0].Fields[events.FieldSrcAddr] != "203.0.113.2" {
+		t.Errorf("events(limit 1) = %+v", got)
+	}
+}
+
+func TestServer_EventsPrivateBeforeLimit(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+	store, err := logging.NewLogStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	server.daemon.store = store
+
+	threat := func(path string, owner int, age time.Duration) events.Event {
+		evt := events.StartThreat(path, "Eicar").Owner(owner).End()
+		evt.StartedAt = time.Now().Add(-age)
+		return evt
+	}
+	store.InsertEvents([]events.Event{threat("/home/alice/old", 1000, 2*time.Hour), threat("/home/bob/old", 1001, time.Hour)})
+	server.daemon.RecentEvents().Write(threat("/home/alice/new", 1000, 2*time.Minute))
+	server.daemon.RecentEvents().Write(threat("/home/bob/new", 1001, time.Minute))
+
+	params, _ := json.Marshal(ipc.EventsParams{Type: string(events.EventTypeThreat), Limit: 2})
+	resp := server.handleRequest(t.Context(), Peer{UID: 1000, GID: 1000, PID: -1}, &ipc.Request{ID: "1", Command: ipc.CmdEvents, Params: params})
+	var result ipc.EventsResponse
+	if err := resp.UnmarshalData(&result); err != nil {
+		t.Fatalf("UnmarshalData error: %v", err)
+	}
+	var paths []string
+	for _, evt := range result.Events {
+		paths = append(paths, evt.Fields[events.FieldPath].(string))
+	}
+	if !slices.Equal(paths, []string{"/home/alice/new", "/home/alice/old"}) {
+		t.Errorf("events = %v, want both of alice's despite bob's newer ones", paths)
+	}
+}
+
+func TestServer_SubscribeEvents(t *testing.T) {
+	server, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	client := ipc.NewClient(sockPath)
+	defer client.Close()
+
+	failedOnly := false
+	evts, err := client.SubscribeEvents(t.Context(), ipc.SubscribeEventsParams{Component: "rules", Success: &failedOnly})
+	if err != nil {
+		t.Fatalf("SubscribeEvents() error = %v", err)
+	}
+
+	server.daemon.Events().Emit(events.StartRulesUpdate("clamav", "clamav").End())
+	server.daemon.Events().Emit(events.StartBan("203.0.113.7", "test").SetError(errors.New("nft failed")).End())
+	failed := events.StartRulesUpdate("clamav", "clamav")
+	failed.SetError(errors.New("freshclam failed"))
+	server.daemon.Events().Emit(failed.End())
+
+	select {
+	case evt := <-evts:
+		if evt.Type != string(events.EventTypeRules) || evt.Error != "freshclam failed" {
+			t.Errorf("got event %+v", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+	select {
+	case evt := <-evts:
+		t.Errorf("unexpected event %+v", evt)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestServer_Watch(t *testing.T) {
+	_, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	client := ipc.NewClient(sockPath)
+	defer client.Close()
+
+	notes, err := client.Watch(t.Context(), ipc.WatchParams{Kinds: []string{ipc.KindFirewall, ipc.KindStateChange}})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	legacy, err := client.Subscribe(t.Context())
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	if err := client.SetFirewallEnabled(t.Context(), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Pause(t.Context(), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []ipc.Notification
+	for len(got) < 2 {
+		select {
+		case n := <-notes:
+			got = append(got, n)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout, got %+v", got)
+		}
+	}
+	if fw, ok := got[0].Payload.(*ipc.FirewallEvent); !ok || !fw.Enabled {
+		t.Errorf("first notification = %+v", got[0])
+	}
+	if sc, ok := got[1].Payload.(*ipc.StateChangeEvent); !ok || sc.NewState != "paused" {
+		t.Errorf("second notification = %+v", got[1])
+	}
+	if got[1].Seq <= got[0].Seq {
+		t.Errorf("seqs not increasing: %d, %d", got[0].Seq, got[1].Seq)
+	}
+
+	select {
+	case evt := <-legacy:
+		if evt.NewState != "paused" {
+			t.Errorf("legacy subscriber got %+v", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for legacy state change")
+	}
+}
+
+func TestServer_WatchResume(t *testing.T) {
+	server, sockPath, cleanup := setupTestServer(t)
+
+	client := ipc.NewClient(sockPath)
+	defer client.Close()
+
+	notes, err := client.Watch(t.Context(), ipc.WatchParams{Kinds: []string{ipc.KindThreat}})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	next := func() ipc.Notification {
+		t.Helper()
+		select {
+		case n := <-notes:
+			return n
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for notification")
+		}
+		return ipc.Notification{}
+	}
+
+	n := server.daemon.Notifier()
+	n.Publish(ipc.KindThreat, ipc.ThreatEvent{Path: "/tmp/one"})
+	if got := next(); got.Payload.(*ipc.ThreatEvent).Path != "/tmp/one" {
+		t.Fatalf("got %+v", got)
+	}
+
+	// restart the socket server; the daemon (and its buffer) stays up
+	cleanup()
+	if got := next(); got.Kind != ipc.KindDisconnected {
+		t.Fatalf("got %+v, want disconnected", got)
+	}
+	n.Publish(ipc.KindThreat, ipc.ThreatEvent{Path: "/tmp/two"})
+	n.Publish(ipc.KindThreat, ipc.ThreatEvent{Path: "/tmp/three"})
+
+	restarted := NewServer(sockPath, server.daemon)
+	if err := restarted.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go restarted.Serve()
+	defer restarted.Close()
+
+	got := next()
+	if ack, ok := got.Payload.(*ipc.WatchResponse); !ok || ack.Missed {
+		t.Fatalf("got %+v, want reconnected without a gap", got)
+	}
+	for _, want := range []string{"/tmp/two", "/tmp/three"} {
+		if got := next(); got.Payload.(*ipc.ThreatEvent).Path != want {
+			t.Errorf("replayed %+v, want %s", got.Payload, want)
+		}
+	}
+}
+
+func TestServer_SlowWatcher(t *testing.T) {
+	server, sockPath, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	// a client that subscribes and then never reads
+	slow, err := net.Dial("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	slow.Write([]byte(`{"id":"1","cmd":"watch","params":{"kinds":["threat"]}}` + "\n"))
+	reader := bufio.NewReader(slow)
+	if _, err := reader.ReadBytes('\n'); err != nil {
+		t.Fatal(err)
+	}
+
+	client := ipc.NewClient(sockPath)
+	defer client.Close()
+	notes, err := client.Watch(t.Context(), ipc.WatchParams{Kinds: []string{ipc.KindThreat}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const total = 400
+	path := strings.Repeat("x", 8<<10)
+	go func() {
+		for range total {
+			server.daemon.Notifier().Publish(ipc.KindThreat, ipc.ThreatEvent{Path: path})
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	for i := 0; i < total; i++ {
+		select {
+		case n := <-notes:
+			if n.Kind != ipc.KindThreat {
+				t.Fatalf("notification %d = %+v", i, n)
+			}
+		case <-time.After(writeTimeout / 2):
+			t.Fatalf("fast client stalled after %d notifications", i)
+		}
+	}
+
+	// the slow client was cut off on overflow, well before the write deadline
+	slow.SetReadDeadline(time.Now().Add(writeTimeout / 2))
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		t.Errorf("slow client not disconnected: %v", err)
+	}
+}
+
+func TestServer_Authorization(t *testing.T) {
+	server, sockPath, cleanup := setupTestServerAs(t, &Peer{UID: 1000, GID: 0, PID: 4242})
+	defer cleanup()
+
+	resp := sendRequest(t, sockPath, &ipc.Request{ID: "1", Command: ipc.CmdStatus})
+	if !resp.Success {
+		t.Fatalf("status refused: %s", resp.Error)
+	}
+
+	// no admin group: only root may pause
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "2", Command: ipc.CmdPause})
+	if resp.Success || !strings.Contains(resp.Error, "permission denied") {
+		t.Fatalf("pause as uid 1000 = %+v, want permission denied", resp)
+	}
+	if resp.Code != ipc.CodePermissionDenied || resp.Details["command"] != ipc.CmdPause {
+		t.Errorf("Code = %q, Details = %v", resp.Code, resp.Details)
+	}
+	if server.daemon.State().State() != StateProtected {
+		t.Error("daemon paused despite refusal")
+	}
+	denied := server.daemon.RecentEvents().Query(events.Filter{Type: events.EventTypeDenied}, 0)
+	if len(denied) != 1 || denied[0].Fields[events.FieldUID] != 1000 || denied[0].Fields[events.FieldCommand] != ipc.CmdPause {
+		t.Errorf("access_denied events = %+v", denied)
+	}
+
+	// the peer's primary group is root's, so naming it lets them in
+	server.daemon.Config().General.AdminGroup = "root"
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "3", Command: ipc.CmdPause})
+	if !resp.Success {
+		t.Fatalf("pause as admin group member refused: %s", resp.Error)
+	}
+}
+
+// polkitSubject and polkitResult are polkit's (sa{sv}) and (bba{ss}).
+type polkitSubject struct {
+	Kind    string
+	Details map[string]dbus.Variant
+}
+
+type polkitResult struct {
+	Authorized, Challenge bool
+	Details               map[string]string
+}
+
+// fakeAuthority is a polkit authority that grants the actions in allow.
+// With hold set it answers only once hold is closed, like a user taking
+// their time over the password prompt.
+type fakeAuthority struct {
+	allow map[string]bool
+	asked chan string
+	hold  chan struct{}
+}
+
+func (f *fakeAuthority) CheckAuthorization(_ polkitSubject, action string, _ map[string]string, _ uint32, _ string) (polkitResult, *dbus.Error) {
+	f.asked <- action
+	if f.hold != nil {
+		<-f.hold
+	}
+	return polkitResult{Authorized: f.allow[action], Details: map[string]string{}}, nil
+}
+
+// selfPeer is this test process connecting as uid, so polkit can look it
+// up.
+func selfPeer(t *testing.T, uid int) *Peer {
+	t.Helper()
+	p := Peer{UID: uid, GID: uid, PID: os.Getpid()}.withStart()
+	if p.Start == 0 {
+		t.Fatal("can't read our own start time")
+	}
+	return &p
+}
+
+// startPolkit puts fake on a private bus and has server ask it.
+func startPolkit(t *testing.T, server *Server, fake *fakeAuthority) {
+	t.Helper()
+	addr := dbustest.StartBus(t)
+	bus := dbustest.Connect(t, addr)
+	if err := bus.Export(fake, "/org/freedesktop/PolicyKit1/Authority", "org.freedesktop.PolicyKit1.Authority"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bus.RequestName("org.freedesktop.PolicyKit1", dbus.NameFlagDoNotQueue); err != nil {
+		t.Fatal(err)
+	}
+	server.daemon.polkit = polkit.New(dbustest.Connect(t, addr))
+}
+
+func TestServer_Polkit(t *testing.T) {
+	// polkit looks the subject up by pid, so it has to be a real process
+	server, sockPath, cleanup := setupTestServerAs(t, selfPeer(t, 1000))
+	defer cleanup()
+
+	fake := &fakeAuthority{allow: map[string]bool{polkit.ActionPause: true}, asked: make(chan string, 10)}
+	startPolkit(t, server, fake)
+
+	resp := sendRequest(t, sockPath, &ipc.Request{ID: "1", Command: ipc.CmdPause})
+	if !resp.Success {
+		t.Fatalf("pause with polkit approval refused: %s", resp.Error)
+	}
+	if got := <-fake.asked; got != polkit.ActionPause {
+		t.Errorf("asked polkit for %q, want %q", got, polkit.ActionPause)
+	}
+
+	resp = sendRequest(t, sockPath, &ipc.Request{ID: "2", Command: ipc.CmdFirewallDisable})
+	if resp.Success || !strings.Contains(resp.Error, "permission denied") {
+		t.Fatalf("firewall_disable = %+v, want permission denied", resp)
+	}
+	if got := <-fake.asked; got != polkit.ActionFirewall {
+		t.Errorf("asked polkit for %q, want %q", got, polkit.ActionFirewall)
+	}
+
+	// open commands never reach polkit
+	sendRequest(t, sockPath, &ipc.Request{ID: "3", Command: ipc.CmdStatus})
+	select {
+	case got := <-fake.asked:
+		t.Errorf("status asked polkit for %q", got)
+	default:
+	}
+}
+
+func TestServer_Pipelining(t *testing.T) {
+	// polkit is held up until the test reads what it was asked
+	server, sockPath, cleanup := setupTestServerAs(t, selfPeer(t, 1000))
+	defer cleanup()
+	fake := &fakeAuthority{allow: map[string]bool{polkit.ActionPause: true}, asked: make(chan string)}
+	startPolkit(t, server, fake)
+
+	conn, err := net.Dial("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(`{"id":"pause","cmd":"pause"}` + "\n" + `{"id":"status","cmd":"status"}` + "\n"))
+
+	reader := bufio.NewReader(conn)
+	read := func() ipc.Response {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		var resp ipc.Response
+		json.Unmarshal(line, &resp)
+		return resp
+	}
+
+	// status doesn't wait behind the pause stuck in polkit
+	if resp := read(); resp.ID != "status" || !resp.Success {
+		t.Fatalf("first response = %+v, want status", resp)
+	}
+	<-fake.asked
+	if resp := read(); resp.ID != "pause" || !resp.Success {
+		t.Fatalf("second response = %+v, want pause", resp)
+	}
+}
+
+func TestServer_Cancel(t *testing.T) {
+	// the pause sits in polkit until the test is over
+	server, sockPath, cleanup := setupTestServerAs(t, selfPeer(t, 1000))
+	defer cleanup()
+	fake := &fakeAuthority{allow: map[string]bool{polkit.ActionPause: true}, asked: make(chan string, 10), hold: make(chan struct{})}
+	startPolkit(t, server, fake)
+	defer close(fake.hold)
+
+	c := dialRPC(t, sockPath) // v1 lines are read the same way
+	c.conn.Write([]byte(`{"id":"p","cmd":"pause"}` + "\n"))
+	<-fake.asked
+	c.conn.Write([]byte(`{"id":"c","cmd":"cancel","params":{"id":"p"}}` + "\n"))
+	got := make(map[string]ipc.Response)
+	for range 2 {
+		var resp ipc.Response
+		c.call("", &resp)
+		got[resp.ID] = resp
+	}
+	if !got["c"].Success {
+		t.Errorf("cancel = %+v", got["c"])
+	}
+	if got["p"].Code != ipc.CodeCanceled {
+		t.Errorf("cancelled pause = %+v, want code %q", got["p"], ipc.CodeCanceled)
+	}
+	if server.daemon.State().State() == StatePaused {
+		t.Error("cancelled pause went through")
+	}
+
+	// hanging up cancels too
+	c2 := dialRPC(t, sockPath)
+	c2.conn.Write([]byte(`{"id":"gone","cmd":"pause"}` + "\n"))
+	<-fake.asked
+	c2.conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		evts := server.daemon.RecentEvents().Query(events.Filter{Type: events.EventTypeIPCRequest}, 0)
+		if len(evts) > 0 && evts[0].Fields[events.FieldRequestID] == "gone" {
+			if evts[0].Success || !strings.Contains(evts[0].Error, "canceled") {
+				t.Errorf("abandoned request event = %+v", evts[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("abandoned request never finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServer_JSONRPCBatchCancel(t *testing.T) {
+	// the batched pause sits in polkit until cancelled
+	server, sockPath, cleanup := setupTestServerAs(t, selfPeer(t, 1000))
+	defer cleanup()
+	fake := &fakeAuthority{allow: map[string]bool{polkit.ActionPause: true}, asked: make(chan string, 10), hold: make(chan struct{})}
+	startPolkit(t, server, fake)
+	defer close(fake.hold)
+
+	c := dialRPC(t, sockPath)
+	c.conn.Write([]byte(`[{"jsonrpc":"2.0","id":"p","method":"pause"},{"jsonrpc":"2.0","id":"s","method":"status"}]` + "\n"))
+	<-fake.asked
+
+	// the rest of the connection carries on meanwhile
+	var resp ipc.RPCResponse
+	c.call(`{"jsonrpc":"2.0","id":"ping","method":"ping"}`, &resp)
+	if string(resp.ID) != `"ping"` || resp.Error != nil {
+		t.Fatalf("ping during batch = %+v", resp)
+	}
+
+	c.conn.Write([]byte(`{"jsonrpc":"2.0","method":"cancel","params":{"id":"p"}}` + "\n"))
+	var batch []ipc.RPCResponse
+	c.call("", &batch)
+	if len(batch) != 2 || string(batch[0].ID) != `"p"` || string(batch[1].ID) != `"s"` || batch[1].Error != nil {
+		t.Fatalf("batch = %+v", batch)
+	}
+	if e := batch[0].Error; e == nil || e.Data.(map[string]any)["code"] != ipc.CodeCanceled {
+		t.Errorf("cancelled pause = %+v, want code %q", e, ipc.CodeCanceled)
+	}
+	if server.daemon.State().State() == StatePaused {
+		t.Error("cancelled pause went through")
+	}
+}
+
+func TestPeerCred(t *testing.T) {
+	ln, err := net.Listen("unix", t.TempDir()+"/peer.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		if c, err := net.Dial("unix", ln.Addr().String()); err == nil {
+			defer c.Close()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	p, err := peerCred(conn)
+	if err != nil {
+		t.Fatalf("peerCred() error = %v", err)
+	}
+	if p.UID != os.Getuid() || p.GID != os.Getgid() || p.PID != os.Getpid() {
+		t.Errorf("peerCred = %+v, want uid %d gid %d pid %d", p, os.Getuid(), os.Getgid(), os.Getpid())
+	}
+}
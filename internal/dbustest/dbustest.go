@@ -0,0 +1,105 @@
+// oreon/defense · watchthelight <wtl>
+
+// Package dbustest runs a throwaway dbus-daemon so tests can exercise
+// D-Bus code without touching the real system or session bus.
+package dbustest
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// busConfig is a minimal bus that lets anyone own any name and call anything.
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>custom</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow user="*"/>
+    <allow own="*"/>
+    <allow send_type="method_call"/>
+    <allow send_type="signal"/>
+    <allow send_type="method_return"/>
+    <allow send_type="error"/>
+    <allow receive_type="method_call"/>
+    <allow receive_type="signal"/>
+    <allow receive_type="method_return"/>
+    <allow receive_type="error"/>
+  </policy>
+</busconfig>
+`
+
+// StartBus launches a private bus and returns its address. The daemon is
+// killed when the test ends. Skips the test if dbus-daemon isn't installed.
+func StartBus(t testing.TB) string {
+	t.Helper()
+
+	bin, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+
+	// unix socket paths are short, so don't use t.TempDir (it can be long)
+	dir, err := os.MkdirTemp("", "dbustest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	cfgPath := filepath.Join(dir, "bus.conf")
+	sock := filepath.Join(dir, "bus.sock")
+	if err := os.WriteFile(cfgPath, []byte(fmt.Sprintf(busConfig, sock)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(bin, "--config-file="+cfgPath, "--nofork", "--nopidfile", "--print-address=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	addr := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(stdout).ReadString('\n')
+		addr <- strings.TrimSpace(line)
+	}()
+	select {
+	case a := <-addr:
+		if a == "" {
+			t.Fatal("dbus-daemon exited without printing an address")
+		}
+		return a
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for dbus-daemon")
+		return ""
+	}
+}
+
+// Connect opens a connection to the bus at addr, closed when the test ends.
+func Connect(t testing.TB, addr string) *dbus.Conn {
+	t.Helper()
+
+	conn, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatalf("connect to test bus: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
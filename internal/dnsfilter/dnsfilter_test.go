@@ -0,0 +1,315 @@
+// oreon/defense · watchthelight <wtl>
+
+package dnsfilter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/config"
+)
+
+func TestParseDomains(t *testing.T) {
+	input := `# malware domains
+evil.example
+Phish.Example.   # trailing dot and caps
+*.tracker.example
+0.0.0.0 ads.example ads2.example
+127.0.0.1 localhost
+not!a.domain
+`
+	domains, invalid, err := ParseDomains(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"evil.example", "phish.example", "tracker.example", "ads.example", "ads2.example"}
+	if strings.Join(domains, ",") != strings.Join(want, ",") {
+		t.Errorf("domains = %v, want %v", domains, want)
+	}
+	if invalid != 1 {
+		t.Errorf("invalid = %d, want 1", invalid)
+	}
+}
+
+func TestLists_Match(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	os.WriteFile(path, []byte("evil.example\n"), 0o644)
+
+	var ls Lists
+	ls.Add("malware", path)
+	ls.Refresh(func(string, int, int, error) {})
+
+	for name, want := range map[string]bool{
+		"evil.example":           true,
+		"cdn.evil.example":       true,
+		"EVIL.example.":          true,
+		"notevil.example":        false,
+		"example":                false,
+		"evil.example.other.com": false,
+	} {
+		if list, ok := ls.Match(name); ok != want || (ok && list != "malware") {
+			t.Errorf("Match(%q) = %q, %v; want %v", name, list, ok, want)
+		}
+	}
+}
+
+// fakeUpstream answers every A query with 192.0.2.10 and AAAA with
+// 2001:db8::10, over both UDP and TCP.
+type fakeUpstream struct {
+	addr    string
+	mu      sync.Mutex
+	queries []string
+}
+
+func startUpstream(t *testing.T) *fakeUpstream {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pc.Close()
+		ln.Close()
+	})
+
+	up := &fakeUpstream{addr: pc.LocalAddr().String()}
+	answer := func(query []byte) []byte {
+		q, err := parseQuery(query)
+		if err != nil {
+			return nil
+		}
+		up.mu.Lock()
+		up.queries = append(up.queries, q.name)
+		up.mu.Unlock()
+		return blockedReply(query, q, true, netip.MustParseAddr("192.0.2.10"), netip.MustParseAddr("2001:db8::10"))
+	}
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := answer(buf[:n]); resp != nil {
+				pc.WriteTo(resp, addr)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					query, err := readTCPMsg(conn)
+					if err != nil {
+						return
+					}
+					writeTCPMsg(conn, answer(query))
+				}
+			}()
+		}
+	}()
+	return up
+}
+
+func (u *fakeUpstream) seen() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.queries...)
+}
+
+// startServer runs a forwarder on a random port with one blocklist.
+func startServer(t *testing.T, cfg config.DNS, opts ...Option) *Server {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "block.txt")
+	os.WriteFile(path, []byte("evil.example\n0.0.0.0 phish.example\n"), 0o644)
+
+	cfg.Listen = "127.0.0.1:0"
+	cfg.Blocklists = []config.DomainList{{Name: "test", Path: path}}
+	s, err := New(cfg, opts...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	s.RefreshLists()
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Serve(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return s
+}
+
+// resolver sends all lookups to addr over the given network.
+func resolver(addr, network string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+func lookup(t *testing.T, r *net.Resolver, host string) ([]string, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return r.LookupHost(ctx, host)
+}
+
+func TestServer_BlockAndForward(t *testing.T) {
+	up := startUpstream(t)
+
+	var mu sync.Mutex
+	var blocks []Block
+	s := startServer(t, config.DNS{Upstreams: []string{up.addr}}, WithBlockHandler(func(b Block, _ int) {
+		mu.Lock()
+		blocks = append(blocks, b)
+		mu.Unlock()
+	}))
+
+	for _, network := range []string{"udp", "tcp"} {
+		r := resolver(s.Addr(), network)
+
+		_, err := lookup(t, r, "www.evil.example")
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Errorf("%s: blocked lookup error = %v, want NXDOMAIN", network, err)
+		}
+
+		addrs, err := lookup(t, r, "good.example")
+		if err != nil {
+			t.Fatalf("%s: forwarded lookup error = %v", network, err)
+		}
+		if !contains(addrs, "192.0.2.10") || !contains(addrs, "2001:db8::10") {
+			t.Errorf("%s: forwarded addrs = %v", network, addrs)
+		}
+	}
+
+	for _, q := range up.seen() {
+		if strings.Contains(q, "evil") {
+			t.Errorf("blocked name %q reached upstream", q)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(blocks) == 0 {
+		t.Fatal("no block events")
+	}
+	if b := blocks[0]; b.Domain != "www.evil.example" || b.List != "test" || b.Client.String() != "127.0.0.1" {
+		t.Errorf("block = %+v", b)
+	}
+	if st := s.Stats(); st.Blocked == 0 || st.Forwarded == 0 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestServer_Sinkhole(t *testing.T) {
+	up := startUpstream(t)
+	s := startServer(t, config.DNS{
+		Upstreams:  []string{up.addr},
+		Mode:       "sinkhole",
+		SinkholeV4: "10.0.0.1",
+	})
+
+	addrs, err := lookup(t, resolver(s.Addr(), "udp"), "phish.example")
+	if err != nil {
+		t.Fatalf("lookup error = %v", err)
+	}
+	if !contains(addrs, "10.0.0.1") || !contains(addrs, "::") {
+		t.Errorf("sinkhole addrs = %v", addrs)
+	}
+}
+
+func TestServer_UpstreamFailover(t *testing.T) {
+	// grab a port nobody is listening on
+	dead, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	deadAddr := dead.LocalAddr().String()
+	dead.Close()
+
+	up := startUpstream(t)
+	s := startServer(t, config.DNS{Upstreams: []string{deadAddr, up.addr}, Timeout: "200ms"})
+	if _, err := lookup(t, resolver(s.Addr(), "udp"), "good.example"); err != nil {
+		t.Errorf("lookup with one dead upstream error = %v", err)
+	}
+
+	s = startServer(t, config.DNS{Upstreams: []string{deadAddr}, Timeout: "200ms"})
+	_, err := lookup(t, resolver(s.Addr(), "udp"), "good.example")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || dnsErr.IsNotFound {
+		t.Errorf("lookup with all upstreams dead error = %v, want SERVFAIL", err)
+	}
+	if s.Stats().Failed == 0 {
+		t.Error("failed forwards not counted")
+	}
+}
+
+func TestNew_Validation(t *testing.T) {
+	for name, cfg := range map[string]config.DNS{
+		"no upstreams":  {},
+		"bad mode":      {Upstreams: []string{"127.0.0.1:53"}, Mode: "refuse"},
+		"v6 sinkhole 4": {Upstreams: []string{"127.0.0.1:53"}, SinkholeV4: "::1"},
+		"bad timeout":   {Upstreams: []string{"127.0.0.1:53"}, Timeout: "soon"},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("%s: New() should fail", name)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBlockLimit(t *testing.T) {
+	l := blockLimit{rate: 2}
+	now := time.Now()
+
+	var emitted int
+	for range 5 {
+		if emit, _ := l.allow(now); emit {
+			emitted++
+		}
+	}
+	if emitted != 2 {
+		t.Errorf("emitted %d blocks in one second, want 2", emitted)
+	}
+
+	// next window reports what was suppressed
+	emit, suppressed := l.allow(now.Add(time.Second))
+	if !emit || suppressed != 3 {
+		t.Errorf("allow() in new window = %v, %d; want true, 3", emit, suppressed)
+	}
+}
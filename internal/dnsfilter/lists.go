@@ -0,0 +1,206 @@
+// oreon/defense · watchthelight <wtl>
+
+package dnsfilter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ListStatus reports the state of one domain blocklist.
+type ListStatus struct {
+	Name     string
+	Path     string
+	Domains  int
+	LoadedAt time.Time // zero if never loaded successfully
+	Error    string
+}
+
+// hostsNames are entries every hosts-format list carries for itself.
+var hostsNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"0.0.0.0":               true,
+}
+
+// ParseDomains reads a domain list. Both plain lists (one domain per line)
+// and hosts files ("0.0.0.0 evil.example") are accepted. A leading "*." is
+// dropped since every entry already covers its subdomains.
+func ParseDomains(r io.Reader) (domains []string, invalid int, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		names := fields[:1]
+		if _, err := netip.ParseAddr(fields[0]); err == nil {
+			names = fields[1:]
+		}
+		for _, name := range names {
+			name = normalize(strings.TrimPrefix(name, "*."))
+			if hostsNames[name] {
+				continue
+			}
+			if !validDomain(name) {
+				invalid++
+				continue
+			}
+			domains = append(domains, name)
+		}
+	}
+	return domains, invalid, scanner.Err()
+}
+
+func normalize(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+func validDomain(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// domainList is one loaded list file. Guarded by Lists.mu.
+type domainList struct {
+	name string
+	path string
+
+	domains  map[string]bool
+	modTime  time.Time
+	size     int64
+	loadedAt time.Time
+	err      error
+}
+
+// load re-reads the file if it changed. On error the previous contents
+// stay in effect.
+func (l *domainList) load() (changed bool, invalid int) {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		l.err = err
+		return false, 0
+	}
+	if l.err == nil && !l.loadedAt.IsZero() && info.ModTime().Equal(l.modTime) && info.Size() == l.size {
+		return false, 0
+	}
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		l.err = err
+		return false, 0
+	}
+	defer f.Close()
+
+	domains, invalid, err := ParseDomains(f)
+	if err != nil {
+		l.err = fmt.Errorf("read %s: %w", l.path, err)
+		return false, 0
+	}
+	l.domains = make(map[string]bool, len(domains))
+	for _, d := range domains {
+		l.domains[d] = true
+	}
+	l.modTime = info.ModTime()
+	l.size = info.Size()
+	l.loadedAt = time.Now()
+	l.err = nil
+	return true, invalid
+}
+
+// Lists is the set of configured domain blocklists. The zero value is
+// an empty set ready to use.
+// Thread-safe.
+type Lists struct {
+	mu    sync.RWMutex
+	lists []*domainList
+}
+
+// Add registers a list file. It isn't read until Refresh.
+func (ls *Lists) Add(name, path string) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.lists = append(ls.lists, &domainList{name: name, path: path})
+}
+
+// Refresh reloads lists whose files changed. report is called once per
+// list that was reloaded or failed.
+func (ls *Lists) Refresh(report func(name string, domains, invalid int, err error)) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	for _, l := range ls.lists {
+		changed, invalid := l.load()
+		switch {
+		case changed:
+			report(l.name, len(l.domains), invalid, nil)
+		case l.err != nil:
+			report(l.name, len(l.domains), 0, l.err)
+		}
+	}
+}
+
+// Match reports which list blocks name, checking the name itself and then
+// each parent domain.
+func (ls *Lists) Match(name string) (list string, ok bool) {
+	name = normalize(name)
+
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	for {
+		for _, l := range ls.lists {
+			if l.domains[name] {
+				return l.name, true
+			}
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return "", false
+		}
+		name = name[i+1:]
+	}
+}
+
+// Status returns domain counts and load state for each list.
+func (ls *Lists) Status() []ListStatus {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	out := make([]ListStatus, 0, len(ls.lists))
+	for _, l := range ls.lists {
+		st := ListStatus{Name: l.name, Path: l.path, Domains: len(l.domains), LoadedAt: l.loadedAt}
+		if l.err != nil {
+			st.Error = l.err.Error()
+		}
+		out = append(out, st)
+	}
+	return out
+}
@@ -0,0 +1,160 @@
+// oreon/defense · watchthelight <wtl>
+
+package dnsfilter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// We only ever look at the header and question; forwarded answers are
+// relayed byte for byte, so there's no need for a full DNS library.
+const (
+	headerLen = 12
+
+	flagQR     = 1 << 15
+	flagAA     = 1 << 10
+	flagRD     = 1 << 8
+	flagRA     = 1 << 7
+	opcodeMask = 0xf << 11
+
+	rcodeServFail = 2
+	rcodeNXDomain = 3
+
+	typeA    = 1
+	typeAAAA = 28
+	classIN  = 1
+
+	// blockTTL is what blocked answers are cached for by clients.
+	blockTTL = 300
+)
+
+var errMalformed = errors.New("malformed dns message")
+
+// question is the single question in a query.
+type question struct {
+	name   string // lower case, no trailing dot
+	qtype  uint16
+	qclass uint16
+	end    int // offset just past the question section
+}
+
+// parseQuery reads the header and first question of a standard query.
+func parseQuery(msg []byte) (question, error) {
+	if len(msg) < headerLen {
+		return question{}, errMalformed
+	}
+	flags := binary.BigEndian.Uint16(msg[2:4])
+	if flags&flagQR != 0 || flags&opcodeMask != 0 {
+		return question{}, errors.New("not a standard query")
+	}
+	if binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		return question{}, errors.New("query must have exactly one question")
+	}
+
+	var labels []string
+	off := headerLen
+	for {
+		if off >= len(msg) {
+			return question{}, errMalformed
+		}
+		l := int(msg[off])
+		off++
+		if l == 0 {
+			break
+		}
+		// compression pointers never appear in a question we'd accept
+		if l&0xc0 != 0 || off+l > len(msg) {
+			return question{}, errMalformed
+		}
+		labels = append(labels, string(msg[off:off+l]))
+		off += l
+	}
+	if off+4 > len(msg) {
+		return question{}, errMalformed
+	}
+
+	return question{
+		name:   strings.ToLower(strings.Join(labels, ".")),
+		qtype:  binary.BigEndian.Uint16(msg[off : off+2]),
+		qclass: binary.BigEndian.Uint16(msg[off+2 : off+4]),
+		end:    off + 4,
+	}, nil
+}
+
+// reply builds a response header + question from the query, with no
+// records. Additional records (EDNS) from the query are dropped.
+func reply(query []byte, q question, rcode uint16) []byte {
+	resp := make([]byte, q.end, q.end+28)
+	copy(resp, query[:q.end])
+
+	flags := binary.BigEndian.Uint16(query[2:4])
+	flags = flagQR | flagRA | flags&flagRD | rcode
+	binary.BigEndian.PutUint16(resp[2:4], flags)
+	binary.BigEndian.PutUint16(resp[6:8], 0)   // ancount
+	binary.BigEndian.PutUint16(resp[8:10], 0)  // nscount
+	binary.BigEndian.PutUint16(resp[10:12], 0) // arcount
+	return resp
+}
+
+// blockedReply answers a blocked query with NXDOMAIN, or in sinkhole mode
+// with the sinkhole address for A/AAAA and an empty NOERROR otherwise.
+func blockedReply(query []byte, q question, sinkhole bool, v4, v6 netip.Addr) []byte {
+	if !sinkhole {
+		return reply(query, q, rcodeNXDomain)
+	}
+
+	resp := reply(query, q, 0)
+	flags := binary.BigEndian.Uint16(resp[2:4]) | flagAA
+	binary.BigEndian.PutUint16(resp[2:4], flags)
+
+	var addr netip.Addr
+	switch {
+	case q.qclass != classIN:
+		return resp
+	case q.qtype == typeA:
+		addr = v4
+	case q.qtype == typeAAAA:
+		addr = v6
+	default:
+		return resp
+	}
+
+	rdata := addr.AsSlice()
+	rr := make([]byte, 12, 12+len(rdata))
+	binary.BigEndian.PutUint16(rr[0:2], 0xc000|headerLen) // pointer to the question name
+	binary.BigEndian.PutUint16(rr[2:4], q.qtype)
+	binary.BigEndian.PutUint16(rr[4:6], classIN)
+	binary.BigEndian.PutUint32(rr[6:10], blockTTL)
+	binary.BigEndian.PutUint16(rr[10:12], uint16(len(rdata)))
+	rr = append(rr, rdata...)
+
+	binary.BigEndian.PutUint16(resp[6:8], 1)
+	return append(resp, rr...)
+}
+
+// typeNames covers the query types worth naming in events.
+var typeNames = map[uint16]string{
+	1:   "A",
+	2:   "NS",
+	5:   "CNAME",
+	6:   "SOA",
+	12:  "PTR",
+	15:  "MX",
+	16:  "TXT",
+	28:  "AAAA",
+	33:  "SRV",
+	64:  "SVCB",
+	65:  "HTTPS",
+	255: "ANY",
+}
+
+func typeName(t uint16) string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
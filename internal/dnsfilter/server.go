@@ -0,0 +1,424 @@
+// oreon/defense · watchthelight <wtl>
+
+// Package dnsfilter is a small DNS forwarder that answers NXDOMAIN (or a
+// sinkhole address) for blocklisted domains and relays everything else to
+// upstream resolvers.
+package dnsfilter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/config"
+)
+
+const (
+	defaultTimeout = 2 * time.Second
+
+	// maxInflight caps concurrent UDP queries; beyond that we drop and
+	// let the client retry rather than pile up goroutines.
+	maxInflight = 256
+
+	// tcpIdleTimeout closes TCP clients that go quiet.
+	tcpIdleTimeout = 10 * time.Second
+)
+
+// Block describes a query that was refused.
+type Block struct {
+	Client netip.Addr
+	Domain string
+	QType  string
+	List   string
+}
+
+// Stats counts what the server has done since it started.
+type Stats struct {
+	Blocked   int64
+	Forwarded int64
+	Failed    int64 // no upstream answered
+}
+
+// Server is the filtering forwarder.
+type Server struct {
+	listen    string
+	upstreams []string
+	timeout   time.Duration
+	sinkhole  bool
+	sinkV4    netip.Addr
+	sinkV6    netip.Addr
+	lists     *Lists
+	onBlock   func(Block, int)
+	limit     blockLimit
+	logger    *slog.Logger
+
+	udp net.PacketConn
+	tcp net.Listener
+
+	blocked   atomic.Int64
+	forwarded atomic.Int64
+	failed    atomic.Int64
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithBlockHandler is called for blocked queries that pass the log rate,
+// with the number suppressed since the last one.
+func WithBlockHandler(fn func(b Block, suppressed int)) Option {
+	return func(s *Server) {
+		s.onBlock = fn
+	}
+}
+
+// WithLogger sets the logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// New validates cfg and creates a server. Lists are registered but not
+// read until RefreshLists.
+func New(cfg config.DNS, opts ...Option) (*Server, error) {
+	if len(cfg.Upstreams) == 0 {
+		return nil, errors.New("no upstream resolvers configured")
+	}
+	s := &Server{
+		listen:    cfg.Listen,
+		upstreams: cfg.Upstreams,
+		timeout:   defaultTimeout,
+		lists:     &Lists{},
+		onBlock:   func(Block, int) {},
+		limit:     blockLimit{rate: cfg.LogRate},
+		logger:    slog.Default(),
+	}
+
+	switch cfg.Mode {
+	case "", "nxdomain":
+	case "sinkhole":
+		s.sinkhole = true
+	default:
+		return nil, fmt.Errorf("unknown dns mode %q", cfg.Mode)
+	}
+
+	var err error
+	if s.sinkV4, err = parseSinkhole(cfg.SinkholeV4, "0.0.0.0", true); err != nil {
+		return nil, err
+	}
+	if s.sinkV6, err = parseSinkhole(cfg.SinkholeV6, "::", false); err != nil {
+		return nil, err
+	}
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid dns timeout %q", cfg.Timeout)
+		}
+		s.timeout = d
+	}
+
+	for _, bl := range cfg.Blocklists {
+		s.lists.Add(bl.Name, bl.Path)
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+func parseSinkhole(value, def string, v4 bool) (netip.Addr, error) {
+	if value == "" {
+		value = def
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil || addr.Is4() != v4 {
+		return netip.Addr{}, fmt.Errorf("invalid sinkhole address %q", value)
+	}
+	return addr, nil
+}
+
+// Listen binds the UDP and TCP sockets.
+func (s *Server) Listen() error {
+	udp, err := net.ListenPacket("udp", s.listen)
+	if err != nil {
+		return fmt.Errorf("listen udp %s: %w", s.listen, err)
+	}
+	// same port for TCP, which matters when listen uses port 0
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		return fmt.Errorf("listen tcp %s: %w", s.listen, err)
+	}
+	s.udp, s.tcp = udp, tcp
+	return nil
+}
+
+// Addr returns the bound address. Only valid after Listen.
+func (s *Server) Addr() string {
+	return s.udp.LocalAddr().String()
+}
+
+// Serve answers queries until ctx is cancelled, then closes the sockets.
+func (s *Server) Serve(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.serveUDP()
+	}()
+	go func() {
+		defer wg.Done()
+		s.serveTCP()
+	}()
+
+	<-ctx.Done()
+	s.udp.Close()
+	s.tcp.Close()
+	wg.Wait()
+}
+
+func (s *Server) serveUDP() {
+	sem := make(chan struct{}, maxInflight)
+	for {
+		buf := make([]byte, 65535)
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		default:
+			continue // overloaded, client will retry
+		}
+		go func() {
+			defer func() { <-sem }()
+			if resp := s.handle(buf[:n], clientAddr(addr), "udp"); resp != nil {
+				s.udp.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go s.serveTCPConn(conn)
+	}
+}
+
+// serveTCPConn handles length-prefixed queries until the client hangs up.
+func (s *Server) serveTCPConn(conn net.Conn) {
+	defer conn.Close()
+	client := clientAddr(conn.RemoteAddr())
+	for {
+		conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+		query, err := readTCPMsg(conn)
+		if err != nil {
+			return
+		}
+		resp := s.handle(query, client, "tcp")
+		if resp == nil {
+			return
+		}
+		if err := writeTCPMsg(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers one query. Returns nil for garbage we shouldn't reply to.
+func (s *Server) handle(query []byte, client netip.Addr, network string) []byte {
+	q, err := parseQuery(query)
+	if err != nil {
+		return nil
+	}
+
+	if list, ok := s.lists.Match(q.name); ok {
+		s.blocked.Add(1)
+		if emit, suppressed := s.limit.allow(time.Now()); emit {
+			s.onBlock(Block{Client: client, Domain: q.name, QType: typeName(q.qtype), List: list}, suppressed)
+		}
+		return blockedReply(query, q, s.sinkhole, s.sinkV4, s.sinkV6)
+	}
+
+	resp, err := s.forward(query, network)
+	if err != nil {
+		s.failed.Add(1)
+		s.logger.Debug("dns forward failed", "domain", q.name, "error", err)
+		return reply(query, q, rcodeServFail)
+	}
+	s.forwarded.Add(1)
+	return resp
+}
+
+// forward tries each upstream in order and returns the first answer.
+func (s *Server) forward(query []byte, network string) ([]byte, error) {
+	var errs []error
+	for _, upstream := range s.upstreams {
+		resp, err := s.exchange(query, network, upstream)
+		if err == nil {
+			return resp, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (s *Server) exchange(query []byte, network, upstream string) ([]byte, error) {
+	conn, err := net.DialTimeout(network, upstream, s.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	if network == "tcp" {
+		if err := writeTCPMsg(conn, query); err != nil {
+			return nil, err
+		}
+		resp, err := readTCPMsg(conn)
+		if err != nil {
+			return nil, err
+		}
+		return checkID(query, resp)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// ignore stray datagrams with the wrong id
+		if resp, err := checkID(query, buf[:n]); err == nil {
+			return resp, nil
+		}
+	}
+}
+
+func checkID(query, resp []byte) ([]byte, error) {
+	if len(resp) < headerLen || resp[0] != query[0] || resp[1] != query[1] {
+		return nil, errors.New("upstream reply id mismatch")
+	}
+	return resp, nil
+}
+
+func readTCPMsg(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeTCPMsg(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+func clientAddr(addr net.Addr) netip.Addr {
+	if ap, err := netip.ParseAddrPort(addr.String()); err == nil {
+		return ap.Addr().Unmap()
+	}
+	return netip.Addr{}
+}
+
+// RefreshLists reloads any blocklist file that changed.
+func (s *Server) RefreshLists() {
+	s.lists.Refresh(func(name string, domains, invalid int, err error) {
+		if err != nil {
+			s.logger.Warn("dns blocklist load failed", "name", name, "error", err)
+			return
+		}
+		s.logger.Info("dns blocklist loaded", "name", name, "domains", domains, "skipped", invalid)
+	})
+}
+
+// WatchLists polls the blocklist files until ctx is cancelled.
+func (s *Server) WatchLists(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.RefreshLists()
+		}
+	}
+}
+
+// Lists returns the state of each blocklist.
+func (s *Server) Lists() []ListStatus {
+	return s.lists.Status()
+}
+
+// Stats returns query counters.
+func (s *Server) Stats() Stats {
+	return Stats{
+		Blocked:   s.blocked.Load(),
+		Forwarded: s.forwarded.Load(),
+		Failed:    s.failed.Load(),
+	}
+}
+
+// blockLimit caps block handler calls per second so a client hammering a
+// blocked name can't flood the event stream. Thread-safe.
+type blockLimit struct {
+	mu          sync.Mutex
+	rate        int // per second, 0 = unlimited
+	windowStart time.Time
+	windowCount int
+	suppressed  int
+}
+
+// allow reports whether a block at now should be passed on, and how many
+// were suppressed since the last one that was.
+func (l *blockLimit) allow(now time.Time) (bool, int) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.windowStart) >= time.Second {
+		l.windowStart = now
+		l.windowCount = 0
+	}
+	if l.windowCount >= l.rate {
+		l.suppressed++
+		return false, 0
+	}
+	l.windowCount++
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return true, suppressed
+}
@@ -0,0 +1,153 @@
+// oreon/defense · watchthelight <wtl>
+
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// Ban set names for the nftables backend. Both use element timeouts so the
+// kernel drops expired entries on its own even if the daemon isn't running.
+const (
+	banSetV4 = "ban_v4"
+	banSetV6 = "ban_v6"
+)
+
+// ErrNotBanned is returned by Unban for addresses that aren't banned.
+var ErrNotBanned = errors.New("address is not banned")
+
+// Ban is an address temporarily blocked by the firewall.
+type Ban struct {
+	Addr   netip.Addr
+	Reason string
+	Since  time.Time
+	Until  time.Time
+}
+
+// banEntry renders a ban as a set element with its remaining timeout.
+// Returns false if the ban has (nearly) expired.
+func banEntry(b Ban, now time.Time) (string, bool) {
+	remaining := b.Until.Sub(now).Truncate(time.Second)
+	if remaining < time.Second {
+		return "", false
+	}
+	return fmt.Sprintf("%s timeout %ds", b.Addr, int(remaining.Seconds())), true
+}
+
+func banSetFor(addr netip.Addr) string {
+	if addr.Is4() {
+		return banSetV4
+	}
+	return banSetV6
+}
+
+// Ban blocks addr for d. Banning an already-banned address replaces the
+// existing ban. The ban is recorded even while the firewall is disabled
+// and gets installed on the next Enable.
+func (f *Firewall) Ban(ctx context.Context, addr netip.Addr, d time.Duration, reason string) error {
+	addr = addr.Unmap()
+	now := time.Now()
+	b := Ban{Addr: addr, Reason: reason, Since: now, Until: now.Add(d)}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if d < time.Second {
+		return fmt.Errorf("ban duration too short: %s", d)
+	}
+	if f.active() {
+		if err := f.backend.AddBan(ctx, b); err != nil {
+			return fmt.Errorf("add ban: %w", err)
+		}
+	}
+
+	f.bans[addr] = b
+	return nil
+}
+
+// Unban lifts a ban early.
+func (f *Firewall) Unban(ctx context.Context, addr netip.Addr) error {
+	addr = addr.Unmap()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.bans[addr]; !ok {
+		return ErrNotBanned
+	}
+
+	if f.active() {
+		if err := f.backend.RemoveBan(ctx, addr); err != nil {
+			return fmt.Errorf("remove ban: %w", err)
+		}
+	}
+
+	delete(f.bans, addr)
+	return nil
+}
+
+// Bans returns active bans, soonest-expiring first.
+func (f *Firewall) Bans() []Ban {
+	now := time.Now()
+
+	f.mu.Lock()
+	out := make([]Ban, 0, len(f.bans))
+	for _, b := range f.bans {
+		if b.Until.After(now) {
+			out = append(out, b)
+		}
+	}
+	f.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Until.Before(out[j].Until) })
+	return out
+}
+
+// ExpireBans forgets bans that ended before now and returns them.
+// The kernel removes the set elements itself; this just keeps our
+// bookkeeping in step so callers can report the unban.
+func (f *Firewall) ExpireBans(now time.Time) []Ban {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var expired []Ban
+	for addr, b := range f.bans {
+		if !b.Until.After(now) {
+			expired = append(expired, b)
+			delete(f.bans, addr)
+		}
+	}
+	return expired
+}
+
+// activeBans snapshots bans for a Backend. Caller holds f.mu.
+func (f *Firewall) activeBans() []Ban {
+	out := make([]Ban, 0, len(f.bans))
+	for _, b := range f.bans {
+		out = append(out, b)
+	}
+	return out
+}
+
+// banElements renders bans as nft set elements, split by family.
+func banElements(bans []Ban, now time.Time) (v4, v6 []string) {
+	for _, b := range bans {
+		elem, ok := banEntry(b, now)
+		if !ok {
+			continue
+		}
+		if b.Addr.Is4() {
+			v4 = append(v4, elem)
+		} else {
+			v6 = append(v6, elem)
+		}
+	}
+	sort.Strings(v4)
+	sort.Strings(v6)
+	return v4, v6
+}
@@ -0,0 +1,72 @@
+// oreon/defense · watchthelight <wtl>
+
+package firewall
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/config"
+)
+
+func TestFirewall_BanUnban(t *testing.T) {
+	runner := &fakeRunner{}
+	fw := New(config.Firewall{}, WithRunner(runner))
+	ctx := context.Background()
+	v4 := netip.MustParseAddr("203.0.113.1")
+	v6 := netip.MustParseAddr("2001:db8::1")
+
+	// bans made while disabled are installed by Enable
+	if err := fw.Ban(ctx, v4, time.Hour, "test"); err != nil {
+		t.Fatalf("Ban() error = %v", err)
+	}
+	if len(runner.scripts) != 0 {
+		t.Error("Ban ran nft while firewall disabled")
+	}
+	if err := fw.Enable(ctx); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if !strings.Contains(runner.scripts[0], "elements = { 203.0.113.1 timeout 3599s }") &&
+		!strings.Contains(runner.scripts[0], "elements = { 203.0.113.1 timeout 3600s }") {
+		t.Errorf("enable script missing ban element:\n%s", runner.scripts[0])
+	}
+
+	if err := fw.Ban(ctx, v6, 10*time.Minute, "test"); err != nil {
+		t.Fatalf("Ban() error = %v", err)
+	}
+	if last := runner.scripts[len(runner.scripts)-1]; !strings.Contains(last, "add element inet "+TableName+" ban_v6 { 2001:db8::1 timeout") {
+		t.Errorf("ban script = %s", last)
+	}
+	if n := len(fw.Bans()); n != 2 {
+		t.Fatalf("len(Bans) = %d, want 2", n)
+	}
+
+	if err := fw.Unban(ctx, v4); err != nil {
+		t.Fatalf("Unban() error = %v", err)
+	}
+	if last := runner.scripts[len(runner.scripts)-1]; !strings.Contains(last, "delete element inet "+TableName+" ban_v4 { 203.0.113.1 }") {
+		t.Errorf("unban script = %s", last)
+	}
+	if err := fw.Unban(ctx, v4); !errors.Is(err, ErrNotBanned) {
+		t.Errorf("second Unban() error = %v, want ErrNotBanned", err)
+	}
+}
+
+func TestFirewall_ExpireBans(t *testing.T) {
+	fw := New(config.Firewall{}, WithRunner(&fakeRunner{}))
+	ctx := context.Background()
+	fw.Ban(ctx, netip.MustParseAddr("192.0.2.1"), time.Minute, "test")
+	fw.Ban(ctx, netip.MustParseAddr("192.0.2.2"), time.Hour, "test")
+
+	expired := fw.ExpireBans(time.Now().Add(2 * time.Minute))
+	if len(expired) != 1 || expired[0].Addr.String() != "192.0.2.1" {
+		t.Errorf("expired = %+v", expired)
+	}
+	if n := len(fw.Bans()); n != 1 {
+		t.Errorf("len(Bans) = %d after expiry, want 1", n)
+	}
+}
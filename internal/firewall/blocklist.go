@@ -0,0 +1,242 @@
+// oreon/defense · watchthelight <wtl>
+
+package firewall
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+// BlocklistStatus reports the state of one configured blocklist.
+type BlocklistStatus struct {
+	Name        string
+	Path        string
+	IPv4Entries int
+	IPv6Entries int
+	LoadedAt    time.Time // zero if never loaded successfully
+	Error       string    // last load error, empty if the last load succeeded
+}
+
+// blocklist is a loaded reputation list. Guarded by Firewall.mu.
+type blocklist struct {
+	name string
+	path string
+
+	v4, v6   []netip.Prefix
+	invalid  int // malformed lines skipped on the last load
+	modTime  time.Time
+	size     int64
+	loadedAt time.Time
+	err      error
+}
+
+// ParseBlocklist reads one IP or CIDR per line. Blank lines and anything
+// after '#' or ';' are ignored. Malformed lines are skipped and counted
+// rather than failing the whole list, since feeds are often a bit dirty.
+func ParseBlocklist(r io.Reader) (v4, v6 []netip.Prefix, invalid int, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		// some feeds append extra columns after the address
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		prefix, ok := parseEntry(fields[0])
+		if !ok {
+			invalid++
+			continue
+		}
+		if prefix.Addr().Is4() {
+			v4 = append(v4, prefix)
+		} else {
+			v6 = append(v6, prefix)
+		}
+	}
+	return v4, v6, invalid, scanner.Err()
+}
+
+// parseEntry accepts a bare address or a CIDR and returns it as a masked prefix.
+func parseEntry(s string) (netip.Prefix, bool) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, false
+		}
+		if p.Addr().Is4In6() {
+			return netip.Prefix{}, false
+		}
+		return p.Masked(), true
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil || addr.Zone() != "" {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), true
+}
+
+// blocklistSetName derives an nftables set name from a list name.
+// nft identifiers only allow letters, digits and underscores; names that
+// needed changing get a short hash of the original so "a-b" and "a_b"
+// don't end up sharing a set.
+func blocklistSetName(name, family string) string {
+	var b strings.Builder
+	b.WriteString("bl_")
+	replaced := false
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+			replaced = true
+		}
+	}
+	if replaced {
+		sum := sha256.Sum256([]byte(name))
+		b.WriteString("_" + hex.EncodeToString(sum[:4]))
+	}
+	b.WriteString("_" + family)
+	return b.String()
+}
+
+// blocklistLoad is a freshly read list, not yet in the live set.
+type blocklistLoad struct {
+	v4, v6  []netip.Prefix
+	invalid int
+	modTime time.Time
+	size    int64
+}
+
+// read re-reads the list if the file changed since the last load, or the
+// last load or push failed. Returns nil if there's nothing new.
+func (bl *blocklist) read() (*blocklistLoad, error) {
+	info, err := os.Stat(bl.path)
+	if err != nil {
+		return nil, err
+	}
+	if bl.err == nil && !bl.loadedAt.IsZero() && info.ModTime().Equal(bl.modTime) && info.Size() == bl.size {
+		return nil, nil
+	}
+
+	f, err := os.Open(bl.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	v4, v6, invalid, err := ParseBlocklist(f)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", bl.path, err)
+	}
+	return &blocklistLoad{v4: v4, v6: v6, invalid: invalid, modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// commit records l as the loaded contents.
+func (bl *blocklist) commit(l *blocklistLoad) {
+	bl.v4, bl.v6 = l.v4, l.v6
+	bl.invalid = l.invalid
+	bl.modTime = l.modTime
+	bl.size = l.size
+	bl.loadedAt = time.Now()
+	bl.err = nil
+}
+
+// RefreshBlocklists reloads any list whose file changed and, if the firewall
+// is enabled, swaps the new contents into the live sets. A list only counts
+// as loaded once it's live; on error the previous entries stay in place so
+// a bad push doesn't open the firewall up, and the next refresh tries again.
+func (f *Firewall) RefreshBlocklists(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var errs []error
+	for _, bl := range f.blocklists {
+		l, err := bl.read()
+		if err != nil {
+			bl.err = err
+			f.logger.Warn("blocklist load failed", "name", bl.name, "path", bl.path, "error", err)
+			continue
+		}
+		if l == nil {
+			continue
+		}
+		// unsupported backends are reported in BlocklistStatus instead
+		if f.active() {
+			err := f.backend.ReplaceBlocklist(ctx, BlocklistSet{Name: bl.name, V4: l.v4, V6: l.v6})
+			if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+				bl.err = fmt.Errorf("update blocklist %s: %w", bl.name, err)
+				errs = append(errs, bl.err)
+				continue
+			}
+		}
+		bl.commit(l)
+		f.logger.Info("blocklist loaded", "name", bl.name, "ipv4", len(bl.v4), "ipv6", len(bl.v6), "skipped", bl.invalid)
+	}
+	return errors.Join(errs...)
+}
+
+// WatchBlocklists polls the blocklist files until ctx is cancelled.
+func (f *Firewall) WatchBlocklists(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.RefreshBlocklists(ctx); err != nil {
+				f.logger.Error("blocklist refresh failed", "error", err)
+			}
+		}
+	}
+}
+
+// BlocklistStatus returns entry counts and load times for each list.
+func (f *Firewall) BlocklistStatus() []BlocklistStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := make([]BlocklistStatus, 0, len(f.blocklists))
+	for _, bl := range f.blocklists {
+		st := BlocklistStatus{
+			Name:        bl.name,
+			Path:        bl.path,
+			IPv4Entries: len(bl.v4),
+			IPv6Entries: len(bl.v6),
+			LoadedAt:    bl.loadedAt,
+		}
+		if bl.err != nil {
+			st.Error = bl.err.Error()
+		} else if f.backend.Name() == BackendFirewalld {
+			st.Error = "not applied: the firewalld backend doesn't support blocklists"
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+// blocklistSets snapshots loaded lists for a Backend. Caller holds f.mu.
+func (f *Firewall) blocklistSets() []BlocklistSet {
+	sets := make([]BlocklistSet, len(f.blocklists))
+	for i, bl := range f.blocklists {
+		sets[i] = BlocklistSet{Name: bl.name, V4: bl.v4, V6: bl.v6}
+	}
+	return sets
+}
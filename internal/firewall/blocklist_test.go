@@ -0,0 +1,203 @@
+// oreon/defense · watchthelight <wtl>
+
+package firewall
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/config"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	input := `# reputation feed
+1.2.3.4
+10.0.0.0/8 ; private
+192.168.1.77/24   SBL12345
+2001:db8::/32
+::ffff:5.6.7.8
+not-an-ip
+
+2001:db8::1 # single v6 host
+`
+	v4, v6, invalid, err := ParseBlocklist(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseBlocklist() error = %v", err)
+	}
+
+	want4 := []string{"1.2.3.4/32", "10.0.0.0/8", "192.168.1.0/24", "5.6.7.8/32"}
+	if got := prefixStrings(v4); strings.Join(got, ",") != strings.Join(want4, ",") {
+		t.Errorf("v4 = %v, want %v", got, want4)
+	}
+	want6 := []string{"2001:db8::/32", "2001:db8::1/128"}
+	if got := prefixStrings(v6); strings.Join(got, ",") != strings.Join(want6, ",") {
+		t.Errorf("v6 = %v, want %v", got, want6)
+	}
+	if invalid != 1 {
+		t.Errorf("invalid = %d, want 1", invalid)
+	}
+}
+
+func TestBlocklistSetName(t *testing.T) {
+	if got := blocklistSetName("spamhaus_drop", "v4"); got != "bl_spamhaus_drop_v4" {
+		t.Errorf("blocklistSetName = %q", got)
+	}
+	if got := blocklistSetName("spamhaus-drop.v2", "v4"); got != "bl_spamhaus_drop_v2_098f977d_v4" {
+		t.Errorf("blocklistSetName = %q", got)
+	}
+	seen := map[string]string{}
+	for _, name := range []string{"a-b", "a_b", "a.b", "a b"} {
+		set := blocklistSetName(name, "v4")
+		if other, ok := seen[set]; ok {
+			t.Errorf("%q and %q both map to %s", name, other, set)
+		}
+		seen[set] = name
+	}
+}
+
+func TestRulesetWithBlocklists(t *testing.T) {
+	v4, v6, _, _ := ParseBlocklist(strings.NewReader("1.2.3.0/24\n2001:db8::/32\n"))
+	rs := BuildRuleset(RuleOptions{
+		Blocklists: []BlocklistSet{{Name: "bad", V4: v4, V6: v6}},
+	})
+	script := rs.Render()
+
+	for _, want := range []string{
+		"set bl_bad_v4 {",
+		"type ipv4_addr",
+		"flags interval",
+		"elements = { 1.2.3.0/24 }",
+		"set bl_bad_v6 {",
+		"ip saddr @bl_bad_v4 counter drop",
+		"ip6 daddr @bl_bad_v6 counter drop",
+		"hook output",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("ruleset missing %q:\n%s", want, script)
+		}
+	}
+}
+
+func TestFirewall_RefreshBlocklists(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bad.txt")
+	if err := os.WriteFile(path, []byte("1.2.3.4\n2001:db8::1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := &fakeRunner{}
+	fw := New(config.Firewall{
+		Blocklists: []config.Blocklist{
+			{Name: "bad", Path: path},
+			{Name: "missing", Path: filepath.Join(dir, "missing.txt")},
+		},
+	}, WithRunner(runner))
+	ctx := context.Background()
+
+	// not enabled yet: lists load, nothing is pushed to nft
+	if err := fw.RefreshBlocklists(ctx); err != nil {
+		t.Fatalf("RefreshBlocklists() error = %v", err)
+	}
+	if len(runner.scripts) != 0 {
+		t.Errorf("ran %d scripts while disabled, want 0", len(runner.scripts))
+	}
+
+	status := fw.BlocklistStatus()
+	if len(status) != 2 {
+		t.Fatalf("len(status) = %d, want 2", len(status))
+	}
+	if status[0].IPv4Entries != 1 || status[0].IPv6Entries != 1 || status[0].LoadedAt.IsZero() {
+		t.Errorf("status[0] = %+v", status[0])
+	}
+	if status[1].Error == "" || !status[1].LoadedAt.IsZero() {
+		t.Errorf("status[1] = %+v, want load error", status[1])
+	}
+
+	if err := fw.Enable(ctx); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if !strings.Contains(runner.scripts[0], "elements = { 1.2.3.4/32 }") {
+		t.Errorf("enable script missing blocklist elements:\n%s", runner.scripts[0])
+	}
+
+	// unchanged file: no update
+	if err := fw.RefreshBlocklists(ctx); err != nil {
+		t.Fatalf("RefreshBlocklists() error = %v", err)
+	}
+	if len(runner.scripts) != 1 {
+		t.Fatalf("ran %d scripts for unchanged list, want 1", len(runner.scripts))
+	}
+
+	// changed file: sets are flushed and refilled in place
+	later := time.Now().Add(time.Minute)
+	if err := os.WriteFile(path, []byte("5.6.7.0/24\n9.9.9.9\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, later, later)
+
+	if err := fw.RefreshBlocklists(ctx); err != nil {
+		t.Fatalf("RefreshBlocklists() error = %v", err)
+	}
+	if len(runner.scripts) != 2 {
+		t.Fatalf("ran %d scripts after change, want 2", len(runner.scripts))
+	}
+	update := runner.scripts[1]
+	if !strings.Contains(update, "flush set inet "+TableName+" bl_bad_v4") ||
+		!strings.Contains(update, "add element inet "+TableName+" bl_bad_v4 { 5.6.7.0/24, 9.9.9.9/32 }") {
+		t.Errorf("update script = %s", update)
+	}
+	if st := fw.BlocklistStatus()[0]; st.IPv4Entries != 2 || st.IPv6Entries != 0 {
+		t.Errorf("status after reload = %+v", st)
+	}
+
+	// a failed push isn't recorded as loaded, and is retried next time
+	// even though the file hasn't changed since
+	later = later.Add(time.Minute)
+	if err := os.WriteFile(path, []byte("10.0.0.0/8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, later, later)
+	runner.err = errors.New("nft: busy")
+	if err := fw.RefreshBlocklists(ctx); err == nil {
+		t.Fatal("RefreshBlocklists() error = nil with a failing push")
+	}
+	if st := fw.BlocklistStatus()[0]; st.IPv4Entries != 2 || st.Error == "" {
+		t.Errorf("status after failed push = %+v, want old entries and an error", st)
+	}
+	runner.err = nil
+	if err := fw.RefreshBlocklists(ctx); err != nil {
+		t.Fatalf("RefreshBlocklists() retry error = %v", err)
+	}
+	if st := fw.BlocklistStatus()[0]; st.IPv4Entries != 1 || st.Error != "" {
+		t.Errorf("status after retry = %+v", st)
+	}
+}
+
+func TestFirewall_BlocklistsFirewalld(t *testing.T) {
+	_, conn := startFakeFirewalld(t)
+	path := filepath.Join(t.TempDir(), "bad.txt")
+	if err := os.WriteFile(path, []byte("1.2.3.4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fw := New(config.Firewall{Blocklists: []config.Blocklist{{Name: "bad", Path: path}}},
+		WithBackend(NewFirewalld(conn, "", nil)))
+	ctx := context.Background()
+	if err := fw.Enable(ctx); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+
+	// no error to log on every poll, just a note in the status
+	for range 2 {
+		if err := fw.RefreshBlocklists(ctx); err != nil {
+			t.Errorf("RefreshBlocklists() error = %v", err)
+		}
+	}
+	if st := fw.BlocklistStatus()[0]; st.IPv4Entries != 1 || !strings.Contains(st.Error, "firewalld") {
+		t.Errorf("status = %+v, want loaded with an unsupported note", st)
+	}
+}
@@ -0,0 +1,165 @@
+// oreon/defense · watchthelight <wtl>
+
+package firewall
+
+import (
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRecentBlocks = 1000 // blocked packets kept for queries
+	maxTrackedSources   = 4096 // distinct sources kept for top-N stats
+)
+
+// SourceStats summarizes blocked traffic from one address.
+type SourceStats struct {
+	Source   netip.Addr
+	Count    int
+	LastSeen time.Time
+	LastPort int
+}
+
+// BlockFilter narrows Recent results. Zero values match everything.
+type BlockFilter struct {
+	Source netip.Addr
+	Since  time.Time
+	Limit  int
+}
+
+// BlockLog keeps recent blocked packets and per-source counters,
+// and rate-limits how many of them turn into events.
+// Thread-safe.
+type BlockLog struct {
+	mu      sync.Mutex
+	recent  []BlockedPacket // ring buffer
+	next    int
+	full    bool
+	sources map[netip.Addr]*SourceStats
+
+	rate        int // events per second, 0 = unlimited
+	windowStart time.Time
+	windowCount int
+	suppressed  int
+}
+
+// NewBlockLog creates a block log keeping up to capacity packets and
+// allowing at most rate events per second (0 = unlimited).
+func NewBlockLog(capacity, rate int) *BlockLog {
+	if capacity <= 0 {
+		capacity = defaultRecentBlocks
+	}
+	return &BlockLog{
+		recent:  make([]BlockedPacket, capacity),
+		sources: make(map[netip.Addr]*SourceStats),
+		rate:    rate,
+	}
+}
+
+// Record stores a packet. It returns true if an event should be emitted
+// for it, along with how many packets were suppressed by the rate limit
+// since the last event that was allowed through.
+func (l *BlockLog) Record(p BlockedPacket) (emit bool, suppressed int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.recent[l.next] = p
+	l.next = (l.next + 1) % len(l.recent)
+	if l.next == 0 {
+		l.full = true
+	}
+
+	st, ok := l.sources[p.Source]
+	if !ok {
+		if len(l.sources) >= maxTrackedSources {
+			l.evictOldestSource()
+		}
+		st = &SourceStats{Source: p.Source}
+		l.sources[p.Source] = st
+	}
+	st.Count++
+	st.LastSeen = p.Time
+	st.LastPort = p.DestPort
+
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	if p.Time.Sub(l.windowStart) >= time.Second {
+		l.windowStart = p.Time
+		l.windowCount = 0
+	}
+	if l.windowCount >= l.rate {
+		l.suppressed++
+		return false, 0
+	}
+	l.windowCount++
+	suppressed = l.suppressed
+	l.suppressed = 0
+	return true, suppressed
+}
+
+// evictOldestSource drops the least recently seen source. Caller holds mu.
+func (l *BlockLog) evictOldestSource() {
+	var oldest netip.Addr
+	var oldestSeen time.Time
+	for addr, st := range l.sources {
+		if oldestSeen.IsZero() || st.LastSeen.Before(oldestSeen) {
+			oldest = addr
+			oldestSeen = st.LastSeen
+		}
+	}
+	delete(l.sources, oldest)
+}
+
+// Recent returns stored packets matching the filter, newest first.
+func (l *BlockLog) Recent(f BlockFilter) []BlockedPacket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := l.next
+	if l.full {
+		n = len(l.recent)
+	}
+
+	var out []BlockedPacket
+	for i := 0; i < n; i++ {
+		idx := (l.next - 1 - i + len(l.recent)) % len(l.recent)
+		p := l.recent[idx]
+		if f.Source.IsValid() && p.Source != f.Source {
+			continue
+		}
+		if !f.Since.IsZero() && p.Time.Before(f.Since) {
+			continue
+		}
+		out = append(out, p)
+		if f.Limit > 0 && len(out) >= f.Limit {
+			break
+		}
+	}
+	return out
+}
+
+// TopSources returns the n sources with the most blocked packets.
+func (l *BlockLog) TopSources(n int) []SourceStats {
+	l.mu.Lock()
+	out := make([]SourceStats, 0, len(l.sources))
+	for _, st := range l.sources {
+		out = append(out, *st)
+	}
+	l.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].LastSeen.After(out[j].LastSeen)
+	})
+
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
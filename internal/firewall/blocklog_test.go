@@ -0,0 +1,95 @@
+// oreon/defense · watchthelight <wtl>
+
+package firewall
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func blocked(src string, port int, at time.Time) BlockedPacket {
+	return BlockedPacket{
+		Time:     at,
+		Source:   netip.MustParseAddr(src),
+		Dest:     netip.MustParseAddr("192.168.1.10"),
+		DestPort: port,
+		Protocol: "tcp",
+	}
+}
+
+func TestBlockLog_RateLimit(t *testing.T) {
+	log := NewBlockLog(10, 2)
+	now := time.Now()
+
+	var emitted int
+	for i := 0; i < 5; i++ {
+		if emit, _ := log.Record(blocked("10.0.0.1", 22, now)); emit {
+			emitted++
+		}
+	}
+	if emitted != 2 {
+		t.Errorf("emitted %d events in one second, want 2", emitted)
+	}
+
+	// next window reports what was suppressed
+	emit, suppressed := log.Record(blocked("10.0.0.1", 22, now.Add(time.Second)))
+	if !emit {
+		t.Fatal("event not emitted in new window")
+	}
+	if suppressed != 3 {
+		t.Errorf("suppressed = %d, want 3", suppressed)
+	}
+}
+
+func TestBlockLog_TopSources(t *testing.T) {
+	log := NewBlockLog(100, 0)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		log.Record(blocked("10.0.0.1", 22, now))
+	}
+	log.Record(blocked("10.0.0.2", 80, now))
+	for i := 0; i < 5; i++ {
+		log.Record(blocked("10.0.0.3", 3389, now))
+	}
+
+	top := log.TopSources(2)
+	if len(top) != 2 {
+		t.Fatalf("len(TopSources) = %d, want 2", len(top))
+	}
+	if top[0].Source.String() != "10.0.0.3" || top[0].Count != 5 {
+		t.Errorf("top[0] = %+v", top[0])
+	}
+	if top[1].Source.String() != "10.0.0.1" || top[1].Count != 3 {
+		t.Errorf("top[1] = %+v", top[1])
+	}
+}
+
+func TestBlockLog_Recent(t *testing.T) {
+	log := NewBlockLog(3, 0)
+	base := time.Now()
+
+	for i := 0; i < 5; i++ {
+		log.Record(blocked("10.0.0.1", 1000+i, base.Add(time.Duration(i)*time.Second)))
+	}
+
+	// ring buffer keeps the newest 3, newest first
+	recent := log.Recent(BlockFilter{})
+	if len(recent) != 3 {
+		t.Fatalf("len(Recent) = %d, want 3", len(recent))
+	}
+	if recent[0].DestPort != 1004 || recent[2].DestPort != 1002 {
+		t.Errorf("Recent order = %d..%d, want 1004..1002", recent[0].DestPort, recent[2].DestPort)
+	}
+
+	since := log.Recent(BlockFilter{Since: base.Add(4 * time.Second)})
+	if len(since) != 1 {
+		t.Errorf("len(Recent since) = %d, want 1", len(since))
+	}
+
+	other := log.Recent(BlockFilter{Source: netip.MustParseAddr("10.0.0.9")})
+	if len(other) != 0 {
+		t.Errorf("len(Recent other source) = %d, want 0", len(other))
+	}
+}
@@ -0,0 +1,258 @@
+// oreon/defense · watchthelight <wtl>
+
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"sync"
+
+	"github.com/oreonproject/defense/pkg/config"
+)
+
+// Backend names, as used in config and status.
+const (
+	BackendNFTables  = "nftables"
+	BackendFirewalld = "firewalld"
+)
+
+// Backend is the system firewall the daemon drives. Calls are serialized
+// by Firewall, so implementations don't need their own locking.
+type Backend interface {
+	Name() string
+	// Apply installs the full desired state, replacing whatever we set before.
+	Apply(ctx context.Context, opts RuleOptions) error
+	// Remove undoes everything Apply and AddBan installed.
+	Remove(ctx context.Context) error
+	AddBan(ctx context.Context, b Ban) error
+	RemoveBan(ctx context.Context, addr netip.Addr) error
+	ReplaceBlocklist(ctx context.Context, bl BlocklistSet) error
+	// SetPanic blocks (or stops blocking) all traffic.
+	SetPanic(ctx context.Context, on bool) error
+	Counts() (tables, chains, rules int)
+}
+
+// Firewall manages the daemon's rules through a Backend.
+// Thread-safe - Enable/Disable may be called from IPC handlers concurrently.
+type Firewall struct {
+	cfg     config.Firewall
+	backend Backend
+	logger  *slog.Logger
+
+	blocks *BlockLog
+
+	mu         sync.Mutex
+	enabled    bool
+	panic      bool
+	blocklists []*blocklist
+	bans       map[netip.Addr]Ban
+	appRules   []AppRule
+	appDeny    bool
+}
+
+// Option configures a Firewall.
+type Option func(*Firewall)
+
+// WithRunner uses the nftables backend with the given nft runner (useful for tests).
+func WithRunner(r Runner) Option {
+	return func(f *Firewall) {
+		f.backend = NewNFTables(r)
+	}
+}
+
+// WithBackend sets the firewall backend. Defaults to nftables.
+func WithBackend(b Backend) Option {
+	return func(f *Firewall) {
+		f.backend = b
+	}
+}
+
+// WithLogger sets the logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(f *Firewall) {
+		f.logger = logger
+	}
+}
+
+// New creates a firewall manager. Nothing is applied until Enable is called,
+// and blocklists aren't read until RefreshBlocklists.
+func New(cfg config.Firewall, opts ...Option) *Firewall {
+	f := &Firewall{
+		cfg:     cfg,
+		backend: NewNFTables(nil),
+		logger:  slog.Default(),
+		blocks:  NewBlockLog(defaultRecentBlocks, cfg.LogRate),
+		bans:    make(map[netip.Addr]Ban),
+	}
+	for _, bl := range cfg.Blocklists {
+		f.blocklists = append(f.blocklists, &blocklist{name: bl.Name, path: bl.Path})
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Enable builds the desired state from config and installs it.
+// While panic mode is on it's only recorded, and applied when panic ends.
+func (f *Firewall) Enable(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.panic {
+		if err := f.backend.Apply(ctx, f.ruleOptions()); err != nil {
+			return err
+		}
+		_, chains, rules := f.backend.Counts()
+		f.logger.Info("firewall ruleset applied", "backend", f.backend.Name(), "chains", chains, "rules", rules)
+	}
+	f.enabled = true
+	return nil
+}
+
+// Disable removes our rules. Rules owned by other tools are left alone.
+func (f *Firewall) Disable(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.panic {
+		if err := f.backend.Remove(ctx); err != nil {
+			return err
+		}
+		f.logger.Info("firewall ruleset removed", "backend", f.backend.Name())
+	}
+	f.enabled = false
+	return nil
+}
+
+// SetPanic turns panic mode on or off. Panic blocks all traffic regardless
+// of whether the firewall is enabled; turning it off restores whatever
+// state was in place before.
+func (f *Firewall) SetPanic(ctx context.Context, on bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if on == f.panic {
+		return nil
+	}
+	if on && f.enabled {
+		// firewalld's panic mode sits alongside zones, so clear ours first
+		// to keep the two backends behaving the same
+		if err := f.backend.Remove(ctx); err != nil {
+			return err
+		}
+	}
+	if err := f.backend.SetPanic(ctx, on); err != nil {
+		return fmt.Errorf("set panic mode: %w", err)
+	}
+	f.panic = on
+	f.logger.Warn("firewall panic mode changed", "backend", f.backend.Name(), "panic", on)
+
+	if !on && f.enabled {
+		if err := f.backend.Apply(ctx, f.ruleOptions()); err != nil {
+			return fmt.Errorf("restore ruleset: %w", err)
+		}
+	}
+	return nil
+}
+
+// Panic reports whether panic mode is on.
+func (f *Firewall) Panic() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.panic
+}
+
+// Backend returns the name of the active backend.
+func (f *Firewall) Backend() string {
+	return f.backend.Name()
+}
+
+// Enabled reports whether our ruleset is currently installed.
+func (f *Firewall) Enabled() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.enabled
+}
+
+// Counts returns table, chain and rule counts for the installed ruleset.
+// All zero when the firewall is disabled.
+func (f *Firewall) Counts() (tables, chains, rules int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.enabled && !f.panic {
+		return 0, 0, 0
+	}
+	return f.backend.Counts()
+}
+
+// active reports whether changes should be pushed to the backend right
+// now. Caller holds f.mu.
+func (f *Firewall) active() bool {
+	return f.enabled && !f.panic
+}
+
+// ruleOptions assembles the desired state. Caller holds f.mu.
+func (f *Firewall) ruleOptions() RuleOptions {
+	return RuleOptions{
+		AllowedTCPPorts: f.cfg.AllowedTCPPorts,
+		AllowedUDPPorts: f.cfg.AllowedUDPPorts,
+		Services:        f.cfg.Services,
+		LogBlocked:      f.cfg.LogBlocked,
+		LogGroup:        f.cfg.LogGroup,
+		Blocklists:      f.blocklistSets(),
+		Bans:            f.activeBans(),
+		AppRules:        f.appRules,
+		AppDefaultDeny:  f.appDeny,
+	}
+}
+
+// SetAppRules replaces the per-application outbound rules and reapplies
+// the ruleset if they changed. No-op while disabled or in panic; the
+// rules are picked up on the next Enable.
+func (f *Firewall) SetAppRules(ctx context.Context, rules []AppRule, defaultDeny bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if slices.Equal(rules, f.appRules) && defaultDeny == f.appDeny {
+		return nil
+	}
+	prevRules, prevDeny := f.appRules, f.appDeny
+	f.appRules, f.appDeny = slices.Clone(rules), defaultDeny
+	if !f.active() {
+		return nil
+	}
+	if err := f.backend.Apply(ctx, f.ruleOptions()); err != nil {
+		// the old ruleset is still live, keep our view in step with it
+		f.appRules, f.appDeny = prevRules, prevDeny
+		return fmt.Errorf("apply app rules: %w", err)
+	}
+	f.logger.Info("app firewall rules applied", "rules", len(rules), "default_deny", defaultDeny)
+	return nil
+}
+
+// Blocked returns the log of recently blocked packets.
+func (f *Firewall) Blocked() *BlockLog {
+	return f.blocks
+}
+
+// WatchBlocked reads dropped packets from NFLOG until ctx is cancelled.
+// Every packet is recorded in the block log; onBlock is called only for
+// packets that pass the rate limit, with the number suppressed before it.
+func (f *Firewall) WatchBlocked(ctx context.Context, onBlock func(p BlockedPacket, suppressed int)) error {
+	r, err := OpenNFLog(f.cfg.LogGroup)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f.logger.Info("watching blocked connections", "nflog_group", f.cfg.LogGroup)
+	return r.Watch(ctx, func(p BlockedPacket) {
+		if emit, suppressed := f.blocks.Record(p); emit {
+			onBlock(p, suppressed)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+// oreon/defense · watchthelight <wtl>
+
+package firewall
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/oreonproject/defense/pkg/config"
+)
+
+// fakeRunner records scripts instead of running nft.
+type fakeRunner struct {
+	scripts []string
+	err     error
+}
+
+func (r *fakeRunner) Run(_ context.Context, script string) error {
+	r.scripts = append(r.scripts, script)
+	return r.err
+}
+
+func TestBuildRuleset(t *testing.T) {
+	rs := BuildRuleset(RuleOptions{
+		AllowedTCPPorts: []int{443, 22},
+		LogBlocked:      true,
+		LogGroup:        100,
+	})
+
+	script := rs.Render()
+
+	for _, want := range []string{
+		"table inet " + TableName,
+		"policy drop;",
+		"tcp dport { 22, 443 } accept",
+		`log prefix "oreon-drop" group 100`,
+		"counter drop",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("ruleset missing %q:\n%s", want, script)
+		}
+	}
+	if strings.Contains(script, "udp dport") {
+		t.Error("ruleset has udp rule with no allowed udp ports")
+	}
+
+	// log rule must come before the final drop
+	if strings.Index(script, "log prefix") > strings.LastIndex(script, "counter drop") {
+		t.Error("log rule placed after drop")
+	}
+}
+
+func TestBuildRuleset_NoLogging(t *testing.T) {
+	rs := BuildRuleset(RuleOptions{LogBlocked: false})
+	if strings.Contains(rs.Render(), "log ") {
+		t.Error("ruleset contains log rule with LogBlocked=false")
+	}
+}
+
+func TestFirewall_EnableDisable(t *testing.T) {
+	runner := &fakeRunner{}
+	fw := New(config.Firewall{LogBlocked: true, LogGroup: 5}, WithRunner(runner))
+
+	if tables, _, _ := fw.Counts(); tables != 0 {
+		t.Errorf("tables = %d before enable, want 0", tables)
+	}
+
+	if err := fw.Enable(context.Background()); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if !fw.Enabled() {
+		t.Error("Enabled() = false after Enable")
+	}
+	tables, chains, rules := fw.Counts()
+	if tables != 1 || chains != 1 || rules == 0 {
+		t.Errorf("Counts() = %d, %d, %d", tables, chains, rules)
+	}
+
+	if err := fw.Disable(context.Background()); err != nil {
+		t.Fatalf("Disable() error = %v", err)
+	}
+	if fw.Enabled() {
+		t.Error("Enabled() = true after Disable")
+	}
+	if len(runner.scripts) != 2 {
+		t.Fatalf("ran %d scripts, want 2", len(runner.scripts))
+	}
+	if !strings.Contains(runner.scripts[1], "delete table inet "+TableName) {
+		t.Errorf("disable script = %q", runner.scripts[1])
+	}
+}
+
+func TestFirewall_EnableError(t *testing.T) {
+	fw := New(config.Firewall{}, WithRunner(&fakeRunner{err: errors.New("boom")}))
+
+	if err := fw.Enable(context.Background()); err == nil {
+		t.Fatal("Enable() should fail when nft fails")
+	}
+	if fw.Enabled() {
+		t.Error("Enabled() = true after failed Enable")
+	}
+}
+
+func TestFirewall_Panic(t *testing.T) {
+	runner := &fakeRunner{}
+	fw := New(config.Firewall{AllowedTCPPorts: []int{22}}, WithRunner(runner))
+	ctx := context.Background()
+
+	if err := fw.Enable(ctx); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if err := fw.SetPanic(ctx, true); err != nil {
+		t.Fatalf("SetPanic(true) error = %v", err)
+	}
+	panicScript := runner.scripts[len(runner.scripts)-1]
+	if !strings.Contains(panicScript, "hook output priority filter; policy drop;") || strings.Contains(panicScript, "dport") {
+		t.Errorf("panic script = %s", panicScript)
+	}
+
+	// changes made during panic wait until it's lifted
+	n := len(runner.scripts)
+	if err := fw.Disable(ctx); err != nil {
+		t.Fatalf("Disable() error = %v", err)
+	}
+	if err := fw.Enable(ctx); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if len(runner.scripts) != n {
+		t.Error("Enable/Disable ran nft during panic")
+	}
+
+	if err := fw.SetPanic(ctx, false); err != nil {
+		t.Fatalf("SetPanic(false) error = %v", err)
+	}
+	if last := runner.scripts[len(runner.scripts)-1]; !strings.Contains(last, "tcp dport { 22 } accept") {
+		t.Errorf("ruleset not restored after panic:\n%s", last)
+	}
+}
+
+func TestBuildRuleset_AppRules(t *testing.T) {
+	rs := BuildRuleset(RuleOptions{
+		AppRules: []AppRule{
+			{Cgroup: "system.slice/nginx.service", Allow: true},
+			{Cgroup: "user.slice/user-1000.slice/app.slice/curl.scope", Allow: false},
+		},
+		AppDefaultDeny: true,
+	})
+	script := rs.Render()
+
+	for _, want := range []string{
+		"hook output priority filter; policy accept;",
+		`socket cgroupv2 level 2 "system.slice/nginx.service" accept`,
+		`socket cgroupv2 level 4 "user.slice/user-1000.slice/app.slice/curl.scope" counter drop`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("ruleset missing %q:\n%s", want, script)
+		}
+	}
+	out := rs.Chains[len(rs.Chains)-1]
+	if out.Rules[len(out.Rules)-1] != "counter drop" {
+		t.Errorf("default deny not last in output chain: %v", out.Rules)
+	}
+}
+
+func TestFirewall_SetAppRules(t *testing.T) {
+	runner := &fakeRunner{}
+	fw := New(config.Firewall{}, WithRunner(runner))
+	ctx := context.Background()
+	rules := []AppRule{{Cgroup: "system.slice/sshd.service", Allow: true}}
+
+	// recorded while disabled, applied on Enable
+	if err := fw.SetAppRules(ctx, rules, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(runner.scripts) != 0 {
+		t.Error("SetAppRules ran nft while disabled")
+	}
+	fw.Enable(ctx)
+	if !strings.Contains(runner.scripts[0], `socket cgroupv2 level 2 "system.slice/sshd.service" accept`) {
+		t.Errorf("enable script missing app rule:\n%s", runner.scripts[0])
+	}
+
+	// unchanged rules don't reapply
+	fw.SetAppRules(ctx, rules, false)
+	if len(runner.scripts) != 1 {
+		t.Errorf("unchanged rules reapplied, %d scripts", len(runner.scripts))
+	}
+
+	runner.err = errors.New("cgroup vanished")
+	if err := fw.SetAppRules(ctx, nil, true); err == nil {
+		t.Fatal("SetAppRules() should fail when nft fails")
+	}
+	runner.err = nil
+	// the failed update was rolled back, so retrying applies it
+	if err := fw.SetAppRules(ctx, nil, true); err != nil || len(runner.scripts) != 3 {
+		t.Errorf("retry: err = %v, scripts = %d", err, len(runner.scripts))
+	}
+}
@@ -0,0 +1,265 @@
+// oreon/defense · watchthelight <wtl>
+
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/oreonproject/defense/pkg/config"
+)
+
+// firewalld D-Bus names. Everything we need lives on the one object.
+const (
+	firewalldName      = "org.fedoraproject.FirewallD1"
+	firewalldPath      = dbus.ObjectPath("/org/fedoraproject/FirewallD1")
+	firewalldIface     = "org.fedoraproject.FirewallD1"
+	firewalldZoneIface = firewalldIface + ".zone"
+)
+
+// FirewalldRunning reports whether firewalld owns its name on conn.
+func FirewalldRunning(conn *dbus.Conn) bool {
+	var has bool
+	err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, firewalldName).Store(&has)
+	return err == nil && has
+}
+
+// portProto is an allowed port as firewalld names it, e.g. {"22", "tcp"}.
+type portProto struct {
+	port  string
+	proto string
+}
+
+// Firewalld drives firewalld over D-Bus instead of owning an nftables
+// table, so we fit into its zone model rather than fighting it.
+// Only the runtime configuration is touched; the admin's permanent
+// zones are never modified. Not thread-safe; Firewall serializes calls.
+type Firewalld struct {
+	obj    dbus.BusObject
+	zone   string // configured zone, empty = default zone
+	logger *slog.Logger
+
+	// what we've added, so Remove can undo exactly that
+	active   string // zone the items below were added to
+	ports    []portProto
+	services []string
+	rich     map[netip.Addr]string // ban rich rules by address
+}
+
+// NewFirewalld creates a firewalld backend on conn (normally the system bus).
+func NewFirewalld(conn *dbus.Conn, zone string, logger *slog.Logger) *Firewalld {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Firewalld{
+		obj:    conn.Object(firewalldName, firewalldPath),
+		zone:   zone,
+		logger: logger,
+		rich:   make(map[netip.Addr]string),
+	}
+}
+
+// Name returns "firewalld".
+func (fd *Firewalld) Name() string {
+	return BackendFirewalld
+}
+
+// Apply opens the allowed ports and services in the zone and adds a rich
+// rule per active ban. Blocklists and NFLOG logging have no firewalld
+// equivalent here and are skipped with a warning.
+func (fd *Firewalld) Apply(ctx context.Context, opts RuleOptions) error {
+	if err := fd.Remove(ctx); err != nil {
+		return err
+	}
+
+	zone := fd.zone
+	if zone == "" {
+		if err := fd.obj.CallWithContext(ctx, firewalldIface+".getDefaultZone", 0).Store(&zone); err != nil {
+			return fmt.Errorf("firewalld: get default zone: %w", err)
+		}
+	}
+	fd.active = zone
+
+	for _, p := range opts.AllowedTCPPorts {
+		if err := fd.addPort(ctx, portProto{strconv.Itoa(p), "tcp"}); err != nil {
+			return err
+		}
+	}
+	for _, p := range opts.AllowedUDPPorts {
+		if err := fd.addPort(ctx, portProto{strconv.Itoa(p), "udp"}); err != nil {
+			return err
+		}
+	}
+	for _, svc := range opts.Services {
+		if err := fd.zoneCall(ctx, "addService", zone, svc, int32(0)); err != nil {
+			return fmt.Errorf("firewalld: add service %s: %w", svc, err)
+		}
+		fd.services = append(fd.services, svc)
+	}
+	for _, b := range opts.Bans {
+		if err := fd.AddBan(ctx, b); err != nil {
+			fd.logger.Warn("firewalld: ban not applied", "addr", b.Addr, "error", err)
+		}
+	}
+
+	if len(opts.Blocklists) > 0 {
+		fd.logger.Warn("firewalld backend does not support blocklists, ignoring them", "count", len(opts.Blocklists))
+	}
+	if len(opts.AppRules) > 0 || opts.AppDefaultDeny {
+		fd.logger.Warn("firewalld backend does not support per-application rules, ignoring them")
+	}
+	if opts.LogBlocked {
+		fd.logger.Info("blocked-connection logging is not available with the firewalld backend")
+	}
+	return nil
+}
+
+func (fd *Firewalld) addPort(ctx context.Context, p portProto) error {
+	if err := fd.zoneCall(ctx, "addPort", fd.active, p.port, p.proto, int32(0)); err != nil {
+		return fmt.Errorf("firewalld: add port %s/%s: %w", p.port, p.proto, err)
+	}
+	fd.ports = append(fd.ports, p)
+	return nil
+}
+
+// Remove takes back every port, service and rich rule we added.
+// Keeps going on errors so one stale item doesn't strand the rest.
+func (fd *Firewalld) Remove(ctx context.Context) error {
+	if fd.active == "" {
+		return nil
+	}
+
+	var errs []error
+	for _, p := range fd.ports {
+		if err := fd.zoneCall(ctx, "removePort", fd.active, p.port, p.proto); err != nil {
+			errs = append(errs, fmt.Errorf("remove port %s/%s: %w", p.port, p.proto, err))
+		}
+	}
+	for _, svc := range fd.services {
+		if err := fd.zoneCall(ctx, "removeService", fd.active, svc); err != nil {
+			errs = append(errs, fmt.Errorf("remove service %s: %w", svc, err))
+		}
+	}
+	for addr, rule := range fd.rich {
+		if err := fd.zoneCall(ctx, "removeRichRule", fd.active, rule); err != nil {
+			errs = append(errs, fmt.Errorf("remove ban %s: %w", addr, err))
+		}
+	}
+
+	fd.ports, fd.services = nil, nil
+	clear(fd.rich)
+	fd.active = ""
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("firewalld: %w", err)
+	}
+	return nil
+}
+
+// AddBan adds a drop rich rule for the address. firewalld expires it on
+// its own via the rule timeout.
+func (fd *Firewalld) AddBan(ctx context.Context, b Ban) error {
+	secs := int32(b.Until.Sub(time.Now()).Seconds())
+	if secs < 1 {
+		return fmt.Errorf("ban for %s already expired", b.Addr)
+	}
+	rule := banRichRule(b.Addr)
+	// remove first so re-banning resets the timeout
+	if err := fd.zoneCall(ctx, "removeRichRule", fd.active, rule); err != nil {
+		return err
+	}
+	if err := fd.zoneCall(ctx, "addRichRule", fd.active, rule, secs); err != nil {
+		return err
+	}
+	fd.rich[b.Addr] = rule
+	return nil
+}
+
+// RemoveBan drops the address's rich rule.
+func (fd *Firewalld) RemoveBan(ctx context.Context, addr netip.Addr) error {
+	if err := fd.zoneCall(ctx, "removeRichRule", fd.active, banRichRule(addr)); err != nil {
+		return err
+	}
+	delete(fd.rich, addr)
+	return nil
+}
+
+// ReplaceBlocklist isn't supported; firewalld ipsets would need permanent
+// config changes we don't want to make behind the admin's back.
+func (fd *Firewalld) ReplaceBlocklist(context.Context, BlocklistSet) error {
+	return fmt.Errorf("firewalld backend: %w", errors.ErrUnsupported)
+}
+
+// SetPanic toggles firewalld's own panic mode.
+func (fd *Firewalld) SetPanic(ctx context.Context, on bool) error {
+	method := "disablePanicMode"
+	if on {
+		method = "enablePanicMode"
+	}
+	return fd.call(ctx, firewalldIface+"."+method)
+}
+
+// Counts reports zero tables and chains (firewalld owns those) and the
+// number of ports, services and rich rules we added.
+func (fd *Firewalld) Counts() (tables, chains, rules int) {
+	return 0, 0, len(fd.ports) + len(fd.services) + len(fd.rich)
+}
+
+func (fd *Firewalld) zoneCall(ctx context.Context, method string, args ...any) error {
+	return fd.call(ctx, firewalldZoneIface+"."+method, args...)
+}
+
+// call invokes a firewalld method, discarding the result. firewalld reports
+// "already there" and "already gone" as errors; we want those idempotent.
+func (fd *Firewalld) call(ctx context.Context, method string, args ...any) error {
+	err := fd.obj.CallWithContext(ctx, method, 0, args...).Err
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if strings.HasPrefix(msg, "ALREADY_ENABLED") || strings.HasPrefix(msg, "NOT_ENABLED") {
+		return nil
+	}
+	return err
+}
+
+// banRichRule renders the rich rule used to ban addr.
+func banRichRule(addr netip.Addr) string {
+	family := "ipv4"
+	if addr.Is6() {
+		family = "ipv6"
+	}
+	return fmt.Sprintf(`rule family="%s" source address="%s" drop`, family, addr)
+}
+
+// SelectBackend picks a backend according to the "backend" config setting.
+// "auto" uses firewalld when it's running on the system bus and nftables
+// otherwise; an empty value means nftables.
+func SelectBackend(cfg config.Firewall, logger *slog.Logger) (Backend, error) {
+	switch cfg.Backend {
+	case "", BackendNFTables:
+		return NewNFTables(nil), nil
+	case BackendFirewalld:
+		conn, err := dbus.SystemBus()
+		if err != nil {
+			return nil, fmt.Errorf("connect to system bus: %w", err)
+		}
+		if !FirewalldRunning(conn) {
+			return nil, errors.New("firewalld backend selected but firewalld is not running")
+		}
+		return NewFirewalld(conn, cfg.Zone, logger), nil
+	case "auto":
+		if conn, err := dbus.SystemBus(); err == nil && FirewalldRunning(conn) {
+			return NewFirewalld(conn, cfg.Zone, logger), nil
+		}
+		return NewNFTables(nil), nil
+	default:
+		return nil, fmt.Errorf("unknown firewall backend %q", cfg.Backend)
+	}
+}
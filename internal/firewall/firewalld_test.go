@@ -0,0 +1,287 @@
+// oreon/defense · watchthelight <wtl>
+
+package firewall
+
+import (
+	"context"
+	"maps"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/oreonproject/defense/internal/dbustest"
+	"github.com/oreonproject/defense/pkg/config"
+)
+
+// fakeFirewalld implements the slice of firewalld's D-Bus API we use,
+// keeping runtime state in maps keyed by zone.
+type fakeFirewalld struct {
+	mu       sync.Mutex
+	ports    map[string]bool  // "zone 22/tcp"
+	services map[string]bool  // "zone ssh"
+	rich     map[string]int32 // "zone rule" -> timeout
+	panic    bool
+}
+
+func alreadyEnabled(what string) *dbus.Error {
+	return dbus.NewError("org.fedoraproject.FirewallD1.Exception", []any{"ALREADY_ENABLED: " + what})
+}
+
+func notEnabled(what string) *dbus.Error {
+	return dbus.NewError("org.fedoraproject.FirewallD1.Exception", []any{"NOT_ENABLED: " + what})
+}
+
+func (f *fakeFirewalld) add(m map[string]bool, key string) *dbus.Error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if m[key] {
+		return alreadyEnabled(key)
+	}
+	m[key] = true
+	return nil
+}
+
+func (f *fakeFirewalld) remove(m map[string]bool, key string) *dbus.Error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !m[key] {
+		return notEnabled(key)
+	}
+	delete(m, key)
+	return nil
+}
+
+func (f *fakeFirewalld) GetDefaultZone() (string, *dbus.Error) { return "public", nil }
+
+func (f *fakeFirewalld) AddPort(zone, port, proto string, _ int32) (string, *dbus.Error) {
+	return zone, f.add(f.ports, zone+" "+port+"/"+proto)
+}
+
+func (f *fakeFirewalld) RemovePort(zone, port, proto string) (string, *dbus.Error) {
+	return zone, f.remove(f.ports, zone+" "+port+"/"+proto)
+}
+
+func (f *fakeFirewalld) AddService(zone, svc string, _ int32) (string, *dbus.Error) {
+	return zone, f.add(f.services, zone+" "+svc)
+}
+
+func (f *fakeFirewalld) RemoveService(zone, svc string) (string, *dbus.Error) {
+	return zone, f.remove(f.services, zone+" "+svc)
+}
+
+func (f *fakeFirewalld) AddRichRule(zone, rule string, timeout int32) (string, *dbus.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.rich[zone+" "+rule]; ok {
+		return "", alreadyEnabled(rule)
+	}
+	f.rich[zone+" "+rule] = timeout
+	return zone, nil
+}
+
+func (f *fakeFirewalld) RemoveRichRule(zone, rule string) (string, *dbus.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.rich[zone+" "+rule]; !ok {
+		return "", notEnabled(rule)
+	}
+	delete(f.rich, zone+" "+rule)
+	return zone, nil
+}
+
+func (f *fakeFirewalld) EnablePanicMode() *dbus.Error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.panic {
+		return alreadyEnabled("panic mode")
+	}
+	f.panic = true
+	return nil
+}
+
+func (f *fakeFirewalld) DisablePanicMode() *dbus.Error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.panic {
+		return notEnabled("panic mode")
+	}
+	f.panic = false
+	return nil
+}
+
+func (f *fakeFirewalld) count() (ports, services, rich int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.ports), len(f.services), len(f.rich)
+}
+
+// hasPort, richKeys and panicking read state under the lock; the bus
+// goroutine writes it while the test runs.
+func (f *fakeFirewalld) hasPort(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ports[key]
+}
+
+func (f *fakeFirewalld) richKeys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Collect(maps.Keys(f.rich))
+}
+
+func (f *fakeFirewalld) panicking() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.panic
+}
+
+// startFakeFirewalld serves a fakeFirewalld on a private bus and returns
+// it with a client connection to the same bus.
+func startFakeFirewalld(t *testing.T) (*fakeFirewalld, *dbus.Conn) {
+	t.Helper()
+	addr := dbustest.StartBus(t)
+	server := dbustest.Connect(t, addr)
+
+	fake := &fakeFirewalld{
+		ports:    make(map[string]bool),
+		services: make(map[string]bool),
+		rich:     make(map[string]int32),
+	}
+	// firewalld uses lowerCamel method names
+	names := map[string]string{
+		"GetDefaultZone":   "getDefaultZone",
+		"AddPort":          "addPort",
+		"RemovePort":       "removePort",
+		"AddService":       "addService",
+		"RemoveService":    "removeService",
+		"AddRichRule":      "addRichRule",
+		"RemoveRichRule":   "removeRichRule",
+		"EnablePanicMode":  "enablePanicMode",
+		"DisablePanicMode": "disablePanicMode",
+	}
+	for _, iface := range []string{firewalldIface, firewalldZoneIface} {
+		if err := server.ExportWithMap(fake, names, firewalldPath, iface); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := dbustest.Connect(t, addr)
+	if FirewalldRunning(client) {
+		t.Fatal("FirewalldRunning() = true before the name was taken")
+	}
+	if reply, err := server.RequestName(firewalldName, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("RequestName() = %v, %v", reply, err)
+	}
+	if !FirewalldRunning(client) {
+		t.Fatal("FirewalldRunning() = false after the name was taken")
+	}
+	return fake, client
+}
+
+func TestFirewalld_EnableDisable(t *testing.T) {
+	fake, conn := startFakeFirewalld(t)
+	cfg := config.Firewall{
+		AllowedTCPPorts: []int{22, 443},
+		AllowedUDPPorts: []int{53},
+		Services:        []string{"cockpit"},
+	}
+	fw := New(cfg, WithBackend(NewFirewalld(conn, "", nil)))
+	ctx := context.Background()
+
+	if err := fw.Ban(ctx, netip.MustParseAddr("2001:db8::1"), time.Hour, "test"); err != nil {
+		t.Fatalf("Ban() error = %v", err)
+	}
+	if err := fw.Enable(ctx); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if fw.Backend() != BackendFirewalld {
+		t.Errorf("Backend() = %q", fw.Backend())
+	}
+
+	fake.mu.Lock()
+	for _, want := range []string{"public 22/tcp", "public 443/tcp", "public 53/udp"} {
+		if !fake.ports[want] {
+			t.Errorf("port %q not added, have %v", want, fake.ports)
+		}
+	}
+	if !fake.services["public cockpit"] {
+		t.Errorf("service not added, have %v", fake.services)
+	}
+	timeout, ok := fake.rich[`public rule family="ipv6" source address="2001:db8::1" drop`]
+	if !ok || timeout < 3590 || timeout > 3600 {
+		t.Errorf("ban rich rule = %d, %v; have %v", timeout, ok, fake.rich)
+	}
+	fake.mu.Unlock()
+
+	if _, _, rules := fw.Counts(); rules != 5 {
+		t.Errorf("Counts() rules = %d, want 5", rules)
+	}
+
+	// re-enabling must not trip over ALREADY_ENABLED
+	if err := fw.Enable(ctx); err != nil {
+		t.Fatalf("second Enable() error = %v", err)
+	}
+
+	if err := fw.Disable(ctx); err != nil {
+		t.Fatalf("Disable() error = %v", err)
+	}
+	if p, s, r := fake.count(); p+s+r != 0 {
+		t.Errorf("Disable left %d ports, %d services, %d rich rules", p, s, r)
+	}
+}
+
+func TestFirewalld_BansAndPanic(t *testing.T) {
+	fake, conn := startFakeFirewalld(t)
+	fw := New(config.Firewall{AllowedTCPPorts: []int{22}}, WithBackend(NewFirewalld(conn, "internal", nil)))
+	ctx := context.Background()
+	addr := netip.MustParseAddr("198.51.100.7")
+
+	if err := fw.Enable(ctx); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if err := fw.Ban(ctx, addr, time.Minute, "test"); err != nil {
+		t.Fatalf("Ban() error = %v", err)
+	}
+	// banning again resets rather than failing
+	if err := fw.Ban(ctx, addr, time.Hour, "test"); err != nil {
+		t.Fatalf("second Ban() error = %v", err)
+	}
+	if _, _, r := fake.count(); r != 1 {
+		t.Fatalf("rich rules = %d after ban, want 1", r)
+	}
+	for _, key := range fake.richKeys() {
+		if !strings.HasPrefix(key, "internal ") {
+			t.Errorf("ban added to wrong zone: %q", key)
+		}
+	}
+	if err := fw.Unban(ctx, addr); err != nil {
+		t.Fatalf("Unban() error = %v", err)
+	}
+	if _, _, r := fake.count(); r != 0 {
+		t.Errorf("rich rules = %d after unban, want 0", r)
+	}
+
+	if err := fw.SetPanic(ctx, true); err != nil {
+		t.Fatalf("SetPanic(true) error = %v", err)
+	}
+	if !fake.panicking() || !fw.Panic() {
+		t.Error("panic mode not enabled")
+	}
+	if p, _, _ := fake.count(); p != 0 {
+		t.Errorf("ports = %d during panic, want 0", p)
+	}
+
+	if err := fw.SetPanic(ctx, false); err != nil {
+		t.Fatalf("SetPanic(false) error = %v", err)
+	}
+	if fake.panicking() {
+		t.Error("panic mode still enabled")
+	}
+	if !fake.hasPort("internal 22/tcp") {
+		t.Error("ports not restored after panic")
+	}
+}
@@ -0,0 +1,311 @@
+// oreon/defense · watchthelight <wtl>
+
+package firewall
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// nfnetlink constants (linux/netfilter/nfnetlink_log.h).
+// syscall doesn't export these so we define the handful we need.
+const (
+	nfnlSubsysULOG = 4
+
+	nfulnlMsgPacket = 0
+	nfulnlMsgConfig = 1
+
+	nfulaCfgCmd  = 1
+	nfulaCfgMode = 2
+
+	nfulnlCfgCmdBind   = 1
+	nfulnlCfgCmdUnbind = 2
+
+	nfulnlCopyPacket = 2
+
+	nfulaIfindexIndev = 4
+	nfulaPayload      = 9
+
+	nlaTypeMask = 0x3fff // strips NLA_F_NESTED / NLA_F_NET_BYTEORDER
+
+	// copyRange is how much of each packet the kernel hands us.
+	// Headers are all we need for src/dst/port.
+	copyRange = 128
+)
+
+// BlockedPacket describes one dropped packet read back from NFLOG.
+type BlockedPacket struct {
+	Time      time.Time
+	Source    netip.Addr
+	Dest      netip.Addr
+	DestPort  int    // 0 for protocols without ports
+	Protocol  string // "tcp", "udp", "icmp", "ipv6-icmp", or the protocol number
+	Interface string // inbound interface name, empty if unknown
+
+	ifIndex int
+}
+
+// NFLogReader reads packets copied to an NFLOG group by the kernel.
+type NFLogReader struct {
+	fd    int
+	group uint16
+	seq   uint32
+	buf   []byte
+}
+
+// OpenNFLog binds a netlink socket to the given NFLOG group.
+// Requires CAP_NET_ADMIN.
+func OpenNFLog(group int) (*NFLogReader, error) {
+	if group < 0 || group > 0xffff {
+		return nil, fmt.Errorf("invalid nflog group %d", group)
+	}
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, fmt.Errorf("open netlink socket: %w", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("bind netlink socket: %w", err)
+	}
+
+	// Wake up periodically so Watch can notice context cancellation.
+	tv := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("set receive timeout: %w", err)
+	}
+
+	r := &NFLogReader{fd: fd, group: uint16(group), buf: make([]byte, 65536)}
+
+	if err := r.config(attr(nfulaCfgCmd, []byte{nfulnlCfgCmdBind})); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("bind nflog group %d: %w", group, err)
+	}
+
+	mode := make([]byte, 6)
+	binary.BigEndian.PutUint32(mode[0:4], copyRange)
+	mode[4] = nfulnlCopyPacket
+	if err := r.config(attr(nfulaCfgMode, mode)); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("set nflog copy mode: %w", err)
+	}
+
+	return r, nil
+}
+
+// Close unbinds the group and closes the socket.
+func (r *NFLogReader) Close() error {
+	r.config(attr(nfulaCfgCmd, []byte{nfulnlCfgCmdUnbind}))
+	return syscall.Close(r.fd)
+}
+
+// Watch reads packets until ctx is cancelled, calling fn for each one.
+func (r *NFLogReader) Watch(ctx context.Context, fn func(BlockedPacket)) error {
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		n, _, err := syscall.Recvfrom(r.fd, r.buf, 0)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			// ENOBUFS means the kernel dropped messages because we fell
+			// behind. Not fatal, the next read picks up where it can.
+			if errors.Is(err, syscall.ENOBUFS) {
+				continue
+			}
+			return fmt.Errorf("read nflog: %w", err)
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(r.buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, m := range msgs {
+			if m.Header.Type != nfulnlMsgType(nfulnlMsgPacket) {
+				continue
+			}
+			pkt, err := parsePacketMsg(m.Data)
+			if err != nil {
+				continue
+			}
+			if pkt.ifIndex > 0 {
+				if ifi, err := net.InterfaceByIndex(pkt.ifIndex); err == nil {
+					pkt.Interface = ifi.Name
+				}
+			}
+			fn(pkt)
+		}
+	}
+}
+
+// config sends an NFULNL_MSG_CONFIG message for our group and waits for the ack.
+func (r *NFLogReader) config(attrs []byte) error {
+	r.seq++
+	msg := make([]byte, syscall.NLMSG_HDRLEN+4+len(attrs))
+
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(len(msg)))
+	binary.NativeEndian.PutUint16(msg[4:6], nfulnlMsgType(nfulnlMsgConfig))
+	binary.NativeEndian.PutUint16(msg[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(msg[8:12], r.seq)
+
+	// nfgenmsg: family, version, res_id (group, big endian)
+	msg[16] = syscall.AF_UNSPEC
+	msg[17] = 0
+	binary.BigEndian.PutUint16(msg[18:20], r.group)
+	copy(msg[20:], attrs)
+
+	if err := syscall.Sendto(r.fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	for {
+		n, _, err := syscall.Recvfrom(r.fd, r.buf, 0)
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(r.buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Type != syscall.NLMSG_ERROR || m.Header.Seq != r.seq {
+				continue
+			}
+			if len(m.Data) < 4 {
+				return errors.New("short netlink ack")
+			}
+			if errno := int32(binary.NativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
+
+func nfulnlMsgType(msg uint16) uint16 {
+	return nfnlSubsysULOG<<8 | msg
+}
+
+// attr encodes a single netlink attribute, padded to 4 bytes.
+func attr(typ uint16, data []byte) []byte {
+	l := 4 + len(data)
+	b := make([]byte, nlaAlign(l))
+	binary.NativeEndian.PutUint16(b[0:2], uint16(l))
+	binary.NativeEndian.PutUint16(b[2:4], typ)
+	copy(b[4:], data)
+	return b
+}
+
+func nlaAlign(n int) int {
+	return (n + 3) &^ 3
+}
+
+// parsePacketMsg decodes the body of an NFULNL_MSG_PACKET message
+// (nfgenmsg followed by attributes) into a BlockedPacket.
+func parsePacketMsg(data []byte) (BlockedPacket, error) {
+	pkt := BlockedPacket{Time: time.Now()}
+	if len(data) < 4 {
+		return pkt, errors.New("short nflog message")
+	}
+
+	var payload []byte
+	attrs := data[4:]
+	for len(attrs) >= 4 {
+		l := int(binary.NativeEndian.Uint16(attrs[0:2]))
+		typ := binary.NativeEndian.Uint16(attrs[2:4]) & nlaTypeMask
+		if l < 4 || l > len(attrs) {
+			return pkt, errors.New("malformed nflog attribute")
+		}
+		val := attrs[4:l]
+
+		switch typ {
+		case nfulaIfindexIndev:
+			if len(val) >= 4 {
+				pkt.ifIndex = int(binary.BigEndian.Uint32(val))
+			}
+		case nfulaPayload:
+			payload = val
+		}
+
+		next := nlaAlign(l)
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+
+	if payload == nil {
+		return pkt, errors.New("nflog message has no payload")
+	}
+	if err := parseIPHeader(payload, &pkt); err != nil {
+		return pkt, err
+	}
+	return pkt, nil
+}
+
+// parseIPHeader fills in addresses, protocol and port from a raw IPv4/IPv6 packet.
+func parseIPHeader(b []byte, pkt *BlockedPacket) error {
+	if len(b) < 1 {
+		return errors.New("empty payload")
+	}
+
+	var proto byte
+	var transport []byte
+
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < 20 {
+			return errors.New("short IPv4 header")
+		}
+		ihl := int(b[0]&0x0f) * 4
+		if ihl < 20 || len(b) < ihl {
+			return errors.New("bad IPv4 header length")
+		}
+		proto = b[9]
+		pkt.Source = netip.AddrFrom4([4]byte(b[12:16]))
+		pkt.Dest = netip.AddrFrom4([4]byte(b[16:20]))
+		transport = b[ihl:]
+	case 6:
+		if len(b) < 40 {
+			return errors.New("short IPv6 header")
+		}
+		// Extension headers aren't walked; the common case is TCP/UDP directly.
+		proto = b[6]
+		pkt.Source = netip.AddrFrom16([16]byte(b[8:24]))
+		pkt.Dest = netip.AddrFrom16([16]byte(b[24:40]))
+		transport = b[40:]
+	default:
+		return fmt.Errorf("unknown IP version %d", b[0]>>4)
+	}
+
+	pkt.Protocol = protocolName(proto)
+	if (proto == syscall.IPPROTO_TCP || proto == syscall.IPPROTO_UDP) && len(transport) >= 4 {
+		pkt.DestPort = int(binary.BigEndian.Uint16(transport[2:4]))
+	}
+	return nil
+}
+
+func protocolName(p byte) string {
+	switch p {
+	case syscall.IPPROTO_TCP:
+		return "tcp"
+	case syscall.IPPROTO_UDP:
+		return "udp"
+	case syscall.IPPROTO_ICMP:
+		return "icmp"
+	case syscall.IPPROTO_ICMPV6:
+		return "ipv6-icmp"
+	default:
+		return fmt.Sprintf("%d", p)
+	}
+}
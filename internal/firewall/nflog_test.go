@@ -0,0 +1,97 @@
+// oreon/defense · watchthelight <wtl>
+
+package firewall
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+// buildPacketMsg assembles an NFULNL_MSG_PACKET body around a raw IP packet.
+func buildPacketMsg(ifindex uint32, payload []byte) []byte {
+	msg := []byte{2, 0, 0, 100} // nfgenmsg: AF_INET, v0, group 100
+
+	idx := make([]byte, 4)
+	binary.BigEndian.PutUint32(idx, ifindex)
+	msg = append(msg, attr(nfulaIfindexIndev, idx)...)
+	msg = append(msg, attr(nfulaPayload, payload)...)
+	return msg
+}
+
+func ipv4TCP(src, dst [4]byte, dport uint16) []byte {
+	b := make([]byte, 24)
+	b[0] = 0x45 // v4, ihl=5
+	b[9] = 6    // tcp
+	copy(b[12:16], src[:])
+	copy(b[16:20], dst[:])
+	binary.BigEndian.PutUint16(b[20:22], 51000)
+	binary.BigEndian.PutUint16(b[22:24], dport)
+	return b
+}
+
+func TestParsePacketMsg_IPv4(t *testing.T) {
+	data := buildPacketMsg(3, ipv4TCP([4]byte{203, 0, 113, 7}, [4]byte{192, 168, 1, 10}, 22))
+
+	pkt, err := parsePacketMsg(data)
+	if err != nil {
+		t.Fatalf("parsePacketMsg() error = %v", err)
+	}
+	if pkt.Source != netip.MustParseAddr("203.0.113.7") {
+		t.Errorf("Source = %v", pkt.Source)
+	}
+	if pkt.Dest != netip.MustParseAddr("192.168.1.10") {
+		t.Errorf("Dest = %v", pkt.Dest)
+	}
+	if pkt.DestPort != 22 {
+		t.Errorf("DestPort = %d, want 22", pkt.DestPort)
+	}
+	if pkt.Protocol != "tcp" {
+		t.Errorf("Protocol = %q, want tcp", pkt.Protocol)
+	}
+	if pkt.ifIndex != 3 {
+		t.Errorf("ifIndex = %d, want 3", pkt.ifIndex)
+	}
+}
+
+func TestParsePacketMsg_IPv6UDP(t *testing.T) {
+	b := make([]byte, 48)
+	b[0] = 0x60
+	b[6] = 17 // udp
+	src := netip.MustParseAddr("2001:db8::1").As16()
+	dst := netip.MustParseAddr("2001:db8::2").As16()
+	copy(b[8:24], src[:])
+	copy(b[24:40], dst[:])
+	binary.BigEndian.PutUint16(b[42:44], 53)
+
+	pkt, err := parsePacketMsg(buildPacketMsg(0, b))
+	if err != nil {
+		t.Fatalf("parsePacketMsg() error = %v", err)
+	}
+	if pkt.Source != netip.MustParseAddr("2001:db8::1") {
+		t.Errorf("Source = %v", pkt.Source)
+	}
+	if pkt.Protocol != "udp" || pkt.DestPort != 53 {
+		t.Errorf("got %s/%d, want udp/53", pkt.Protocol, pkt.DestPort)
+	}
+}
+
+func TestParsePacketMsg_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short", []byte{2, 0}},
+		{"no payload", []byte{2, 0, 0, 100}},
+		{"truncated ipv4", buildPacketMsg(1, []byte{0x45, 0, 0})},
+		{"bad version", buildPacketMsg(1, make([]byte, 40))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parsePacketMsg(tt.data); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+// oreon/defense · watchthelight <wtl>
+
+package firewall
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Runner executes nft. The default shells out to the nft binary;
+// tests swap in a fake so nothing touches the host's rules.
+type Runner interface {
+	Run(ctx context.Context, script string) error
+}
+
+// execRunner feeds scripts to `nft -f -`.
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, script string) error {
+	cmd := exec.CommandContext(ctx, "nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("nft: %s", msg)
+		}
+		return fmt.Errorf("nft: %w", err)
+	}
+	return nil
+}
+
+// NFTables is the default backend. It owns a dedicated table and never
+// touches rules installed by anything else.
+// Not thread-safe on its own; Firewall serializes calls.
+type NFTables struct {
+	runner  Runner
+	ruleset *Ruleset // nil when our table isn't installed
+}
+
+// NewNFTables creates an nftables backend. A nil runner shells out to nft.
+func NewNFTables(runner Runner) *NFTables {
+	if runner == nil {
+		runner = execRunner{}
+	}
+	return &NFTables{runner: runner}
+}
+
+// Name returns "nftables".
+func (n *NFTables) Name() string {
+	return BackendNFTables
+}
+
+// Apply replaces our table with a ruleset built from opts.
+func (n *NFTables) Apply(ctx context.Context, opts RuleOptions) error {
+	return n.install(ctx, BuildRuleset(opts))
+}
+
+func (n *NFTables) install(ctx context.Context, rs *Ruleset) error {
+	if err := n.runner.Run(ctx, rs.Render()); err != nil {
+		return fmt.Errorf("apply ruleset: %w", err)
+	}
+	n.ruleset = rs
+	return nil
+}
+
+// Remove deletes our table.
+func (n *NFTables) Remove(ctx context.Context) error {
+	// add-then-delete so this succeeds even if the table is already gone
+	script := fmt.Sprintf("add table inet %s\ndelete table inet %s\n", TableName, TableName)
+	if err := n.runner.Run(ctx, script); err != nil {
+		return fmt.Errorf("remove ruleset: %w", err)
+	}
+	n.ruleset = nil
+	return nil
+}
+
+// AddBan puts an address in the matching ban set.
+func (n *NFTables) AddBan(ctx context.Context, b Ban) error {
+	elem, ok := banEntry(b, time.Now())
+	if !ok {
+		return fmt.Errorf("ban for %s already expired", b.Addr)
+	}
+	// add+delete+add resets the timeout if the element already exists
+	script := fmt.Sprintf("add element inet %[1]s %[2]s { %[3]s }\ndelete element inet %[1]s %[2]s { %[3]s }\nadd element inet %[1]s %[2]s { %[4]s }\n",
+		TableName, banSetFor(b.Addr), b.Addr, elem)
+	return n.runner.Run(ctx, script)
+}
+
+// RemoveBan takes an address out of its ban set.
+func (n *NFTables) RemoveBan(ctx context.Context, addr netip.Addr) error {
+	// the kernel may already have expired it, so add before deleting
+	script := fmt.Sprintf("add element inet %[1]s %[2]s { %[3]s }\ndelete element inet %[1]s %[2]s { %[3]s }\n",
+		TableName, banSetFor(addr), addr)
+	return n.runner.Run(ctx, script)
+}
+
+// ReplaceBlocklist swaps a blocklist's live set contents in one transaction.
+func (n *NFTables) ReplaceBlocklist(ctx context.Context, bl BlocklistSet) error {
+	script := renderSetReplace(blocklistSetName(bl.Name, "v4"), prefixStrings(bl.V4)) +
+		renderSetReplace(blocklistSetName(bl.Name, "v6"), prefixStrings(bl.V6))
+	return n.runner.Run(ctx, script)
+}
+
+// SetPanic swaps our table for PanicRuleset. Turning panic off removes the
+// table; Firewall re-applies the normal ruleset afterwards if it's enabled.
+func (n *NFTables) SetPanic(ctx context.Context, on bool) error {
+	if on {
+		return n.install(ctx, PanicRuleset())
+	}
+	return n.Remove(ctx)
+}
+
+// Counts returns table, chain and rule counts for the installed ruleset.
+func (n *NFTables) Counts() (tables, chains, rules int) {
+	if n.ruleset == nil {
+		return 0, 0, 0
+	}
+	return 1, n.ruleset.ChainCount(), n.ruleset.RuleCount()
+}
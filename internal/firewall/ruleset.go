@@ -0,0 +1,289 @@
+// oreon/defense · watchthelight <wtl>
+
+package firewall
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TableName is the nftables table owned by the daemon. Everything we
+// install lives inside it so we never touch rules managed by other tools.
+const TableName = "oreon_defense"
+
+// logPrefix tags packets we log so they're recognizable in nflog output.
+const logPrefix = "oreon-drop"
+
+// Chain is a single base chain in the ruleset.
+type Chain struct {
+	Name     string
+	Hook     string // "input", "output", "forward"
+	Priority string // e.g. "filter"
+	Policy   string // "accept" or "drop"
+	Rules    []string
+}
+
+// Set is a named nftables set.
+type Set struct {
+	Name     string
+	Type     string   // "ipv4_addr", "ipv6_addr"
+	Flags    []string // e.g. "interval"
+	Elements []string
+}
+
+// Ruleset is the full table the daemon installs.
+// Built with BuildRuleset, rendered with Render.
+type Ruleset struct {
+	Sets   []Set
+	Chains []Chain
+}
+
+// RuleOptions is the desired firewall state handed to a Backend.
+type RuleOptions struct {
+	AllowedTCPPorts []int
+	AllowedUDPPorts []int
+	Services        []string // firewalld service names, ignored by nftables
+	LogBlocked      bool
+	LogGroup        int
+	Blocklists      []BlocklistSet
+	Bans            []Ban
+	AppRules        []AppRule
+	AppDefaultDeny  bool // drop new outbound connections from apps without a rule
+}
+
+// AppRule allows or denies outbound traffic from one cgroup.
+type AppRule struct {
+	Cgroup string // path below the cgroup2 root, e.g. "system.slice/nginx.service"
+	Allow  bool
+}
+
+// cgroupLevel is the depth nft needs for `socket cgroupv2 level N`.
+func (r AppRule) cgroupLevel() int {
+	return strings.Count(strings.Trim(r.Cgroup, "/"), "/") + 1
+}
+
+// BlocklistSet is the contents of one blocklist, split by address family.
+type BlocklistSet struct {
+	Name string
+	V4   []netip.Prefix
+	V6   []netip.Prefix
+}
+
+// BuildRuleset generates the default inbound ruleset: drop everything that
+// isn't loopback, ICMP, part of an existing connection, or an allowed port.
+// When LogBlocked is set the drop is preceded by a rate-limited NFLOG rule.
+// Each blocklist gets a v4 and v6 interval set, matched in both directions.
+// Banned addresses live in timeout sets checked ahead of everything else.
+// App rules go in the output chain after the blocklist drops.
+func BuildRuleset(opts RuleOptions) *Ruleset {
+	input := Chain{
+		Name:     "input",
+		Hook:     "input",
+		Priority: "filter",
+		Policy:   "drop",
+		Rules: []string{
+			"ct state established,related accept",
+			"ct state invalid drop",
+			`iif "lo" accept`,
+			"meta l4proto { icmp, ipv6-icmp } accept",
+		},
+	}
+
+	bansV4, bansV6 := banElements(opts.Bans, time.Now())
+	sets := []Set{
+		{Name: banSetV4, Type: "ipv4_addr", Flags: []string{"timeout"}, Elements: bansV4},
+		{Name: banSetV6, Type: "ipv6_addr", Flags: []string{"timeout"}, Elements: bansV6},
+	}
+	blockIn := []string{
+		"ip saddr @" + banSetV4 + " counter drop",
+		"ip6 saddr @" + banSetV6 + " counter drop",
+	}
+	var blockOut []string
+	for _, bl := range opts.Blocklists {
+		v4, v6 := blocklistSetName(bl.Name, "v4"), blocklistSetName(bl.Name, "v6")
+		sets = append(sets,
+			intervalSet(v4, "ipv4_addr", bl.V4),
+			intervalSet(v6, "ipv6_addr", bl.V6),
+		)
+		blockIn = append(blockIn, "ip saddr @"+v4+" counter drop", "ip6 saddr @"+v6+" counter drop")
+		blockOut = append(blockOut, "ip daddr @"+v4+" counter drop", "ip6 daddr @"+v6+" counter drop")
+	}
+	// banned and blocklisted peers are dropped before anything else, including
+	// connections that were established before the ban or list update
+	input.Rules = append(blockIn, input.Rules...)
+
+	if len(opts.AllowedTCPPorts) > 0 {
+		input.Rules = append(input.Rules, "tcp dport "+portSet(opts.AllowedTCPPorts)+" accept")
+	}
+	if len(opts.AllowedUDPPorts) > 0 {
+		input.Rules = append(input.Rules, "udp dport "+portSet(opts.AllowedUDPPorts)+" accept")
+	}
+
+	if opts.LogBlocked {
+		input.Rules = append(input.Rules, logRule(opts.LogGroup))
+	}
+	input.Rules = append(input.Rules, "counter drop")
+
+	if len(opts.AppRules) > 0 || opts.AppDefaultDeny {
+		blockOut = append(blockOut, appRules(opts.AppRules, opts.AppDefaultDeny)...)
+	}
+
+	rs := &Ruleset{Sets: sets, Chains: []Chain{input}}
+	if len(blockOut) > 0 {
+		rs.Chains = append(rs.Chains, Chain{
+			Name:     "output",
+			Hook:     "output",
+			Priority: "filter",
+			Policy:   "accept",
+			Rules:    blockOut,
+		})
+	}
+	return rs
+}
+
+// appRules renders per-application outbound rules. Only new connections
+// are judged so a rule change doesn't cut off traffic mid-stream.
+// The cgroups must exist when the ruleset is loaded - nft resolves the
+// path to a cgroup id and fails the whole transaction otherwise.
+func appRules(rules []AppRule, defaultDeny bool) []string {
+	out := []string{
+		`oif "lo" accept`,
+		"ct state established,related accept",
+	}
+	for _, r := range rules {
+		verdict := "counter drop"
+		if r.Allow {
+			verdict = "accept"
+		}
+		out = append(out, fmt.Sprintf(`socket cgroupv2 level %d "%s" %s`, r.cgroupLevel(), strings.Trim(r.Cgroup, "/"), verdict))
+	}
+	if defaultDeny {
+		out = append(out, "counter drop")
+	}
+	return out
+}
+
+// PanicRuleset drops everything in both directions except loopback.
+// Used by the nftables backend for panic mode.
+func PanicRuleset() *Ruleset {
+	return &Ruleset{Chains: []Chain{
+		{Name: "input", Hook: "input", Priority: "filter", Policy: "drop", Rules: []string{`iif "lo" accept`}},
+		{Name: "forward", Hook: "forward", Priority: "filter", Policy: "drop"},
+		{Name: "output", Hook: "output", Priority: "filter", Policy: "drop", Rules: []string{`oif "lo" accept`}},
+	}}
+}
+
+// intervalSet builds an address set that accepts CIDR ranges.
+// auto-merge lets overlapping entries from the list coexist.
+func intervalSet(name, typ string, prefixes []netip.Prefix) Set {
+	return Set{
+		Name:     name,
+		Type:     typ,
+		Flags:    []string{"interval"},
+		Elements: prefixStrings(prefixes),
+	}
+}
+
+func prefixStrings(prefixes []netip.Prefix) []string {
+	out := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		out[i] = p.String()
+	}
+	return out
+}
+
+// logRule returns an nft rule that copies matching packets to an NFLOG group.
+// The kernel-side limit keeps a flood from overwhelming the reader.
+func logRule(group int) string {
+	return fmt.Sprintf(`limit rate 50/second burst 100 packets log prefix "%s" group %d`, logPrefix, group)
+}
+
+// portSet renders ports as an nft anonymous set, e.g. "{ 22, 443 }".
+func portSet(ports []int) string {
+	sorted := append([]int(nil), ports...)
+	sort.Ints(sorted)
+	parts := make([]string, len(sorted))
+	for i, p := range sorted {
+		parts[i] = strconv.Itoa(p)
+	}
+	return "{ " + strings.Join(parts, ", ") + " }"
+}
+
+// ChainCount returns the number of chains in the ruleset.
+func (r *Ruleset) ChainCount() int {
+	return len(r.Chains)
+}
+
+// RuleCount returns the total number of rules across all chains.
+func (r *Ruleset) RuleCount() int {
+	n := 0
+	for _, c := range r.Chains {
+		n += len(c.Rules)
+	}
+	return n
+}
+
+// Render produces an nft script that atomically replaces our table.
+// The leading add+delete makes the script work whether or not the table
+// already exists, and nft applies the whole file as one transaction.
+func (r *Ruleset) Render() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "add table inet %s\n", TableName)
+	fmt.Fprintf(&b, "delete table inet %s\n", TableName)
+	fmt.Fprintf(&b, "table inet %s {\n", TableName)
+
+	for _, set := range r.Sets {
+		fmt.Fprintf(&b, "\tset %s {\n", set.Name)
+		fmt.Fprintf(&b, "\t\ttype %s\n", set.Type)
+		if len(set.Flags) > 0 {
+			fmt.Fprintf(&b, "\t\tflags %s\n", strings.Join(set.Flags, ", "))
+			if containsString(set.Flags, "interval") {
+				b.WriteString("\t\tauto-merge\n")
+			}
+		}
+		if len(set.Elements) > 0 {
+			fmt.Fprintf(&b, "\t\telements = { %s }\n", strings.Join(set.Elements, ", "))
+		}
+		b.WriteString("\t}\n")
+	}
+
+	for _, c := range r.Chains {
+		fmt.Fprintf(&b, "\tchain %s {\n", c.Name)
+		fmt.Fprintf(&b, "\t\ttype filter hook %s priority %s; policy %s;\n", c.Hook, c.Priority, c.Policy)
+		for _, rule := range c.Rules {
+			fmt.Fprintf(&b, "\t\t%s\n", rule)
+		}
+		b.WriteString("\t}\n")
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// renderSetReplace produces an nft script that swaps a live set's contents.
+// Elements are added in chunks to keep individual commands a sane size.
+func renderSetReplace(set string, elements []string) string {
+	const chunk = 1000
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "flush set inet %s %s\n", TableName, set)
+	for start := 0; start < len(elements); start += chunk {
+		end := min(start+chunk, len(elements))
+		fmt.Fprintf(&b, "add element inet %s %s { %s }\n", TableName, set, strings.Join(elements[start:end], ", "))
+	}
+	return b.String()
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+// oreon/defense · watchthelight <wtl>
+
+package ids
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// maxTrackedAddrs caps memory use under a distributed attack.
+const maxTrackedAddrs = 10000
+
+// Detector counts failures per source within a sliding window.
+// Thread-safe.
+type Detector struct {
+	maxFailures int
+	window      time.Duration
+
+	mu       sync.Mutex
+	failures map[netip.Addr][]time.Time
+}
+
+// NewDetector creates a detector that trips after maxFailures
+// failures from one address within window.
+func NewDetector(maxFailures int, window time.Duration) *Detector {
+	return &Detector{
+		maxFailures: maxFailures,
+		window:      window,
+		failures:    make(map[netip.Addr][]time.Time),
+	}
+}
+
+// Failure records a failure at the given time. When the address reaches
+// the threshold its history is cleared and the failure count is returned
+// with tripped=true.
+func (d *Detector) Failure(addr netip.Addr, at time.Time) (count int, tripped bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cutoff := at.Add(-d.window)
+	times := d.failures[addr]
+	kept := times[:0]
+	for _, t := range times {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	kept = append(kept, at)
+
+	if len(kept) >= d.maxFailures {
+		delete(d.failures, addr)
+		return len(kept), true
+	}
+
+	if _, ok := d.failures[addr]; !ok && len(d.failures) >= maxTrackedAddrs {
+		d.pruneLocked(cutoff)
+	}
+	d.failures[addr] = kept
+	return len(kept), false
+}
+
+// Forget drops the failure history for addr.
+func (d *Detector) Forget(addr netip.Addr) {
+	d.mu.Lock()
+	delete(d.failures, addr)
+	d.mu.Unlock()
+}
+
+// Prune drops histories with no failures after cutoff.
+func (d *Detector) Prune(cutoff time.Time) {
+	d.mu.Lock()
+	d.pruneLocked(cutoff)
+	d.mu.Unlock()
+}
+
+func (d *Detector) pruneLocked(cutoff time.Time) {
+	for addr, times := range d.failures {
+		if len(times) == 0 || !times[len(times)-1].After(cutoff) {
+			delete(d.failures, addr)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// oreon/defense · watchthelight <wtl>
+
+package ids
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestDetector_Threshold(t *testing.T) {
+	d := NewDetector(3, time.Minute)
+	addr := netip.MustParseAddr("192.0.2.1")
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if _, tripped := d.Failure(addr, now); tripped {
+			t.Fatalf("tripped after %d failures", i+1)
+		}
+	}
+	count, tripped := d.Failure(addr, now)
+	if !tripped || count != 3 {
+		t.Fatalf("Failure() = %d, %v; want 3, true", count, tripped)
+	}
+
+	// history is reset once tripped
+	if count, _ := d.Failure(addr, now); count != 1 {
+		t.Errorf("count after trip = %d, want 1", count)
+	}
+}
+
+func TestDetector_Window(t *testing.T) {
+	d := NewDetector(3, time.Minute)
+	addr := netip.MustParseAddr("192.0.2.1")
+	start := time.Now()
+
+	d.Failure(addr, start)
+	d.Failure(addr, start.Add(10*time.Second))
+	// first failure has aged out of the window
+	if _, tripped := d.Failure(addr, start.Add(65*time.Second)); tripped {
+		t.Error("tripped with failures spread beyond the window")
+	}
+}
+
+func TestDetector_Prune(t *testing.T) {
+	d := NewDetector(3, time.Minute)
+	now := time.Now()
+	d.Failure(netip.MustParseAddr("192.0.2.1"), now.Add(-time.Hour))
+	d.Failure(netip.MustParseAddr("192.0.2.2"), now)
+
+	d.Prune(now.Add(-time.Minute))
+	if len(d.failures) != 1 {
+		t.Errorf("len(failures) = %d after prune, want 1", len(d.failures))
+	}
+}
@@ -0,0 +1,202 @@
+// oreon/defense · watchthelight <wtl>
+
+// Package ids detects intrusion attempts in auth logs and bans offenders
+// through the firewall.
+package ids
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/oreonproject/defense/internal/firewall"
+	"github.com/oreonproject/defense/pkg/config"
+	"github.com/oreonproject/defense/pkg/events"
+)
+
+const (
+	defaultMaxFailures = 5
+	defaultFindTime    = 10 * time.Minute
+	defaultBanTime     = time.Hour
+
+	// sweepInterval is how often expired bans are collected.
+	sweepInterval = 10 * time.Second
+)
+
+// Reasons recorded on bans and unbans.
+const (
+	ReasonSSHBruteForce = "ssh_bruteforce"
+	ReasonExpired       = "expired"
+	ReasonLifted        = "lifted"
+)
+
+// Banner is the part of the firewall the IDS drives.
+type Banner interface {
+	Ban(ctx context.Context, addr netip.Addr, d time.Duration, reason string) error
+	Unban(ctx context.Context, addr netip.Addr) error
+	Bans() []firewall.Ban
+	ExpireBans(now time.Time) []firewall.Ban
+}
+
+// Manager watches auth logs and bans sources that fail too often.
+type Manager struct {
+	banner   Banner
+	events   *events.Emitter
+	logger   *slog.Logger
+	detector *Detector
+	banTime  time.Duration
+	findTime time.Duration
+	ignore   []netip.Prefix
+}
+
+// New creates an IDS manager from config. Invalid durations and ignore
+// entries fall back to defaults with a warning rather than failing startup.
+func New(cfg config.IDS, banner Banner, emitter *events.Emitter, logger *slog.Logger) *Manager {
+	maxFailures := cfg.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = defaultMaxFailures
+	}
+	findTime := parseDuration(logger, "find_time", cfg.FindTime, defaultFindTime)
+	banTime := parseDuration(logger, "ban_time", cfg.BanTime, defaultBanTime)
+
+	m := &Manager{
+		banner:   banner,
+		events:   emitter,
+		logger:   logger,
+		detector: NewDetector(maxFailures, findTime),
+		banTime:  banTime,
+		findTime: findTime,
+	}
+
+	for _, s := range cfg.IgnoreIPs {
+		p, err := parsePrefix(s)
+		if err != nil {
+			logger.Warn("ignoring invalid ids.ignore_ips entry", "value", s, "error", err)
+			continue
+		}
+		m.ignore = append(m.ignore, p)
+	}
+	return m
+}
+
+func parseDuration(logger *slog.Logger, name, value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		logger.Warn("invalid ids duration, using default", "setting", name, "value", value, "default", def)
+		return def
+	}
+	return d
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Run reads lines from src and handles failures until ctx is cancelled.
+func (m *Manager) Run(ctx context.Context, src Source) error {
+	lines := make(chan string, 64)
+	srcErr := make(chan error, 1)
+	go func() {
+		srcErr <- src.Lines(ctx, lines)
+	}()
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-srcErr:
+			if err != nil {
+				return fmt.Errorf("auth log source: %w", err)
+			}
+			return nil
+		case line := <-lines:
+			m.HandleLine(ctx, line)
+		case now := <-ticker.C:
+			m.sweep(now)
+		}
+	}
+}
+
+// HandleLine processes one auth log line, banning the source if it
+// crossed the failure threshold.
+func (m *Manager) HandleLine(ctx context.Context, line string) {
+	addr, ok := ParseSSHFailure(line)
+	if !ok || m.ignored(addr) || m.banned(addr) {
+		return
+	}
+
+	count, tripped := m.detector.Failure(addr, time.Now())
+	if !tripped {
+		return
+	}
+
+	evt := events.StartBan(addr.String(), ReasonSSHBruteForce).
+		Failures(count).
+		BanDuration(m.banTime)
+	if err := m.banner.Ban(ctx, addr, m.banTime, ReasonSSHBruteForce); err != nil {
+		evt.SetError(err)
+	} else {
+		m.logger.Warn("banned ssh brute-force source", "addr", addr, "failures", count, "duration", m.banTime)
+	}
+	m.events.Emit(evt.End())
+}
+
+// Lift removes a ban before it expires.
+func (m *Manager) Lift(ctx context.Context, addr netip.Addr) error {
+	evt := events.StartUnban(addr.String(), ReasonLifted)
+	err := m.banner.Unban(ctx, addr)
+	if err == nil {
+		m.detector.Forget(addr)
+	}
+	evt.SetError(err)
+	m.events.Emit(evt.End())
+	return err
+}
+
+// Bans returns the currently active bans.
+func (m *Manager) Bans() []firewall.Ban {
+	return m.banner.Bans()
+}
+
+// sweep reports bans that have run out and forgets stale failure history.
+func (m *Manager) sweep(now time.Time) {
+	for _, b := range m.banner.ExpireBans(now) {
+		m.events.Emit(events.StartUnban(b.Addr.String(), ReasonExpired).End())
+	}
+	m.detector.Prune(now.Add(-m.findTime))
+}
+
+func (m *Manager) ignored(addr netip.Addr) bool {
+	for _, p := range m.ignore {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) banned(addr netip.Addr) bool {
+	for _, b := range m.banner.Bans() {
+		if b.Addr == addr {
+			return true
+		}
+	}
+	return false
+}
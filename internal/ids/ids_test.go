@@ -0,0 +1,184 @@
+// oreon/defense · watchthelight <wtl>
+
+package ids
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oreonproject/defense/internal/firewall"
+	"github.com/oreonproject/defense/pkg/config"
+	"github.com/oreonproject/defense/pkg/events"
+)
+
+// fakeBanner records bans in memory.
+type fakeBanner struct {
+	mu   sync.Mutex
+	bans map[netip.Addr]firewall.Ban
+}
+
+func newFakeBanner() *fakeBanner {
+	return &fakeBanner{bans: make(map[netip.Addr]firewall.Ban)}
+}
+
+func (f *fakeBanner) Ban(_ context.Context, addr netip.Addr, d time.Duration, reason string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	f.bans[addr] = firewall.Ban{Addr: addr, Reason: reason, Since: now, Until: now.Add(d)}
+	return nil
+}
+
+func (f *fakeBanner) Unban(_ context.Context, addr netip.Addr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.bans[addr]; !ok {
+		return firewall.ErrNotBanned
+	}
+	delete(f.bans, addr)
+	return nil
+}
+
+func (f *fakeBanner) Bans() []firewall.Ban {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []firewall.Ban
+	for _, b := range f.bans {
+		out = append(out, b)
+	}
+	return out
+}
+
+func (f *fakeBanner) ExpireBans(now time.Time) []firewall.Ban {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []firewall.Ban
+	for addr, b := range f.bans {
+		if !b.Until.After(now) {
+			out = append(out, b)
+			delete(f.bans, addr)
+		}
+	}
+	return out
+}
+
+// linesSource replays fixed lines then blocks until cancelled.
+type linesSource []string
+
+func (s linesSource) Lines(ctx context.Context, out chan<- string) error {
+	for _, l := range s {
+		out <- l
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func testManager(banner Banner) *Manager {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return New(config.IDS{
+		MaxFailures: 3,
+		FindTime:    "1m",
+		BanTime:     "1h",
+		IgnoreIPs:   []string{"10.0.0.0/8", "bogus"},
+	}, banner, events.NewEmitter(events.WithLogger(logger)), logger)
+}
+
+func TestManager_BansAfterThreshold(t *testing.T) {
+	banner := newFakeBanner()
+	m := testManager(banner)
+	ctx := context.Background()
+
+	line := "Failed password for root from 203.0.113.9 port 4000 ssh2"
+	m.HandleLine(ctx, line)
+	m.HandleLine(ctx, line)
+	if len(banner.Bans()) != 0 {
+		t.Fatal("banned before threshold")
+	}
+	m.HandleLine(ctx, line)
+
+	bans := banner.Bans()
+	if len(bans) != 1 {
+		t.Fatalf("len(bans) = %d, want 1", len(bans))
+	}
+	if bans[0].Reason != ReasonSSHBruteForce {
+		t.Errorf("Reason = %q", bans[0].Reason)
+	}
+	if d := bans[0].Until.Sub(bans[0].Since); d != time.Hour {
+		t.Errorf("ban duration = %v, want 1h", d)
+	}
+}
+
+func TestManager_IgnoredAddresses(t *testing.T) {
+	banner := newFakeBanner()
+	m := testManager(banner)
+
+	for i := 0; i < 10; i++ {
+		m.HandleLine(context.Background(), "Failed password for root from 10.1.2.3 port 4000 ssh2")
+	}
+	if len(banner.Bans()) != 0 {
+		t.Error("banned an ignored address")
+	}
+}
+
+func TestManager_Lift(t *testing.T) {
+	banner := newFakeBanner()
+	m := testManager(banner)
+	addr := netip.MustParseAddr("203.0.113.9")
+	banner.Ban(context.Background(), addr, time.Hour, ReasonSSHBruteForce)
+
+	if err := m.Lift(context.Background(), addr); err != nil {
+		t.Fatalf("Lift() error = %v", err)
+	}
+	if len(m.Bans()) != 0 {
+		t.Error("ban still present after Lift")
+	}
+	if err := m.Lift(context.Background(), addr); !errors.Is(err, firewall.ErrNotBanned) {
+		t.Errorf("second Lift() error = %v, want ErrNotBanned", err)
+	}
+}
+
+func TestManager_SweepExpires(t *testing.T) {
+	banner := newFakeBanner()
+	m := testManager(banner)
+	banner.Ban(context.Background(), netip.MustParseAddr("203.0.113.9"), time.Minute, ReasonSSHBruteForce)
+
+	m.sweep(time.Now().Add(2 * time.Minute))
+	if len(banner.Bans()) != 0 {
+		t.Error("expired ban not swept")
+	}
+}
+
+func TestManager_Run(t *testing.T) {
+	banner := newFakeBanner()
+	m := testManager(banner)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Run(ctx, linesSource{
+			"Invalid user a from 198.51.100.1 port 1",
+			"Invalid user b from 198.51.100.1 port 2",
+			"Invalid user c from 198.51.100.1 port 3",
+		})
+	}()
+
+	deadline := time.After(time.Second)
+	for len(banner.Bans()) == 0 {
+		select {
+		case <-deadline:
+			t.Fatal("timeout waiting for ban")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
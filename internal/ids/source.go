@@ -0,0 +1,201 @@
+// oreon/defense · watchthelight <wtl>
+
+package ids
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Source streams auth log lines until ctx is cancelled.
+type Source interface {
+	Lines(ctx context.Context, out chan<- string) error
+}
+
+// journalMatches select sshd's messages by fields journald fills in
+// itself, not SYSLOG_IDENTIFIER, which any local user can set with
+// `logger -t sshd`. Different fields are ANDed, so this is root processes
+// named sshd or sshd-session (what OpenSSH 9.8+ logs pre-auth failures
+// as); a user can name their own binary sshd but can't log as uid 0.
+var journalMatches = []string{"_UID=0", "_COMM=sshd", "_COMM=sshd-session"}
+
+// JournalSource follows sshd messages in the systemd journal.
+type JournalSource struct{}
+
+// Lines runs journalctl in follow mode and forwards each message.
+func (JournalSource) Lines(ctx context.Context, out chan<- string) error {
+	args := append([]string{"--follow", "--lines=0", "--output=cat"}, journalMatches...)
+	cmd := exec.CommandContext(ctx, "journalctl", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start journalctl: %w", err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		select {
+		case out <- scanner.Text():
+		case <-ctx.Done():
+		}
+	}
+
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	return fmt.Errorf("journalctl exited: %w", err)
+}
+
+// FileSource tails a syslog-style file such as /var/log/secure,
+// following it across logrotate.
+type FileSource struct {
+	Path         string
+	PollInterval time.Duration // defaults to 1s
+}
+
+// Lines starts at the end of the file and forwards lines as they're appended.
+func (s FileSource) Lines(ctx context.Context, out chan<- string) error {
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+
+	// only new lines matter; old failures were handled by whoever was running then
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(f)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var partial string
+	for {
+		line, err := reader.ReadString('\n')
+		offset += int64(len(line))
+		if err == nil {
+			select {
+			case out <- partial + line[:len(line)-1]:
+			case <-ctx.Done():
+				return nil
+			}
+			partial = ""
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		partial += line
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// reopen if the file was rotated (new inode) or truncated
+		rotated, err := fileRotated(f, s.Path, offset)
+		if err != nil || !rotated {
+			continue
+		}
+		nf, err := os.Open(s.Path)
+		if err != nil {
+			continue
+		}
+		f.Close()
+		f = nf
+		reader.Reset(f)
+		offset = 0
+		partial = ""
+	}
+}
+
+// SyslogSource passes on only what sshd logged to a syslog-format source,
+// without the "Jan 12 10:00:01 host sshd[123]: " header, so other
+// programs' lines that merely mention sshd are ignored. Syslog files
+// can't say who really wrote a line, so the journal is preferred.
+type SyslogSource struct {
+	Source
+}
+
+// Lines filters the underlying source's lines.
+func (s SyslogSource) Lines(ctx context.Context, out chan<- string) error {
+	raw := make(chan string, 64)
+	srcErr := make(chan error, 1)
+	go func() {
+		srcErr <- s.Source.Lines(ctx, raw)
+	}()
+	for {
+		select {
+		case err := <-srcErr:
+			return err
+		case line := <-raw:
+			msg, ok := sshdMessage(line)
+			if !ok {
+				continue
+			}
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// fileRotated reports whether path now refers to a different file than f,
+// or the file shrank below what we've already read.
+func fileRotated(f *os.File, path string, offset int64) (bool, error) {
+	cur, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	next, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if !os.SameFile(cur, next) {
+		return true, nil
+	}
+	if next.Size() < offset {
+		return true, nil
+	}
+	return false, nil
+}
+
+// DetectSource picks the journal when journald is running,
+// otherwise falls back to tailing the given file.
+func DetectSource(source, logPath string) (Source, error) {
+	switch source {
+	case "journal":
+		return JournalSource{}, nil
+	case "file":
+		return SyslogSource{FileSource{Path: logPath}}, nil
+	case "", "auto":
+		if _, err := exec.LookPath("journalctl"); err == nil && journaldRunning() {
+			return JournalSource{}, nil
+		}
+		return SyslogSource{FileSource{Path: logPath}}, nil
+	default:
+		return nil, fmt.Errorf("unknown auth log source %q", source)
+	}
+}
+
+func journaldRunning() bool {
+	_, err := os.Stat("/run/systemd/journal/socket")
+	return err == nil
+}
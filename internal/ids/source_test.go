@@ -0,0 +1,74 @@
+// oreon/defense · watchthelight <wtl>
+
+package ids
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSource_FollowsAppendsAndRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secure")
+	if err := os.WriteFile(path, []byte("old line before start\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lines := make(chan string, 10)
+	go FileSource{Path: path, PollInterval: 10 * time.Millisecond}.Lines(ctx, lines)
+	time.Sleep(30 * time.Millisecond)
+
+	appendLine := func(p, s string) {
+		f, err := os.OpenFile(p, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(s)
+		f.Close()
+	}
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-lines:
+			if got != want {
+				t.Errorf("line = %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+
+	appendLine(path, "first\n")
+	expect("first")
+
+	// partial writes are joined once the newline arrives
+	appendLine(path, "sec")
+	time.Sleep(30 * time.Millisecond)
+	appendLine(path, "ond\n")
+	expect("second")
+
+	// logrotate: move the old file away and start a new one
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLine(path, "after rotate\n")
+	expect("after rotate")
+}
+
+func TestDetectSource(t *testing.T) {
+	if _, err := DetectSource("file", "/var/log/secure"); err != nil {
+		t.Errorf("DetectSource(file) error = %v", err)
+	}
+	if src, _ := DetectSource("journal", ""); src == nil {
+		t.Error("DetectSource(journal) returned nil")
+	}
+	if _, err := DetectSource("carrier-pigeon", ""); err == nil {
+		t.Error("DetectSource accepted unknown source")
+	}
+}
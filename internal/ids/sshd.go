@@ -0,0 +1,52 @@
+// oreon/defense · watchthelight <wtl>
+
+package ids
+
+import (
+	"net/netip"
+	"regexp"
+)
+
+// sshdFailurePatterns match sshd log messages that indicate a failed
+// login attempt. Each captures the remote address in group 1. They're
+// anchored at the start of the message, and the greedy user part makes
+// the address the last "from X port N", since the user name is the
+// client's to choose.
+var sshdFailurePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^Failed (?:password|publickey|keyboard-interactive/pam|none) for (?:invalid user )?.* from (\S+) port \d+`),
+	regexp.MustCompile(`^Invalid user .* from (\S+)(?: port \d+)?`),
+	regexp.MustCompile(`^(?:error: )?maximum authentication attempts exceeded for (?:invalid user )?.* from (\S+) port \d+`),
+}
+
+// syslogSSHD matches a syslog line from sshd (or OpenSSH 9.8+'s
+// sshd-session) with a classic or RFC 3339 timestamp, capturing the
+// message.
+var syslogSSHD = regexp.MustCompile(`^(?:[A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d|\d{4}-\d\d-\d\dT\S+) \S+ sshd(?:-session)?\[\d+\]: (.*)$`)
+
+// sshdMessage strips the syslog header off a line sshd logged. Lines
+// without the sshd[pid] tag don't match.
+func sshdMessage(line string) (string, bool) {
+	m := syslogSSHD.FindStringSubmatch(line)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// ParseSSHFailure extracts the remote address from an sshd failure
+// message, as the journal has it (SyslogSource strips the header off
+// /var/log/secure lines).
+func ParseSSHFailure(line string) (netip.Addr, bool) {
+	for _, re := range sshdFailurePatterns {
+		m := re.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		addr, err := netip.ParseAddr(m[1])
+		if err != nil {
+			continue
+		}
+		return addr.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
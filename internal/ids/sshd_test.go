@@ -0,0 +1,97 @@
+// oreon/defense · watchthelight <wtl>
+
+package ids
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSSHFailure(t *testing.T) {
+	tests := []struct {
+		line string
+		want string // empty = no match
+	}{
+		{"Failed password for root from 203.0.113.4 port 50022 ssh2", "203.0.113.4"},
+		{"Failed password for invalid user admin from 198.51.100.7 port 4242 ssh2", "198.51.100.7"},
+		{"Failed publickey for git from 2001:db8::5 port 22 ssh2: RSA SHA256:abc", "2001:db8::5"},
+		{"Invalid user oracle from 192.0.2.9 port 3333", "192.0.2.9"},
+		{"error: maximum authentication attempts exceeded for root from 192.0.2.10 port 1111 ssh2 [preauth]", "192.0.2.10"},
+		{"Failed password for root from ::ffff:192.0.2.11 port 1 ssh2", "192.0.2.11"},
+		{"Accepted publickey for alice from 192.0.2.1 port 22 ssh2", ""},
+		// the client picks the user name; the address is the last one
+		{"Invalid user x from 192.0.2.99 port 1 from 192.0.2.12 port 2", "192.0.2.12"},
+		// not at the start of the message
+		{"alice: Failed password for root from 192.0.2.13 port 22 ssh2", ""},
+		{"Server listening on 0.0.0.0 port 22.", ""},
+	}
+
+	for _, tt := range tests {
+		addr, ok := ParseSSHFailure(tt.line)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("ParseSSHFailure(%q) matched %v, want no match", tt.line, addr)
+			}
+			continue
+		}
+		if !ok || addr.String() != tt.want {
+			t.Errorf("ParseSSHFailure(%q) = %v, %v; want %s", tt.line, addr, ok, tt.want)
+		}
+	}
+}
+
+func TestSSHDMessage(t *testing.T) {
+	tests := []struct {
+		line string
+		want string // empty = no match
+	}{
+		{"Jan 12 10:00:01 host sshd[123]: Failed password for root from 192.0.2.1 port 22 ssh2", "Failed password for root from 192.0.2.1 port 22 ssh2"},
+		{"Jan  2 10:00:01 host sshd-session[9]: Invalid user a from 192.0.2.2 port 1", "Invalid user a from 192.0.2.2 port 1"},
+		{"2024-01-12T10:00:01.123456+00:00 host sshd[123]: Invalid user b from 192.0.2.3 port 1", "Invalid user b from 192.0.2.3 port 1"},
+		// `logger -t sshd` without -i: no pid
+		{"Jan 12 10:00:01 host sshd: Failed password for root from 192.0.2.4 port 22 ssh2", ""},
+		// someone else's line that quotes sshd
+		{"Jan 12 10:00:01 host alice[55]: x sshd[1]: Failed password for root from 192.0.2.5 port 22 ssh2", ""},
+	}
+	for _, tt := range tests {
+		got, ok := sshdMessage(tt.line)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("sshdMessage(%q) = %q, %v; want %q", tt.line, got, ok, tt.want)
+		}
+	}
+}
+
+func TestSyslogSource_IgnoresSpoofedTags(t *testing.T) {
+	src := SyslogSource{linesSource{
+		"Jan 12 10:00:01 host sshd: Failed password for root from 192.0.2.4 port 22 ssh2",
+		"Jan 12 10:00:02 host alice[55]: sshd[1]: Failed password for root from 192.0.2.5 port 22 ssh2",
+		"Jan 12 10:00:03 host sshd[123]: Invalid user c from 192.0.2.6 port 1",
+	}}
+	out := make(chan string, 3)
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go src.Lines(ctx, out)
+
+	select {
+	case got := <-out:
+		if got != "Invalid user c from 192.0.2.6 port 1" {
+			t.Errorf("first line = %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the sshd line")
+	}
+}
+
+func TestJournalMatches(t *testing.T) {
+	for _, m := range journalMatches {
+		if !strings.HasPrefix(m, "_") {
+			t.Errorf("journal match %q uses a field the client sets itself", m)
+		}
+	}
+	if !slices.Contains(journalMatches, "_UID=0") {
+		t.Errorf("journal matches %v don't require root", journalMatches)
+	}
+}
@@ -0,0 +1,157 @@
+// oreon/defense · watchthelight <wtl>
+
+// Package inventory lists listening sockets and established connections
+// along with the process, user and executable behind each one.
+package inventory
+
+import (
+	"net/netip"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oreonproject/defense/internal/procnet"
+)
+
+// Socket is a listening socket or connection with its owner attached.
+// Owner fields are zero when the owning process couldn't be found
+// (it exited, or the socket belongs to the kernel).
+type Socket struct {
+	Proto  string // "tcp" or "udp"
+	Local  netip.AddrPort
+	Remote netip.AddrPort // zero for listeners
+	State  string
+	UID    int
+	User   string
+	PID    int
+	Comm   string
+	Exe    string
+}
+
+// NonLoopback reports whether the socket is bound to a wildcard or
+// non-loopback address, so other hosts (on the LAN at least) may reach it.
+func (s Socket) NonLoopback() bool {
+	return !s.Local.Addr().IsLoopback()
+}
+
+// Snapshot is the socket inventory at one point in time.
+type Snapshot struct {
+	Time        time.Time
+	Listening   []Socket
+	Connections []Socket
+}
+
+// Collector builds snapshots from /proc.
+// Thread-safe.
+type Collector struct {
+	procRoot string
+
+	mu    sync.Mutex
+	users map[int]string // uid -> name cache
+}
+
+// Option configures a Collector.
+type Option func(*Collector)
+
+// WithProcRoot reads from root instead of /proc.
+func WithProcRoot(root string) Option {
+	return func(c *Collector) {
+		c.procRoot = root
+	}
+}
+
+// New creates a collector.
+func New(opts ...Option) *Collector {
+	c := &Collector{
+		procRoot: "/proc",
+		users:    make(map[int]string),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Collect reads the socket tables and resolves owners. Connections are
+// established TCP sessions and connected UDP sockets; sockets in other
+// states (TIME_WAIT etc.) are left out.
+func (c *Collector) Collect() (*Snapshot, error) {
+	socks, err := procnet.ReadSockets(c.procRoot)
+	if err != nil {
+		return nil, err
+	}
+	owners, err := procnet.SocketOwners(c.procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &Snapshot{Time: time.Now()}
+	for _, s := range socks {
+		entry := Socket{
+			Proto: strings.TrimSuffix(s.Proto, "6"),
+			Local: s.Local,
+			State: s.State,
+			UID:   s.UID,
+			User:  c.userName(s.UID),
+		}
+		if p, ok := owners[s.Inode]; ok {
+			entry.PID = p.PID
+			entry.Comm = p.Comm
+			entry.Exe = p.Exe
+		}
+
+		switch {
+		case s.Listening():
+			snap.Listening = append(snap.Listening, entry)
+		case connected(s):
+			entry.Remote = s.Remote
+			snap.Connections = append(snap.Connections, entry)
+		}
+	}
+
+	sortSockets(snap.Listening)
+	sortSockets(snap.Connections)
+	return snap, nil
+}
+
+func connected(s procnet.Socket) bool {
+	if strings.HasPrefix(s.Proto, "tcp") {
+		return s.State == "ESTABLISHED"
+	}
+	return s.Remote.Port() != 0
+}
+
+func sortSockets(socks []Socket) {
+	sort.Slice(socks, func(i, j int) bool {
+		a, b := socks[i], socks[j]
+		if a.Proto != b.Proto {
+			return a.Proto < b.Proto
+		}
+		if a.Local.Port() != b.Local.Port() {
+			return a.Local.Port() < b.Local.Port()
+		}
+		if a.Local.Addr() != b.Local.Addr() {
+			return a.Local.Addr().Less(b.Local.Addr())
+		}
+		return a.Remote.Addr().Less(b.Remote.Addr())
+	})
+}
+
+// userName resolves a uid, falling back to the number.
+func (c *Collector) userName(uid int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if name, ok := c.users[uid]; ok {
+		return name
+	}
+	name := strconv.Itoa(uid)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	c.users[uid] = name
+	return name
+}
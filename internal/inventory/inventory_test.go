@@ -0,0 +1,113 @@
+// oreon/defense · watchthelight <wtl>
+
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const tcpTable = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0277 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 100 0 0 10 0
+   2: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1004 1 0000000000000000 100 0 0 10 0
+   3: 0F02000A:0016 2200A8C0:D1B4 01 00000000:00000000 02:000A7D2E 00000000     0        0 1003 2 0000000000000000 20 4 30 10 -1
+   4: 0F02000A:0016 2300A8C0:D1B5 06 00000000:00000000 02:000A7D2E 00000000     0        0 0 2 0000000000000000 20 4 30 10 -1
+`
+
+const udp6Table = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  10: 00000000000000000000000000000000:14E9 00000000000000000000000000000000:0000 07 00000000:00000000 00:00000000 00000000    70        0 2001 2 0000000000000000 0
+`
+
+// fakeProc builds a minimal /proc with the tables above and two processes.
+func fakeProc(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "net"), 0o755)
+	os.WriteFile(filepath.Join(root, "net", "tcp"), []byte(tcpTable), 0o644)
+	os.WriteFile(filepath.Join(root, "net", "udp6"), []byte(udp6Table), 0o644)
+
+	procs := []struct {
+		pid    int
+		exe    string
+		inodes []uint64
+	}{
+		{812, "/usr/sbin/sshd", []uint64{1001, 1003}},
+		{3100, "/home/user/.local/bin/devserver", []uint64{1004}},
+		{640, "/usr/sbin/avahi-daemon", []uint64{2001}},
+	}
+	for _, p := range procs {
+		dir := filepath.Join(root, fmt.Sprint(p.pid))
+		os.MkdirAll(filepath.Join(dir, "fd"), 0o755)
+		os.WriteFile(filepath.Join(dir, "comm"), []byte(filepath.Base(p.exe)+"\n"), 0o644)
+		os.Symlink(p.exe, filepath.Join(dir, "exe"))
+		for i, inode := range p.inodes {
+			os.Symlink(fmt.Sprintf("socket:[%d]", inode), filepath.Join(dir, "fd", fmt.Sprint(i+3)))
+		}
+	}
+	return root
+}
+
+func TestCollect(t *testing.T) {
+	snap, err := New(WithProcRoot(fakeProc(t))).Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snap.Listening) != 4 {
+		t.Fatalf("listening = %+v", snap.Listening)
+	}
+	// sorted by proto then port
+	ssh := snap.Listening[0]
+	if ssh.Proto != "tcp" || ssh.Local.Port() != 22 || ssh.PID != 812 || ssh.Exe != "/usr/sbin/sshd" || ssh.User != "root" {
+		t.Errorf("listening[0] = %+v", ssh)
+	}
+	if cups := snap.Listening[1]; cups.Local.Port() != 631 || cups.NonLoopback() || cups.PID != 0 {
+		t.Errorf("listening[1] = %+v", cups)
+	}
+	if mdns := snap.Listening[3]; mdns.Proto != "udp" || mdns.Local.Port() != 5353 || mdns.Comm != "avahi-daemon" {
+		t.Errorf("listening[3] = %+v", mdns)
+	}
+
+	// TIME_WAIT is left out
+	if len(snap.Connections) != 1 {
+		t.Fatalf("connections = %+v", snap.Connections)
+	}
+	if c := snap.Connections[0]; c.Remote.String() != "192.168.0.34:53684" || c.PID != 812 {
+		t.Errorf("connection = %+v", c)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	snap, err := New(WithProcRoot(fakeProc(t))).Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMonitor([]string{"22/tcp", "bogus", "70000/udp"}, nil, []int{5353})
+	if err == nil {
+		t.Error("NewMonitor() should report bad entries")
+	}
+
+	unexpected, added := m.Check(snap)
+	if len(unexpected) != 1 || unexpected[0].Local.Port() != 8080 {
+		t.Fatalf("unexpected = %+v", unexpected)
+	}
+	if len(added) != 1 {
+		t.Errorf("added = %+v on first check", added)
+	}
+
+	// still there: unexpected, but not new
+	unexpected, added = m.Check(snap)
+	if len(unexpected) != 1 || len(added) != 0 {
+		t.Errorf("second check: unexpected = %d, added = %d", len(unexpected), len(added))
+	}
+
+	// gone, then back: new again
+	m.Check(&Snapshot{})
+	if _, added = m.Check(snap); len(added) != 1 {
+		t.Errorf("listener that came back not reported, added = %+v", added)
+	}
+}
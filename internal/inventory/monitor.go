@@ -0,0 +1,90 @@
+// oreon/defense · watchthelight <wtl>
+
+package inventory
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// portKey is a port/protocol pair, e.g. {22, "tcp"}.
+type portKey struct {
+	port  uint16
+	proto string
+}
+
+// Monitor flags non-loopback listeners on ports nobody said to expect.
+// Not thread-safe; the daemon calls it from its health check loop.
+type Monitor struct {
+	expected map[portKey]bool
+	reported map[string]bool // listeners already returned as new
+}
+
+// NewMonitor builds a monitor from "port/proto" strings plus the firewall's
+// allowed ports, which are expected by definition. Bad entries are returned
+// as an error after the valid ones are loaded.
+func NewMonitor(expected []string, tcpPorts, udpPorts []int) (*Monitor, error) {
+	m := &Monitor{
+		expected: make(map[portKey]bool),
+		reported: make(map[string]bool),
+	}
+	for _, p := range tcpPorts {
+		m.expected[portKey{uint16(p), "tcp"}] = true
+	}
+	for _, p := range udpPorts {
+		m.expected[portKey{uint16(p), "udp"}] = true
+	}
+
+	var bad []string
+	for _, e := range expected {
+		key, err := parsePortKey(e)
+		if err != nil {
+			bad = append(bad, e)
+			continue
+		}
+		m.expected[key] = true
+	}
+	if len(bad) > 0 {
+		return m, fmt.Errorf("invalid expected ports: %s", strings.Join(bad, ", "))
+	}
+	return m, nil
+}
+
+func parsePortKey(s string) (portKey, error) {
+	portStr, proto, ok := strings.Cut(s, "/")
+	if !ok || (proto != "tcp" && proto != "udp") {
+		return portKey{}, fmt.Errorf("want port/tcp or port/udp, got %q", s)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return portKey{}, fmt.Errorf("bad port in %q", s)
+	}
+	return portKey{uint16(port), proto}, nil
+}
+
+// Expected reports whether s is a listener we know about.
+func (m *Monitor) Expected(s Socket) bool {
+	return !s.NonLoopback() || m.expected[portKey{s.Local.Port(), s.Proto}]
+}
+
+// Check returns every unexpected non-loopback listener in snap, and the subset
+// that wasn't there on the previous check. A listener that goes away and
+// comes back is reported as new again.
+func (m *Monitor) Check(snap *Snapshot) (unexpected, added []Socket) {
+	seen := make(map[string]bool)
+	for _, s := range snap.Listening {
+		if m.Expected(s) {
+			continue
+		}
+		unexpected = append(unexpected, s)
+
+		id := fmt.Sprintf("%s %s %s", s.Proto, s.Local, s.Exe)
+		seen[id] = true
+		if !m.reported[id] {
+			added = append(added, s)
+		}
+	}
+	m.reported = seen
+	return unexpected, added
+}
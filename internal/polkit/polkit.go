@@ -0,0 +1,103 @@
+// oreon/defense · watchthelight <wtl>
+
+// Package polkit asks the polkit authority whether a local process may
+// perform one of our actions, so desktop users can authenticate for
+// privileged commands instead of needing root.
+package polkit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// polkit D-Bus names.
+const (
+	authorityName  = "org.freedesktop.PolicyKit1"
+	authorityPath  = dbus.ObjectPath("/org/freedesktop/PolicyKit1/Authority")
+	authorityIface = "org.freedesktop.PolicyKit1.Authority"
+)
+
+// Actions, as declared in configs/org.oreon.defense.policy.
+const (
+	ActionPause      = "org.oreon.defense.pause"      // pause and resume protection
+	ActionFirewall   = "org.oreon.defense.firewall"   // toggle the firewall, lift bans, app learning
+	ActionQuarantine = "org.oreon.defense.quarantine" // restore or delete quarantined files
+	ActionManage     = "org.oreon.defense.manage"     // everything else that changes the daemon
+)
+
+// allowUserInteraction lets polkit pop up an authentication dialog on the
+// caller's session and wait for it.
+const allowUserInteraction = uint32(1)
+
+// Authority is a client for the polkit authority on the system bus.
+type Authority struct {
+	obj dbus.BusObject
+}
+
+// New creates an authority client on conn (normally the system bus).
+func New(conn *dbus.Conn) *Authority {
+	return &Authority{obj: conn.Object(authorityName, authorityPath)}
+}
+
+// authResult mirrors polkit's (bba{ss}) AuthorizationResult.
+type authResult struct {
+	Authorized bool
+	Challenge  bool
+	Details    map[string]string
+}
+
+// Check asks whether process pid, running as uid, may perform action.
+// start is the process's start time as read by ProcessStartTime when it
+// connected: it pins the subject to that process, not whatever reuses the
+// pid later. The user may be prompted for a password, in which case Check
+// blocks until they answer or ctx ends.
+func (a *Authority) Check(ctx context.Context, pid int, start uint64, uid int, action string) (bool, error) {
+	if start == 0 {
+		return false, fmt.Errorf("polkit: start time of pid %d unknown", pid)
+	}
+	subject := struct {
+		Kind    string
+		Details map[string]dbus.Variant
+	}{
+		Kind: "unix-process",
+		Details: map[string]dbus.Variant{
+			"pid":        dbus.MakeVariant(uint32(pid)),
+			"start-time": dbus.MakeVariant(start),
+			"uid":        dbus.MakeVariant(int32(uid)),
+		},
+	}
+
+	var res authResult
+	err := a.obj.CallWithContext(ctx, authorityIface+".CheckAuthorization", 0,
+		subject, action, map[string]string{}, allowUserInteraction, "").Store(&res)
+	if err != nil {
+		return false, fmt.Errorf("polkit: check %s: %w", action, err)
+	}
+	return res.Authorized, nil
+}
+
+// ProcessStartTime reads a process's start time (in clock ticks since
+// boot) from /proc, the same way polkit does.
+func ProcessStartTime(pid int) (uint64, error) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, fmt.Errorf("polkit: %w", err)
+	}
+	// comm can contain spaces and parens, so count fields after the last ')'
+	s := string(data)
+	i := strings.LastIndexByte(s, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("polkit: malformed /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(s[i+1:])
+	// starttime is field 22 overall; fields[0] is field 3 (state)
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("polkit: malformed /proc/%d/stat", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
@@ -0,0 +1,97 @@
+// oreon/defense · watchthelight <wtl>
+
+package polkit
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/oreonproject/defense/internal/dbustest"
+)
+
+// subject is polkit's (sa{sv}) Subject as the fake receives it.
+type subject struct {
+	Kind    string
+	Details map[string]dbus.Variant
+}
+
+// FakeAuthority grants the actions in allow and records what it was asked.
+type FakeAuthority struct {
+	mu       sync.Mutex
+	allow    map[string]bool
+	subjects []subject
+	flags    []uint32
+}
+
+func (f *FakeAuthority) CheckAuthorization(s subject, action string, _ map[string]string, flags uint32, _ string) (authResult, *dbus.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subjects = append(f.subjects, s)
+	f.flags = append(f.flags, flags)
+	return authResult{Authorized: f.allow[action], Details: map[string]string{}}, nil
+}
+
+// StartFakeAuthority exports fake as the polkit authority on a private bus
+// and returns a client connection to that bus.
+func StartFakeAuthority(t *testing.T, fake *FakeAuthority) *dbus.Conn {
+	t.Helper()
+	addr := dbustest.StartBus(t)
+
+	srv := dbustest.Connect(t, addr)
+	if err := srv.Export(fake, authorityPath, authorityIface); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.RequestName(authorityName, dbus.NameFlagDoNotQueue); err != nil {
+		t.Fatal(err)
+	}
+	return dbustest.Connect(t, addr)
+}
+
+func TestCheck(t *testing.T) {
+	fake := &FakeAuthority{allow: map[string]bool{ActionPause: true}}
+	auth := New(StartFakeAuthority(t, fake))
+	ctx := context.Background()
+	start, err := ProcessStartTime(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := auth.Check(ctx, os.Getpid(), start, 1000, ActionPause)
+	if err != nil || !ok {
+		t.Fatalf("Check(pause) = %v, %v, want true", ok, err)
+	}
+	ok, err = auth.Check(ctx, os.Getpid(), start, 1000, ActionFirewall)
+	if err != nil || ok {
+		t.Fatalf("Check(firewall) = %v, %v, want false", ok, err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	s := fake.subjects[0]
+	if s.Kind != "unix-process" ||
+		s.Details["pid"].Value() != uint32(os.Getpid()) ||
+		s.Details["uid"].Value() != int32(1000) ||
+		s.Details["start-time"].Value() != start {
+		t.Errorf("subject = %+v", s)
+	}
+	if fake.flags[0] != allowUserInteraction {
+		t.Errorf("flags = %d, want user interaction allowed", fake.flags[0])
+	}
+}
+
+func TestCheck_UnknownStart(t *testing.T) {
+	fake := &FakeAuthority{allow: map[string]bool{ActionPause: true}}
+	auth := New(StartFakeAuthority(t, fake))
+
+	// the process was gone by the time it was looked up
+	if ok, err := auth.Check(context.Background(), os.Getpid(), 0, 1000, ActionPause); err == nil || ok {
+		t.Errorf("Check(no start time) = %v, %v, want error", ok, err)
+	}
+	// pid_max is at most 2^22, so this pid never exists
+	if _, err := ProcessStartTime(1 << 23); err == nil {
+		t.Error("ProcessStartTime(missing pid) error = nil")
+	}
+}
@@ -0,0 +1,158 @@
+// oreon/defense · watchthelight <wtl>
+
+package procnet
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Process identifies the owner of a socket.
+type Process struct {
+	PID    int
+	UID    int
+	Comm   string
+	Exe    string // empty if unreadable (kernel threads, permissions)
+	Cgroup string // cgroup v2 path without the leading slash
+}
+
+// SocketOwners walks /proc/*/fd under root and maps socket inodes to the
+// process holding them. When several processes share a socket (forked
+// servers) the lowest PID wins, which is usually the parent.
+// Processes that exit mid-walk are skipped.
+func SocketOwners(root string) (map[uint64]Process, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[uint64]Process)
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join(root, e.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+
+		var proc *Process
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			inode, ok := socketInode(link)
+			if !ok {
+				continue
+			}
+			if prev, seen := owners[inode]; seen && prev.PID < pid {
+				continue
+			}
+			if proc == nil {
+				p := ReadProcess(root, pid)
+				proc = &p
+			}
+			owners[inode] = *proc
+		}
+	}
+	return owners, nil
+}
+
+// socketInode extracts the inode from a "socket:[12345]" fd link.
+func socketInode(link string) (uint64, bool) {
+	s, ok := strings.CutPrefix(link, "socket:[")
+	if !ok {
+		return 0, false
+	}
+	s, ok = strings.CutSuffix(s, "]")
+	if !ok {
+		return 0, false
+	}
+	inode, err := strconv.ParseUint(s, 10, 64)
+	return inode, err == nil
+}
+
+// ReadProcess gathers what we know about pid. Fields that can't be read
+// are left empty rather than failing the whole lookup.
+func ReadProcess(root string, pid int) Process {
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	p := Process{PID: pid, UID: -1}
+
+	if comm, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil {
+		p.Comm = strings.TrimSpace(string(comm))
+	}
+	if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		// replaced binaries show up as "/usr/bin/foo (deleted)"
+		p.Exe = strings.TrimSuffix(exe, " (deleted)")
+	}
+	p.Cgroup = readCgroup(filepath.Join(dir, "cgroup"))
+	p.UID = readUID(filepath.Join(dir, "status"))
+	return p
+}
+
+// readCgroup returns the unified hierarchy path from /proc/<pid>/cgroup.
+func readCgroup(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rest, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return strings.TrimPrefix(rest, "/")
+		}
+	}
+	return ""
+}
+
+// readUID returns the real UID from /proc/<pid>/status, or -1.
+func readUID(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		rest, ok := strings.CutPrefix(scanner.Text(), "Uid:")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return -1
+		}
+		uid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return -1
+		}
+		return uid
+	}
+	return -1
+}
+
+// Processes lists every readable process under root.
+func Processes(root string) ([]Process, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var out []Process
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		out = append(out, ReadProcess(root, pid))
+	}
+	return out, nil
+}
@@ -0,0 +1,161 @@
+// oreon/defense · watchthelight <wtl>
+
+// Package procnet reads sockets from /proc/net and maps them back to the
+// processes that own them.
+package procnet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Socket is one row of /proc/net/{tcp,udp}{,6}.
+type Socket struct {
+	Proto  string // "tcp", "tcp6", "udp", "udp6"
+	Local  netip.AddrPort
+	Remote netip.AddrPort
+	State  string // "LISTEN", "ESTABLISHED", ... (UDP uses "CLOSE" for unconnected)
+	UID    int
+	Inode  uint64
+}
+
+// EphemeralPorts is the range the kernel picks client ports from, Linux's
+// default net.ipv4.ip_local_port_range.
+var EphemeralPorts = [2]uint16{32768, 60999}
+
+// Listening reports whether the socket accepts connections or datagrams:
+// a TCP LISTEN socket or an unconnected UDP socket bound to a fixed port.
+// Unconnected UDP sockets on ephemeral ports are nearly always clients
+// (resolvers, mDNS queries) that just haven't called connect.
+func (s Socket) Listening() bool {
+	if strings.HasPrefix(s.Proto, "tcp") {
+		return s.State == "LISTEN"
+	}
+	port := s.Local.Port()
+	ephemeral := port >= EphemeralPorts[0] && port <= EphemeralPorts[1]
+	return s.State == "CLOSE" && s.Remote.Port() == 0 && port != 0 && !ephemeral
+}
+
+// tcpStates maps the kernel's st column (include/net/tcp_states.h).
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+// Protocols lists the /proc/net files ReadSockets reads.
+var Protocols = []string{"tcp", "tcp6", "udp", "udp6"}
+
+// ReadSockets reads every socket table under root (normally "/proc").
+// Missing tables are skipped, e.g. when IPv6 is disabled.
+func ReadSockets(root string) ([]Socket, error) {
+	var out []Socket
+	for _, proto := range Protocols {
+		f, err := os.Open(filepath.Join(root, "net", proto))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		socks, err := ParseSockets(f, proto)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", proto, err)
+		}
+		out = append(out, socks...)
+	}
+	return out, nil
+}
+
+// ParseSockets parses one /proc/net socket table. Malformed rows are skipped.
+func ParseSockets(r io.Reader, proto string) ([]Socket, error) {
+	var out []Socket
+	scanner := bufio.NewScanner(r)
+	first := true
+	for scanner.Scan() {
+		if first { // header
+			first = false
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		local, err := parseAddrPort(fields[1])
+		if err != nil {
+			continue
+		}
+		remote, err := parseAddrPort(fields[2])
+		if err != nil {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[7])
+		if err != nil {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			continue
+		}
+		state, ok := tcpStates[fields[3]]
+		if !ok {
+			state = fields[3]
+		}
+		out = append(out, Socket{
+			Proto:  proto,
+			Local:  local,
+			Remote: remote,
+			State:  state,
+			UID:    uid,
+			Inode:  inode,
+		})
+	}
+	return out, scanner.Err()
+}
+
+// parseAddrPort decodes "0100007F:0277". The address is printed as 32-bit
+// words in host byte order, the port in plain hex.
+func parseAddrPort(s string) (netip.AddrPort, error) {
+	addrHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("bad address %q", s)
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	raw, err := hex.DecodeString(addrHex)
+	if err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return netip.AddrPort{}, fmt.Errorf("bad address %q", s)
+	}
+	for i := 0; i < len(raw); i += 4 {
+		binary.NativeEndian.PutUint32(raw[i:], binary.BigEndian.Uint32(raw[i:]))
+	}
+
+	var addr netip.Addr
+	if len(raw) == 4 {
+		addr = netip.AddrFrom4([4]byte(raw))
+	} else {
+		addr = netip.AddrFrom16([16]byte(raw)).Unmap()
+	}
+	return netip.AddrPortFrom(addr, uint16(port)), nil
+}
@@ -0,0 +1,161 @@
+// oreon/defense · watchthelight <wtl>
+
+package procnet
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const tcpTable = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0277 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 100 0 0 10 0
+   2: 0F02000A:D1B4 2200A8C0:01BB 01 00000000:00000000 02:000A7D2E 00000000  1000        0 1003 2 0000000000000000 20 4 30 10 -1
+   3: garbage
+`
+
+const tcp6Table = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2001 1 0000000000000000 100 0 0 10 0
+   1: 00000000000000000000000001000000:0277 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2002 1 0000000000000000 100 0 0 10 0
+`
+
+const udpTable = `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  100: 00000000:0044 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 3001 2 0000000000000000 0
+`
+
+func TestParseSockets_IPv4(t *testing.T) {
+	socks, err := ParseSockets(strings.NewReader(tcpTable), "tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(socks) != 3 {
+		t.Fatalf("got %d sockets, want 3", len(socks))
+	}
+
+	if s := socks[0]; s.Local.String() != "0.0.0.0:22" || s.State != "LISTEN" || !s.Listening() || s.Inode != 1001 {
+		t.Errorf("socks[0] = %+v", s)
+	}
+	if s := socks[1]; s.Local.String() != "127.0.0.1:631" {
+		t.Errorf("socks[1].Local = %s", s.Local)
+	}
+	s := socks[2]
+	if s.Local.String() != "10.0.2.15:53684" || s.Remote.String() != "192.168.0.34:443" {
+		t.Errorf("socks[2] = %s -> %s", s.Local, s.Remote)
+	}
+	if s.State != "ESTABLISHED" || s.Listening() || s.UID != 1000 {
+		t.Errorf("socks[2] = %+v", s)
+	}
+}
+
+func TestParseSockets_IPv6(t *testing.T) {
+	socks, err := ParseSockets(strings.NewReader(tcp6Table), "tcp6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(socks) != 2 {
+		t.Fatalf("got %d sockets, want 2", len(socks))
+	}
+	if got := socks[0].Local.String(); got != "[::]:80" {
+		t.Errorf("socks[0].Local = %s", got)
+	}
+	if got := socks[1].Local.String(); got != "[::1]:631" {
+		t.Errorf("socks[1].Local = %s", got)
+	}
+}
+
+func TestParseSockets_UDP(t *testing.T) {
+	socks, err := ParseSockets(strings.NewReader(udpTable), "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(socks) != 1 || !socks[0].Listening() || socks[0].Local.Port() != 68 {
+		t.Errorf("socks = %+v", socks)
+	}
+}
+
+func TestSocket_Listening(t *testing.T) {
+	for _, tt := range []struct {
+		sock Socket
+		want bool
+	}{
+		{Socket{Proto: "tcp", Local: netip.MustParseAddrPort("0.0.0.0:22"), State: "LISTEN"}, true},
+		{Socket{Proto: "udp", Local: netip.MustParseAddrPort("0.0.0.0:5353"), State: "CLOSE"}, true},
+		// a resolver waiting on its reply
+		{Socket{Proto: "udp", Local: netip.MustParseAddrPort("0.0.0.0:41234"), State: "CLOSE"}, false},
+		{Socket{Proto: "udp6", Local: netip.MustParseAddrPort("[::]:5353"), Remote: netip.MustParseAddrPort("[2001:db8::1]:5353"), State: "ESTABLISHED"}, false},
+	} {
+		if got := tt.sock.Listening(); got != tt.want {
+			t.Errorf("%s %s Listening() = %v, want %v", tt.sock.Proto, tt.sock.Local, got, tt.want)
+		}
+	}
+}
+
+// fakeProcess lays out the bits of /proc/<pid> that procnet reads.
+func fakeProcess(t *testing.T, root string, pid int, exe, cgroup string, uid int, inodes ...uint64) {
+	t.Helper()
+	dir := filepath.Join(root, fmt.Sprint(pid))
+	if err := os.MkdirAll(filepath.Join(dir, "fd"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"comm":   strings.TrimSuffix(filepath.Base(exe), " (deleted)") + "\n",
+		"cgroup": "0::/" + cgroup + "\n",
+		"status": fmt.Sprintf("Name:\t%s\nUid:\t%d\t%d\t%d\t%d\n", filepath.Base(exe), uid, uid, uid, uid),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(exe, filepath.Join(dir, "exe")); err != nil {
+		t.Fatal(err)
+	}
+	os.Symlink("/dev/null", filepath.Join(dir, "fd", "0"))
+	for i, inode := range inodes {
+		link := fmt.Sprintf("socket:[%d]", inode)
+		if err := os.Symlink(link, filepath.Join(dir, "fd", fmt.Sprint(i+3))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSocketOwners(t *testing.T) {
+	root := t.TempDir()
+	fakeProcess(t, root, 812, "/usr/sbin/sshd", "system.slice/sshd.service", 0, 1001)
+	fakeProcess(t, root, 4410, "/usr/sbin/sshd", "system.slice/sshd.service", 0, 1001) // forked child
+	fakeProcess(t, root, 2300, "/usr/lib64/firefox/firefox (deleted)", "user.slice/user-1000.slice/app.slice/firefox.scope", 1000, 1003)
+	os.MkdirAll(filepath.Join(root, "self"), 0o755)
+
+	owners, err := SocketOwners(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := owners[1001]; p.PID != 812 || p.Exe != "/usr/sbin/sshd" || p.Cgroup != "system.slice/sshd.service" || p.UID != 0 {
+		t.Errorf("owner of 1001 = %+v", p)
+	}
+	if p := owners[1003]; p.Exe != "/usr/lib64/firefox/firefox" || p.UID != 1000 || p.Comm != "firefox" {
+		t.Errorf("owner of 1003 = %+v", p)
+	}
+	if _, ok := owners[1002]; ok {
+		t.Error("unowned socket has an owner")
+	}
+}
+
+func TestReadSockets(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "net"), 0o755)
+	os.WriteFile(filepath.Join(root, "net", "tcp"), []byte(tcpTable), 0o644)
+	os.WriteFile(filepath.Join(root, "net", "udp"), []byte(udpTable), 0o644)
+
+	socks, err := ReadSockets(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(socks) != 4 {
+		t.Errorf("got %d sockets, want 4 (missing v6 tables skipped)", len(socks))
+	}
+}
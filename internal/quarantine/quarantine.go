@@ -0,0 +1,296 @@
+// oreon/defense · watchthelight <wtl>
+
+// Package quarantine moves infected files out of reach and can put them
+// back. Each item is a payload file plus a JSON record in one directory
+// that only root can read.
+package quarantine
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrNotFound is returned for unknown item IDs.
+var ErrNotFound = errors.New("quarantine item not found")
+
+// Item describes one quarantined file.
+type Item struct {
+	ID            string      `json:"id"`
+	OriginalPath  string      `json:"original_path"`
+	Threat        string      `json:"threat"`
+	Size          int64       `json:"size"`
+	SHA256        string      `json:"sha256"`
+	Mode          fs.FileMode `json:"mode"`
+	UID           int         `json:"uid"`
+	GID           int         `json:"gid"`
+	QuarantinedAt time.Time   `json:"quarantined_at"`
+}
+
+// Store is a quarantine directory.
+type Store struct {
+	dir string
+}
+
+// New returns a store in dir, creating it if needed.
+func New(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	// tighten an existing directory too
+	if err := os.Chmod(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+func (s *Store) dataPath(id string) string { return filepath.Join(s.dir, id+".data") }
+func (s *Store) metaPath(id string) string { return filepath.Join(s.dir, id+".json") }
+
+// Add moves path into quarantine. The payload is stored without any
+// permission bits so nothing can execute it in place.
+func (s *Store) Add(path, threat string) (Item, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return Item{}, err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return Item{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return Item{}, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	// Restore won't follow symlinks, so remember where the file really was
+	original := path
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+		if dir, err = filepath.Abs(dir); err == nil {
+			original = filepath.Join(dir, filepath.Base(path))
+		}
+	}
+	item := Item{
+		ID:            newID(),
+		OriginalPath:  original,
+		Threat:        threat,
+		Mode:          info.Mode().Perm(),
+		UID:           -1,
+		GID:           -1,
+		QuarantinedAt: time.Now(),
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		item.UID, item.GID = int(st.Uid), int(st.Gid)
+	}
+
+	dst, err := os.OpenFile(s.dataPath(item.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0)
+	if err != nil {
+		return Item{}, err
+	}
+	h := sha256.New()
+	item.Size, err = io.Copy(io.MultiWriter(dst, h), src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(s.dataPath(item.ID))
+		return Item{}, err
+	}
+	item.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	if err := s.writeMeta(item); err != nil {
+		os.Remove(s.dataPath(item.ID))
+		return Item{}, err
+	}
+	if err := os.Remove(path); err != nil {
+		s.Delete(item.ID)
+		return Item{}, fmt.Errorf("remove %s: %w", path, err)
+	}
+	return item, nil
+}
+
+func (s *Store) writeMeta(item Item) error {
+	data, err := json.MarshalIndent(item, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.metaPath(item.ID), data, 0o600)
+}
+
+// Get returns one item.
+func (s *Store) Get(id string) (Item, error) {
+	if !validID(id) {
+		return Item{}, ErrNotFound
+	}
+	data, err := os.ReadFile(s.metaPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Item{}, ErrNotFound
+	}
+	if err != nil {
+		return Item{}, err
+	}
+	var item Item
+	if err := json.Unmarshal(data, &item); err != nil {
+		return Item{}, fmt.Errorf("quarantine item %s: %w", id, err)
+	}
+	return item, nil
+}
+
+// List returns all items, newest first. Unreadable records are skipped.
+func (s *Store) List() ([]Item, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var items []Item
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if item, err := s.Get(id); err == nil {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].QuarantinedAt.After(items[j].QuarantinedAt)
+	})
+	return items, nil
+}
+
+// Restore puts the file back where it came from with its old mode and
+// owner, refusing to overwrite anything that has appeared there since.
+// If ctx ends mid-copy the partial file is removed and the item stays.
+//
+// The parent directories are opened one at a time without following
+// symlinks, so whoever owns one can't swap it for a link and have root
+// write the payload somewhere else.
+func (s *Store) Restore(ctx context.Context, id string) (Item, error) {
+	item, err := s.Get(id)
+	if err != nil {
+		return Item{}, err
+	}
+	src, err := os.Open(s.dataPath(id))
+	if err != nil {
+		return Item{}, err
+	}
+	defer src.Close()
+
+	path, err := filepath.Abs(item.OriginalPath)
+	if err != nil {
+		return Item{}, fmt.Errorf("restore %s: %w", item.OriginalPath, err)
+	}
+	dir, err := openDir(filepath.Dir(path))
+	if err != nil {
+		return Item{}, fmt.Errorf("restore %s: %w", item.OriginalPath, err)
+	}
+	defer dir.Close()
+	name := filepath.Base(path)
+
+	fd, err := syscall.Openat(int(dir.Fd()), name,
+		syscall.O_WRONLY|syscall.O_CREAT|syscall.O_EXCL|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0o600)
+	if err != nil {
+		return Item{}, fmt.Errorf("restore %s: %w", item.OriginalPath, &fs.PathError{Op: "open", Path: path, Err: err})
+	}
+	dst := os.NewFile(uintptr(fd), path)
+	_, err = io.Copy(dst, ctxReader{ctx, src})
+	// ownership only works as root, and goes first since it clears setuid
+	if err == nil && item.UID >= 0 {
+		if cerr := dst.Chown(item.UID, item.GID); cerr != nil && !errors.Is(cerr, fs.ErrPermission) {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = dst.Chmod(item.Mode)
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		syscall.Unlinkat(int(dir.Fd()), name)
+		return Item{}, fmt.Errorf("restore %s: %w", item.OriginalPath, err)
+	}
+	return item, s.Delete(id)
+}
+
+// openDir opens dir starting from / without following symlinks anywhere
+// along the way.
+func openDir(dir string) (*os.File, error) {
+	fd, err := syscall.Open("/", syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: "/", Err: err}
+	}
+	for name := range strings.SplitSeq(strings.Trim(dir, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		next, err := syscall.Openat(fd, name, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		syscall.Close(fd)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: dir, Err: err}
+		}
+		fd = next
+	}
+	return os.NewFile(uintptr(fd), dir), nil
+}
+
+// Delete removes an item for good.
+func (s *Store) Delete(id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	err := os.Remove(s.metaPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(s.dataPath(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ctxReader stops a copy once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validID keeps IDs from the wire from escaping the directory.
+func validID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
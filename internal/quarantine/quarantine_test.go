@@ -0,0 +1,140 @@
+// oreon/defense · watchthelight <wtl>
+
+package quarantine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	s, err := New(filepath.Join(t.TempDir(), "quarantine"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	victim := filepath.Join(t.TempDir(), "invoice.pdf.exe")
+	os.WriteFile(victim, []byte("X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR"), 0o755)
+
+	item, err := s.Add(victim, "Eicar-Signature")
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := os.Stat(victim); !os.IsNotExist(err) {
+		t.Error("original file still present after quarantine")
+	}
+	if item.Mode != 0o755 || item.Size == 0 || item.SHA256 == "" {
+		t.Errorf("item = %+v", item)
+	}
+	info, err := os.Stat(s.dataPath(item.ID))
+	if err != nil || info.Mode().Perm() != 0 {
+		t.Errorf("payload mode = %v, %v; want no permissions", info.Mode(), err)
+	}
+
+	items, err := s.List()
+	if err != nil || len(items) != 1 || items[0].Threat != "Eicar-Signature" {
+		t.Fatalf("List() = %+v, %v", items, err)
+	}
+
+	// restore refuses to clobber a new file at the original path
+	os.WriteFile(victim, []byte("new"), 0o644)
+	if _, err := s.Restore(t.Context(), item.ID); err == nil {
+		t.Error("Restore() overwrote an existing file")
+	}
+	os.Remove(victim)
+
+	// a cancelled restore leaves nothing behind and the item in place
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := s.Restore(ctx, item.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("Restore(cancelled) error = %v", err)
+	}
+	if _, err := os.Stat(victim); !os.IsNotExist(err) {
+		t.Errorf("cancelled restore left %s: %v", victim, err)
+	}
+
+	if _, err := s.Restore(t.Context(), item.ID); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	info, err = os.Stat(victim)
+	if err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("restored mode = %v, %v", info, err)
+	}
+	if items, _ := s.List(); len(items) != 0 {
+		t.Errorf("item still listed after restore: %+v", items)
+	}
+}
+
+func TestStore_RestoreSymlinkedParent(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := t.TempDir()
+	os.Mkdir(filepath.Join(home, "dl"), 0o755)
+	victim := filepath.Join(home, "dl", "bad")
+	os.WriteFile(victim, []byte("bad"), 0o644)
+	item, err := s.Add(victim, "Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the owner swaps the directory for a link somewhere else
+	elsewhere := t.TempDir()
+	os.Remove(filepath.Join(home, "dl"))
+	if err := os.Symlink(elsewhere, filepath.Join(home, "dl")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Restore(t.Context(), item.ID); err == nil {
+		t.Error("Restore() followed a symlinked parent")
+	}
+	if _, err := os.Stat(filepath.Join(elsewhere, "bad")); !os.IsNotExist(err) {
+		t.Errorf("payload written through the link: %v", err)
+	}
+	if _, err := s.Get(item.ID); err != nil {
+		t.Errorf("item gone after refused restore: %v", err)
+	}
+
+	// a path that was already behind a link when quarantined is recorded
+	// resolved, so it still restores
+	os.Remove(filepath.Join(home, "dl"))
+	os.WriteFile(filepath.Join(elsewhere, "bad2"), []byte("bad"), 0o644)
+	os.Symlink(elsewhere, filepath.Join(home, "dl"))
+	item, err = s.Add(filepath.Join(home, "dl", "bad2"), "Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.OriginalPath != filepath.Join(elsewhere, "bad2") {
+		t.Errorf("OriginalPath = %s, want the resolved path", item.OriginalPath)
+	}
+	if _, err := s.Restore(t.Context(), item.ID); err != nil {
+		t.Errorf("Restore() error = %v", err)
+	}
+}
+
+func TestStore_Delete(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	victim := filepath.Join(t.TempDir(), "bad")
+	os.WriteFile(victim, []byte("bad"), 0o644)
+	item, err := s.Add(victim, "Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete(item.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := s.Get(item.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after delete error = %v", err)
+	}
+	for _, id := range []string{item.ID, "../../etc/passwd", ""} {
+		if err := s.Delete(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Delete(%q) error = %v, want ErrNotFound", id, err)
+		}
+	}
+}
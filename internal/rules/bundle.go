@@ -0,0 +1,481 @@
+// oreon/defense · watchthelight <wtl>
+
+package rules
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Offline bundles are tarballs (optionally gzipped) that start with
+// manifest.json and manifest.sig, followed by the files the manifest
+// lists. The signature is an ed25519 signature over manifest.json, raw or
+// base64. Putting the manifest first lets us check the signature before
+// writing a single byte of the payload.
+const (
+	manifestName  = "manifest.json"
+	signatureName = "manifest.sig"
+
+	maxManifestSize   = 1 << 20
+	maxBundleFileSize = 2 << 30
+)
+
+var (
+	// ErrBadSignature means no trusted key signed the bundle.
+	ErrBadSignature = errors.New("bundle signature not valid for any trusted key")
+	// ErrDowngrade means the bundle is older than what's installed.
+	ErrDowngrade = errors.New("bundle is older than installed rules")
+)
+
+// Manifest describes a signed bundle.
+type Manifest struct {
+	Version uint64       `json:"version"` // must increase with every bundle
+	Created time.Time    `json:"created"`
+	Files   []BundleFile `json:"files"`
+}
+
+// BundleFile is one payload file.
+type BundleFile struct {
+	Path   string `json:"path"`             // name inside the tarball
+	Kind   string `json:"kind"`             // clamav, yara or hashes
+	Source string `json:"source,omitempty"` // rule source to replace, for yara and hashes
+	SHA256 string `json:"sha256"`
+}
+
+// ImportResult describes an installed bundle.
+type ImportResult struct {
+	Version   uint64
+	Created   time.Time
+	Installed []string // destination paths
+}
+
+// bundleState is persisted at statePath.
+type bundleState struct {
+	Version    uint64    `json:"bundle_version"`
+	Created    time.Time `json:"bundle_created"`
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+// staged is a verified payload file waiting next to its destination.
+type staged struct {
+	file BundleFile
+	dest string
+	tmp  string
+}
+
+// BundleVersion returns the version of the last imported bundle, 0 if none.
+func (m *Manager) BundleVersion() uint64 {
+	st, _ := m.loadState()
+	return st.Version
+}
+
+// Import verifies and installs an offline bundle, then reloads clamd.
+// Nothing is installed unless every file verifies.
+func (m *Manager) Import(ctx context.Context, bundlePath string) (ImportResult, error) {
+	if len(m.keys) == 0 {
+		return ImportResult{}, errors.New("no trusted keys configured for bundle import")
+	}
+	if !m.begin() {
+		return ImportResult{}, ErrUpdateInProgress
+	}
+	defer func() {
+		m.mu.Lock()
+		m.updating = false
+		m.mu.Unlock()
+	}()
+
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer f.Close()
+
+	tr, err := tarReader(f)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	manifest, err := m.readManifest(tr)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	files, err := m.stage(ctx, tr, manifest)
+	defer func() {
+		for _, s := range files {
+			os.Remove(s.tmp) // gone already if installed
+		}
+	}()
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if err := install(files); err != nil {
+		return ImportResult{}, err
+	}
+
+	if err := m.saveState(bundleState{Version: manifest.Version, Created: manifest.Created, ImportedAt: time.Now()}); err != nil {
+		m.logger.Warn("failed to record bundle version", "error", err)
+	}
+
+	result := ImportResult{Version: manifest.Version, Created: manifest.Created}
+	reload := false
+	for _, s := range files {
+		result.Installed = append(result.Installed, s.dest)
+		reload = reload || s.file.Kind == KindClamAV
+	}
+	if clam := m.clamAV(); reload && clam != nil {
+		if err := clam.clamd.Reload(); err != nil {
+			m.logger.Warn("clamd reload after bundle import failed", "error", err)
+		}
+	}
+	m.Refresh()
+	return result, nil
+}
+
+// tarReader sniffs for gzip so both .tar and .tar.gz work.
+func tarReader(r io.Reader) (*tar.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil {
+		return nil, fmt.Errorf("read bundle: %w", err)
+	}
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("read bundle: %w", err)
+		}
+		return tar.NewReader(zr), nil
+	}
+	return tar.NewReader(br), nil
+}
+
+// readManifest reads and verifies the first two entries.
+func (m *Manager) readManifest(tr *tar.Reader) (Manifest, error) {
+	raw, err := readEntry(tr, manifestName)
+	if err != nil {
+		return Manifest{}, err
+	}
+	sig, err := readEntry(tr, signatureName)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if len(sig) != ed25519.SignatureSize {
+		if sig, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig))); err != nil {
+			return Manifest{}, fmt.Errorf("bad %s: %w", signatureName, err)
+		}
+	}
+	if !m.verify(raw, sig) {
+		return Manifest{}, ErrBadSignature
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return Manifest{}, fmt.Errorf("bad %s: %w", manifestName, err)
+	}
+	if len(manifest.Files) == 0 {
+		return Manifest{}, errors.New("bundle contains no files")
+	}
+	if st, err := m.loadState(); err != nil {
+		return Manifest{}, err
+	} else if manifest.Version <= st.Version {
+		return Manifest{}, fmt.Errorf("%w: bundle version %d, last imported %d", ErrDowngrade, manifest.Version, st.Version)
+	}
+	return manifest, nil
+}
+
+func (m *Manager) verify(msg, sig []byte) bool {
+	for _, key := range m.keys {
+		if ed25519.Verify(key, msg, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+func readEntry(tr *tar.Reader, name string) ([]byte, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("read bundle: expected %s: %w", name, err)
+	}
+	if path.Clean(hdr.Name) != name {
+		return nil, fmt.Errorf("read bundle: expected %s, found %s", name, hdr.Name)
+	}
+	if hdr.Size > maxManifestSize {
+		return nil, fmt.Errorf("read bundle: %s too large", name)
+	}
+	return io.ReadAll(tr)
+}
+
+// stage writes every payload file to a temp file beside its destination,
+// checking hashes and content on the way. The returned files are staged
+// even when err is set so the caller can clean them up.
+func (m *Manager) stage(ctx context.Context, tr *tar.Reader, manifest Manifest) ([]staged, error) {
+	want := make(map[string]BundleFile, len(manifest.Files))
+	for _, bf := range manifest.Files {
+		name := path.Clean(bf.Path)
+		if _, dup := want[name]; dup {
+			return nil, fmt.Errorf("manifest lists %s twice", name)
+		}
+		want[name] = bf
+	}
+
+	var files []staged
+	dests := make(map[string]bool)
+	for {
+		if err := ctx.Err(); err != nil {
+			return files, err
+		}
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return files, fmt.Errorf("read bundle: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		bf, ok := want[name]
+		if !ok {
+			return files, fmt.Errorf("bundle file %s is not in the manifest", hdr.Name)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return files, fmt.Errorf("bundle file %s is not a regular file", hdr.Name)
+		}
+		delete(want, name)
+
+		dest, err := m.destination(bf)
+		if err != nil {
+			return files, err
+		}
+		if dests[dest] {
+			return files, fmt.Errorf("bundle installs %s twice", dest)
+		}
+		dests[dest] = true
+		tmp, err := stageFile(tr, dest, bf.SHA256)
+		if err != nil {
+			return files, fmt.Errorf("%s: %w", bf.Path, err)
+		}
+		files = append(files, staged{file: bf, dest: dest, tmp: tmp})
+		if err := m.checkStaged(bf, tmp); err != nil {
+			return files, fmt.Errorf("%s: %w", bf.Path, err)
+		}
+	}
+	for name := range want {
+		return files, fmt.Errorf("bundle is missing %s", name)
+	}
+	return files, nil
+}
+
+// destination maps a bundle file to where it gets installed.
+func (m *Manager) destination(bf BundleFile) (string, error) {
+	switch bf.Kind {
+	case KindClamAV:
+		clam := m.clamAV()
+		if clam == nil || clam.dbDir == "" {
+			return "", errors.New("no ClamAV database directory configured")
+		}
+		base := path.Base(bf.Path)
+		if ext := path.Ext(base); ext != ".cvd" && ext != ".cld" {
+			return "", fmt.Errorf("%s: not a ClamAV database", bf.Path)
+		}
+		return filepath.Join(clam.dbDir, base), nil
+	case KindYARA, KindHashes:
+		for _, src := range m.sources {
+			if l, ok := src.(*List); ok && l.Name() == bf.Source {
+				if l.Kind() != bf.Kind {
+					return "", fmt.Errorf("%s: source %s holds %s, not %s", bf.Path, bf.Source, l.Kind(), bf.Kind)
+				}
+				return l.Path(), nil
+			}
+		}
+		return "", fmt.Errorf("%s: no rule source named %q", bf.Path, bf.Source)
+	default:
+		return "", fmt.Errorf("%s: unknown kind %q", bf.Path, bf.Kind)
+	}
+}
+
+// stageFile copies r into a temp file in dest's directory and checks its
+// SHA-256 against want.
+func stageFile(r io.Reader, dest, want string) (string, error) {
+	dir := filepath.Dir(dest)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(dest)+".*")
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r, maxBundleFileSize+1))
+	if err == nil && n > maxBundleFileSize {
+		err = errors.New("file too large")
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), want) {
+		err = errors.New("sha256 does not match manifest")
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// checkStaged validates content and refuses to replace newer ClamAV
+// databases with older ones.
+func (m *Manager) checkStaged(bf BundleFile, tmp string) error {
+	if bf.Kind != KindClamAV {
+		data, err := os.ReadFile(tmp)
+		if err != nil {
+			return err
+		}
+		return Validate(bf.Kind, data)
+	}
+
+	v, err := readCVDHeader(tmp)
+	if err != nil {
+		return err
+	}
+	db := strings.TrimSuffix(path.Base(bf.Path), path.Ext(bf.Path))
+	cur, err := m.clamAV().databaseVersion(db)
+	if err != nil {
+		return nil // nothing installed yet
+	}
+	if versionLess(v.ID, cur.ID) {
+		return fmt.Errorf("%w: %s version %s, installed %s", ErrDowngrade, db, v.ID, cur.ID)
+	}
+	return nil
+}
+
+// versionLess compares ClamAV database versions numerically.
+func versionLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// install renames the staged files into place. If a rename fails, the
+// files already replaced are put back so we never end up half-installed.
+func install(files []staged) error {
+	type backup struct{ dest, saved string }
+	var backups []backup
+	rollback := func() {
+		for i := len(backups) - 1; i >= 0; i-- {
+			b := backups[i]
+			if b.saved == "" {
+				os.Remove(b.dest)
+			} else {
+				os.Rename(b.saved, b.dest)
+			}
+		}
+	}
+
+	for _, s := range files {
+		saved := ""
+		if _, err := os.Stat(s.dest); err == nil {
+			saved = s.tmp + ".old"
+			if err := os.Link(s.dest, saved); err != nil {
+				rollback()
+				return fmt.Errorf("back up %s: %w", s.dest, err)
+			}
+		}
+		if err := os.Rename(s.tmp, s.dest); err != nil {
+			if saved != "" {
+				os.Remove(saved)
+			}
+			rollback()
+			return fmt.Errorf("install %s: %w", s.dest, err)
+		}
+		backups = append(backups, backup{s.dest, saved})
+	}
+
+	for _, b := range backups {
+		if b.saved != "" {
+			os.Remove(b.saved)
+		}
+	}
+	// a fresh daily.cvd makes an older daily.cld pointless and the other way
+	// round; clamd would otherwise load whichever it finds
+	installed := make(map[string]bool, len(files))
+	for _, s := range files {
+		installed[s.dest] = true
+	}
+	for _, s := range files {
+		if s.file.Kind != KindClamAV {
+			continue
+		}
+		other := strings.TrimSuffix(s.dest, ".cvd") + ".cld"
+		if strings.HasSuffix(s.dest, ".cld") {
+			other = strings.TrimSuffix(s.dest, ".cld") + ".cvd"
+		}
+		if !installed[other] {
+			os.Remove(other)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) clamAV() *ClamAV {
+	for _, src := range m.sources {
+		if c, ok := src.(*ClamAV); ok {
+			return c
+		}
+	}
+	return nil
+}
+
+func (m *Manager) loadState() (bundleState, error) {
+	var st bundleState
+	if m.statePath == "" {
+		return st, nil
+	}
+	data, err := os.ReadFile(m.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("bad rules state %s: %w", m.statePath, err)
+	}
+	return st, nil
+}
+
+func (m *Manager) saveState(st bundleState) error {
+	if m.statePath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(m.statePath, data, 0o644)
+}
@@ -0,0 +1,265 @@
+// oreon/defense · watchthelight <wtl>
+
+package rules
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/config"
+)
+
+type bundleEntry struct {
+	path, kind, source string
+	data               []byte
+}
+
+// cvd returns a database with just a header.
+func cvd(built, version string) []byte {
+	header := fmt.Sprintf("ClamAV-VDB:%s:%s:2055378:90:md5:dsig:builder:1707903032", built, version)
+	return []byte(header + strings.Repeat(" ", 512-len(header)) + "body")
+}
+
+// writeBundle builds a signed .tar.gz. extra entries are appended without
+// being listed in the manifest; corrupt changes a payload after hashing.
+func writeBundle(t *testing.T, key ed25519.PrivateKey, version uint64, entries []bundleEntry, extra []bundleEntry, corrupt bool) string {
+	t.Helper()
+	manifest := Manifest{Version: version, Created: time.Now().UTC().Truncate(time.Second)}
+	for _, e := range entries {
+		sum := sha256.Sum256(e.data)
+		manifest.Files = append(manifest.Files, BundleFile{Path: e.path, Kind: e.kind, Source: e.source, SHA256: hex.EncodeToString(sum[:])})
+	}
+	raw, _ := json.Marshal(manifest)
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, raw))
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	add := func(name string, data []byte) {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		tw.Write(data)
+	}
+	add(manifestName, raw)
+	add(signatureName, []byte(sig+"\n"))
+	for _, e := range append(entries, extra...) {
+		data := e.data
+		if corrupt {
+			data = append([]byte{}, data...)
+			data[len(data)-1] ^= 0xff
+		}
+		add(e.path, data)
+	}
+	tw.Close()
+	zw.Close()
+
+	path := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+type bundleEnv struct {
+	m     *Manager
+	key   ed25519.PrivateKey
+	clamd *fakeClamd
+	dbDir string
+	yara  string
+}
+
+func newBundleEnv(t *testing.T) *bundleEnv {
+	t.Helper()
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	dir := t.TempDir()
+	env := &bundleEnv{
+		key:   priv,
+		clamd: &fakeClamd{},
+		dbDir: filepath.Join(dir, "clamav"),
+		yara:  filepath.Join(dir, "rules", "oreon.yar"),
+	}
+	os.MkdirAll(env.dbDir, 0o755)
+	writeCVD(t, filepath.Join(env.dbDir, "daily.cld"), "14 Feb 2024 09-30 +0000", "27185")
+
+	m, err := New(config.Rules{
+		DatabaseDir: env.dbDir,
+		TrustedKeys: []string{base64.StdEncoding.EncodeToString(pub)},
+		StatePath:   filepath.Join(dir, "state.json"),
+		Sources:     []config.RuleSource{{Name: "oreon", Kind: KindYARA, URL: "http://unused", Path: env.yara}},
+	}, env.clamd, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.m = m
+	return env
+}
+
+func (env *bundleEnv) payload() []bundleEntry {
+	return []bundleEntry{
+		{path: "clamav/daily.cvd", kind: KindClamAV, data: cvd("18 Oct 2026 08-00 +0000", "27190")},
+		{path: "yara/oreon.yar", kind: KindYARA, source: "oreon", data: []byte("rule Bundled { condition: true }\n")},
+	}
+}
+
+// leftovers lists temp files left in the install directories.
+func (env *bundleEnv) leftovers() []string {
+	var out []string
+	for _, dir := range []string{env.dbDir, filepath.Dir(env.yara)} {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), ".") {
+				out = append(out, e.Name())
+			}
+		}
+	}
+	return out
+}
+
+func TestImport(t *testing.T) {
+	env := newBundleEnv(t)
+	bundle := writeBundle(t, env.key, 20261018, env.payload(), nil, false)
+
+	res, err := env.m.Import(context.Background(), bundle)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if res.Version != 20261018 || len(res.Installed) != 2 {
+		t.Errorf("result = %+v", res)
+	}
+	if env.clamd.reloads != 1 {
+		t.Errorf("clamd reloads = %d, want 1", env.clamd.reloads)
+	}
+	if _, err := os.Stat(filepath.Join(env.dbDir, "daily.cld")); !os.IsNotExist(err) {
+		t.Error("older daily.cld left next to the imported daily.cvd")
+	}
+	if got, _ := os.ReadFile(env.yara); string(got) != "rule Bundled { condition: true }\n" {
+		t.Errorf("yara rules = %q", got)
+	}
+	if st := env.m.Status(); st[0].Version != "27190" || st[1].Version == "" {
+		t.Errorf("status after import = %+v", st)
+	}
+	if env.m.BundleVersion() != 20261018 {
+		t.Errorf("BundleVersion() = %d", env.m.BundleVersion())
+	}
+	if left := env.leftovers(); len(left) != 0 {
+		t.Errorf("temp files left behind: %v", left)
+	}
+
+	// the same bundle again is refused
+	if _, err := env.m.Import(context.Background(), bundle); !errors.Is(err, ErrDowngrade) {
+		t.Errorf("re-import error = %v, want ErrDowngrade", err)
+	}
+}
+
+func TestImport_Rejected(t *testing.T) {
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+
+	tests := []struct {
+		name    string
+		bundle  func(env *bundleEnv) string
+		wantErr error
+		wantMsg string
+	}{
+		{
+			name: "untrusted key",
+			bundle: func(env *bundleEnv) string {
+				return writeBundle(t, otherKey, 1, env.payload(), nil, false)
+			},
+			wantErr: ErrBadSignature,
+		},
+		{
+			name: "hash mismatch",
+			bundle: func(env *bundleEnv) string {
+				return writeBundle(t, env.key, 1, env.payload(), nil, true)
+			},
+			wantMsg: "sha256 does not match",
+		},
+		{
+			name: "older database",
+			bundle: func(env *bundleEnv) string {
+				entries := env.payload()
+				entries[0].data = cvd("01 Jan 2024 00-00 +0000", "27000")
+				return writeBundle(t, env.key, 1, entries, nil, false)
+			},
+			wantErr: ErrDowngrade,
+		},
+		{
+			name: "unlisted file",
+			bundle: func(env *bundleEnv) string {
+				extra := []bundleEntry{{path: "../../etc/passwd", data: []byte("root::0:0::/root:/bin/sh\n")}}
+				return writeBundle(t, env.key, 1, env.payload(), extra, false)
+			},
+			wantMsg: "not in the manifest",
+		},
+		{
+			name: "unknown source",
+			bundle: func(env *bundleEnv) string {
+				entries := env.payload()
+				entries[1].source = "missing"
+				return writeBundle(t, env.key, 1, entries, nil, false)
+			},
+			wantMsg: `no rule source named "missing"`,
+		},
+		{
+			name: "not yara",
+			bundle: func(env *bundleEnv) string {
+				entries := env.payload()
+				entries[1].data = []byte("<html></html>")
+				return writeBundle(t, env.key, 1, entries, nil, false)
+			},
+			wantMsg: "no YARA rules",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newBundleEnv(t)
+			_, err := env.m.Import(context.Background(), tt.bundle(env))
+			if err == nil {
+				t.Fatal("Import() succeeded")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantMsg != "" && !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("error = %v, want %q", err, tt.wantMsg)
+			}
+
+			// nothing changed
+			if v, _ := env.m.clamAV().Installed(); v.ID != "27185" {
+				t.Errorf("installed daily = %s, want 27185", v.ID)
+			}
+			if _, err := os.Stat(env.yara); !os.IsNotExist(err) {
+				t.Error("yara rules installed from a rejected bundle")
+			}
+			if left := env.leftovers(); len(left) != 0 {
+				t.Errorf("temp files left behind: %v", left)
+			}
+			if env.clamd.reloads != 0 || env.m.BundleVersion() != 0 {
+				t.Error("rejected bundle reloaded clamd or recorded a version")
+			}
+		})
+	}
+}
+
+func TestImport_NoKeys(t *testing.T) {
+	m, err := New(config.Rules{}, &fakeClamd{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Import(context.Background(), "/nonexistent"); err == nil || !strings.Contains(err.Error(), "no trusted keys") {
+		t.Errorf("Import() error = %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+// oreon/defense · watchthelight <wtl>
+
+package rules
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Runner executes freshclam. Tests swap in a fake.
+type Runner interface {
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+// Clamd is the part of the clamd client the ClamAV source needs.
+type Clamd interface {
+	Reload() error
+	Version() (string, error)
+}
+
+// freshclamUpToDate is freshclam's exit status when nothing was downloaded.
+const freshclamUpToDate = 1
+
+// ClamAV updates the ClamAV signature databases with freshclam and tells
+// clamd to reload them. Without freshclam (e.g. when freshclam runs as its
+// own service) an update is just a reload.
+type ClamAV struct {
+	freshclam string
+	dbDir     string
+	clamd     Clamd
+	runner    Runner
+}
+
+// NewClamAV creates the ClamAV source. A nil runner shells out.
+func NewClamAV(freshclam, dbDir string, clamd Clamd, runner Runner) *ClamAV {
+	if runner == nil {
+		runner = execRunner{}
+	}
+	return &ClamAV{freshclam: freshclam, dbDir: dbDir, clamd: clamd, runner: runner}
+}
+
+// Name returns "clamav".
+func (c *ClamAV) Name() string { return KindClamAV }
+
+// Kind returns "clamav".
+func (c *ClamAV) Kind() string { return KindClamAV }
+
+// Update runs freshclam if configured, then reloads clamd.
+func (c *ClamAV) Update(ctx context.Context) (Version, error) {
+	if c.freshclam == "" {
+		if err := c.clamd.Reload(); err != nil {
+			return Version{}, fmt.Errorf("clamd reload: %w", err)
+		}
+		return c.Installed()
+	}
+
+	out, err := c.runner.Run(ctx, c.freshclam, "--stdout")
+	var exit interface{ ExitCode() int }
+	if err != nil && !(errors.As(err, &exit) && exit.ExitCode() == freshclamUpToDate) {
+		if line := lastLine(out); line != "" {
+			return Version{}, fmt.Errorf("freshclam: %s", line)
+		}
+		return Version{}, fmt.Errorf("freshclam: %w", err)
+	}
+	if err == nil {
+		// new signatures on disk; clamd's SelfCheck would get there
+		// eventually, so a failed reload isn't worth failing the update
+		c.clamd.Reload()
+	}
+	return c.Installed()
+}
+
+// Installed reads the daily database header, falling back to asking clamd.
+func (c *ClamAV) Installed() (Version, error) {
+	if v, err := c.databaseVersion("daily"); err == nil {
+		return v, nil
+	}
+	line, err := c.clamd.Version()
+	if err != nil {
+		return Version{}, fmt.Errorf("no daily database in %s and clamd unreachable: %w", c.dbDir, err)
+	}
+	return parseClamdVersion(line)
+}
+
+// databaseVersion reads name.cld or name.cvd, whichever is newer.
+func (c *ClamAV) databaseVersion(name string) (Version, error) {
+	var best Version
+	var firstErr error
+	for _, ext := range []string{".cld", ".cvd"} {
+		v, err := readCVDHeader(filepath.Join(c.dbDir, name+ext))
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if v.Updated.After(best.Updated) {
+			best = v
+		}
+	}
+	if best.ID == "" {
+		return Version{}, firstErr
+	}
+	return best, nil
+}
+
+// readCVDHeader parses the 512-byte header at the start of a .cvd/.cld:
+// "ClamAV-VDB:14 Feb 2024 09-30 +0000:27185:2055378:90:...".
+func readCVDHeader(path string) (Version, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Version{}, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 512)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return Version{}, fmt.Errorf("%s: read header: %w", path, err)
+	}
+	fields := strings.Split(string(bytes.TrimRight(header, " \x00")), ":")
+	if len(fields) < 3 || fields[0] != "ClamAV-VDB" {
+		return Version{}, fmt.Errorf("%s: not a ClamAV database", path)
+	}
+	built, err := time.Parse("02 Jan 2006 15-04 -0700", fields[1])
+	if err != nil {
+		return Version{}, fmt.Errorf("%s: bad build time %q", path, fields[1])
+	}
+	return Version{ID: fields[2], Updated: built}, nil
+}
+
+// parseClamdVersion parses "ClamAV 1.0.5/27185/Wed Feb 14 09:30:32 2024".
+func parseClamdVersion(line string) (Version, error) {
+	parts := strings.SplitN(line, "/", 3)
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("unexpected clamd version %q", line)
+	}
+	built, err := time.ParseInLocation(time.ANSIC, parts[2], time.Local)
+	if err != nil {
+		return Version{}, fmt.Errorf("unexpected clamd version %q", line)
+	}
+	return Version{ID: parts[1], Updated: built}, nil
+}
+
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
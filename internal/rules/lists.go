@@ -0,0 +1,213 @@
+// oreon/defense · watchthelight <wtl>
+
+package rules
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxListSize caps a downloaded rule list. Anything bigger is almost
+// certainly not what we asked for.
+const maxListSize = 64 << 20
+
+// List is one of our own rule files (YARA rules or a hash list),
+// downloaded from a URL and installed at a fixed path.
+type List struct {
+	name   string
+	kind   string
+	url    string
+	path   string
+	client *http.Client
+
+	// Installed caches the hash until the file changes
+	mu      sync.Mutex
+	cached  Version
+	modTime time.Time
+	size    int64
+}
+
+// NewList creates a list source. A nil client uses http.DefaultClient.
+func NewList(name, kind, url, path string, client *http.Client) (*List, error) {
+	if kind != KindYARA && kind != KindHashes {
+		return nil, fmt.Errorf("rule source %s: unknown kind %q", name, kind)
+	}
+	if url == "" || path == "" {
+		return nil, fmt.Errorf("rule source %s: url and path are required", name)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &List{name: name, kind: kind, url: url, path: path, client: client}, nil
+}
+
+// Name returns the configured name.
+func (l *List) Name() string { return l.name }
+
+// Kind returns "yara" or "hashes".
+func (l *List) Kind() string { return l.kind }
+
+// Path returns where the list is installed.
+func (l *List) Path() string { return l.path }
+
+// Update downloads the list and installs it if the content changed.
+// Content that doesn't look like the expected kind is rejected so a
+// captive portal page never replaces good rules.
+func (l *List) Update(ctx context.Context) (Version, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.url, nil)
+	if err != nil {
+		return Version{}, err
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return Version{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Version{}, fmt.Errorf("download %s: %s", l.url, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxListSize+1))
+	if err != nil {
+		return Version{}, fmt.Errorf("download %s: %w", l.url, err)
+	}
+	if len(data) > maxListSize {
+		return Version{}, fmt.Errorf("download %s: larger than %d bytes", l.url, maxListSize)
+	}
+	if err := Validate(l.kind, data); err != nil {
+		return Version{}, fmt.Errorf("download %s: %w", l.url, err)
+	}
+
+	if cur, err := os.ReadFile(l.path); err == nil && bytes.Equal(cur, data) {
+		// still current: touch it so the age counts from this check, not
+		// from whenever the content last changed
+		now := time.Now()
+		if err := os.Chtimes(l.path, now, now); err != nil {
+			return Version{}, err
+		}
+		return l.Installed()
+	}
+	if err := WriteFileAtomic(l.path, data, 0o644); err != nil {
+		return Version{}, err
+	}
+	return l.Installed()
+}
+
+// Installed hashes the file on disk; its mtime is when we last wrote it or
+// found it up to date.
+func (l *List) Installed() (Version, error) {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return Version{}, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cached.ID != "" && info.ModTime().Equal(l.modTime) && info.Size() == l.size {
+		return l.cached, nil
+	}
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return Version{}, err
+	}
+	l.cached = Version{ID: contentID(data), Updated: info.ModTime()}
+	l.modTime, l.size = info.ModTime(), info.Size()
+	return l.cached, nil
+}
+
+// contentID is the short content hash used as a list's version.
+func contentID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+var yaraRule = regexp.MustCompile(`(?m)^\s*((private|global)\s+)*rule\s+[A-Za-z_]\w*`)
+
+// Validate checks that data looks like a rule list of the given kind:
+// at least one YARA rule, or a hash list where every entry starts with
+// an MD5, SHA-1 or SHA-256 hex digest.
+func Validate(kind string, data []byte) error {
+	switch kind {
+	case KindYARA:
+		if !yaraRule.Match(data) {
+			return errors.New("no YARA rules found")
+		}
+		return nil
+	case KindHashes:
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		line, entries := 0, 0
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			hash := strings.Fields(text)[0]
+			if !validHash(hash) {
+				return fmt.Errorf("line %d: %q is not a hash", line, hash)
+			}
+			entries++
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		if entries == 0 {
+			return errors.New("no hashes found")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown rule kind %q", kind)
+	}
+}
+
+func validHash(s string) bool {
+	switch len(s) {
+	case 32, 40, 64:
+		_, err := hex.DecodeString(s)
+		return err == nil
+	}
+	return false
+}
+
+// WriteFileAtomic writes data next to path and renames it into place, so
+// readers see either the old file or the new one.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
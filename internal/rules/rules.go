@@ -0,0 +1,306 @@
+// oreon/defense · watchthelight <wtl>
+
+// Package rules keeps detection content current: ClamAV signatures via
+// freshclam and clamd, plus our own YARA rules and hash lists.
+package rules
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/config"
+)
+
+// Source kinds.
+const (
+	KindClamAV = "clamav"
+	KindYARA   = "yara"
+	KindHashes = "hashes"
+)
+
+// ErrUpdateInProgress is returned when an update is already running.
+var ErrUpdateInProgress = errors.New("rules update already in progress")
+
+// Version identifies installed content and when it was built or written.
+type Version struct {
+	ID      string
+	Updated time.Time
+}
+
+// Source is something that can be updated.
+type Source interface {
+	Name() string
+	Kind() string
+	// Update fetches new content if there is any and returns what's
+	// installed afterwards.
+	Update(ctx context.Context) (Version, error)
+	// Installed reports what's on disk right now.
+	Installed() (Version, error)
+}
+
+// Status is the last known state of one source.
+type Status struct {
+	Name    string
+	Kind    string
+	Version string
+	Updated time.Time // zero if nothing is installed
+	Checked time.Time // last update attempt, zero if never
+	Error   string    // last update error
+}
+
+// Stale reports whether the source's content is older than maxAge.
+// Sources with nothing installed are always stale.
+func (s Status) Stale(now time.Time, maxAge time.Duration) bool {
+	return s.Updated.IsZero() || now.Sub(s.Updated) > maxAge
+}
+
+// Manager updates all sources and tracks their versions.
+type Manager struct {
+	sources  []Source
+	maxAge   time.Duration
+	interval time.Duration // 0 = no automatic updates
+	logger   *slog.Logger
+	onUpdate func(Status, error)
+
+	// offline bundles
+	keys      []ed25519.PublicKey
+	statePath string
+
+	mu       sync.Mutex
+	status   map[string]Status
+	updating bool
+}
+
+// Option configures a Manager.
+type Option func(*Manager)
+
+// WithLogger sets the logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(m *Manager) {
+		m.logger = logger
+	}
+}
+
+// WithUpdateHandler is called after each source finishes updating.
+func WithUpdateHandler(fn func(Status, error)) Option {
+	return func(m *Manager) {
+		m.onUpdate = fn
+	}
+}
+
+// WithSources replaces the sources built from config. Used by tests.
+func WithSources(sources ...Source) Option {
+	return func(m *Manager) {
+		m.sources = sources
+	}
+}
+
+// New builds the ClamAV source and one source per configured list.
+// runner and client may be nil for the real freshclam and HTTP.
+func New(cfg config.Rules, clamd Clamd, runner Runner, client *http.Client, opts ...Option) (*Manager, error) {
+	m := &Manager{
+		maxAge:   72 * time.Hour,
+		logger:   slog.Default(),
+		onUpdate: func(Status, error) {},
+		status:   make(map[string]Status),
+	}
+	if cfg.MaxAge != "" {
+		d, err := time.ParseDuration(cfg.MaxAge)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid rules max_age %q", cfg.MaxAge)
+		}
+		m.maxAge = d
+	}
+	if cfg.UpdateInterval != "" {
+		d, err := time.ParseDuration(cfg.UpdateInterval)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid rules update_interval %q", cfg.UpdateInterval)
+		}
+		m.interval = d
+	}
+
+	for _, k := range cfg.TrustedKeys {
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid trusted key %q", k)
+		}
+		m.keys = append(m.keys, ed25519.PublicKey(key))
+	}
+	m.statePath = cfg.StatePath
+
+	m.sources = append(m.sources, NewClamAV(cfg.FreshclamPath, cfg.DatabaseDir, clamd, runner))
+	seen := map[string]bool{KindClamAV: true}
+	for _, src := range cfg.Sources {
+		if seen[src.Name] {
+			return nil, fmt.Errorf("duplicate rule source %q", src.Name)
+		}
+		seen[src.Name] = true
+		l, err := NewList(src.Name, src.Kind, src.URL, src.Path, client)
+		if err != nil {
+			return nil, err
+		}
+		m.sources = append(m.sources, l)
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.Refresh()
+	return m, nil
+}
+
+// Refresh re-reads what each source has installed, e.g. after something
+// outside the manager (freshclam's own service) changed it.
+func (m *Manager) Refresh() {
+	for _, src := range m.sources {
+		v, _ := src.Installed()
+		m.mu.Lock()
+		st := m.status[src.Name()]
+		st.Name, st.Kind = src.Name(), src.Kind()
+		st.Version, st.Updated = v.ID, v.Updated
+		m.status[src.Name()] = st
+		m.mu.Unlock()
+	}
+}
+
+// Update updates every source in turn. One failing source doesn't stop
+// the others; their errors are joined.
+func (m *Manager) Update(ctx context.Context) error {
+	if !m.begin() {
+		return ErrUpdateInProgress
+	}
+	return m.run(ctx)
+}
+
+// Start kicks off Update in the background.
+func (m *Manager) Start(ctx context.Context) error {
+	if !m.begin() {
+		return ErrUpdateInProgress
+	}
+	go m.run(ctx)
+	return nil
+}
+
+func (m *Manager) begin() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.updating {
+		return false
+	}
+	m.updating = true
+	return true
+}
+
+func (m *Manager) run(ctx context.Context) error {
+	defer func() {
+		m.mu.Lock()
+		m.updating = false
+		m.mu.Unlock()
+	}()
+
+	var errs []error
+	for _, src := range m.sources {
+		v, err := src.Update(ctx)
+
+		m.mu.Lock()
+		st := m.status[src.Name()]
+		st.Name, st.Kind = src.Name(), src.Kind()
+		st.Checked = time.Now()
+		st.Error = ""
+		if err != nil {
+			st.Error = err.Error()
+			errs = append(errs, fmt.Errorf("%s: %w", src.Name(), err))
+		} else {
+			st.Version, st.Updated = v.ID, v.Updated
+		}
+		m.status[src.Name()] = st
+		m.mu.Unlock()
+
+		if err != nil {
+			m.logger.Warn("rules update failed", "source", src.Name(), "error", err)
+		} else {
+			m.logger.Info("rules updated", "source", src.Name(), "version", v.ID, "built", v.Updated)
+		}
+		m.onUpdate(st, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Updating reports whether an update is running.
+func (m *Manager) Updating() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.updating
+}
+
+// Status returns each source's state in configuration order.
+func (m *Manager) Status() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Status, 0, len(m.sources))
+	for _, src := range m.sources {
+		out = append(out, m.status[src.Name()])
+	}
+	return out
+}
+
+// MaxAge returns how old rules may get before they count as stale.
+func (m *Manager) MaxAge() time.Duration {
+	return m.maxAge
+}
+
+// Stale returns the names of sources older than MaxAge.
+func (m *Manager) Stale() []string {
+	now := time.Now()
+	var out []string
+	for _, st := range m.Status() {
+		if st.Stale(now, m.maxAge) {
+			out = append(out, st.Name)
+		}
+	}
+	return out
+}
+
+// Oldest returns the build time of the oldest installed content, ignoring
+// sources with nothing installed. Zero if nothing is installed at all.
+func (m *Manager) Oldest() time.Time {
+	var oldest time.Time
+	for _, st := range m.Status() {
+		if st.Updated.IsZero() {
+			continue
+		}
+		if oldest.IsZero() || st.Updated.Before(oldest) {
+			oldest = st.Updated
+		}
+	}
+	return oldest
+}
+
+// Run updates on the configured interval until ctx is cancelled, starting
+// with an immediate update if anything is already stale.
+func (m *Manager) Run(ctx context.Context) {
+	if m.interval == 0 {
+		return
+	}
+	if len(m.Stale()) > 0 {
+		m.Update(ctx)
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Update(ctx)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+// oreon/defense · watchthelight <wtl>
+
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/config"
+)
+
+// writeCVD writes a database with just a header.
+func writeCVD(t *testing.T, path, built, version string) {
+	t.Helper()
+	header := fmt.Sprintf("ClamAV-VDB:%s:%s:2055378:90:md5:dsig:builder:1707903032", built, version)
+	header += strings.Repeat(" ", 512-len(header))
+	if err := os.WriteFile(path, []byte(header+"body"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type fakeClamd struct {
+	reloads int
+	version string
+	err     error
+}
+
+func (f *fakeClamd) Reload() error {
+	f.reloads++
+	return f.err
+}
+
+func (f *fakeClamd) Version() (string, error) {
+	return f.version, f.err
+}
+
+type exitError int
+
+func (e exitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e exitError) ExitCode() int { return int(e) }
+
+type fakeRunner struct {
+	out  string
+	err  error
+	args [][]string
+}
+
+func (f *fakeRunner) Run(_ context.Context, name string, args ...string) ([]byte, error) {
+	f.args = append(f.args, append([]string{name}, args...))
+	return []byte(f.out), f.err
+}
+
+func TestClamAV_Installed(t *testing.T) {
+	dir := t.TempDir()
+	writeCVD(t, filepath.Join(dir, "daily.cvd"), "13 Feb 2024 09-30 +0000", "27184")
+	writeCVD(t, filepath.Join(dir, "daily.cld"), "14 Feb 2024 09-30 +0000", "27185")
+
+	v, err := NewClamAV("", dir, &fakeClamd{}, nil).Installed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2024, 2, 14, 9, 30, 0, 0, time.UTC)
+	if v.ID != "27185" || !v.Updated.Equal(want) {
+		t.Errorf("Installed() = %+v", v)
+	}
+
+	// no databases on disk: ask clamd
+	clamd := &fakeClamd{version: "ClamAV 1.0.5/27190/Wed Feb 14 09:30:32 2024"}
+	v, err = NewClamAV("", t.TempDir(), clamd, nil).Installed()
+	if err != nil || v.ID != "27190" {
+		t.Errorf("Installed() via clamd = %+v, %v", v, err)
+	}
+
+	clamd.err = errors.New("connection refused")
+	if _, err := NewClamAV("", t.TempDir(), clamd, nil).Installed(); err == nil {
+		t.Error("Installed() with nothing available should fail")
+	}
+}
+
+func TestClamAV_Update(t *testing.T) {
+	dir := t.TempDir()
+	writeCVD(t, filepath.Join(dir, "daily.cld"), "14 Feb 2024 09-30 +0000", "27185")
+
+	tests := []struct {
+		name        string
+		freshclam   string
+		runErr      error
+		out         string
+		wantErr     string
+		wantReloads int
+	}{
+		{name: "updated", freshclam: "/usr/bin/freshclam", wantReloads: 1},
+		{name: "up to date", freshclam: "/usr/bin/freshclam", runErr: exitError(1)},
+		{name: "failed", freshclam: "/usr/bin/freshclam", runErr: exitError(62), out: "ClamAV update process started\nERROR: Can't create freshclam.dat\n", wantErr: "Can't create freshclam.dat"},
+		{name: "reload only", wantReloads: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clamd := &fakeClamd{}
+			runner := &fakeRunner{out: tt.out, err: tt.runErr}
+			v, err := NewClamAV(tt.freshclam, dir, clamd, runner).Update(context.Background())
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Update() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+			if v.ID != "27185" {
+				t.Errorf("version = %q", v.ID)
+			}
+			if clamd.reloads != tt.wantReloads {
+				t.Errorf("reloads = %d, want %d", clamd.reloads, tt.wantReloads)
+			}
+			if tt.freshclam == "" && len(runner.args) != 0 {
+				t.Errorf("freshclam ran without being configured: %v", runner.args)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		kind string
+		data string
+		ok   bool
+	}{
+		{KindYARA, "rule Evil { condition: true }", true},
+		{KindYARA, "import \"pe\"\nprivate rule helper { condition: true }", true},
+		{KindYARA, "<html>captive portal</html>", false},
+		{KindHashes, "# sha256\n" + strings.Repeat("a", 64) + " EICAR\n" + strings.Repeat("b", 32) + "\n", true},
+		{KindHashes, strings.Repeat("a", 64) + "\n<html>\n", false},
+		{KindHashes, "# empty\n", false},
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.kind, []byte(tt.data)); (err == nil) != tt.ok {
+			t.Errorf("Validate(%s, %q) error = %v, want ok=%v", tt.kind, tt.data, err, tt.ok)
+		}
+	}
+}
+
+func TestList_Update(t *testing.T) {
+	var mu sync.Mutex
+	body := "rule One { condition: true }\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	setBody := func(s string) {
+		mu.Lock()
+		body = s
+		mu.Unlock()
+	}
+
+	path := filepath.Join(t.TempDir(), "rules", "oreon.yar")
+	l, err := NewList("oreon", KindYARA, srv.URL, path, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Installed(); err == nil {
+		t.Error("Installed() before first update should fail")
+	}
+
+	v1, err := l.Update(context.Background())
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != body {
+		t.Errorf("installed %q", got)
+	}
+
+	// same content: same version, but it counts as fresh again
+	old := time.Now().Add(-100 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	v2, err := l.Update(context.Background())
+	if err != nil || v2.ID != v1.ID {
+		t.Errorf("unchanged update = %+v, %v; want ID %s", v2, err, v1.ID)
+	}
+	if st := (Status{Updated: v2.Updated}); st.Stale(time.Now(), time.Hour) {
+		t.Errorf("unchanged list still stale, updated %v", v2.Updated)
+	}
+
+	// garbage is rejected and the old rules stay
+	setBody("<html>sign in to the wifi</html>")
+	if _, err := l.Update(context.Background()); err == nil {
+		t.Error("Update() accepted non-YARA content")
+	}
+	if v, _ := l.Installed(); v.ID != v1.ID {
+		t.Error("rejected download replaced the installed rules")
+	}
+
+	setBody("rule One { condition: true }\nrule Two { condition: false }\n")
+	v3, err := l.Update(context.Background())
+	if err != nil || v3.ID == v1.ID {
+		t.Errorf("changed update = %+v, %v", v3, err)
+	}
+
+	if _, err := NewList("x", "sigma", srv.URL, path, nil); err == nil {
+		t.Error("NewList accepted an unknown kind")
+	}
+}
+
+// fakeSource is a Source with canned results.
+type fakeSource struct {
+	name    string
+	version Version
+	err     error
+	block   chan struct{}
+}
+
+func (f *fakeSource) Name() string                { return f.name }
+func (f *fakeSource) Kind() string                { return KindHashes }
+func (f *fakeSource) Installed() (Version, error) { return f.version, nil }
+
+func (f *fakeSource) Update(context.Context) (Version, error) {
+	if f.block != nil {
+		<-f.block
+	}
+	return f.version, f.err
+}
+
+func TestManager(t *testing.T) {
+	now := time.Now()
+	fresh := &fakeSource{name: "fresh", version: Version{ID: "2", Updated: now.Add(-time.Hour)}}
+	old := &fakeSource{name: "old", version: Version{ID: "1", Updated: now.Add(-100 * time.Hour)}}
+	broken := &fakeSource{name: "broken", err: errors.New("download failed")}
+
+	var updates []string
+	m, err := New(config.Rules{MaxAge: "72h"}, &fakeClamd{}, nil, nil,
+		WithSources(fresh, old, broken),
+		WithUpdateHandler(func(st Status, err error) {
+			updates = append(updates, st.Name)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(m.Stale(), ","); got != "old,broken" {
+		t.Errorf("Stale() = %s, want old,broken", got)
+	}
+	if !m.Oldest().Equal(old.version.Updated) {
+		t.Errorf("Oldest() = %v", m.Oldest())
+	}
+
+	err = m.Update(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "broken: download failed") {
+		t.Errorf("Update() error = %v", err)
+	}
+	if strings.Join(updates, ",") != "fresh,old,broken" {
+		t.Errorf("update handler saw %v", updates)
+	}
+	st := m.Status()
+	if st[0].Version != "2" || st[0].Checked.IsZero() || st[0].Error != "" {
+		t.Errorf("fresh status = %+v", st[0])
+	}
+	if st[2].Error != "download failed" {
+		t.Errorf("broken status = %+v", st[2])
+	}
+}
+
+func TestManager_SingleUpdate(t *testing.T) {
+	src := &fakeSource{name: "slow", block: make(chan struct{})}
+	m, err := New(config.Rules{}, &fakeClamd{}, nil, nil, WithSources(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if !m.Updating() {
+		t.Error("Updating() = false during update")
+	}
+	if err := m.Update(context.Background()); !errors.Is(err, ErrUpdateInProgress) {
+		t.Errorf("second Update() error = %v, want ErrUpdateInProgress", err)
+	}
+	close(src.block)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for m.Updating() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if m.Updating() {
+		t.Error("update never finished")
+	}
+}
+
+func TestNew_Config(t *testing.T) {
+	clamd := &fakeClamd{}
+	for name, cfg := range map[string]config.Rules{
+		"bad max age":  {MaxAge: "old"},
+		"bad interval": {UpdateInterval: "-1h"},
+		"bad kind":     {Sources: []config.RuleSource{{Name: "x", Kind: "sigma", URL: "http://x", Path: "/x"}}},
+		"duplicate": {Sources: []config.RuleSource{
+			{Name: "x", Kind: KindYARA, URL: "http://x", Path: "/x"},
+			{Name: "x", Kind: KindHashes, URL: "http://y", Path: "/y"},
+		}},
+	} {
+		if _, err := New(cfg, clamd, nil, nil); err == nil {
+			t.Errorf("%s: New() should fail", name)
+		}
+	}
+}
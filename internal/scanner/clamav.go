@@ -8,6 +8,7 @@ import (
 	"net"
 	"os"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -98,8 +99,55 @@ func (c *ClamAV) ScanFile(path string) *ScanResult {
 		result.Error = fmt.Errorf("read scan response: %w", err)
 		return result
 	}
+	parseScanResponse(result, response)
+	return result
+}
+
+// ScanFD scans an open file by handing clamd the descriptor, so what gets
+// scanned is what the caller opened, not whatever the path points at by
+// the time clamd gets to it. path is only used in the result.
+func (c *ClamAV) ScanFD(f *os.File, path string) *ScanResult {
+	result := &ScanResult{
+		Path:      path,
+		ScannedAt: time.Now(),
+	}
+
+	conn, err := net.DialTimeout("unix", c.socketPath, 5*time.Second)
+	if err != nil {
+		result.Error = fmt.Errorf("connect to clamd: %w", err)
+		return result
+	}
+	defer conn.Close()
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		result.Error = fmt.Errorf("clamd socket is not a unix socket")
+		return result
+	}
+	uc.SetDeadline(time.Now().Add(60 * time.Second))
+
+	// like clamdscan: the command, then one byte carrying the descriptor
+	if _, err := uc.Write([]byte("zFILDES\x00")); err != nil {
+		result.Error = fmt.Errorf("send FILDES command: %w", err)
+		return result
+	}
+	if _, _, err := uc.WriteMsgUnix([]byte{0}, syscall.UnixRights(int(f.Fd())), nil); err != nil {
+		result.Error = fmt.Errorf("pass descriptor: %w", err)
+		return result
+	}
 
-	// Parse response: "/path: OK" or "/path: ThreatName FOUND"
+	// z-commands are answered with a NUL-terminated "fd[N]: ..." line
+	response, err := bufio.NewReader(uc).ReadString(0)
+	if err != nil && response == "" {
+		result.Error = fmt.Errorf("read scan response: %w", err)
+		return result
+	}
+	parseScanResponse(result, strings.TrimRight(response, "\x00"))
+	return result
+}
+
+// parseScanResponse fills in result from clamd's answer: "<name>: OK",
+// "<name>: ThreatName FOUND" or an error.
+func parseScanResponse(result *ScanResult, response string) {
 	response = strings.TrimSpace(response)
 	if strings.HasSuffix(response, " OK") {
 		result.Clean = true
@@ -114,6 +162,43 @@ func (c *ClamAV) ScanFile(path string) *ScanResult {
 	} else if strings.Contains(response, "ERROR") {
 		result.Error = fmt.Errorf("clamd error: %s", response)
 	}
+}
 
-	return result
+// Reload asks clamd to reload its signature databases. clamd answers
+// right away and reloads in the background.
+func (c *ClamAV) Reload() error {
+	resp, err := c.command("RELOAD")
+	if err != nil {
+		return err
+	}
+	if resp != "RELOADING" {
+		return fmt.Errorf("unexpected response: %s", resp)
+	}
+	return nil
+}
+
+// Version returns clamd's version line, e.g.
+// "ClamAV 1.0.5/27185/Wed Feb 14 09:30:32 2024".
+func (c *ClamAV) Version() (string, error) {
+	return c.command("VERSION")
+}
+
+// command sends a one-line command and returns the one-line reply.
+func (c *ClamAV) command(cmd string) (string, error) {
+	conn, err := net.DialTimeout("unix", c.socketPath, 5*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+		return "", fmt.Errorf("send %s: %w", cmd, err)
+	}
+	response, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("read response: %w", err)
+	}
+	return strings.TrimSpace(response), nil
 }
@@ -4,9 +4,12 @@ package scanner
 
 import (
 	"bufio"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -158,6 +161,75 @@ func TestScanFile_Error(t *testing.T) {
 	}
 }
 
+// recvFD reads a z-command and the descriptor passed with it, the way
+// clamd does for FILDES.
+func recvFD(conn net.Conn) (string, *os.File) {
+	uc := conn.(*net.UnixConn)
+	var data []byte
+	buf, oob := make([]byte, 64), make([]byte, syscall.CmsgSpace(4))
+	for {
+		n, oobn, _, _, err := uc.ReadMsgUnix(buf, oob)
+		if err != nil {
+			return string(data), nil
+		}
+		data = append(data, buf[:n]...)
+		if oobn == 0 {
+			continue
+		}
+		msgs, _ := syscall.ParseSocketControlMessage(oob[:oobn])
+		if len(msgs) == 0 {
+			return string(data), nil
+		}
+		fds, _ := syscall.ParseUnixRights(&msgs[0])
+		if len(fds) == 0 {
+			return string(data), nil
+		}
+		cmd, _, _ := strings.Cut(string(data), "\x00")
+		return cmd, os.NewFile(uintptr(fds[0]), "passed")
+	}
+}
+
+func TestScanFD(t *testing.T) {
+	sockPath, cleanup := mockClamdServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		cmd, f := recvFD(conn)
+		if cmd != "zFILDES" || f == nil {
+			conn.Write([]byte("UNKNOWN COMMAND\x00"))
+			return
+		}
+		defer f.Close()
+		data, _ := io.ReadAll(f)
+		if strings.Contains(string(data), "EICAR") {
+			conn.Write([]byte("fd[10]: Eicar-Test-Signature FOUND\x00"))
+		} else {
+			conn.Write([]byte("fd[10]: OK\x00"))
+		}
+	})
+	defer cleanup()
+
+	dir := t.TempDir()
+	scanner := New(sockPath)
+	for name, want := range map[string]string{"clean.txt": "", "bad.txt": "Eicar-Test-Signature"} {
+		path := filepath.Join(dir, name)
+		content := "hello"
+		if want != "" {
+			content = "EICAR"
+		}
+		os.WriteFile(path, []byte(content), 0o644)
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// once opened, the path no longer matters
+		os.Remove(path)
+		result := scanner.ScanFD(f, path)
+		f.Close()
+		if result.Error != nil || result.Clean != (want == "") || result.Threat != want || result.Path != path {
+			t.Errorf("ScanFD(%s) = %+v, want threat %q", name, result, want)
+		}
+	}
+}
+
 func TestScanFile_ConnectionError(t *testing.T) {
 	scanner := New("/nonexistent/socket.sock")
 	result := scanner.ScanFile("/some/file")
@@ -166,3 +238,27 @@ func TestScanFile_ConnectionError(t *testing.T) {
 		t.Error("ScanFile() should return error when can't connect")
 	}
 }
+
+func TestReloadAndVersion(t *testing.T) {
+	sockPath, cleanup := mockClamdServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		cmd, _ := reader.ReadString('\n')
+		switch cmd {
+		case "RELOAD\n":
+			conn.Write([]byte("RELOADING\n"))
+		case "VERSION\n":
+			conn.Write([]byte("ClamAV 1.0.5/27185/Wed Feb 14 09:30:32 2024\n"))
+		}
+	})
+	defer cleanup()
+
+	scanner := New(sockPath)
+	if err := scanner.Reload(); err != nil {
+		t.Errorf("Reload() error = %v", err)
+	}
+	v, err := scanner.Version()
+	if err != nil || v != "ClamAV 1.0.5/27185/Wed Feb 14 09:30:32 2024" {
+		t.Errorf("Version() = %q, %v", v, err)
+	}
+}
@@ -2,10 +2,16 @@ package tray
 
 import (
 	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/energye/systray"
 )
 
+// rulesUpdateTimeout is how long we wait on a rules update before giving up
+// on telling the user how it went.
+const rulesUpdateTimeout = 15 * time.Minute
+
 // menu represents the system tray menu structure
 type menu struct {
 	tray *Tray
@@ -93,7 +99,9 @@ func (m *menu) setupHandlers() {
 func (m *menu) handleQuickScan() {
 	m.tray.setIcon("scanning")
 	go func() {
-		_, err := m.tray.client.StartQuickScan()
+		ctx, cancel := m.tray.request()
+		defer cancel()
+		_, err := m.tray.client.StartQuickScan(ctx)
 		if err != nil {
 			m.tray.setIcon("warning")
 			m.tray.showNotification(None, "Scan Failed", "Failed to start quick scan: "+err.Error())
@@ -108,7 +116,9 @@ func (m *menu) handleQuickScan() {
 func (m *menu) handleFullScan() {
 	m.tray.setIcon("scanning")
 	go func() {
-		_, err := m.tray.client.StartFullScan()
+		ctx, cancel := m.tray.request()
+		defer cancel()
+		_, err := m.tray.client.StartFullScan(ctx)
 		if err != nil {
 			m.tray.setIcon("warning")
 			m.tray.showNotification(None, "Scan Failed", "Failed to start full scan: "+err.Error())
@@ -118,13 +128,43 @@ func (m *menu) handleFullScan() {
 	}()
 }
 func (m *menu) handleUpdateRules() {
-	// TODO: Implement rules update
 	go func() {
-		// err := m.tray.client.UpdateRules()
-		// if err != nil {
-		// 	m.tray.showNotification("Update Failed", "Failed to update security rules", true)
-		// 	return
-		// }
+		ctx, cancel := m.tray.request()
+		defer cancel()
+		status, err := m.tray.client.UpdateRules(ctx)
+		if err != nil {
+			m.tray.showNotification(None, "Update Failed", "Failed to start rules update: "+err.Error())
+			return
+		}
+		m.tray.showNotification(None, "Updating Rules", "Downloading the latest security rules...")
+
+		// freshclam can take a while on a slow link
+		deadline := time.Now().Add(rulesUpdateTimeout)
+		for status.Updating && time.Now().Before(deadline) {
+			time.Sleep(2 * time.Second)
+			ctx, cancel := m.tray.request()
+			status, err = m.tray.client.RulesStatus(ctx)
+			cancel()
+			if err != nil {
+				m.tray.showNotification(None, "Update Failed", "Lost contact with the daemon: "+err.Error())
+				return
+			}
+		}
+		if status.Updating {
+			m.tray.showNotification(None, "Update Still Running", "The rules update is taking longer than expected")
+			return
+		}
+
+		var failed []string
+		for _, src := range status.Sources {
+			if src.Error != "" {
+				failed = append(failed, src.Name+": "+src.Error)
+			}
+		}
+		if len(failed) > 0 {
+			m.tray.showNotification(None, "Update Failed", strings.Join(failed, "\n"))
+			return
+		}
 		m.tray.showNotification(None, "Rules Updated", "Security rules have been updated successfully")
 	}()
 }
@@ -132,7 +172,9 @@ func (m *menu) handleUpdateRules() {
 func (m *menu) handlePause(duration string) {
 	// Check if we're resuming
 	if m.isPaused {
-		err := m.tray.client.Resume()
+		ctx, cancel := m.tray.request()
+		defer cancel()
+		err := m.tray.client.Resume(ctx)
 		if err != nil {
 			m.tray.showNotification(None, "Error", "Failed to resume protection: "+err.Error())
 			return
@@ -144,7 +186,9 @@ func (m *menu) handlePause(duration string) {
 	}
 
 	// Pause protection
-	err := m.tray.client.Pause()
+	ctx, cancel := m.tray.request()
+	defer cancel()
+	err := m.tray.client.Pause(ctx, duration)
 	if err != nil {
 		m.tray.showNotification(None, "Error", "Failed to pause protection: "+err.Error())
 		return
@@ -165,7 +209,9 @@ func (m *menu) handlePause(duration string) {
 
 // syncStateWithDaemon queries the daemon and updates menu state to match
 func (m *menu) syncStateWithDaemon() {
-	status, err := m.tray.client.Status()
+	ctx, cancel := m.tray.request()
+	defer cancel()
+	status, err := m.tray.client.Status(ctx)
 	if err != nil {
 		// Can't reach daemon, leave defaults
 		return
@@ -193,7 +239,9 @@ func (m *menu) syncStateWithDaemon() {
 func (m *menu) handleFirewallToggle() {
 	newState := !m.firewallItem.Checked()
 
-	err := m.tray.client.SetFirewallEnabled(newState)
+	ctx, cancel := m.tray.request()
+	defer cancel()
+	err := m.tray.client.SetFirewallEnabled(ctx, newState)
 	if err != nil {
 		m.tray.showNotification(None, "Error", "Failed to toggle firewall: "+err.Error())
 		return
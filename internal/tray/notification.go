@@ -24,6 +24,7 @@ const (
 	NotificationRulesOutdated    NotificationType = "rules_outdated"
 	NotificationScanComplete     NotificationType = "scan_complete"
 	NotificationThreatBlocked    NotificationType = "threat_blocked"
+	NotificationThreatFound      NotificationType = "threat_found" // from ScanPaths, which doesn't wait for clicks
 	NotificationStateChange      NotificationType = "state_change"
 )
 
@@ -70,6 +71,8 @@ func (t *Tray) showNotification(notificationType NotificationType, title, messag
 			{Key: "view_results", Label: "View Results"},
 			{Key: "dismiss", Label: "Dismiss"},
 		}
+	case NotificationThreatFound:
+		// Critical, but nobody is listening for actions
 	case NotificationStateChange:
 		// No actions for state change notifications
 		n.ExpireTimeout = 5 * time.Second
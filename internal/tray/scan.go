@@ -0,0 +1,132 @@
+// oreon/defense · watchthelight <wtl>
+
+package tray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+// scanStatusPoll is how often ScanPaths asks for the job's status in case
+// a progress notification got lost (e.g. the watch reconnected).
+const scanStatusPoll = 5 * time.Second
+
+// ScanPaths is the file manager's "Scan with Oreon Defense": it asks the
+// daemon to scan paths, writes progress to out while it runs and shows
+// the verdict as a desktop notification. Cancelling ctx cancels the scan.
+func ScanPaths(ctx context.Context, client ipc.Client, paths []string, out io.Writer) (*ipc.ScanStatusResponse, error) {
+	t := New(client)
+	t.connectNotifier(nil)
+	if t.notifier != nil {
+		defer t.notifier.Close()
+	}
+
+	st, err := t.scanPaths(ctx, paths, out)
+	switch {
+	case errors.Is(err, context.Canceled):
+		// the user stopped it, they don't need telling
+	case err != nil:
+		t.showNotification(None, "Scan failed", err.Error())
+	default:
+		t.showNotification(scanVerdict(st))
+	}
+	return st, err
+}
+
+func (t *Tray) scanPaths(ctx context.Context, paths []string, out io.Writer) (*ipc.ScanStatusResponse, error) {
+	params := ipc.ScanParams{}
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		params.Paths = append(params.Paths, abs)
+	}
+
+	// watch first so no progress is missed between starting and following
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	defer stopWatch()
+	notes, err := t.client.Watch(watchCtx, ipc.WatchParams{Kinds: []string{ipc.KindScanProgress, ipc.KindThreat}})
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := t.client.Scan(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "scanning %s (%s)\n", strings.Join(params.Paths, ", "), st.JobID)
+
+	poll := time.NewTicker(scanStatusPoll)
+	defer poll.Stop()
+	for st.Status == "running" {
+		refresh := false
+		select {
+		case n, ok := <-notes:
+			if !ok {
+				notes = nil // watch gave up, keep polling
+				break
+			}
+			switch p := n.Payload.(type) {
+			case *ipc.ScanProgressEvent:
+				if p.JobID == st.JobID {
+					fmt.Fprintf(out, "%d files scanned, %d threats\n", p.FilesScanned, p.ThreatsFound)
+					refresh = p.Status != "running"
+				}
+			case *ipc.ThreatEvent:
+				if p.JobID == st.JobID {
+					fmt.Fprintf(out, "%s: %s (%s)\n", p.Path, p.Threat, p.Action)
+				}
+			}
+		case <-poll.C:
+			refresh = true
+		case <-ctx.Done():
+			cancelCtx, cancel := t.request()
+			err := t.client.CancelScan(cancelCtx, st.JobID)
+			cancel()
+			if err != nil {
+				// still running, so don't pass it off as stopped
+				return st, fmt.Errorf("cancel scan %s: %w", st.JobID, err)
+			}
+			return st, ctx.Err()
+		}
+		if refresh {
+			reqCtx, cancel := t.request()
+			latest, err := t.client.ScanStatus(reqCtx, st.JobID)
+			cancel()
+			if err != nil {
+				return st, err
+			}
+			st = latest
+		}
+	}
+
+	if st.Status == "failed" {
+		return st, fmt.Errorf("scan failed: %s", st.Error)
+	}
+	fmt.Fprintf(out, "%s: %d files scanned, %d threats\n", st.Status, st.FilesScanned, st.ThreatsFound)
+	return st, nil
+}
+
+// scanVerdict is the notification for a finished scan.
+func scanVerdict(st *ipc.ScanStatusResponse) (NotificationType, string, string) {
+	switch {
+	case st.ThreatsFound == 1 && len(st.Threats) == 1:
+		t := st.Threats[0]
+		return NotificationThreatFound, "Threat found",
+			fmt.Sprintf("%s: %s (%s)", filepath.Base(t.Path), t.Threat, t.Action)
+	case st.ThreatsFound > 1:
+		return NotificationThreatFound, "Threats found",
+			fmt.Sprintf("%d infected files found in %d scanned", st.ThreatsFound, st.FilesScanned)
+	case st.Status == "cancelled":
+		return None, "Scan cancelled", fmt.Sprintf("%d files scanned before it stopped", st.FilesScanned)
+	}
+	return None, "No threats found", fmt.Sprintf("%d files scanned", st.FilesScanned)
+}
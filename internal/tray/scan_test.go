@@ -0,0 +1,83 @@
+// oreon/defense · watchthelight <wtl>
+
+package tray
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oreonproject/defense/pkg/ipc"
+)
+
+func TestTray_scanPaths(t *testing.T) {
+	final := &ipc.ScanStatusResponse{
+		JobID:        "custom-test",
+		Type:         "custom",
+		Status:       "completed",
+		FilesScanned: 2,
+		ThreatsFound: 1,
+		Threats:      []ipc.ScanThreat{{Path: "/home/u/bad.exe", Threat: "Eicar-Test-Signature", Action: "detected"}},
+	}
+	client := &mockClient{notes: make(chan ipc.Notification, 4), scanStatus: final}
+	client.notes <- ipc.Notification{Kind: ipc.KindScanProgress, Payload: &ipc.ScanProgressEvent{JobID: "other", Status: "completed"}}
+	client.notes <- ipc.Notification{Kind: ipc.KindThreat, Payload: &ipc.ThreatEvent{JobID: "custom-test", Path: "/home/u/bad.exe", Threat: "Eicar-Test-Signature", Action: "detected"}}
+	client.notes <- ipc.Notification{Kind: ipc.KindScanProgress, Payload: &ipc.ScanProgressEvent{JobID: "custom-test", Status: "completed", FilesScanned: 2, ThreatsFound: 1}}
+
+	var out bytes.Buffer
+	st, err := New(client).scanPaths(t.Context(), []string{"relative.txt"}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != final {
+		t.Errorf("status = %+v", st)
+	}
+	if p := client.scanParams.Paths; len(p) != 1 || !filepath.IsAbs(p[0]) || client.scanParams.Follow {
+		t.Errorf("scan params = %+v", client.scanParams)
+	}
+	for _, want := range []string{"/home/u/bad.exe: Eicar-Test-Signature (detected)", "2 files scanned, 1 threats", "completed"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	kind, title, _ := scanVerdict(st)
+	if kind != NotificationThreatFound || title != "Threat found" {
+		t.Errorf("verdict = %s %q", kind, title)
+	}
+	if kind, title, _ = scanVerdict(&ipc.ScanStatusResponse{Status: "completed", FilesScanned: 3}); kind != None || title != "No threats found" {
+		t.Errorf("clean verdict = %s %q", kind, title)
+	}
+}
+
+func TestTray_scanPathsFailed(t *testing.T) {
+	client := &mockClient{
+		notes:      make(chan ipc.Notification, 1),
+		scanStatus: &ipc.ScanStatusResponse{JobID: "custom-test", Status: "failed", Error: "ClamAV not available"},
+	}
+	client.notes <- ipc.Notification{Kind: ipc.KindScanProgress, Payload: &ipc.ScanProgressEvent{JobID: "custom-test", Status: "failed"}}
+
+	_, err := New(client).scanPaths(t.Context(), []string{"/tmp"}, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "ClamAV not available") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestTray_scanPathsCancel(t *testing.T) {
+	client := &mockClient{}
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := New(client).scanPaths(ctx, []string{"/tmp"}, &bytes.Buffer{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+
+	// a scan the daemon wouldn't stop is still running, so say so
+	client.cancelErr = &ipc.Error{Code: ipc.CodePermissionDenied, Message: "scan_cancel needs root"}
+	_, err := New(client).scanPaths(ctx, []string{"/tmp"}, &bytes.Buffer{})
+	if err == nil || errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "needs root") {
+		t.Errorf("error = %v, want the cancel failure", err)
+	}
+}
@@ -2,6 +2,7 @@
 package tray
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 	"os"
@@ -30,6 +31,10 @@ type Tray struct {
 	currentState string
 }
 
+// requestTimeout bounds each call to the daemon, so a hung daemon can't
+// freeze the menu. Long enough for a polkit password prompt.
+const requestTimeout = 30 * time.Second
+
 // New creates a new Tray instance
 func New(client ipc.Client) *Tray {
 	return &Tray{client: client}
@@ -50,14 +55,13 @@ func (t *Tray) Run() error {
 // onReady is called when the system tray is ready
 func (t *Tray) onReady() {
 	// Initialize D-Bus notifier
-	conn, err := dbus.SessionBus()
 	onAction := func(action *notify.ActionInvokedSignal) {
 		slog.Debug("notification action invoked", "action", action.ActionKey)
 		switch action.ActionKey {
 		case "enable":
 			t.executeOrder66("defense-ui", []string{"--enable-firewall"})
 		case "update":
-			t.executeOrder66("defense-ui", []string{"--update-rules"})
+			t.menu.handleUpdateRules()
 		case "details":
 			t.executeOrder66("defense-ui", []string{"--show-threats"})
 		case "view_results":
@@ -67,14 +71,7 @@ func (t *Tray) onReady() {
 		// 	t.notifier.CloseNotification(uint32(id))
 		}
 	}
-	if err != nil {
-		slog.Error("failed to connect to session bus", "error", err)
-	} else {
-		t.notifier, err = notify.New(conn,notify.WithOnAction(onAction))
-		if err != nil {
-			slog.Error("failed to create notifier", "error", err)
-		}
-	}
+	t.connectNotifier(onAction)
 
 	// Load icons
 	t.loadIcons()
@@ -93,6 +90,23 @@ func (t *Tray) onReady() {
 	t.showNotification(NotificationStateChange, "Oreon Defense", "Protection is now active")
 }
 
+// connectNotifier sets up desktop notifications on the session bus.
+// onAction may be nil for callers that don't stay around for clicks.
+func (t *Tray) connectNotifier(onAction notify.ActionInvokedHandler) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		slog.Error("failed to connect to session bus", "error", err)
+		return
+	}
+	if onAction == nil {
+		onAction = func(*notify.ActionInvokedSignal) {}
+	}
+	t.notifier, err = notify.New(conn, notify.WithOnAction(onAction))
+	if err != nil {
+		slog.Error("failed to create notifier", "error", err)
+	}
+}
+
 // onExit is called when the system tray is exiting
 func (t *Tray) onExit() {
 	// Cleanup resources if needed
@@ -148,41 +162,57 @@ func (t *Tray) loadIcons() {
 	t.iconPaused = loadIcon("paused")
 }
 
-// monitorStatus subscribes to state changes and updates the UI.
-// Falls back to polling if subscription fails.
+// monitorStatus follows state changes and updates the UI. The watch
+// reconnects on its own once established; until the daemon is reachable
+// we poll.
 func (t *Tray) monitorStatus() {
-	// Try to subscribe for push events
-	events, err := t.client.Subscribe()
-	if err != nil {
-		slog.Warn("subscription failed, falling back to polling", "error", err)
-		t.pollStatus()
-		return
+	for {
+		notes, err := t.client.Watch(context.Background(), ipc.WatchParams{Kinds: []string{ipc.KindStateChange}})
+		if err == nil {
+			slog.Info("watching daemon state changes")
+			t.followState(notes)
+			return
+		}
+		slog.Warn("watch failed, polling until the daemon is up", "error", err)
+		t.refreshStatus()
+		time.Sleep(2 * time.Second)
 	}
+}
 
-	slog.Info("subscribed to daemon state changes")
-
-	for event := range events {
-		t.setIcon(event.NewState)
+// followState applies notifications until the client is closed.
+func (t *Tray) followState(notes <-chan ipc.Notification) {
+	for n := range notes {
+		switch p := n.Payload.(type) {
+		case *ipc.StateChangeEvent:
+			t.setIcon(p.NewState)
+		case *ipc.WatchResponse:
+			// replayed changes follow, but the icon was set to warning
+			// while disconnected
+			t.refreshStatus()
+		default:
+			if n.Kind == ipc.KindDisconnected {
+				slog.Warn("lost connection to daemon, reconnecting", "error", n.Payload)
+				t.setIcon("warning")
+			}
+		}
 	}
-
-	// Subscription ended (connection closed), fall back to polling
-	slog.Warn("subscription ended, falling back to polling")
-	t.pollStatus()
 }
 
-// pollStatus periodically checks the system status (fallback mode).
-func (t *Tray) pollStatus() {
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+// request returns the context for one call to the daemon.
+func (t *Tray) request() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), requestTimeout)
+}
 
-	for range ticker.C {
-		status, err := t.client.Status()
-		if err != nil {
-			t.setIcon("warning")
-			continue
-		}
-		t.setIcon(status.State)
+// refreshStatus sets the icon from the daemon's current status.
+func (t *Tray) refreshStatus() {
+	ctx, cancel := t.request()
+	defer cancel()
+	status, err := t.client.Status(ctx)
+	if err != nil {
+		t.setIcon("warning")
+		return
 	}
+	t.setIcon(status.State)
 }
 
 // showNotification displays a desktop notification
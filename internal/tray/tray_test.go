@@ -3,6 +3,7 @@
 package tray
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -15,9 +16,17 @@ type mockClient struct {
 	statusErr       error
 	firewallEnabled bool
 	events          chan ipc.StateChangeEvent
+	notes           chan ipc.Notification
+	scanParams      ipc.ScanParams
+	scanStatus      *ipc.ScanStatusResponse // what ScanStatus returns, if set
+	cancelErr       error
 }
 
-func (m *mockClient) Status() (*ipc.StatusResponse, error) {
+func (m *mockClient) Hello(context.Context, string) (*ipc.HelloResponse, error) {
+	return &ipc.HelloResponse{Protocol: ipc.ProtocolVersion}, nil
+}
+
+func (m *mockClient) Status(_ context.Context) (*ipc.StatusResponse, error) {
 	if m.statusErr != nil {
 		return nil, m.statusErr
 	}
@@ -27,37 +36,119 @@ func (m *mockClient) Status() (*ipc.StatusResponse, error) {
 	}, nil
 }
 
-func (m *mockClient) GetProtectionStatus() (bool, error) {
+func (m *mockClient) GetProtectionStatus(_ context.Context) (bool, error) {
 	return m.statusState == "protected", nil
 }
 
-func (m *mockClient) SetFirewallEnabled(enabled bool) error {
+func (m *mockClient) SetFirewallEnabled(_ context.Context, enabled bool) error {
 	m.firewallEnabled = enabled
 	return nil
 }
 
-func (m *mockClient) IsFirewallEnabled() (bool, error) {
+func (m *mockClient) IsFirewallEnabled(_ context.Context) (bool, error) {
 	return m.firewallEnabled, nil
 }
 
-func (m *mockClient) StartQuickScan() (*ipc.ScanResponse, error) {
+func (m *mockClient) FirewallBlocked(_ context.Context, params ipc.FirewallBlockedParams) (*ipc.FirewallBlockedResponse, error) {
+	return &ipc.FirewallBlockedResponse{}, nil
+}
+
+func (m *mockClient) Bans(_ context.Context) ([]ipc.BanInfo, error)   { return nil, nil }
+func (m *mockClient) LiftBan(_ context.Context, address string) error { return nil }
+
+func (m *mockClient) SetFirewallPanic(_ context.Context, enabled bool) error { return nil }
+
+func (m *mockClient) AppRules(_ context.Context) (*ipc.AppRulesResponse, error) {
+	return &ipc.AppRulesResponse{}, nil
+}
+
+func (m *mockClient) AppLearned(_ context.Context) (*ipc.AppLearnedResponse, error) {
+	return &ipc.AppLearnedResponse{}, nil
+}
+
+func (m *mockClient) SetAppLearning(_ context.Context, enabled bool) error { return nil }
+
+func (m *mockClient) NetInventory(_ context.Context, params ipc.NetInventoryParams) (*ipc.NetInventoryResponse, error) {
+	return &ipc.NetInventoryResponse{}, nil
+}
+
+func (m *mockClient) RulesStatus(_ context.Context) (*ipc.RulesStatusResponse, error) {
+	return &ipc.RulesStatusResponse{}, nil
+}
+
+func (m *mockClient) UpdateRules(_ context.Context) (*ipc.RulesStatusResponse, error) {
+	return &ipc.RulesStatusResponse{Updating: true}, nil
+}
+
+func (m *mockClient) ImportRules(_ context.Context, path string) (*ipc.RulesImportResponse, error) {
+	return &ipc.RulesImportResponse{}, nil
+}
+
+func (m *mockClient) Scan(_ context.Context, params ipc.ScanParams) (*ipc.ScanStatusResponse, error) {
+	m.scanParams = params
+	return &ipc.ScanStatusResponse{JobID: "custom-test", Type: "custom", Status: "running"}, nil
+}
+
+func (m *mockClient) StartQuickScan(_ context.Context) (*ipc.ScanResponse, error) {
 	return &ipc.ScanResponse{JobID: "quick-test"}, nil
 }
 
-func (m *mockClient) StartFullScan() (*ipc.ScanResponse, error) {
+func (m *mockClient) StartFullScan(_ context.Context) (*ipc.ScanResponse, error) {
 	return &ipc.ScanResponse{JobID: "full-test"}, nil
 }
 
-func (m *mockClient) Pause() error  { return nil }
-func (m *mockClient) Resume() error { return nil }
+func (m *mockClient) ScanStatus(_ context.Context, jobID string) (*ipc.ScanStatusResponse, error) {
+	if m.scanStatus != nil {
+		return m.scanStatus, nil
+	}
+	return &ipc.ScanStatusResponse{JobID: jobID, Status: "completed"}, nil
+}
+
+func (m *mockClient) CancelScan(_ context.Context, jobID string) error {
+	return m.cancelErr
+}
 
-func (m *mockClient) Subscribe() (<-chan ipc.StateChangeEvent, error) {
+func (m *mockClient) ScanHistory(_ context.Context, limit int) ([]ipc.ScanStatusResponse, error) {
+	return nil, nil
+}
+
+func (m *mockClient) Quarantine(_ context.Context) ([]ipc.QuarantineItem, error) {
+	return nil, nil
+}
+
+func (m *mockClient) RestoreQuarantined(_ context.Context, id string) error {
+	return nil
+}
+
+func (m *mockClient) DeleteQuarantined(_ context.Context, id string) error {
+	return nil
+}
+
+func (m *mockClient) Events(_ context.Context, params ipc.EventsParams) ([]ipc.Event, error) {
+	return nil, nil
+}
+
+func (m *mockClient) Pause(_ context.Context, duration string) error { return nil }
+func (m *mockClient) Resume(_ context.Context) error                 { return nil }
+
+func (m *mockClient) Subscribe(_ context.Context) (<-chan ipc.StateChangeEvent, error) {
 	if m.events == nil {
 		m.events = make(chan ipc.StateChangeEvent, 10)
 	}
 	return m.events, nil
 }
 
+func (m *mockClient) SubscribeEvents(_ context.Context, params ipc.SubscribeEventsParams) (<-chan ipc.Event, error) {
+	return make(chan ipc.Event), nil
+}
+
+func (m *mockClient) Watch(_ context.Context, params ipc.WatchParams) (<-chan ipc.Notification, error) {
+	if m.notes != nil {
+		return m.notes, nil
+	}
+	return make(chan ipc.Notification), nil
+}
+
 func (m *mockClient) Close() error { return nil }
 
 func TestNew(t *testing.T) {
@@ -149,7 +240,7 @@ func TestTray_pollStatus(t *testing.T) {
 
 func TestMockClient_Subscribe(t *testing.T) {
 	client := &mockClient{}
-	events, err := client.Subscribe()
+	events, err := client.Subscribe(t.Context())
 	if err != nil {
 		t.Fatalf("Subscribe() error = %v", err)
 	}
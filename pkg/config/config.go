@@ -3,6 +3,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -16,16 +17,41 @@ type Config struct {
 	Scanning      Scanning      `toml:"scanning"`
 	ClamAV        ClamAV        `toml:"clamav"`
 	Events        Events        `toml:"events"`
+	IDS           IDS           `toml:"ids"`
+	AppFirewall   AppFirewall   `toml:"app_firewall"`
+	Inventory     Inventory     `toml:"inventory"`
+	DNS           DNS           `toml:"dns"`
+	Rules         Rules         `toml:"rules"`
+	HTTP          HTTP          `toml:"http"`
 }
 
 type General struct {
 	RealTimeProtection bool   `toml:"real_time_protection"`
 	LogLevel           string `toml:"log_level"`
 	SocketPath         string `toml:"socket_path"` // IPC socket path (default: /run/oreon/defense.sock)
+	AdminGroup         string `toml:"admin_group"` // members may run privileged IPC commands (pause, firewall, ...) without polkit; "" = none
+	Polkit             bool   `toml:"polkit"`      // ask polkit (password prompt) when a caller isn't root or in admin_group
+	DBus               bool   `toml:"dbus"`        // export org.oreon.Defense1 on the system bus
 }
 
 type Firewall struct {
-	Enabled bool `toml:"enabled"`
+	Enabled         bool     `toml:"enabled"`
+	Backend         string   `toml:"backend"`           // "auto", "nftables", "firewalld"
+	AllowedTCPPorts []int    `toml:"allowed_tcp_ports"` // inbound TCP ports to accept
+	AllowedUDPPorts []int    `toml:"allowed_udp_ports"` // inbound UDP ports to accept
+	LogBlocked      bool     `toml:"log_blocked"`       // log dropped packets via NFLOG (nftables only)
+	LogGroup        int      `toml:"log_group"`         // NFLOG group used for dropped packets
+	LogRate         int      `toml:"log_rate"`          // max blocked-connection events per second
+	Zone            string   `toml:"zone"`              // firewalld zone, empty = default zone
+	Services        []string `toml:"services"`          // firewalld services to allow
+
+	Blocklists []Blocklist `toml:"blocklists"` // IP reputation lists loaded into nftables sets
+}
+
+// Blocklist is a plain-text file of IPs/CIDRs, one per line.
+type Blocklist struct {
+	Name string `toml:"name"` // short identifier, used in the nftables set name
+	Path string `toml:"path"`
 }
 
 type Notifications struct {
@@ -33,17 +59,100 @@ type Notifications struct {
 }
 
 type Scanning struct {
-	Exclusions     []string `toml:"exclusions"`
+	Exclusions     []string `toml:"exclusions"` // globs matched against the file name, or the full path if they contain a "/"
 	QuickScanPaths []string `toml:"quick_scan_paths"`
+	Action         string   `toml:"action"`         // what to do with infected files: "detect" or "quarantine"
+	QuarantineDir  string   `toml:"quarantine_dir"` // where quarantined files are kept
 }
 
 type ClamAV struct {
 	SocketPath string `toml:"socket_path"`
 }
 
+// IDS configures intrusion detection (currently SSH brute-force bans).
+type IDS struct {
+	SSHEnabled  bool     `toml:"ssh_enabled"`
+	Source      string   `toml:"source"`       // "auto", "journal", or "file"
+	LogPath     string   `toml:"log_path"`     // auth log tailed when source is "file"
+	MaxFailures int      `toml:"max_failures"` // failures within find_time before a ban
+	FindTime    string   `toml:"find_time"`    // window for counting failures, e.g. "10m"
+	BanTime     string   `toml:"ban_time"`     // how long a ban lasts, e.g. "1h"
+	IgnoreIPs   []string `toml:"ignore_ips"`   // addresses/CIDRs that are never banned
+}
+
+// AppFirewall configures per-application outbound rules.
+type AppFirewall struct {
+	Enabled  bool      `toml:"enabled"`
+	Default  string    `toml:"default"`  // "allow" or "deny" outbound for apps without a rule
+	Learning bool      `toml:"learning"` // record which apps connect where and propose rules
+	Rules    []AppRule `toml:"rules"`
+}
+
+// AppRule allows or denies outbound traffic for one application.
+// Set either Exe or Cgroup.
+type AppRule struct {
+	Name   string `toml:"name"`
+	Exe    string `toml:"exe"`    // executable path, matched against running processes
+	Cgroup string `toml:"cgroup"` // cgroup v2 path, e.g. "system.slice/nginx.service"
+	Action string `toml:"action"` // "allow" or "deny"
+}
+
+// Inventory configures the listening-port health check.
+type Inventory struct {
+	WarnUnexpected bool     `toml:"warn_unexpected"` // warn when an unexpected port listens on a non-loopback address
+	ExpectedPorts  []string `toml:"expected_ports"`  // e.g. "22/tcp"; firewall allowed ports count too
+}
+
+// DNS configures the local forwarder that blocks listed domains.
+type DNS struct {
+	Enabled    bool         `toml:"enabled"`
+	Listen     string       `toml:"listen"`      // address served on, UDP and TCP
+	Upstreams  []string     `toml:"upstreams"`   // resolvers to forward to, "host:port"
+	Mode       string       `toml:"mode"`        // "nxdomain" or "sinkhole"
+	SinkholeV4 string       `toml:"sinkhole_v4"` // A answer for blocked names in sinkhole mode
+	SinkholeV6 string       `toml:"sinkhole_v6"` // AAAA answer for blocked names in sinkhole mode
+	Timeout    string       `toml:"timeout"`     // per-upstream timeout, e.g. "2s"
+	LogRate    int          `toml:"log_rate"`    // max blocked-query events per second
+	Blocklists []DomainList `toml:"blocklists"`
+}
+
+// Rules configures signature and rule-list updates.
+type Rules struct {
+	FreshclamPath  string       `toml:"freshclam_path"`  // empty = just ask clamd to RELOAD
+	DatabaseDir    string       `toml:"database_dir"`    // ClamAV signature databases
+	UpdateInterval string       `toml:"update_interval"` // e.g. "6h", empty disables automatic updates
+	MaxAge         string       `toml:"max_age"`         // warn when rules are older than this
+	Sources        []RuleSource `toml:"sources"`
+	TrustedKeys    []string     `toml:"trusted_keys"` // base64 ed25519 public keys that may sign offline bundles
+	StatePath      string       `toml:"state_path"`   // remembers the last imported bundle version
+}
+
+// RuleSource is one of our own rule lists, downloaded from URL to Path.
+type RuleSource struct {
+	Name string `toml:"name"`
+	Kind string `toml:"kind"` // "yara" or "hashes"
+	URL  string `toml:"url"`
+	Path string `toml:"path"`
+}
+
+// DomainList is a file of domains to block, plain or hosts format.
+type DomainList struct {
+	Name string `toml:"name"`
+	Path string `toml:"path"`
+}
+
+// HTTP configures the optional REST gateway to the IPC commands.
+type HTTP struct {
+	Enabled    bool   `toml:"enabled"`
+	SocketPath string `toml:"socket_path"` // unix socket, callers identified like on the IPC socket
+	Address    string `toml:"address"`     // loopback host:port instead of the socket; needs the token
+	TokenFile  string `toml:"token_file"`  // bearer token for address, created (0600) if missing
+}
+
 type Events struct {
-	DatabasePath string  `toml:"database_path"` // path to SQLite database for event storage
+	DatabasePath string  `toml:"database_path"` // path to SQLite database for event storage, "" = don't store
 	SampleRate   float64 `toml:"sample_rate"`   // 0.0-1.0, percentage of successful events to store
+	Retention    string  `toml:"retention"`     // how long stored events are kept, e.g. "720h", "" = forever
 }
 
 func Default() *Config {
@@ -52,9 +161,22 @@ func Default() *Config {
 			RealTimeProtection: true,
 			LogLevel:           "info",
 			SocketPath:         SocketPath,
+			AdminGroup:         "", // polkit asks admins for their password instead
+			Polkit:             true,
+			DBus:               true,
 		},
 		Firewall: Firewall{
 			Enabled: true,
+			Backend: "auto",
+			// the input chain drops everything else, so keep ssh and the
+			// dhcp/mdns clients reachable (same as inventory.expected_ports)
+			AllowedTCPPorts: []int{22},
+			AllowedUDPPorts: []int{68, 546, 5353},
+			LogBlocked:      true,
+			LogGroup:        100,
+			LogRate:         10,
+			Services:        []string{},
+			Blocklists:      []Blocklist{},
 		},
 		Notifications: Notifications{
 			Level: "all",
@@ -65,6 +187,8 @@ func Default() *Config {
 				"/tmp",
 				"/var/tmp",
 			},
+			Action:        "detect",
+			QuarantineDir: "/var/lib/oreon/quarantine",
 		},
 		ClamAV: ClamAV{
 			SocketPath: "/var/run/clamav/clamd.sock",
@@ -72,6 +196,48 @@ func Default() *Config {
 		Events: Events{
 			DatabasePath: "/var/lib/oreon/events.db",
 			SampleRate:   1.0, // 100% by default
+			Retention:    "720h",
+		},
+		IDS: IDS{
+			SSHEnabled:  true,
+			Source:      "auto",
+			LogPath:     "/var/log/secure",
+			MaxFailures: 5,
+			FindTime:    "10m",
+			BanTime:     "1h",
+			IgnoreIPs:   []string{"127.0.0.0/8", "::1"},
+		},
+		AppFirewall: AppFirewall{
+			Default: "allow",
+			Rules:   []AppRule{},
+		},
+		DNS: DNS{
+			Listen:     "127.0.0.1:53",
+			Upstreams:  []string{"1.1.1.1:53", "9.9.9.9:53"},
+			Mode:       "nxdomain",
+			SinkholeV4: "0.0.0.0",
+			SinkholeV6: "::",
+			Timeout:    "2s",
+			LogRate:    10,
+			Blocklists: []DomainList{},
+		},
+		Rules: Rules{
+			FreshclamPath:  "/usr/bin/freshclam",
+			DatabaseDir:    "/var/lib/clamav",
+			UpdateInterval: "6h",
+			MaxAge:         "72h",
+			Sources:        []RuleSource{},
+			TrustedKeys:    []string{},
+			StatePath:      "/var/lib/oreon/rules-state.json",
+		},
+		HTTP: HTTP{
+			SocketPath: "/run/oreon/defense-http.sock",
+			TokenFile:  "/etc/oreon/defense-http.token",
+		},
+		Inventory: Inventory{
+			WarnUnexpected: true,
+			// ssh plus the dhcp/mdns clients most desktops run
+			ExpectedPorts: []string{"22/tcp", "68/udp", "546/udp", "5353/udp"},
 		},
 	}
 }
@@ -90,10 +256,29 @@ func Load(path string) (*Config, error) {
 	if _, err := toml.Decode(string(data), cfg); err != nil {
 		return nil, err
 	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
 
 	return cfg, nil
 }
 
+// validate catches settings that decode fine but can't work.
+func (c *Config) validate() error {
+	// list names key the nftables sets, so they must be unique
+	seen := make(map[string]bool)
+	for _, bl := range c.Firewall.Blocklists {
+		if bl.Name == "" {
+			return fmt.Errorf("firewall blocklist %q: name is empty", bl.Path)
+		}
+		if seen[bl.Name] {
+			return fmt.Errorf("firewall blocklist %q: name used twice", bl.Name)
+		}
+		seen[bl.Name] = true
+	}
+	return nil
+}
+
 func (c *Config) Save(path string) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
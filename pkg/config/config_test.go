@@ -5,6 +5,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 )
 
@@ -20,6 +21,16 @@ func TestDefault(t *testing.T) {
 	if !cfg.Firewall.Enabled {
 		t.Error("expected firewall to be enabled by default")
 	}
+	// the default-deny input chain mustn't lock anyone out of a box it
+	// was just installed on
+	if !slices.Contains(cfg.Firewall.AllowedTCPPorts, 22) {
+		t.Errorf("ssh not allowed by default: %v", cfg.Firewall.AllowedTCPPorts)
+	}
+	for _, port := range []int{68, 546, 5353} {
+		if !slices.Contains(cfg.Firewall.AllowedUDPPorts, port) {
+			t.Errorf("udp %d not allowed by default: %v", port, cfg.Firewall.AllowedUDPPorts)
+		}
+	}
 	if cfg.Notifications.Level != "all" {
 		t.Errorf("expected notification level 'all', got %q", cfg.Notifications.Level)
 	}
@@ -64,6 +75,19 @@ func TestSaveAndLoad(t *testing.T) {
 	}
 }
 
+func TestLoadBlocklistNames(t *testing.T) {
+	for name, lists := range map[string]string{
+		"empty":     "[[firewall.blocklists]]\npath = \"/etc/a.txt\"\n",
+		"duplicate": "[[firewall.blocklists]]\nname = \"a\"\npath = \"/etc/a.txt\"\n[[firewall.blocklists]]\nname = \"a\"\npath = \"/etc/b.txt\"\n",
+	} {
+		path := filepath.Join(t.TempDir(), "defense.toml")
+		os.WriteFile(path, []byte(lists), 0o644)
+		if _, err := Load(path); err == nil {
+			t.Errorf("%s: Load() accepted bad blocklist names", name)
+		}
+	}
+}
+
 func TestSaveCreatesDir(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "nested", "dir", "config.toml")
@@ -34,6 +34,12 @@ func (b *Builder) WithOperationID(id string) *Builder {
 	return b
 }
 
+// OperationID returns the event's operation ID, for passing on to work
+// done as part of it (see WithOperationID).
+func (b *Builder) OperationID() string {
+	return b.event.OperationID
+}
+
 // Set adds a field to the event.
 func (b *Builder) Set(key string, value interface{}) *Builder {
 	b.event.Fields[key] = value
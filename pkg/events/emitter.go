@@ -13,6 +13,18 @@ type Emitter struct {
 	logger     *slog.Logger
 	sampleRate float64 // 0.0-1.0, percentage of successful events to emit
 	slowThresh time.Duration
+	sinks      []sink
+}
+
+// sink is a Sink with its own sample rate on top of the emitter's.
+type sink struct {
+	Sink
+	rate float64
+}
+
+// Sink receives every event the emitter outputs, after sampling.
+type Sink interface {
+	Write(evt Event)
 }
 
 // EmitterOption configures an Emitter.
@@ -40,6 +52,20 @@ func WithSlowThreshold(d time.Duration) EmitterOption {
 	}
 }
 
+// WithSink adds a sink that sees every emitted event.
+func WithSink(s Sink) EmitterOption {
+	return WithSampledSink(s, 1)
+}
+
+// WithSampledSink adds a sink that sees only rate (0.0-1.0) of the
+// successful events the emitter outputs. Like WithSampleRate, errors and
+// slow operations always get through.
+func WithSampledSink(s Sink, rate float64) EmitterOption {
+	return func(e *Emitter) {
+		e.sinks = append(e.sinks, sink{Sink: s, rate: min(max(rate, 0), 1)})
+	}
+}
+
 // WithLogger sets a custom slog.Logger for output.
 func WithLogger(logger *slog.Logger) EmitterOption {
 	return func(e *Emitter) {
@@ -69,10 +95,21 @@ func (e *Emitter) Emit(evt Event) {
 		return
 	}
 	e.log(evt)
+	for _, s := range e.sinks {
+		if s.rate < 1 && !e.sample(evt, s.rate) {
+			continue
+		}
+		s.Write(evt)
+	}
 }
 
 // shouldEmit determines if an event should be output based on sampling rules.
 func (e *Emitter) shouldEmit(evt Event) bool {
+	return e.sample(evt, e.sampleRate)
+}
+
+// sample applies the sampling rules at the given rate.
+func (e *Emitter) sample(evt Event, rate float64) bool {
 	// Always emit errors
 	if !evt.Success {
 		return true
@@ -82,13 +119,13 @@ func (e *Emitter) shouldEmit(evt Event) bool {
 		return true
 	}
 	// Sample successful fast operations
-	if e.sampleRate >= 1.0 {
+	if rate >= 1.0 {
 		return true
 	}
-	if e.sampleRate <= 0 {
+	if rate <= 0 {
 		return false
 	}
-	return rand.Float64() < e.sampleRate
+	return rand.Float64() < rate
 }
 
 // log outputs the event via slog.
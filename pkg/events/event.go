@@ -15,6 +15,14 @@ const (
 	EventTypeStateChange EventType = "state_change"
 	EventTypeThreat      EventType = "threat_detected"
 	EventTypeHealthCheck EventType = "health_check"
+	EventTypeFWBlock     EventType = "firewall_block"
+	EventTypeBan         EventType = "ip_ban"
+	EventTypeUnban       EventType = "ip_unban"
+	EventTypeListener    EventType = "unexpected_listener"
+	EventTypeDNSBlock    EventType = "dns_block"
+	EventTypeRules       EventType = "rules_update"
+	EventTypeImport      EventType = "rules_import"
+	EventTypeDenied      EventType = "access_denied"
 )
 
 // Event represents a wide event / canonical log line.
@@ -61,4 +69,41 @@ const (
 	FieldAction        = "action"
 	FieldClamAvailable = "clamav_available"
 	FieldFWEnabled     = "firewall_enabled"
+	FieldSrcAddr       = "src_addr"
+	FieldDstAddr       = "dst_addr"
+	FieldDstPort       = "dst_port"
+	FieldProtocol      = "protocol"
+	FieldInterface     = "interface"
+	FieldSuppressed    = "suppressed"
+	FieldFailures      = "failures"
+	FieldBanSeconds    = "ban_seconds"
+	FieldLocalAddr     = "local_addr"
+	FieldLocalPort     = "local_port"
+	FieldPID           = "pid"
+	FieldExe           = "exe"
+	FieldUser          = "user"
+	FieldUnexpected    = "unexpected_listeners"
+	FieldDomain        = "domain"
+	FieldQueryType     = "query_type"
+	FieldList          = "list"
+	FieldSource        = "source"
+	FieldKind          = "kind"
+	FieldVersion       = "version"
+	FieldRulesStale    = "rules_stale"
+	FieldFiles         = "files"
+	FieldUID           = "uid"
+	FieldOwnerUID      = "owner_uid"
+	FieldGID           = "gid"
 )
+
+// Owner returns the uid the event is private to (FieldOwnerUID), or -1 if
+// anyone may see it. Fields read back from JSON hold numbers as float64.
+func (e Event) Owner() int {
+	switch uid := e.Fields[FieldOwnerUID].(type) {
+	case int:
+		return uid
+	case float64:
+		return int(uid)
+	}
+	return -1
+}
@@ -4,6 +4,9 @@ package events
 
 import (
 	"context"
+	"errors"
+	"io"
+	"log/slog"
 	"testing"
 	"time"
 )
@@ -128,6 +131,27 @@ func TestEmitterSampling(t *testing.T) {
 	}
 }
 
+type captureSink []Event
+
+func (c *captureSink) Write(evt Event) { *c = append(*c, evt) }
+
+func TestEmitterSampledSink(t *testing.T) {
+	var all, sampled captureSink
+	e := NewEmitter(WithSink(&all), WithSampledSink(&sampled, 0),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	e.Emit(Event{Success: true, Duration: 10 * time.Millisecond})
+	e.Emit(Event{Success: true, Duration: 2 * time.Second})
+	e.Emit(Event{Success: false, Error: "test error"})
+
+	if len(all) != 3 {
+		t.Errorf("WithSink saw %d events, want 3", len(all))
+	}
+	if len(sampled) != 2 || sampled[0].Duration != 2*time.Second || sampled[1].Success {
+		t.Errorf("sampled sink saw %+v, want the slow and the failed event", sampled)
+	}
+}
+
 func TestTypedBuilders(t *testing.T) {
 	t.Run("ScanBuilder", func(t *testing.T) {
 		evt := StartScan("quick", "job-123").
@@ -211,3 +235,39 @@ func TestTypedBuilders(t *testing.T) {
 		}
 	})
 }
+
+func TestRecent(t *testing.T) {
+	r := NewRecent(3)
+	e := NewEmitter(WithSink(r), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	e.Emit(StartBan("192.0.2.1", "ssh").End())
+	e.Emit(StartDNSBlock("evil.example", "A", "127.0.0.1").End())
+	failed := StartRulesUpdate("clamav", "clamav")
+	failed.SetError(errors.New("freshclam failed"))
+	e.Emit(failed.End())
+	e.Emit(StartDNSBlock("phish.example", "A", "127.0.0.1").End())
+
+	all := r.Query(Filter{}, 0)
+	if len(all) != 3 {
+		t.Fatalf("Query() returned %d events, want 3 (oldest dropped)", len(all))
+	}
+	if all[0].Fields[FieldDomain] != "phish.example" || all[2].Type != EventTypeDNSBlock {
+		t.Errorf("events not newest first: %v, %v", all[0].Fields, all[2].Type)
+	}
+
+	if got := r.Query(Filter{Type: EventTypeDNSBlock}, 1); len(got) != 1 || got[0].Fields[FieldDomain] != "phish.example" {
+		t.Errorf("type filter with limit = %+v", got)
+	}
+	failedOnly := false
+	if got := r.Query(Filter{Success: &failedOnly}, 0); len(got) != 1 || got[0].Component != "rules" {
+		t.Errorf("failed filter = %+v", got)
+	}
+
+	e.Emit(StartThreat("/home/bob/x", "Eicar").Owner(1001).End())
+	if got := r.Query(Filter{Restricted: true, UID: 1000}, 1); len(got) != 1 || got[0].Owner() != -1 {
+		t.Errorf("restricted filter = %+v, want the newest public event", got)
+	}
+	if got := r.Query(Filter{Since: time.Now().Add(time.Hour)}, 0); len(got) != 0 {
+		t.Errorf("since filter = %+v", got)
+	}
+}
@@ -0,0 +1,88 @@
+// oreon/defense · watchthelight <wtl>
+
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Filter selects events. Zero fields match everything.
+type Filter struct {
+	Type      EventType
+	Component string
+	Since     time.Time // events that started at or after this
+	Success   *bool     // nil matches both outcomes
+
+	// Restricted skips events private to anyone but UID.
+	Restricted bool
+	UID        int
+}
+
+// Match reports whether evt passes the filter.
+func (f Filter) Match(evt Event) bool {
+	if f.Type != "" && evt.Type != f.Type {
+		return false
+	}
+	if f.Component != "" && evt.Component != f.Component {
+		return false
+	}
+	if !f.Since.IsZero() && evt.StartedAt.Before(f.Since) {
+		return false
+	}
+	if f.Success != nil && evt.Success != *f.Success {
+		return false
+	}
+	if owner := evt.Owner(); f.Restricted && owner >= 0 && owner != f.UID {
+		return false
+	}
+	return true
+}
+
+// Recent keeps the last N events in memory so clients can ask what
+// happened without a database.
+type Recent struct {
+	mu     sync.Mutex
+	buf    []Event
+	next   int
+	filled bool
+}
+
+// NewRecent creates a buffer holding up to size events.
+func NewRecent(size int) *Recent {
+	return &Recent{buf: make([]Event, size)}
+}
+
+// Write stores evt, overwriting the oldest event when full.
+func (r *Recent) Write(evt Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf[r.next] = evt
+	r.next = (r.next + 1) % len(r.buf)
+	if r.next == 0 {
+		r.filled = true
+	}
+}
+
+// Query returns matching events, newest first. limit <= 0 means all.
+func (r *Recent) Query(f Filter, limit int) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.next
+	if r.filled {
+		n = len(r.buf)
+	}
+	var out []Event
+	for i := 0; i < n; i++ {
+		evt := r.buf[(r.next-1-i+len(r.buf))%len(r.buf)]
+		if !f.Match(evt) {
+			continue
+		}
+		out = append(out, evt)
+		if limit > 0 && len(out) == limit {
+			break
+		}
+	}
+	return out
+}
@@ -2,6 +2,8 @@
 
 package events
 
+import "time"
+
 // ScanBuilder is a typed builder for scan events.
 type ScanBuilder struct {
 	*Builder
@@ -52,6 +54,14 @@ func (b *IPCRequestBuilder) ClientVersion(version int) *IPCRequestBuilder {
 	return b
 }
 
+// Peer records who sent the request.
+func (b *IPCRequestBuilder) Peer(uid, gid, pid int) *IPCRequestBuilder {
+	b.Set(FieldUID, uid)
+	b.Set(FieldGID, gid)
+	b.Set(FieldPID, pid)
+	return b
+}
+
 // ResponseSize sets the response size in bytes.
 func (b *IPCRequestBuilder) ResponseSize(bytes int) *IPCRequestBuilder {
 	b.Set(FieldResponseSize, bytes)
@@ -102,6 +112,13 @@ func (b *ThreatBuilder) FileSize(bytes int64) *ThreatBuilder {
 	return b
 }
 
+// Owner marks the threat as found by uid's own scan, which only they and
+// admins get to see.
+func (b *ThreatBuilder) Owner(uid int) *ThreatBuilder {
+	b.Set(FieldOwnerUID, uid)
+	return b
+}
+
 // HealthCheckBuilder is a typed builder for health check events.
 type HealthCheckBuilder struct {
 	*Builder
@@ -119,8 +136,177 @@ func (b *HealthCheckBuilder) ClamAVAvailable(available bool) *HealthCheckBuilder
 	return b
 }
 
+// UnexpectedListeners sets how many unexpected non-loopback listeners were found.
+func (b *HealthCheckBuilder) UnexpectedListeners(count int) *HealthCheckBuilder {
+	b.Set(FieldUnexpected, count)
+	return b
+}
+
+// RulesStale sets how many rule sources are older than the allowed age.
+func (b *HealthCheckBuilder) RulesStale(count int) *HealthCheckBuilder {
+	b.Set(FieldRulesStale, count)
+	return b
+}
+
 // FirewallEnabled sets whether the firewall is enabled.
 func (b *HealthCheckBuilder) FirewallEnabled(enabled bool) *HealthCheckBuilder {
 	b.Set(FieldFWEnabled, enabled)
 	return b
 }
+
+// FirewallBlockBuilder is a typed builder for blocked-connection events.
+type FirewallBlockBuilder struct {
+	*Builder
+}
+
+// StartFirewallBlock creates a new blocked-connection event builder.
+func StartFirewallBlock(src, dst string, port int, protocol string) *FirewallBlockBuilder {
+	b := Start(EventTypeFWBlock, "firewall")
+	b.Set(FieldSrcAddr, src)
+	b.Set(FieldDstAddr, dst)
+	b.Set(FieldDstPort, port)
+	b.Set(FieldProtocol, protocol)
+	return &FirewallBlockBuilder{Builder: b}
+}
+
+// Interface sets the inbound interface the packet arrived on.
+func (b *FirewallBlockBuilder) Interface(name string) *FirewallBlockBuilder {
+	b.Set(FieldInterface, name)
+	return b
+}
+
+// Suppressed sets how many blocks were rate-limited before this one.
+func (b *FirewallBlockBuilder) Suppressed(count int) *FirewallBlockBuilder {
+	b.Set(FieldSuppressed, count)
+	return b
+}
+
+// BanBuilder is a typed builder for ban and unban events.
+type BanBuilder struct {
+	*Builder
+}
+
+// StartBan creates a new ban event builder.
+func StartBan(addr, reason string) *BanBuilder {
+	b := Start(EventTypeBan, "ids")
+	b.Set(FieldSrcAddr, addr)
+	b.Set(FieldReason, reason)
+	return &BanBuilder{Builder: b}
+}
+
+// StartUnban creates a new unban event builder.
+func StartUnban(addr, reason string) *BanBuilder {
+	b := Start(EventTypeUnban, "ids")
+	b.Set(FieldSrcAddr, addr)
+	b.Set(FieldReason, reason)
+	return &BanBuilder{Builder: b}
+}
+
+// Failures sets how many failures triggered the ban.
+func (b *BanBuilder) Failures(count int) *BanBuilder {
+	b.Set(FieldFailures, count)
+	return b
+}
+
+// BanDuration sets how long the ban lasts.
+func (b *BanBuilder) BanDuration(d time.Duration) *BanBuilder {
+	b.Set(FieldBanSeconds, int64(d.Seconds()))
+	return b
+}
+
+// ListenerBuilder is a typed builder for unexpected-listener events.
+type ListenerBuilder struct {
+	*Builder
+}
+
+// StartUnexpectedListener creates a new unexpected-listener event builder.
+func StartUnexpectedListener(protocol, addr string, port int) *ListenerBuilder {
+	b := Start(EventTypeListener, "inventory")
+	b.Set(FieldProtocol, protocol)
+	b.Set(FieldLocalAddr, addr)
+	b.Set(FieldLocalPort, port)
+	return &ListenerBuilder{Builder: b}
+}
+
+// Process sets the process that owns the socket.
+func (b *ListenerBuilder) Process(pid int, exe, user string) *ListenerBuilder {
+	b.Set(FieldPID, pid)
+	b.Set(FieldExe, exe)
+	b.Set(FieldUser, user)
+	return b
+}
+
+// DNSBlockBuilder is a typed builder for blocked DNS queries.
+type DNSBlockBuilder struct {
+	*Builder
+}
+
+// StartDNSBlock creates a new DNS block event builder.
+func StartDNSBlock(domain, queryType, client string) *DNSBlockBuilder {
+	b := Start(EventTypeDNSBlock, "dns")
+	b.Set(FieldDomain, domain)
+	b.Set(FieldQueryType, queryType)
+	b.Set(FieldSrcAddr, client)
+	return &DNSBlockBuilder{Builder: b}
+}
+
+// List sets the blocklist that matched.
+func (b *DNSBlockBuilder) List(name string) *DNSBlockBuilder {
+	b.Set(FieldList, name)
+	return b
+}
+
+// Suppressed records how many blocks the rate limit dropped before this one.
+func (b *DNSBlockBuilder) Suppressed(count int) *DNSBlockBuilder {
+	b.Set(FieldSuppressed, count)
+	return b
+}
+
+// RulesBuilder is a typed builder for rule update events.
+type RulesBuilder struct {
+	*Builder
+}
+
+// StartRulesUpdate creates a new rule update event builder.
+func StartRulesUpdate(source, kind string) *RulesBuilder {
+	b := Start(EventTypeRules, "rules")
+	b.Set(FieldSource, source)
+	b.Set(FieldKind, kind)
+	return &RulesBuilder{Builder: b}
+}
+
+// Version sets the version installed after the update.
+func (b *RulesBuilder) Version(version string) *RulesBuilder {
+	b.Set(FieldVersion, version)
+	return b
+}
+
+// ImportBuilder is a typed builder for offline bundle imports.
+type ImportBuilder struct {
+	*Builder
+}
+
+// StartRulesImport creates a new bundle import event builder.
+func StartRulesImport(path string) *ImportBuilder {
+	b := Start(EventTypeImport, "rules")
+	b.Set(FieldPath, path)
+	return &ImportBuilder{Builder: b}
+}
+
+// Bundle sets the imported bundle's version and how many files it installed.
+func (b *ImportBuilder) Bundle(version uint64, files int) *ImportBuilder {
+	b.Set(FieldVersion, version)
+	b.Set(FieldFiles, files)
+	return b
+}
+
+// StartAccessDenied creates an event for an IPC command refused by policy.
+// Set the reason with SetError.
+func StartAccessDenied(command string, uid, gid, pid int) *Builder {
+	b := Start(EventTypeDenied, "ipc")
+	b.Set(FieldCommand, command)
+	b.Set(FieldUID, uid)
+	b.Set(FieldGID, gid)
+	b.Set(FieldPID, pid)
+	return b
+}
@@ -2,6 +2,7 @@ package ipc
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,73 +15,209 @@ import (
 
 // Client defines the interface for IPC communication with the daemon
 type Client interface {
-	Status() (*StatusResponse, error)
-	GetProtectionStatus() (bool, error)
-	SetFirewallEnabled(enabled bool) error
-	IsFirewallEnabled() (bool, error)
-	StartQuickScan() (*ScanResponse, error)
-	StartFullScan() (*ScanResponse, error)
-	Pause() error
-	Resume() error
-	Subscribe() (<-chan StateChangeEvent, error)
+	Hello(ctx context.Context, client string) (*HelloResponse, error)
+	Status(ctx context.Context) (*StatusResponse, error)
+	GetProtectionStatus(ctx context.Context) (bool, error)
+	SetFirewallEnabled(ctx context.Context, enabled bool) error
+	IsFirewallEnabled(ctx context.Context) (bool, error)
+	FirewallBlocked(ctx context.Context, params FirewallBlockedParams) (*FirewallBlockedResponse, error)
+	SetFirewallPanic(ctx context.Context, enabled bool) error
+	Bans(ctx context.Context) ([]BanInfo, error)
+	LiftBan(ctx context.Context, address string) error
+	AppRules(ctx context.Context) (*AppRulesResponse, error)
+	AppLearned(ctx context.Context) (*AppLearnedResponse, error)
+	SetAppLearning(ctx context.Context, enabled bool) error
+	NetInventory(ctx context.Context, params NetInventoryParams) (*NetInventoryResponse, error)
+	RulesStatus(ctx context.Context) (*RulesStatusResponse, error)
+	UpdateRules(ctx context.Context) (*RulesStatusResponse, error)
+	ImportRules(ctx context.Context, path string) (*RulesImportResponse, error)
+	Scan(ctx context.Context, params ScanParams) (*ScanStatusResponse, error)
+	StartQuickScan(ctx context.Context) (*ScanResponse, error)
+	StartFullScan(ctx context.Context) (*ScanResponse, error)
+	ScanStatus(ctx context.Context, jobID string) (*ScanStatusResponse, error)
+	CancelScan(ctx context.Context, jobID string) error
+	ScanHistory(ctx context.Context, limit int) ([]ScanStatusResponse, error)
+	Quarantine(ctx context.Context) ([]QuarantineItem, error)
+	RestoreQuarantined(ctx context.Context, id string) error
+	DeleteQuarantined(ctx context.Context, id string) error
+	Events(ctx context.Context, params EventsParams) ([]Event, error)
+	Pause(ctx context.Context, duration string) error
+	Resume(ctx context.Context) error
+	Subscribe(ctx context.Context) (<-chan StateChangeEvent, error)
+	SubscribeEvents(ctx context.Context, params SubscribeEventsParams) (<-chan Event, error)
+	Watch(ctx context.Context, params WatchParams) (<-chan Notification, error)
 	Close() error
 }
 
-// socketClient is the real IPC client implementation
+// socketClient is the real IPC client implementation. Calls share one
+// connection: each request gets its own ID and a reader goroutine hands
+// responses back by it, so a slow scan_history doesn't hold up a status.
 type socketClient struct {
 	socketPath string
-	conn       net.Conn
-	reader     *bufio.Reader
-	mu         sync.Mutex
 	reqID      atomic.Uint64
-	connected  bool
+
+	mu   sync.Mutex
+	conn *muxConn // nil until the first call
+
+	// ctx is cancelled by Close to stop subscription goroutines
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
+// ErrClientClosed is returned by calls made after Close.
+var ErrClientClosed = errors.New("ipc client closed")
+
 // NewClient creates a new IPC client. Connection is established lazily on first call.
 func NewClient(socketPath string) Client {
-	return &socketClient{socketPath: socketPath}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &socketClient{socketPath: socketPath, ctx: ctx, cancel: cancel}
 }
 
-// connect establishes a connection to the daemon
-func (c *socketClient) connect() error {
-	conn, err := net.Dial("unix", c.socketPath)
+// muxConn is one connection to the daemon and the calls waiting on it.
+type muxConn struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan *Response
+
+	done chan struct{} // closed when the connection breaks
+	err  error         // why; set before done is closed
+}
+
+// connection returns the shared connection, dialing a new one on first
+// use or after the last one broke.
+func (c *socketClient) connection(ctx context.Context) (*muxConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+	if c.conn != nil {
+		select {
+		case <-c.conn.done:
+		default:
+			return c.conn, nil
+		}
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", c.socketPath)
 	if err != nil {
-		return fmt.Errorf("connect to daemon: %w", err)
+		return nil, fmt.Errorf("connect to daemon: %w", err)
 	}
-	c.conn = conn
-	c.reader = bufio.NewReader(conn)
-	c.connected = true
-	return nil
+	c.conn = &muxConn{conn: conn, pending: make(map[string]chan *Response), done: make(chan struct{})}
+	go c.conn.readLoop()
+	return c.conn, nil
 }
 
-// reconnect closes the existing connection and establishes a new one
-func (c *socketClient) reconnect() error {
-	if c.conn != nil {
-		c.conn.Close()
+// readLoop routes responses to their callers until the connection breaks,
+// then fails everything still waiting.
+func (mc *muxConn) readLoop() {
+	reader := bufio.NewReader(mc.conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			mc.mu.Lock()
+			mc.err = err
+			mc.pending = nil
+			mc.mu.Unlock()
+			close(mc.done)
+			mc.conn.Close()
+			return
+		}
+
+		var resp Response
+		if json.Unmarshal(line, &resp) != nil {
+			continue
+		}
+		mc.mu.Lock()
+		ch := mc.pending[resp.ID]
+		delete(mc.pending, resp.ID)
+		mc.mu.Unlock()
+		if ch != nil {
+			ch <- &resp // buffered, never blocks
+		}
+		// no one waiting: the caller gave up, drop it
 	}
-	c.connected = false
-	return c.connect()
 }
 
-func (c *socketClient) call(cmd string, params interface{}) (*Response, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// roundTrip sends one request line and waits for the response with the
+// same ID, the connection to break, or ctx.
+func (mc *muxConn) roundTrip(ctx context.Context, id string, data []byte) (*Response, error) {
+	ch := make(chan *Response, 1)
+	mc.mu.Lock()
+	if mc.pending == nil {
+		mc.mu.Unlock()
+		<-mc.done
+		return nil, mc.err
+	}
+	mc.pending[id] = ch
+	mc.mu.Unlock()
+	forget := func() {
+		mc.mu.Lock()
+		delete(mc.pending, id)
+		mc.mu.Unlock()
+	}
 
-	// Connect on first use
-	if !c.connected {
-		if err := c.connect(); err != nil {
-			return nil, err
+	if err := mc.write(ctx, data); err != nil {
+		forget()
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-mc.done:
+		// the response may have arrived just before the connection broke
+		select {
+		case resp := <-ch:
+			return resp, nil
+		default:
+			return nil, mc.err
 		}
+	case <-ctx.Done():
+		forget()
+		mc.cancel(id)
+		return nil, ctx.Err()
+	}
+}
+
+// cancel tells the daemon to stop working on a request we gave up on.
+// Best effort, and no response comes back.
+func (mc *muxConn) cancel(id string) {
+	idJSON, _ := json.Marshal(id)
+	params, _ := json.Marshal(CancelParams{ID: idJSON})
+	data, _ := json.Marshal(Request{Version: ProtocolVersion, Command: CmdCancel, Params: params})
+	ctx, stop := context.WithTimeout(context.Background(), time.Second)
+	defer stop()
+	mc.write(ctx, append(data, '\n'))
+}
+
+func (mc *muxConn) write(ctx context.Context, data []byte) error {
+	mc.writeMu.Lock()
+	defer mc.writeMu.Unlock()
+	deadline, _ := ctx.Deadline() // zero means none
+	mc.conn.SetWriteDeadline(deadline)
+	if _, err := mc.conn.Write(data); err != nil {
+		// a partial line would garble the stream for everyone
+		mc.conn.Close()
+		return err
 	}
+	return nil
+}
 
-	resp, err := c.doCall(cmd, params)
-	if err != nil && c.isConnectionError(err) {
-		// Try to reconnect once
-		if reconnErr := c.reconnect(); reconnErr != nil {
-			return nil, fmt.Errorf("reconnect failed: %w", reconnErr)
+// call sends cmd and waits for its response until ctx is done. A call
+// that finds the connection broken (say the daemon restarted) is retried
+// once on a fresh one.
+func (c *socketClient) call(ctx context.Context, cmd string, params interface{}) (*Response, error) {
+	resp, err := c.doCall(ctx, cmd, params)
+	if err != nil && c.isConnectionError(err) && ctx.Err() == nil {
+		resp, err = c.doCall(ctx, cmd, params)
+		if err != nil && c.isConnectionError(err) {
+			return nil, fmt.Errorf("reconnect failed: %w", err)
 		}
-		// Retry the call
-		resp, err = c.doCall(cmd, params)
 	}
 	return resp, err
 }
@@ -103,9 +240,13 @@ func (c *socketClient) isConnectionError(err error) bool {
 }
 
 // doCall performs the actual IPC call without reconnect logic
-func (c *socketClient) doCall(cmd string, params interface{}) (*Response, error) {
-	id := fmt.Sprintf("%d", c.reqID.Add(1))
+func (c *socketClient) doCall(ctx context.Context, cmd string, params interface{}) (*Response, error) {
+	mc, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
 
+	id := fmt.Sprintf("%d", c.reqID.Add(1))
 	req := Request{Version: ProtocolVersion, ID: id, Command: cmd}
 	if params != nil {
 		p, err := json.Marshal(params)
@@ -121,31 +262,34 @@ func (c *socketClient) doCall(cmd string, params interface{}) (*Response, error)
 	}
 	data = append(data, '\n')
 
-	if _, err := c.conn.Write(data); err != nil {
+	resp, err := mc.roundTrip(ctx, id, data)
+	if err != nil {
 		return nil, err
 	}
-
-	// Set read deadline to avoid blocking forever if daemon hangs
-	c.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
-	line, err := c.reader.ReadBytes('\n')
-	if err != nil {
+	if err := resp.Err(); err != nil {
 		return nil, err
 	}
+	return resp, nil
+}
 
-	var resp Response
-	if err := json.Unmarshal(line, &resp); err != nil {
+// Hello introduces the client (e.g. "defensectl/0.1.0") and returns what
+// the daemon supports. Daemons from before hello answer with
+// ErrUnknownCommand.
+func (c *socketClient) Hello(ctx context.Context, client string) (*HelloResponse, error) {
+	resp, err := c.call(ctx, CmdHello, HelloParams{Client: client, Protocol: ProtocolVersion})
+	if err != nil {
 		return nil, err
 	}
 
-	if !resp.Success {
-		return nil, fmt.Errorf("daemon error: %s", resp.Error)
+	var hello HelloResponse
+	if err := resp.UnmarshalData(&hello); err != nil {
+		return nil, err
 	}
-
-	return &resp, nil
+	return &hello, nil
 }
 
-func (c *socketClient) Status() (*StatusResponse, error) {
-	resp, err := c.call(CmdStatus, nil)
+func (c *socketClient) Status(ctx context.Context) (*StatusResponse, error) {
+	resp, err := c.call(ctx, CmdStatus, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -157,33 +301,164 @@ func (c *socketClient) Status() (*StatusResponse, error) {
 	return &status, nil
 }
 
-func (c *socketClient) GetProtectionStatus() (bool, error) {
-	status, err := c.Status()
+func (c *socketClient) GetProtectionStatus(ctx context.Context) (bool, error) {
+	status, err := c.Status(ctx)
 	if err != nil {
 		return false, err
 	}
 	return status.State == "protected", nil
 }
 
-func (c *socketClient) SetFirewallEnabled(enabled bool) error {
+func (c *socketClient) SetFirewallEnabled(ctx context.Context, enabled bool) error {
 	cmd := CmdFirewallDisable
 	if enabled {
 		cmd = CmdFirewallEnable
 	}
-	_, err := c.call(cmd, nil)
+	_, err := c.call(ctx, cmd, nil)
 	return err
 }
 
-func (c *socketClient) IsFirewallEnabled() (bool, error) {
-	status, err := c.Status()
+func (c *socketClient) IsFirewallEnabled(ctx context.Context) (bool, error) {
+	status, err := c.Status(ctx)
 	if err != nil {
 		return false, err
 	}
 	return status.FirewallEnabled, nil
 }
 
-func (c *socketClient) StartQuickScan() (*ScanResponse, error) {
-	resp, err := c.call(CmdScanQuick, nil)
+func (c *socketClient) FirewallBlocked(ctx context.Context, params FirewallBlockedParams) (*FirewallBlockedResponse, error) {
+	resp, err := c.call(ctx, CmdFirewallBlocked, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocked FirewallBlockedResponse
+	if err := resp.UnmarshalData(&blocked); err != nil {
+		return nil, err
+	}
+	return &blocked, nil
+}
+
+func (c *socketClient) SetFirewallPanic(ctx context.Context, enabled bool) error {
+	_, err := c.call(ctx, CmdFirewallPanic, FirewallPanicParams{Enabled: enabled})
+	return err
+}
+
+func (c *socketClient) Bans(ctx context.Context) ([]BanInfo, error) {
+	resp, err := c.call(ctx, CmdBansList, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var bans BansResponse
+	if err := resp.UnmarshalData(&bans); err != nil {
+		return nil, err
+	}
+	return bans.Bans, nil
+}
+
+func (c *socketClient) LiftBan(ctx context.Context, address string) error {
+	_, err := c.call(ctx, CmdBanLift, BanLiftParams{Address: address})
+	return err
+}
+
+func (c *socketClient) AppRules(ctx context.Context) (*AppRulesResponse, error) {
+	resp, err := c.call(ctx, CmdAppRules, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules AppRulesResponse
+	if err := resp.UnmarshalData(&rules); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+func (c *socketClient) AppLearned(ctx context.Context) (*AppLearnedResponse, error) {
+	resp, err := c.call(ctx, CmdAppLearned, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var learned AppLearnedResponse
+	if err := resp.UnmarshalData(&learned); err != nil {
+		return nil, err
+	}
+	return &learned, nil
+}
+
+func (c *socketClient) SetAppLearning(ctx context.Context, enabled bool) error {
+	_, err := c.call(ctx, CmdAppLearning, AppLearningParams{Enabled: enabled})
+	return err
+}
+
+func (c *socketClient) NetInventory(ctx context.Context, params NetInventoryParams) (*NetInventoryResponse, error) {
+	resp, err := c.call(ctx, CmdNetInventory, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var inv NetInventoryResponse
+	if err := resp.UnmarshalData(&inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (c *socketClient) RulesStatus(ctx context.Context) (*RulesStatusResponse, error) {
+	return c.rules(ctx, CmdRulesStatus)
+}
+
+// UpdateRules starts an update and returns right away; poll RulesStatus
+// until Updating is false to see how it went.
+func (c *socketClient) UpdateRules(ctx context.Context) (*RulesStatusResponse, error) {
+	return c.rules(ctx, CmdRulesUpdate)
+}
+
+func (c *socketClient) ImportRules(ctx context.Context, path string) (*RulesImportResponse, error) {
+	resp, err := c.call(ctx, CmdRulesImport, RulesImportParams{Path: path})
+	if err != nil {
+		return nil, err
+	}
+
+	var result RulesImportResponse
+	if err := resp.UnmarshalData(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *socketClient) rules(ctx context.Context, cmd string) (*RulesStatusResponse, error) {
+	resp, err := c.call(ctx, cmd, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var status RulesStatusResponse
+	if err := resp.UnmarshalData(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Scan starts a scan. With params.Follow it returns when the scan has
+// finished; cancelling ctx then cancels the scan too.
+func (c *socketClient) Scan(ctx context.Context, params ScanParams) (*ScanStatusResponse, error) {
+	resp, err := c.call(ctx, CmdScan, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var status ScanStatusResponse
+	if err := resp.UnmarshalData(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *socketClient) StartQuickScan(ctx context.Context) (*ScanResponse, error) {
+	resp, err := c.call(ctx, CmdScanQuick, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -195,8 +470,8 @@ func (c *socketClient) StartQuickScan() (*ScanResponse, error) {
 	return &scanResp, nil
 }
 
-func (c *socketClient) StartFullScan() (*ScanResponse, error) {
-	resp, err := c.call(CmdScanFull, nil)
+func (c *socketClient) StartFullScan(ctx context.Context) (*ScanResponse, error) {
+	resp, err := c.call(ctx, CmdScanFull, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -208,82 +483,327 @@ func (c *socketClient) StartFullScan() (*ScanResponse, error) {
 	return &scanResp, nil
 }
 
-func (c *socketClient) Pause() error {
-	_, err := c.call(CmdPause, nil)
-	return err
+func (c *socketClient) ScanStatus(ctx context.Context, jobID string) (*ScanStatusResponse, error) {
+	resp, err := c.call(ctx, CmdScanStatus, ScanJobParams{JobID: jobID})
+	if err != nil {
+		return nil, err
+	}
+
+	var status ScanStatusResponse
+	if err := resp.UnmarshalData(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
 }
 
-func (c *socketClient) Resume() error {
-	_, err := c.call(CmdResume, nil)
+func (c *socketClient) CancelScan(ctx context.Context, jobID string) error {
+	_, err := c.call(ctx, CmdScanCancel, ScanJobParams{JobID: jobID})
 	return err
 }
 
-func (c *socketClient) Subscribe() (<-chan StateChangeEvent, error) {
-	// Create a dedicated connection for subscription
-	conn, err := net.Dial("unix", c.socketPath)
+func (c *socketClient) ScanHistory(ctx context.Context, limit int) ([]ScanStatusResponse, error) {
+	resp, err := c.call(ctx, CmdScanHistory, ScanHistoryParams{Limit: limit})
 	if err != nil {
-		return nil, fmt.Errorf("connect for subscribe: %w", err)
+		return nil, err
 	}
 
-	// Send subscribe request
-	req := Request{Version: ProtocolVersion, ID: "sub", Command: CmdSubscribe}
-	data, _ := json.Marshal(req)
-	data = append(data, '\n')
-	if _, err := conn.Write(data); err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("send subscribe: %w", err)
+	var history ScanHistoryResponse
+	if err := resp.UnmarshalData(&history); err != nil {
+		return nil, err
 	}
+	return history.Scans, nil
+}
 
-	// Read subscription confirmation
-	reader := bufio.NewReader(conn)
-	line, err := reader.ReadBytes('\n')
+func (c *socketClient) Quarantine(ctx context.Context) ([]QuarantineItem, error) {
+	resp, err := c.call(ctx, CmdQuarantineList, nil)
 	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("read subscribe response: %w", err)
+		return nil, err
 	}
 
-	var resp Response
-	if err := json.Unmarshal(line, &resp); err != nil || !resp.Success {
-		conn.Close()
-		return nil, fmt.Errorf("subscribe failed: %s", resp.Error)
+	var list QuarantineListResponse
+	if err := resp.UnmarshalData(&list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (c *socketClient) RestoreQuarantined(ctx context.Context, id string) error {
+	_, err := c.call(ctx, CmdQuarantineRestore, QuarantineParams{ID: id})
+	return err
+}
+
+func (c *socketClient) DeleteQuarantined(ctx context.Context, id string) error {
+	_, err := c.call(ctx, CmdQuarantineDelete, QuarantineParams{ID: id})
+	return err
+}
+
+func (c *socketClient) Events(ctx context.Context, params EventsParams) ([]Event, error) {
+	resp, err := c.call(ctx, CmdEvents, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var events EventsResponse
+	if err := resp.UnmarshalData(&events); err != nil {
+		return nil, err
+	}
+	return events.Events, nil
+}
+
+// Pause pauses protection for a duration like "15m" or "1h". "reboot" or
+// an empty duration pauses until Resume.
+func (c *socketClient) Pause(ctx context.Context, duration string) error {
+	_, err := c.call(ctx, CmdPause, PauseParams{Duration: duration})
+	return err
+}
+
+func (c *socketClient) Resume(ctx context.Context) error {
+	_, err := c.call(ctx, CmdResume, nil)
+	return err
+}
+
+// Subscribe delivers state changes, reconnecting like Watch. After a
+// reconnect that missed notifications, the current state is sent as a
+// change from "" so the subscriber catches up.
+func (c *socketClient) Subscribe(ctx context.Context) (<-chan StateChangeEvent, error) {
+	ctx, cancel := c.scope(ctx)
+	notes, err := c.Watch(ctx, WatchParams{Kinds: []string{KindStateChange}})
+	if err != nil {
+		cancel()
+		return nil, err
 	}
 
-	// Create channel and start reading events
 	events := make(chan StateChangeEvent, 10)
 	go func() {
-		defer conn.Close()
+		defer cancel()
 		defer close(events)
-
-		for {
-			line, err := reader.ReadBytes('\n')
-			if err != nil {
+		for n := range notes {
+			var event StateChangeEvent
+			switch p := n.Payload.(type) {
+			case *StateChangeEvent:
+				event = *p
+			case *WatchResponse:
+				if !p.Missed {
+					continue
+				}
+				status, err := c.Status(ctx)
+				if err != nil {
+					continue
+				}
+				event = StateChangeEvent{NewState: status.State}
+			default:
+				continue
+			}
+			if !deliver(ctx, events, event) {
 				return
 			}
+		}
+	}()
+	return events, nil
+}
 
+func (c *socketClient) SubscribeEvents(ctx context.Context, params SubscribeEventsParams) (<-chan Event, error) {
+	ctx, cancel := c.scope(ctx)
+	conn, reader, _, err := c.subscribe(ctx, CmdSubscribeEvents, params)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	events := make(chan Event, 100)
+	go func() {
+		defer cancel()
+		defer close(events)
+		readPushed(ctx, conn, reader, func(line []byte) bool {
 			var resp Response
-			if err := json.Unmarshal(line, &resp); err != nil {
-				continue
+			var event Event
+			if json.Unmarshal(line, &resp) != nil || resp.UnmarshalData(&event) != nil {
+				return true
 			}
+			return deliver(ctx, events, event)
+		})
+	}()
+	return events, nil
+}
 
-			var event StateChangeEvent
-			if err := resp.UnmarshalData(&event); err != nil {
-				continue
+// Reconnect backoff for Watch.
+const (
+	watchRetryMin = 500 * time.Millisecond
+	watchRetryMax = 30 * time.Second
+)
+
+// Watch streams typed notifications. Each Notification's Payload is the
+// concrete type for its Kind, e.g. *ThreatEvent for KindThreat.
+//
+// Only the first connection attempt can fail. After that, a dropped
+// connection is reported as KindDisconnected and retried with backoff;
+// once back, KindReconnected carries the daemon's *WatchResponse and
+// anything missed in between is replayed from the daemon's buffer. The
+// channel closes when ctx is done or the client is closed.
+func (c *socketClient) Watch(ctx context.Context, params WatchParams) (<-chan Notification, error) {
+	ctx, cancel := c.scope(ctx)
+	conn, reader, ack, err := c.subscribe(ctx, CmdWatch, params)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	notes := make(chan Notification, 100)
+	go func() {
+		defer cancel()
+		defer close(notes)
+		for {
+			// track where to resume from: a fresh watch is live from
+			// ack.Seq, while after a daemon restart the new run's buffer is
+			// replayed from the start
+			if ack.Epoch != params.Epoch {
+				if params.Epoch == "" {
+					params.After = ack.Seq
+				} else {
+					params.After = 0
+				}
+				params.Epoch = ack.Epoch
+			}
+			err := readPushed(ctx, conn, reader, func(line []byte) bool {
+				var env Envelope
+				if json.Unmarshal(line, &env) != nil {
+					return true
+				}
+				params.After = max(params.After, env.Seq)
+				payload, err := env.Decode()
+				if err != nil {
+					return true
+				}
+				return deliver(ctx, notes, Notification{Kind: env.Kind, Seq: env.Seq, Time: env.Time, Payload: payload})
+			})
+			if ctx.Err() != nil || !deliver(ctx, notes, Notification{Kind: KindDisconnected, Time: time.Now(), Payload: err}) {
+				return
 			}
 
-			select {
-			case events <- event:
-			default:
-				// drop if channel is full
+			delay := watchRetryMin
+			for {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+				if conn, reader, ack, err = c.subscribe(ctx, CmdWatch, params); err == nil {
+					break
+				}
+				delay = min(delay*2, watchRetryMax)
+			}
+			reconnected := ack
+			if !deliver(ctx, notes, Notification{Kind: KindReconnected, Time: time.Now(), Payload: &reconnected}) {
+				return
 			}
 		}
 	}()
+	return notes, nil
+}
 
-	return events, nil
+// deliver sends v on ch, waiting for the reader rather than dropping it.
+// It returns false if ctx was done first.
+func deliver[T any](ctx context.Context, ch chan<- T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// scope derives a context for a subscription that ends with either ctx
+// or the client.
+func (c *socketClient) scope(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(c.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// subscribe opens a dedicated connection and sends a subscription
+// command, returning once the daemon has acknowledged it. ack is only
+// filled in for CmdWatch.
+func (c *socketClient) subscribe(ctx context.Context, cmd string, params interface{}) (net.Conn, *bufio.Reader, WatchResponse, error) {
+	var ack WatchResponse
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", c.socketPath)
+	if err != nil {
+		return nil, nil, ack, fmt.Errorf("connect for subscribe: %w", err)
+	}
+	// unblocks the ack read below if ctx ends first
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	req := Request{Version: ProtocolVersion, ID: "sub", Command: cmd}
+	if params != nil {
+		if req.Params, err = json.Marshal(params); err != nil {
+			conn.Close()
+			return nil, nil, ack, fmt.Errorf("marshal params: %w", err)
+		}
+	}
+	data, _ := json.Marshal(req)
+	data = append(data, '\n')
+	if _, err := conn.Write(data); err != nil {
+		conn.Close()
+		return nil, nil, ack, fmt.Errorf("send subscribe: %w", err)
+	}
+
+	// Read subscription confirmation
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		conn.Close()
+		return nil, nil, ack, fmt.Errorf("read subscribe response: %w", err)
+	}
+
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		conn.Close()
+		return nil, nil, ack, fmt.Errorf("subscribe failed: %w", err)
+	}
+	if err := resp.Err(); err != nil {
+		conn.Close()
+		return nil, nil, ack, fmt.Errorf("subscribe failed: %w", err)
+	}
+	if cmd == CmdWatch {
+		if err := resp.UnmarshalData(&ack); err != nil {
+			conn.Close()
+			return nil, nil, ack, fmt.Errorf("subscribe failed: %w", err)
+		}
+	}
+	return conn, reader, ack, nil
+}
+
+// readPushed hands each pushed line to handle until the connection drops,
+// handle returns false, or ctx is done. It closes conn.
+func readPushed(ctx context.Context, conn net.Conn, reader *bufio.Reader, handle func(line []byte) bool) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+		if !handle(line) {
+			return nil
+		}
+	}
+}
+
+func (c *socketClient) isClosed() bool {
+	return c.ctx.Err() != nil
 }
 
 func (c *socketClient) Close() error {
+	c.cancel() // ends subscriptions
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.conn != nil {
-		return c.conn.Close()
+		return c.conn.conn.Close()
 	}
 	return nil
 }
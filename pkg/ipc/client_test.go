@@ -4,7 +4,10 @@ package ipc
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
 	"path/filepath"
 	"testing"
@@ -45,8 +48,9 @@ func mockIPCServer(t *testing.T, handler func(req *Request) *Response) (string,
 						continue
 					}
 
-					resp := handler(&req)
-					encoder.Encode(resp)
+					if resp := handler(&req); resp != nil {
+						encoder.Encode(resp)
+					}
 				}
 			}(conn)
 		}
@@ -78,7 +82,7 @@ func TestClient_Status(t *testing.T) {
 	client := NewClient(sockPath)
 	defer client.Close()
 
-	status, err := client.Status()
+	status, err := client.Status(t.Context())
 	if err != nil {
 		t.Fatalf("Status() error = %v", err)
 	}
@@ -101,7 +105,7 @@ func TestClient_GetProtectionStatus(t *testing.T) {
 	client := NewClient(sockPath)
 	defer client.Close()
 
-	protected, err := client.GetProtectionStatus()
+	protected, err := client.GetProtectionStatus(t.Context())
 	if err != nil {
 		t.Fatalf("GetProtectionStatus() error = %v", err)
 	}
@@ -124,7 +128,7 @@ func TestClient_SetFirewallEnabled(t *testing.T) {
 	defer client.Close()
 
 	// Test enable
-	if err := client.SetFirewallEnabled(true); err != nil {
+	if err := client.SetFirewallEnabled(t.Context(), true); err != nil {
 		t.Fatalf("SetFirewallEnabled(true) error = %v", err)
 	}
 	if receivedCmd != CmdFirewallEnable {
@@ -132,7 +136,7 @@ func TestClient_SetFirewallEnabled(t *testing.T) {
 	}
 
 	// Test disable
-	if err := client.SetFirewallEnabled(false); err != nil {
+	if err := client.SetFirewallEnabled(t.Context(), false); err != nil {
 		t.Fatalf("SetFirewallEnabled(false) error = %v", err)
 	}
 	if receivedCmd != CmdFirewallDisable {
@@ -153,7 +157,7 @@ func TestClient_StartQuickScan(t *testing.T) {
 	client := NewClient(sockPath)
 	defer client.Close()
 
-	resp, err := client.StartQuickScan()
+	resp, err := client.StartQuickScan(t.Context())
 	if err != nil {
 		t.Fatalf("StartQuickScan() error = %v", err)
 	}
@@ -164,9 +168,13 @@ func TestClient_StartQuickScan(t *testing.T) {
 
 func TestClient_PauseResume(t *testing.T) {
 	var receivedCmd string
+	var params PauseParams
 
 	sockPath, cleanup := mockIPCServer(t, func(req *Request) *Response {
 		receivedCmd = req.Command
+		if req.Command == CmdPause {
+			json.Unmarshal(req.Params, &params)
+		}
 		data, _ := json.Marshal("ok")
 		return &Response{ID: req.ID, Success: true, Data: data}
 	})
@@ -175,14 +183,14 @@ func TestClient_PauseResume(t *testing.T) {
 	client := NewClient(sockPath)
 	defer client.Close()
 
-	if err := client.Pause(); err != nil {
+	if err := client.Pause(t.Context(), "15m"); err != nil {
 		t.Fatalf("Pause() error = %v", err)
 	}
-	if receivedCmd != CmdPause {
-		t.Errorf("command = %v, want %v", receivedCmd, CmdPause)
+	if receivedCmd != CmdPause || params.Duration != "15m" {
+		t.Errorf("command = %v %+v, want %v 15m", receivedCmd, params, CmdPause)
 	}
 
-	if err := client.Resume(); err != nil {
+	if err := client.Resume(t.Context()); err != nil {
 		t.Fatalf("Resume() error = %v", err)
 	}
 	if receivedCmd != CmdResume {
@@ -203,7 +211,7 @@ func TestClient_Reconnect(t *testing.T) {
 	defer client.Close()
 
 	// First call
-	_, err := client.Status()
+	_, err := client.Status(t.Context())
 	if err != nil {
 		t.Fatalf("first Status() error = %v", err)
 	}
@@ -239,7 +247,7 @@ func TestClient_Error(t *testing.T) {
 	client := NewClient(sockPath)
 	defer client.Close()
 
-	_, err := client.Status()
+	_, err := client.Status(t.Context())
 	if err == nil {
 		t.Fatal("Status() should return error")
 	}
@@ -248,11 +256,139 @@ func TestClient_Error(t *testing.T) {
 	}
 }
 
+func TestClient_TypedError(t *testing.T) {
+	sockPath, cleanup := mockIPCServer(t, func(req *Request) *Response {
+		return &Response{
+			ID:      req.ID,
+			Error:   "unknown command: hello",
+			Code:    CodeUnknownCommand,
+			Details: map[string]any{"command": req.Command},
+		}
+	})
+	defer cleanup()
+
+	client := NewClient(sockPath)
+	defer client.Close()
+
+	_, err := client.Hello(t.Context(), "test")
+	if !errors.Is(err, ErrUnknownCommand) {
+		t.Fatalf("Hello() error = %v, want ErrUnknownCommand", err)
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.Details["command"] != CmdHello {
+		t.Errorf("errors.As = %+v", e)
+	}
+}
+
+func TestClient_Concurrent(t *testing.T) {
+	// a daemon that holds scan_history until status has been answered
+	sockPath := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		encoder := json.NewEncoder(conn)
+		var held *Request
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				return
+			}
+			var req Request
+			json.Unmarshal(line, &req)
+			if req.Command == CmdScanHistory {
+				held = &req
+				continue
+			}
+			data, _ := json.Marshal(StatusResponse{State: "protected"})
+			encoder.Encode(&Response{ID: req.ID, Success: true, Data: data})
+			if held != nil {
+				data, _ := json.Marshal(ScanHistoryResponse{Scans: []ScanStatusResponse{{JobID: "old"}}})
+				encoder.Encode(&Response{ID: held.ID, Success: true, Data: data})
+			}
+		}
+	}()
+
+	client := NewClient(sockPath)
+	defer client.Close()
+
+	history := make(chan error)
+	go func() {
+		scans, err := client.ScanHistory(t.Context(), 10)
+		if err == nil && (len(scans) != 1 || scans[0].JobID != "old") {
+			err = fmt.Errorf("scans = %+v", scans)
+		}
+		history <- err
+	}()
+	time.Sleep(50 * time.Millisecond) // let scan_history go out first
+
+	status, err := client.Status(t.Context())
+	if err != nil || status.State != "protected" {
+		t.Fatalf("Status() = %+v, %v", status, err)
+	}
+	if err := <-history; err != nil {
+		t.Errorf("ScanHistory() error = %v", err)
+	}
+}
+
+func TestClient_Context(t *testing.T) {
+	// answers everything but scan_history
+	cancelled := make(chan string, 1)
+	sockPath, cleanup := mockIPCServer(t, func(req *Request) *Response {
+		if req.Command == CmdCancel {
+			var params CancelParams
+			json.Unmarshal(req.Params, &params)
+			cancelled <- string(params.ID)
+			return nil
+		}
+		if req.Command == CmdScanHistory {
+			return nil
+		}
+		return &Response{ID: req.ID, Success: true, Data: json.RawMessage(`{"state":"protected"}`)}
+	})
+	defer cleanup()
+
+	client := NewClient(sockPath)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.ScanHistory(ctx, 10); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ScanHistory() error = %v, want deadline exceeded", err)
+	}
+	// and the daemon is told to stop
+	select {
+	case id := <-cancelled:
+		if id != `"1"` {
+			t.Errorf("cancelled id = %s, want \"1\"", id)
+		}
+	case <-time.After(time.Second):
+		t.Error("no cancel sent")
+	}
+	// the connection is still good for the next call
+	if _, err := client.Status(t.Context()); err != nil {
+		t.Errorf("Status() after timeout error = %v", err)
+	}
+
+	client.Close()
+	if _, err := client.Status(t.Context()); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("Status() after Close error = %v, want ErrClientClosed", err)
+	}
+}
+
 func TestClient_ConnectionFailure(t *testing.T) {
 	client := NewClient("/nonexistent/socket.sock")
 	defer client.Close()
 
-	_, err := client.Status()
+	_, err := client.Status(t.Context())
 	if err == nil {
 		t.Fatal("Status() should return error for nonexistent socket")
 	}
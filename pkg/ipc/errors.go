@@ -0,0 +1,116 @@
+// oreon/defense · watchthelight <wtl>
+
+package ipc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error codes, sent in Response.Code so clients don't have to parse
+// messages. Daemons older than the hello command send none.
+const (
+	CodeUnknownCommand   = "unknown_command"
+	CodeInvalidParams    = "invalid_params"
+	CodePermissionDenied = "permission_denied"
+	CodeBusy             = "busy"             // a scan or rules update is already running
+	CodeNotFound         = "not_found"        // no such scan, quarantine item, ...
+	CodeUnavailable      = "unavailable"      // the subsystem isn't running
+	CodeVersionMismatch  = "version_mismatch" // see HelloResponse for the range
+	CodeCanceled         = "canceled"         // the client cancelled or went away
+	CodeFailed           = "failed"           // anything else
+)
+
+// Sentinel errors, one per code. Errors returned by Client methods match
+// them with errors.Is:
+//
+//	if errors.Is(err, ipc.ErrPermissionDenied) { ... }
+//
+// and errors.As(err, &ipcErr) with an *Error gets the code and details.
+var (
+	ErrUnknownCommand   = errors.New("unknown command")
+	ErrInvalidParams    = errors.New("invalid params")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrBusy             = errors.New("busy")
+	ErrNotFound         = errors.New("not found")
+	ErrUnavailable      = errors.New("unavailable")
+	ErrVersionMismatch  = errors.New("protocol version mismatch")
+	ErrCanceled         = errors.New("canceled")
+	ErrFailed           = errors.New("failed")
+)
+
+// codeErrors pairs each code with its sentinel. A slice rather than a map
+// so an error wrapping several sentinels always gets the same code.
+var codeErrors = []struct {
+	code string
+	err  error
+}{
+	{CodeUnknownCommand, ErrUnknownCommand},
+	{CodeInvalidParams, ErrInvalidParams},
+	{CodePermissionDenied, ErrPermissionDenied},
+	{CodeBusy, ErrBusy},
+	{CodeNotFound, ErrNotFound},
+	{CodeUnavailable, ErrUnavailable},
+	{CodeVersionMismatch, ErrVersionMismatch},
+	{CodeCanceled, ErrCanceled},
+	{CodeFailed, ErrFailed},
+}
+
+// sentinel returns the sentinel error for code, nil if there's none.
+func sentinel(code string) error {
+	for _, ce := range codeErrors {
+		if ce.code == code {
+			return ce.err
+		}
+	}
+	return nil
+}
+
+// Error is a failed command as reported by the daemon.
+type Error struct {
+	Code    string         // one of the Code constants
+	Message string         // human-readable, as the daemon logged it
+	Details map[string]any // code-specific, e.g. the command that was refused
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is matches the sentinel for e's code, or another *Error with the same code.
+func (e *Error) Is(target error) bool {
+	if t, ok := target.(*Error); ok {
+		return t.Code == e.Code
+	}
+	s := sentinel(e.Code)
+	return s != nil && target == s
+}
+
+// ErrorCode returns the code for err: its own if it's (or wraps) an
+// *Error, else that of the first sentinel in codeErrors it wraps, else
+// CodeFailed.
+func ErrorCode(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	for _, ce := range codeErrors {
+		if errors.Is(err, ce.err) {
+			return ce.code
+		}
+	}
+	return CodeFailed
+}
+
+// Err returns the response's error, or nil if it succeeded. Responses
+// from daemons that don't send codes get CodeFailed.
+func (r *Response) Err() error {
+	if r.Success {
+		return nil
+	}
+	code := r.Code
+	if code == "" {
+		code = CodeFailed
+	}
+	return fmt.Errorf("daemon error: %w", &Error{Code: code, Message: r.Error, Details: r.Details})
+}
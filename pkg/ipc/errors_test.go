@@ -0,0 +1,58 @@
+// oreon/defense · watchthelight <wtl>
+
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestError_Is(t *testing.T) {
+	err := (&Response{Error: "permission denied: pause needs root", Code: CodePermissionDenied,
+		Details: map[string]any{"command": "pause"}}).Err()
+
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Error("errors.Is(err, ErrPermissionDenied) = false")
+	}
+	if errors.Is(err, ErrBusy) {
+		t.Error("errors.Is(err, ErrBusy) = true")
+	}
+	if !errors.Is(err, &Error{Code: CodePermissionDenied}) {
+		t.Error("errors.Is(err, &Error{permission_denied}) = false")
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.Details["command"] != "pause" {
+		t.Errorf("errors.As = %+v", e)
+	}
+	if err.Error() != "daemon error: permission denied: pause needs root" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+
+	// old daemons send no code
+	if err := (&Response{Error: "boom"}).Err(); !errors.Is(err, ErrFailed) {
+		t.Errorf("codeless error = %v, want ErrFailed", err)
+	}
+	if err := (&Response{Success: true}).Err(); err != nil {
+		t.Errorf("Err() on success = %v", err)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&Error{Code: CodeBusy}, CodeBusy},
+		{fmt.Errorf("wrapped: %w", &Error{Code: CodeNotFound}), CodeNotFound},
+		{fmt.Errorf("%w: bad json", ErrInvalidParams), CodeInvalidParams},
+		{errors.New("disk on fire"), CodeFailed},
+		// several sentinels: always the same one, not map order
+		{errors.Join(ErrNotFound, ErrBusy, ErrInvalidParams), CodeInvalidParams},
+	}
+	for _, tt := range tests {
+		if got := ErrorCode(tt.err); got != tt.want {
+			t.Errorf("ErrorCode(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// oreon/defense · watchthelight <wtl>
+
+package ipc
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// JSON-RPC 2.0 is spoken on the same socket as the v1 Request/Response
+// format. A connection whose first message has "jsonrpc": "2.0" (or is a
+// batch) uses it for its lifetime; anything else is v1, so existing
+// clients are unaffected.
+//
+// Methods are the command names ("status", "firewall_enable", ...) and
+// params the same objects. After "watch" (or "subscribe",
+// "subscribe_events") notifications arrive as JSON-RPC notifications with
+// method RPCNotifyMethod and an Envelope as params.
+//
+//	{"jsonrpc": "2.0", "id": 1, "method": "pause", "params": {"duration": "15m"}}
+//	{"jsonrpc": "2.0", "id": 1, "result": "protection paused"}
+const JSONRPCVersion = "2.0"
+
+// RPCNotifyMethod is the method of pushed notifications.
+const RPCNotifyMethod = "notify"
+
+// JSON-RPC error codes: the spec's, then ours from its server range.
+const (
+	RPCParseError     = -32700 // not JSON
+	RPCInvalidRequest = -32600 // JSON, but not a request
+	RPCMethodNotFound = -32601 // unknown command
+	RPCInvalidParams  = -32602 // params don't fit the command
+	RPCInternalError  = -32603
+
+	RPCFailed           = -32000 // the command ran and failed; see the message
+	RPCPermissionDenied = -32001 // the caller may not run the command
+)
+
+// RPCRequest is a JSON-RPC request. No ID makes it a notification, which
+// gets no response.
+type RPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"` // string, number or null
+}
+
+// RPCResponse answers an RPCRequest; exactly one of Result and Error is set.
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"` // null if the request's couldn't be read
+}
+
+// RPCError is a JSON-RPC error object.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// RPCNotification is a notification pushed by the daemon.
+type RPCNotification struct {
+	JSONRPC string   `json:"jsonrpc"`
+	Method  string   `json:"method"`
+	Params  Envelope `json:"params"`
+}
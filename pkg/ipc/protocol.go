@@ -4,6 +4,8 @@ package ipc
 
 import (
 	"encoding/json"
+	"fmt"
+	"slices"
 	"time"
 )
 
@@ -11,6 +13,9 @@ import (
 // Bump this when making breaking changes to the protocol.
 const ProtocolVersion = 1
 
+// MinProtocolVersion is the oldest version the daemon still accepts.
+const MinProtocolVersion = 1
+
 // Request is sent from client (tray, CLI) to daemon.
 //
 // Example requests:
@@ -31,6 +36,10 @@ type Response struct {
 	Success bool            `json:"success"`         // true if command succeeded
 	Data    json.RawMessage `json:"data,omitempty"`  // command-specific response data (raw JSON)
 	Error   string          `json:"error,omitempty"` // error message if success=false
+
+	// Code and Details describe a failure for programs; see Err.
+	Code    string         `json:"code,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
 }
 
 // UnmarshalData decodes the raw JSON data into the target type.
@@ -43,6 +52,8 @@ func (r *Response) UnmarshalData(target interface{}) error {
 
 // Commands - use these constants instead of raw strings.
 const (
+	CmdHello    = "hello"    // daemon version, protocol range, commands and features
+	CmdCancel   = "cancel"   // stop a request still running on this connection
 	CmdStatus   = "status"   // get current daemon state
 	CmdPing     = "ping"     // health check
 	CmdScan     = "scan"     // start a scan
@@ -54,6 +65,8 @@ const (
 	CmdFirewallStatus  = "firewall_status"
 	CmdFirewallEnable  = "firewall_enable"
 	CmdFirewallDisable = "firewall_disable"
+	CmdFirewallBlocked = "firewall_blocked" // recent blocked connections + top sources
+	CmdFirewallPanic   = "firewall_panic"   // block all traffic / lift the block
 
 	// Scan commands
 	CmdScanQuick   = "scan_quick"
@@ -62,12 +75,35 @@ const (
 	CmdScanCancel  = "scan_cancel"
 	CmdScanHistory = "scan_history"
 
+	// Quarantine
+	CmdQuarantineList    = "quarantine_list"
+	CmdQuarantineRestore = "quarantine_restore"
+	CmdQuarantineDelete  = "quarantine_delete"
+
+	// Event queries
+	CmdEvents = "events" // recent events, newest first
+
+	// Intrusion detection bans
+	CmdBansList = "bans_list"
+	CmdBanLift  = "ban_lift"
+
+	// Per-application outbound rules
+	CmdAppRules    = "app_rules"    // configured rules and what they matched
+	CmdAppLearned  = "app_learned"  // apps seen in learning mode + proposed rules
+	CmdAppLearning = "app_learning" // turn learning mode on/off
+
+	// Network inventory
+	CmdNetInventory = "net_inventory" // listening sockets + connections with owning processes
+
 	// Rule updates
-	CmdRulesStatus = "rules_status"
-	CmdRulesUpdate = "rules_update"
+	CmdRulesStatus = "rules_status" // per-source versions and staleness
+	CmdRulesUpdate = "rules_update" // start an update in the background
+	CmdRulesImport = "rules_import" // install a signed offline bundle
 
 	// Subscriptions
-	CmdSubscribe = "subscribe" // subscribe to state changes (push notifications)
+	CmdSubscribe       = "subscribe"        // subscribe to state changes (push notifications)
+	CmdSubscribeEvents = "subscribe_events" // stream every daemon event, see SubscribeEventsParams
+	CmdWatch           = "watch"            // typed notifications in an Envelope, see WatchParams
 )
 
 // StateChangeEvent is pushed to subscribed clients when state changes.
@@ -76,6 +112,34 @@ type StateChangeEvent struct {
 	NewState string `json:"new_state"`
 }
 
+// CancelParams for CmdCancel. Cancelling only reaches requests sent on
+// the same connection with an ID; the request then fails with
+// CodeCanceled unless it finished first. A cancel without an ID of its own
+// gets no response.
+type CancelParams struct {
+	ID json.RawMessage `json:"id"` // the request's ID: a string for v1, any JSON-RPC id
+}
+
+// HelloParams for CmdHello. Both fields are informational.
+type HelloParams struct {
+	Client   string `json:"client,omitempty"`   // e.g. "defensectl/0.1.0"
+	Protocol int    `json:"protocol,omitempty"` // newest version the client speaks
+}
+
+// HelloResponse is returned by CmdHello.
+type HelloResponse struct {
+	Daemon      string   `json:"daemon"` // daemon version
+	Protocol    int      `json:"protocol"`
+	MinProtocol int      `json:"min_protocol"`
+	Commands    []string `json:"commands"` // every command this daemon answers
+	Features    []string `json:"features"` // e.g. "jsonrpc", "watch_resume", "polkit"
+}
+
+// Has reports whether the daemon advertised feature.
+func (h *HelloResponse) Has(feature string) bool {
+	return slices.Contains(h.Features, feature)
+}
+
 // StatusResponse is returned by CmdStatus.
 //
 // Example (josh will use this for tray icon):
@@ -88,13 +152,24 @@ type StatusResponse struct {
 	FirewallEnabled bool      `json:"firewall_enabled"` // pan's firewall integration
 	LastScan        time.Time `json:"last_scan"`
 	RulesUpdated    time.Time `json:"rules_updated"`
+	PausedUntil     time.Time `json:"paused_until,omitzero"` // set while a timed pause runs
 }
 
-// ScanParams for CmdScan.
+// ScanParams for CmdScan. Without Paths it runs a quick or full scan
+// like CmdScanQuick/CmdScanFull; with them it's a "custom" scan of just
+// those files and directories.
 type ScanParams struct {
-	Type string `json:"type"` // "quick" or "full"
+	Type    string   `json:"type,omitempty"`    // "quick" (default) or "full"; ignored when Paths is set
+	Paths   []string `json:"paths,omitempty"`   // absolute paths to files or directories
+	Depth   int      `json:"depth,omitempty"`   // directory levels to descend, 1 = only the files directly inside; 0 = no limit
+	Exclude []string `json:"exclude,omitempty"` // glob patterns skipped this run, on top of scanning.exclusions
+	Engine  string   `json:"engine,omitempty"`  // see ScanEngines; "" = the default
+	Follow  bool     `json:"follow,omitempty"`  // answer when the scan finishes instead of when it starts
 }
 
+// ScanEngines are the engines CmdScan accepts, the default first.
+var ScanEngines = []string{"clamav"}
+
 // ScanResponse is returned when starting a scan.
 type ScanResponse struct {
 	JobID string `json:"job_id"`
@@ -102,24 +177,419 @@ type ScanResponse struct {
 
 // ScanStatusResponse is returned by CmdScanStatus.
 type ScanStatusResponse struct {
-	JobID        string    `json:"job_id"`
-	Status       string    `json:"status"` // "running", "completed", "cancelled"
-	Progress     float64   `json:"progress"`
-	FilesScanned int       `json:"files_scanned"`
-	ThreatsFound int       `json:"threats_found"`
-	StartedAt    time.Time `json:"started_at"`
+	JobID        string       `json:"job_id"`
+	Type         string       `json:"type"`
+	Status       string       `json:"status"` // "running", "completed", "cancelled", "failed"
+	Progress     float64      `json:"progress"`
+	FilesScanned int          `json:"files_scanned"`
+	ThreatsFound int          `json:"threats_found"`
+	StartedAt    time.Time    `json:"started_at"`
+	FinishedAt   time.Time    `json:"finished_at,omitzero"`
+	Threats      []ScanThreat `json:"threats"`
+	Error        string       `json:"error,omitempty"`
+}
+
+// ScanThreat is an infected file found by a scan.
+type ScanThreat struct {
+	Path         string `json:"path"`
+	Threat       string `json:"threat"`
+	Action       string `json:"action"` // "detected" or "quarantined"
+	QuarantineID string `json:"quarantine_id,omitempty"`
+}
+
+// ScanJobParams for CmdScanStatus and CmdScanCancel. An empty JobID means
+// the running scan (or, for status, the most recent one).
+type ScanJobParams struct {
+	JobID string `json:"job_id,omitempty"`
+}
+
+// ScanHistoryParams for CmdScanHistory.
+type ScanHistoryParams struct {
+	Limit int `json:"limit,omitempty"` // 0 = everything kept
+}
+
+// ScanHistoryResponse is returned by CmdScanHistory, newest first.
+type ScanHistoryResponse struct {
+	Scans []ScanStatusResponse `json:"scans"`
 }
 
 // PauseParams for CmdPause.
 type PauseParams struct {
-	Duration string `json:"duration"` // "15m", "1h", "reboot"
+	Duration string `json:"duration"` // "15m", "1h", "reboot" (or empty) until resumed
 }
 
 // FirewallStatusResponse is returned by CmdFirewallStatus.
 // Pan will implement the firewall package that provides this data.
 type FirewallStatusResponse struct {
-	Enabled    bool `json:"enabled"`
-	TableCount int  `json:"table_count"`
-	ChainCount int  `json:"chain_count"`
-	RuleCount  int  `json:"rule_count"`
+	Enabled    bool              `json:"enabled"`
+	Backend    string            `json:"backend"` // "nftables" or "firewalld"
+	Panic      bool              `json:"panic"`   // all traffic blocked
+	TableCount int               `json:"table_count"`
+	ChainCount int               `json:"chain_count"`
+	RuleCount  int               `json:"rule_count"`
+	Blocklists []BlocklistStatus `json:"blocklists,omitempty"`
+}
+
+// BlocklistStatus describes one IP blocklist loaded into the firewall.
+type BlocklistStatus struct {
+	Name        string    `json:"name"`
+	Path        string    `json:"path"`
+	IPv4Entries int       `json:"ipv4_entries"`
+	IPv6Entries int       `json:"ipv6_entries"`
+	LoadedAt    time.Time `json:"loaded_at"`       // zero if never loaded
+	Error       string    `json:"error,omitempty"` // last load error
+}
+
+// FirewallPanicParams for CmdFirewallPanic.
+type FirewallPanicParams struct {
+	Enabled bool `json:"enabled"`
+}
+
+// FirewallBlockedParams for CmdFirewallBlocked. All fields optional.
+type FirewallBlockedParams struct {
+	Source string    `json:"source,omitempty"` // only connections from this address
+	Since  time.Time `json:"since,omitempty"`  // only connections after this time
+	Limit  int       `json:"limit,omitempty"`  // max recent entries (default 100)
+	Top    int       `json:"top,omitempty"`    // number of top sources (default 10)
+}
+
+// BlockedConnection is a single packet dropped by the firewall.
+type BlockedConnection struct {
+	Time        time.Time `json:"time"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Port        int       `json:"port,omitempty"`
+	Protocol    string    `json:"protocol"`
+	Interface   string    `json:"interface,omitempty"`
+}
+
+// BlockedSource summarizes blocked traffic from one address.
+type BlockedSource struct {
+	Source   string    `json:"source"`
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+	LastPort int       `json:"last_port,omitempty"`
+}
+
+// FirewallBlockedResponse is returned by CmdFirewallBlocked.
+type FirewallBlockedResponse struct {
+	Recent     []BlockedConnection `json:"recent"`
+	TopSources []BlockedSource     `json:"top_sources"`
+}
+
+// BanInfo describes an address banned by intrusion detection.
+type BanInfo struct {
+	Address   string    `json:"address"`
+	Reason    string    `json:"reason"` // e.g. "ssh_bruteforce"
+	BannedAt  time.Time `json:"banned_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// BansResponse is returned by CmdBansList.
+type BansResponse struct {
+	Bans []BanInfo `json:"bans"`
+}
+
+// BanLiftParams for CmdBanLift.
+type BanLiftParams struct {
+	Address string `json:"address"`
+}
+
+// AppRulesResponse is returned by CmdAppRules.
+type AppRulesResponse struct {
+	Enabled  bool            `json:"enabled"`
+	Default  string          `json:"default"` // "allow" or "deny"
+	Learning bool            `json:"learning"`
+	Rules    []AppRuleStatus `json:"rules"`
+}
+
+// AppRuleStatus is a configured app rule and the cgroups it currently matches.
+type AppRuleStatus struct {
+	Name    string   `json:"name"`
+	Exe     string   `json:"exe,omitempty"`
+	Cgroup  string   `json:"cgroup,omitempty"`
+	Action  string   `json:"action"`
+	Cgroups []string `json:"cgroups"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// AppLearnedResponse is returned by CmdAppLearned.
+type AppLearnedResponse struct {
+	Apps     []LearnedApp `json:"apps"`
+	Proposed []AppRule    `json:"proposed"` // allow rules for apps with no rule yet
+}
+
+// LearnedApp is an application seen making outbound connections.
+type LearnedApp struct {
+	Exe          string           `json:"exe"`
+	Cgroup       string           `json:"cgroup"`
+	UID          int              `json:"uid"`
+	FirstSeen    time.Time        `json:"first_seen"`
+	LastSeen     time.Time        `json:"last_seen"`
+	Destinations []AppDestination `json:"destinations"`
+}
+
+// AppDestination is somewhere a learned app connected to.
+type AppDestination struct {
+	Address  string    `json:"address"`
+	Port     int       `json:"port"`
+	Protocol string    `json:"protocol"`
+	Count    int       `json:"count"` // samples in which a connection was open
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// AppRule is a proposed rule, in the same shape as the config file.
+type AppRule struct {
+	Name   string `json:"name"`
+	Exe    string `json:"exe,omitempty"`
+	Cgroup string `json:"cgroup,omitempty"`
+	Action string `json:"action"`
+}
+
+// NetInventoryParams for CmdNetInventory. All fields optional.
+type NetInventoryParams struct {
+	ListeningOnly bool `json:"listening_only,omitempty"` // skip established connections
+}
+
+// NetInventoryResponse is returned by CmdNetInventory.
+type NetInventoryResponse struct {
+	Time        time.Time   `json:"time"`
+	Listening   []NetSocket `json:"listening"`
+	Connections []NetSocket `json:"connections"`
+}
+
+// NetSocket is a listening socket or connection and the process behind it.
+// Process fields are empty if the owner couldn't be determined.
+type NetSocket struct {
+	Protocol      string `json:"protocol"` // "tcp" or "udp"
+	LocalAddress  string `json:"local_address"`
+	LocalPort     int    `json:"local_port"`
+	RemoteAddress string `json:"remote_address,omitempty"`
+	RemotePort    int    `json:"remote_port,omitempty"`
+	State         string `json:"state"`
+	PID           int    `json:"pid,omitempty"`
+	UID           int    `json:"uid"`
+	User          string `json:"user"`
+	Command       string `json:"command,omitempty"`
+	Executable    string `json:"executable,omitempty"`
+	NonLoopback   bool   `json:"non_loopback"`         // bound to a wildcard or non-loopback address
+	Unexpected    bool   `json:"unexpected,omitempty"` // non-loopback listener on a port not in expected_ports
+}
+
+// AppLearningParams for CmdAppLearning.
+type AppLearningParams struct {
+	Enabled bool `json:"enabled"`
+}
+
+// RulesStatusResponse is returned by CmdRulesStatus and CmdRulesUpdate.
+type RulesStatusResponse struct {
+	Updating bool         `json:"updating"`
+	MaxAge   string       `json:"max_age"` // e.g. "72h0m0s"
+	Stale    bool         `json:"stale"`   // any source older than max_age
+	Sources  []RuleSource `json:"sources"`
+	Bundle   uint64       `json:"bundle_version,omitempty"` // last imported offline bundle
+}
+
+// RuleSource is the state of one rule source (ClamAV, a YARA or hash list).
+type RuleSource struct {
+	Name    string    `json:"name"`
+	Kind    string    `json:"kind"`
+	Version string    `json:"version,omitempty"`
+	Updated time.Time `json:"updated,omitempty"` // when the installed content was built
+	Checked time.Time `json:"checked,omitempty"` // last update attempt
+	Error   string    `json:"error,omitempty"`
+	Stale   bool      `json:"stale"`
+}
+
+// RulesImportParams for CmdRulesImport. Path is read by the daemon, so it
+// must be absolute.
+type RulesImportParams struct {
+	Path string `json:"path"`
+}
+
+// RulesImportResponse is returned by CmdRulesImport.
+type RulesImportResponse struct {
+	Version   uint64    `json:"version"`
+	Created   time.Time `json:"created"`
+	Installed []string  `json:"installed"`
+}
+
+// QuarantineItem is a file held in quarantine.
+type QuarantineItem struct {
+	ID            string    `json:"id"`
+	OriginalPath  string    `json:"original_path"`
+	Threat        string    `json:"threat"`
+	Size          int64     `json:"size"`
+	SHA256        string    `json:"sha256"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// QuarantineListResponse is returned by CmdQuarantineList, newest first.
+type QuarantineListResponse struct {
+	Items []QuarantineItem `json:"items"`
+}
+
+// QuarantineParams for CmdQuarantineRestore and CmdQuarantineDelete.
+type QuarantineParams struct {
+	ID string `json:"id"`
+}
+
+// EventsParams for CmdEvents. Zero fields match everything.
+type EventsParams struct {
+	Type      string    `json:"type,omitempty"`
+	Component string    `json:"component,omitempty"`
+	Since     time.Time `json:"since,omitzero"`
+	Success   *bool     `json:"success,omitempty"` // nil = both outcomes
+	Limit     int       `json:"limit,omitempty"`   // 0 = everything kept
+}
+
+// SubscribeEventsParams for CmdSubscribeEvents. Matching events are pushed
+// as responses with ID "event" and an Event payload.
+type SubscribeEventsParams struct {
+	Type      string `json:"type,omitempty"`
+	Component string `json:"component,omitempty"`
+	Success   *bool  `json:"success,omitempty"` // nil = both outcomes
+}
+
+// EventsResponse is returned by CmdEvents.
+type EventsResponse struct {
+	Events []Event `json:"events"`
+}
+
+// Event is one daemon event (scan, ban, blocked query, ...).
+type Event struct {
+	Type        string         `json:"event_type"`
+	OperationID string         `json:"operation_id"`
+	Component   string         `json:"component"`
+	StartedAt   time.Time      `json:"started_at"`
+	DurationMs  int64          `json:"duration_ms"`
+	Success     bool           `json:"success"`
+	Error       string         `json:"error,omitempty"`
+	Fields      map[string]any `json:"fields,omitempty"`
+}
+
+// Notification kinds carried in an Envelope.
+const (
+	KindStateChange  = "state_change"  // StateChangeEvent
+	KindScanProgress = "scan_progress" // ScanProgressEvent
+	KindThreat       = "threat"        // ThreatEvent
+	KindQuarantine   = "quarantine"    // QuarantineEvent
+	KindFirewall     = "firewall"      // FirewallEvent
+	KindRules        = "rules"         // RulesEvent
+	KindEvent        = "event"         // Event (every wide event, see WatchParams.Events)
+
+	// Generated by the client, never sent by the daemon.
+	KindDisconnected = "disconnected" // error; the client is reconnecting
+	KindReconnected  = "reconnected"  // *WatchResponse; check Missed
+)
+
+// WatchParams for CmdWatch. After the WatchResponse, the daemon writes one
+// Envelope per line on the connection.
+//
+//	{"id": "1", "cmd": "watch", "params": {"kinds": ["threat", "scan_progress"]}}
+//	{"kind": "threat", "seq": 42, "time": "...", "payload": {"path": "/tmp/x", ...}}
+//
+// To resume after a dropped connection, send the Epoch from the last
+// WatchResponse and the last Seq seen as After; buffered notifications
+// after it are replayed first.
+type WatchParams struct {
+	Kinds  []string               `json:"kinds,omitempty"`  // empty = every kind
+	Events *SubscribeEventsParams `json:"events,omitempty"` // filter for KindEvent
+	Epoch  string                 `json:"epoch,omitempty"`  // set to resume
+	After  uint64                 `json:"after,omitempty"`
+}
+
+// WatchResponse is returned by CmdWatch.
+type WatchResponse struct {
+	Epoch  string `json:"epoch"`  // changes when the daemon restarts
+	Seq    uint64 `json:"seq"`    // latest notification at subscribe time
+	Missed bool   `json:"missed"` // resume couldn't replay everything; re-read state
+}
+
+// Envelope wraps every pushed notification.
+type Envelope struct {
+	Kind    string          `json:"kind"`
+	Seq     uint64          `json:"seq"` // daemon-wide counter; filtered watchers see gaps
+	Time    time.Time       `json:"time"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Decode unmarshals the payload into the type matching Kind. Unknown kinds
+// come back as the raw JSON so newer daemons don't break older clients.
+func (e *Envelope) Decode() (any, error) {
+	var v any
+	switch e.Kind {
+	case KindStateChange:
+		v = &StateChangeEvent{}
+	case KindScanProgress:
+		v = &ScanProgressEvent{}
+	case KindThreat:
+		v = &ThreatEvent{}
+	case KindQuarantine:
+		v = &QuarantineEvent{}
+	case KindFirewall:
+		v = &FirewallEvent{}
+	case KindRules:
+		v = &RulesEvent{}
+	case KindEvent:
+		v = &Event{}
+	default:
+		return e.Payload, nil
+	}
+	if err := json.Unmarshal(e.Payload, v); err != nil {
+		return nil, fmt.Errorf("decode %s payload: %w", e.Kind, err)
+	}
+	return v, nil
+}
+
+// Notification is a decoded Envelope. Payload is a pointer to the type
+// listed next to each Kind constant, e.g. *ThreatEvent.
+type Notification struct {
+	Kind    string
+	Seq     uint64
+	Time    time.Time
+	Payload any
+}
+
+// ScanProgressEvent is pushed when a scan starts, periodically while it
+// runs, and when it finishes.
+type ScanProgressEvent struct {
+	JobID        string `json:"job_id"`
+	Type         string `json:"type"`
+	Status       string `json:"status"` // same values as ScanStatusResponse.Status
+	FilesScanned int    `json:"files_scanned"`
+	ThreatsFound int    `json:"threats_found"`
+	Error        string `json:"error,omitempty"`
+}
+
+// ThreatEvent is pushed for every infected file a scan finds.
+type ThreatEvent struct {
+	JobID        string `json:"job_id,omitempty"`
+	Path         string `json:"path"`
+	Threat       string `json:"threat"`
+	Action       string `json:"action"` // "detected" or "quarantined"
+	QuarantineID string `json:"quarantine_id,omitempty"`
+}
+
+// QuarantineEvent is pushed when a file enters or leaves quarantine.
+type QuarantineEvent struct {
+	Action string `json:"action"` // "quarantined", "restored" or "deleted"
+	ID     string `json:"id"`
+	Path   string `json:"path,omitempty"`
+	Threat string `json:"threat,omitempty"`
+}
+
+// FirewallEvent is pushed after the firewall is enabled, disabled or
+// switched in or out of panic mode.
+type FirewallEvent struct {
+	Enabled bool `json:"enabled"`
+	Panic   bool `json:"panic"`
+}
+
+// RulesEvent is pushed after each rule source update attempt.
+type RulesEvent struct {
+	Source  string `json:"source"`
+	Kind    string `json:"kind"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
 }
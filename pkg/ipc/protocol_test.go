@@ -89,3 +89,28 @@ func TestScanParamsJSON(t *testing.T) {
 		t.Errorf("got %s, want %s", data, expected)
 	}
 }
+
+func TestEnvelopeDecode(t *testing.T) {
+	payload, _ := json.Marshal(ThreatEvent{Path: "/tmp/eicar.com", Threat: "Eicar-Test-Signature", Action: "detected"})
+	env := Envelope{Kind: KindThreat, Seq: 7, Payload: payload}
+
+	v, err := env.Decode()
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	threat, ok := v.(*ThreatEvent)
+	if !ok || threat.Path != "/tmp/eicar.com" || threat.Action != "detected" {
+		t.Errorf("Decode() = %#v", v)
+	}
+
+	// kinds from a newer daemon pass through untouched
+	env = Envelope{Kind: "something_new", Payload: json.RawMessage(`{"x":1}`)}
+	if v, err := env.Decode(); err != nil || string(v.(json.RawMessage)) != `{"x":1}` {
+		t.Errorf("Decode(unknown) = %v, %v", v, err)
+	}
+
+	env = Envelope{Kind: KindFirewall, Payload: json.RawMessage(`"nope"`)}
+	if _, err := env.Decode(); err == nil {
+		t.Error("Decode() of a bad payload succeeded")
+	}
+}
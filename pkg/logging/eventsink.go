@@ -0,0 +1,137 @@
+// oreon/defense · watchthelight <wtl>
+
+package logging
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/oreonproject/defense/pkg/events"
+)
+
+// Batching defaults for EventSink.
+const (
+	eventBatchSize     = 100
+	eventFlushInterval = time.Second
+	eventQueueSize     = 4096
+)
+
+// EventSink is an events.Sink that writes events to a LogStore in
+// batches from its own goroutine, so emitting never waits on the disk.
+// Events that arrive while the queue is full are dropped (and counted).
+type EventSink struct {
+	store    *LogStore
+	logger   *slog.Logger
+	interval time.Duration
+	queue    chan events.Event
+
+	mu      sync.Mutex
+	closed  bool
+	dropped int
+
+	done chan struct{}
+}
+
+// EventSinkOption configures an EventSink.
+type EventSinkOption func(*EventSink)
+
+// WithFlushInterval sets how long events wait before being written if
+// a batch doesn't fill up first.
+func WithFlushInterval(d time.Duration) EventSinkOption {
+	return func(s *EventSink) {
+		s.interval = d
+	}
+}
+
+// WithSinkLogger sets where write failures are reported.
+func WithSinkLogger(logger *slog.Logger) EventSinkOption {
+	return func(s *EventSink) {
+		s.logger = logger
+	}
+}
+
+// NewEventSink starts writing events to store. Close flushes what's
+// queued and stops it; the store stays open.
+func NewEventSink(store *LogStore, opts ...EventSinkOption) *EventSink {
+	s := &EventSink{
+		store:    store,
+		logger:   slog.Default(),
+		interval: eventFlushInterval,
+		queue:    make(chan events.Event, eventQueueSize),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	go s.run()
+	return s
+}
+
+// Write implements events.Sink.
+func (s *EventSink) Write(evt events.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- evt:
+	default:
+		s.dropped++
+	}
+}
+
+// Close writes out queued events and stops the sink.
+func (s *EventSink) Close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	<-s.done
+}
+
+func (s *EventSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	batch := make([]events.Event, 0, eventBatchSize)
+	for {
+		select {
+		case evt, ok := <-s.queue:
+			if !ok {
+				s.flush(batch)
+				return
+			}
+			batch = append(batch, evt)
+			if len(batch) < eventBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		s.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// flush writes a batch, reporting failures and drops rather than
+// retrying: the events are also in the journal.
+func (s *EventSink) flush(batch []events.Event) {
+	s.mu.Lock()
+	dropped := s.dropped
+	s.dropped = 0
+	s.mu.Unlock()
+	if dropped > 0 {
+		s.logger.Warn("event store queue full, events dropped", "dropped", dropped)
+	}
+
+	if len(batch) == 0 {
+		return
+	}
+	if err := s.store.InsertEvents(batch); err != nil {
+		s.logger.Error("failed to store events", "count", len(batch), "error", err)
+	}
+}
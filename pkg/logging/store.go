@@ -3,11 +3,13 @@
 package logging
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"log/slog"
 	"time"
 
+	"github.com/oreonproject/defense/pkg/events"
 	_ "modernc.org/sqlite"
 )
 
@@ -34,6 +36,9 @@ func NewLogStore(path string) (*LogStore, error) {
 	if err != nil {
 		return nil, err
 	}
+	// SQLite takes one writer at a time anyway, and every ":memory:"
+	// connection would be a separate database
+	db.SetMaxOpenConns(1)
 
 	if err := createSchema(db); err != nil {
 		db.Close()
@@ -62,6 +67,21 @@ func createSchema(db *sql.DB) error {
 	CREATE INDEX IF NOT EXISTS idx_logs_timestamp ON logs(timestamp);
 	CREATE INDEX IF NOT EXISTS idx_logs_level ON logs(level);
 	CREATE INDEX IF NOT EXISTS idx_logs_operation_id ON logs(operation_id);
+
+	CREATE TABLE IF NOT EXISTS events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		started_at DATETIME NOT NULL,
+		type TEXT NOT NULL,
+		operation_id TEXT,
+		component TEXT,
+		duration_ms INTEGER NOT NULL,
+		success BOOLEAN NOT NULL,
+		error TEXT,
+		fields TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_events_started_at ON events(started_at);
+	CREATE INDEX IF NOT EXISTS idx_events_type ON events(type);
+	CREATE INDEX IF NOT EXISTS idx_events_operation_id ON events(operation_id);
 	`
 	_, err := db.Exec(schema)
 	return err
@@ -203,3 +223,141 @@ func (s *LogStore) QueryByOperationID(operationID string) ([]LogEntry, error) {
 
 	return entries, rows.Err()
 }
+
+// InsertEvents stores wide events in one transaction. Events whose fields
+// can't be encoded are logged and skipped rather than failing the batch.
+func (s *LogStore) InsertEvents(evts []events.Event) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(
+		`INSERT INTO events (started_at, type, operation_id, component, duration_ms, success, error, fields) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+	)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, evt := range evts {
+		var fieldsJSON []byte
+		if len(evt.Fields) > 0 {
+			if fieldsJSON, err = json.Marshal(evt.Fields); err != nil {
+				slog.Warn("dropping event with unencodable fields", "type", evt.Type, "operation_id", evt.OperationID, "error", err)
+				continue
+			}
+		}
+		_, err = stmt.Exec(evt.StartedAt, string(evt.Type), evt.OperationID, evt.Component,
+			evt.DurationMs, evt.Success, evt.Error, fieldsJSON)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// EventQueryOptions specifies filters for querying events.
+type EventQueryOptions struct {
+	Type        events.EventType // empty = all
+	Component   string           // empty = all
+	OperationID string           // empty = all
+	Success     *bool            // nil = both outcomes
+	Since       time.Time        // only events started at or after this (zero = no filter)
+	Before      time.Time        // only events started before this (zero = no filter)
+	Limit       int              // max results (0 = no limit)
+
+	// Restricted skips events private to anyone but UID (events.FieldOwnerUID).
+	Restricted bool
+	UID        int
+}
+
+// QueryEvents retrieves events matching the options, newest first.
+func (s *LogStore) QueryEvents(ctx context.Context, opts EventQueryOptions) ([]events.Event, error) {
+	query := `SELECT started_at, type, operation_id, component, duration_ms, success, error, fields FROM events WHERE 1=1`
+	args := []interface{}{}
+
+	if opts.Type != "" {
+		query += ` AND type = ?`
+		args = append(args, string(opts.Type))
+	}
+	if opts.Component != "" {
+		query += ` AND component = ?`
+		args = append(args, opts.Component)
+	}
+	if opts.OperationID != "" {
+		query += ` AND operation_id = ?`
+		args = append(args, opts.OperationID)
+	}
+	if opts.Success != nil {
+		query += ` AND success = ?`
+		args = append(args, *opts.Success)
+	}
+	if !opts.Since.IsZero() {
+		query += ` AND started_at >= ?`
+		args = append(args, opts.Since)
+	}
+	if !opts.Before.IsZero() {
+		query += ` AND started_at < ?`
+		args = append(args, opts.Before)
+	}
+	if opts.Restricted {
+		query += ` AND (json_extract(fields, '$.` + events.FieldOwnerUID + `') IS NULL OR json_extract(fields, '$.` + events.FieldOwnerUID + `') = ?)`
+		args = append(args, opts.UID)
+	}
+
+	query += ` ORDER BY started_at DESC, id DESC`
+
+	if opts.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, opts.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []events.Event
+	for rows.Next() {
+		var evt events.Event
+		var eventType string
+		var operationID, component, errMsg sql.NullString
+		var fieldsJSON []byte
+
+		err := rows.Scan(&evt.StartedAt, &eventType, &operationID, &component, &evt.DurationMs, &evt.Success, &errMsg, &fieldsJSON)
+		if err != nil {
+			return nil, err
+		}
+		evt.Type = events.EventType(eventType)
+		evt.OperationID = operationID.String
+		evt.Component = component.String
+		evt.Error = errMsg.String
+		evt.Duration = time.Duration(evt.DurationMs) * time.Millisecond
+
+		if len(fieldsJSON) > 0 {
+			if err := json.Unmarshal(fieldsJSON, &evt.Fields); err != nil {
+				slog.Warn("failed to unmarshal event fields", "operation_id", evt.OperationID, "error", err)
+			}
+		}
+
+		out = append(out, evt)
+	}
+
+	return out, rows.Err()
+}
+
+// PruneEvents deletes events that started more than olderThan ago.
+// Returns the number of events deleted.
+func (s *LogStore) PruneEvents(olderThan time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-olderThan)
+
+	result, err := s.db.Exec(`DELETE FROM events WHERE started_at < ?`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -3,8 +3,11 @@
 package logging
 
 import (
+	"errors"
 	"testing"
 	"time"
+
+	"github.com/oreonproject/defense/pkg/events"
 )
 
 func TestNewLogStore(t *testing.T) {
@@ -147,3 +150,163 @@ func TestPrune(t *testing.T) {
 		t.Errorf("expected 1 remaining, got %d", len(results))
 	}
 }
+
+func TestInsertAndQueryEvents(t *testing.T) {
+	store, err := NewLogStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewLogStore: %v", err)
+	}
+	defer store.Close()
+
+	ban := events.StartBan("192.0.2.1", "ssh").WithOperationID("op-1").End()
+	failed := events.StartRulesUpdate("clamav", "clamav")
+	failed.SetError(errors.New("freshclam failed"))
+	if err := store.InsertEvents([]events.Event{ban, failed.End()}); err != nil {
+		t.Fatalf("InsertEvents: %v", err)
+	}
+
+	all, err := store.QueryEvents(t.Context(), EventQueryOptions{})
+	if err != nil {
+		t.Fatalf("QueryEvents: %v", err)
+	}
+	if len(all) != 2 || all[0].Type != events.EventTypeRules {
+		t.Fatalf("QueryEvents() = %+v, want 2 events newest first", all)
+	}
+
+	got, err := store.QueryEvents(t.Context(), EventQueryOptions{OperationID: "op-1"})
+	if err != nil || len(got) != 1 {
+		t.Fatalf("QueryEvents(op-1) = %+v, %v", got, err)
+	}
+	if got[0].Component != ban.Component || !got[0].Success || got[0].Fields[events.FieldSrcAddr] != "192.0.2.1" {
+		t.Errorf("stored ban = %+v", got[0])
+	}
+
+	ok := false
+	failures, err := store.QueryEvents(t.Context(), EventQueryOptions{Success: &ok})
+	if err != nil || len(failures) != 1 || failures[0].Error != "freshclam failed" {
+		t.Errorf("QueryEvents(failed) = %+v, %v", failures, err)
+	}
+
+	older, err := store.QueryEvents(t.Context(), EventQueryOptions{Before: all[0].StartedAt})
+	if err != nil || len(older) != 1 || older[0].OperationID != "op-1" {
+		t.Errorf("QueryEvents(before) = %+v, %v", older, err)
+	}
+}
+
+func TestQueryEvents_Restricted(t *testing.T) {
+	store, err := NewLogStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewLogStore: %v", err)
+	}
+	defer store.Close()
+
+	store.InsertEvents([]events.Event{
+		events.StartThreat("/home/alice/x", "Eicar").Owner(1000).End(),
+		events.StartThreat("/home/bob/x", "Eicar").Owner(1001).End(),
+		events.StartThreat("/srv/x", "Eicar").End(),
+	})
+	got, err := store.QueryEvents(t.Context(), EventQueryOptions{Restricted: true, UID: 1000})
+	if err != nil {
+		t.Fatalf("QueryEvents: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("QueryEvents(restricted) = %+v, want alice's and the public one", got)
+	}
+	for _, evt := range got {
+		if evt.Owner() == 1001 {
+			t.Errorf("got bob's event: %+v", evt)
+		}
+	}
+}
+
+func TestInsertEvents_SkipsUnencodable(t *testing.T) {
+	store, err := NewLogStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewLogStore: %v", err)
+	}
+	defer store.Close()
+
+	bad := events.StartBan("192.0.2.2", "ssh").End()
+	bad.Fields["oops"] = func() {}
+	good := events.StartBan("192.0.2.1", "ssh").End()
+	if err := store.InsertEvents([]events.Event{bad, good}); err != nil {
+		t.Fatalf("InsertEvents: %v", err)
+	}
+
+	got, err := store.QueryEvents(t.Context(), EventQueryOptions{})
+	if err != nil {
+		t.Fatalf("QueryEvents: %v", err)
+	}
+	if len(got) != 1 || got[0].Fields[events.FieldSrcAddr] != "192.0.2.1" {
+		t.Errorf("stored events = %+v, want only the encodable one", got)
+	}
+}
+
+func TestPruneEvents(t *testing.T) {
+	store, err := NewLogStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewLogStore: %v", err)
+	}
+	defer store.Close()
+
+	old := events.StartBan("192.0.2.1", "ssh").End()
+	old.StartedAt = time.Now().Add(-48 * time.Hour)
+	recent := events.StartBan("192.0.2.2", "ssh").End()
+	if err := store.InsertEvents([]events.Event{old, recent}); err != nil {
+		t.Fatalf("InsertEvents: %v", err)
+	}
+
+	deleted, err := store.PruneEvents(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("PruneEvents: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted = %d, want 1", deleted)
+	}
+
+	got, _ := store.QueryEvents(t.Context(), EventQueryOptions{})
+	if len(got) != 1 || got[0].Fields[events.FieldSrcAddr] != "192.0.2.2" {
+		t.Errorf("remaining events = %+v", got)
+	}
+}
+
+func TestEventSink(t *testing.T) {
+	store, err := NewLogStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewLogStore: %v", err)
+	}
+	defer store.Close()
+
+	sink := NewEventSink(store, WithFlushInterval(10*time.Millisecond))
+	sink.Write(events.StartBan("192.0.2.1", "ssh").End())
+
+	// written by the ticker, not by Close
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := store.QueryEvents(t.Context(), EventQueryOptions{})
+		if err != nil {
+			t.Fatalf("QueryEvents: %v", err)
+		}
+		if len(got) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("event never flushed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for range eventBatchSize + 5 {
+		sink.Write(events.StartDNSBlock("evil.example", "A", "127.0.0.1").End())
+	}
+	sink.Close()
+	sink.Write(events.StartDNSBlock("late.example", "A", "127.0.0.1").End()) // ignored
+
+	got, err := store.QueryEvents(t.Context(), EventQueryOptions{Type: events.EventTypeDNSBlock})
+	if err != nil {
+		t.Fatalf("QueryEvents: %v", err)
+	}
+	if len(got) != eventBatchSize+5 {
+		t.Errorf("stored %d DNS blocks, want %d", len(got), eventBatchSize+5)
+	}
+}